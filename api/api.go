@@ -0,0 +1,98 @@
+// Package api exposes the user API as a plain http.Handler so other
+// services can embed it in their own router (net/http, chi, ...).
+//
+//	users, err := api.Mount(mux, pool, api.Options{Prefix: "/users-api"})
+//	...
+//	defer users.Close(ctx)
+//
+// The handlers and middleware are the ones the standalone server runs;
+// only the router differs, net/http's ServeMux instead of gin, so both
+// behave identically and embedding leaves gin's global state alone.
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/server"
+)
+
+// Config holds the server tunables; see the standalone server's
+// environment variables for what each one does. Addr and
+// ManagementAddr are ignored.
+type Config = server.Config
+
+// Options configure an embedded API.
+type Options struct {
+	// Prefix is where the API is mounted, e.g. "/users-api". Health
+	// probes are served under it too, since the host owns the root.
+	Prefix string
+	Config Config
+	// Logger defaults to the global zerolog logger.
+	Logger *zerolog.Logger
+}
+
+// API is an embedded instance of the user API.
+type API struct {
+	srv *server.Server
+}
+
+// New builds the API on pool and starts its background workers. The
+// caller keeps ownership of pool.
+func New(pool *pgxpool.Pool, opts Options) (*API, error) {
+	if pool == nil {
+		return nil, errors.New("api: a database pool is required")
+	}
+	reg := metrics.NewRegistry()
+	metrics.RegisterPool(reg, pool.Stat)
+	return newAPI(repository.New(pool), reg, opts)
+}
+
+func newAPI(repo repository.UserRepository, reg *metrics.Registry, opts Options) (*API, error) {
+	cfg := opts.Config
+	cfg.BasePath = opts.Prefix
+	cfg.ProbesUnderBasePath = true
+	cfg.ManagementAddr = ""
+
+	logger := log.Logger
+	if opts.Logger != nil {
+		logger = *opts.Logger
+	}
+
+	srv, err := server.New(cfg, server.WithRepository(repo), server.WithLogger(logger), server.WithMetrics(reg), server.WithServeMux())
+	if err != nil {
+		return nil, err
+	}
+	srv.StartWorkers()
+	return &API{srv: srv}, nil
+}
+
+// Mount builds the API and registers it on mux under opts.Prefix. The
+// pattern form suits http.ServeMux; with chi use New and r.Mount.
+func Mount(mux interface{ Handle(string, http.Handler) }, pool *pgxpool.Pool, opts Options) (*API, error) {
+	a, err := New(pool, opts)
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle(opts.Prefix+"/", a)
+	return a, nil
+}
+
+// ServeHTTP implements http.Handler. Requests must keep the full path,
+// prefix included.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.srv.Handler().ServeHTTP(w, r)
+}
+
+// Close stops the background workers, waiting for them (e.g. the final
+// flush of batched view counts) until ctx expires.
+func (a *API) Close(ctx context.Context) error {
+	return a.srv.Shutdown(ctx)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/server"
+)
+
+const prefix = "/users-api"
+
+// sha256 of "alice-key".
+const keys = "alice:72ee9d4355ccb9d3a4c9dbf37382e38e75c1b1a225b5bd1f729ee91bbda30c20"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	m.Run()
+}
+
+func TestNewRequiresPool(t *testing.T) {
+	if _, err := New(nil, Options{}); err == nil {
+		t.Fatal("nil pool accepted")
+	}
+}
+
+// embedded mounts the API on a plain net/http mux the way Mount does.
+func embedded(t *testing.T, cfg Config) http.Handler {
+	t.Helper()
+	a, err := newAPI(repository.NewMemory(), metrics.NewRegistry(), Options{Prefix: prefix, Config: cfg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close(context.Background()) })
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", a)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "host route", http.StatusTeapot)
+	})
+	return mux
+}
+
+// standalone is the gin server run on its own under the same prefix.
+func standalone(t *testing.T, cfg Config) http.Handler {
+	t.Helper()
+	cfg.BasePath = prefix
+	cfg.ProbesUnderBasePath = true
+	s, err := server.New(cfg, server.WithRepository(repository.NewMemory()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Handler()
+}
+
+type step struct {
+	method, path, body string
+	header             http.Header
+	status             int
+}
+
+type result struct {
+	Status    int
+	RequestID string
+	Body      any
+}
+
+func run(h http.Handler, st step) result {
+	req := httptest.NewRequest(st.method, st.path, strings.NewReader(st.body))
+	if st.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range st.header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	raw, _ := io.ReadAll(w.Body)
+	var body any
+	if json.Unmarshal(raw, &body) != nil {
+		body = string(raw)
+	}
+	return result{w.Code, w.Header().Get("X-Request-ID"), stripVolatile(body)}
+}
+
+// stripVolatile drops the fields that differ between two otherwise
+// identical runs.
+func stripVolatile(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for _, k := range []string{"created_at", "updated_at", "request_id"} {
+			delete(v, k)
+		}
+		for k, e := range v {
+			v[k] = stripVolatile(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = stripVolatile(e)
+		}
+	}
+	return v
+}
+
+// The same contract runs against the gin server and the embedded
+// handler on ServeMux; every response must match, request ID, error
+// envelope, auth and the router's not-found answers included.
+func TestContractParity(t *testing.T) {
+	auth := http.Header{"X-Api-Key": {"alice-key"}}
+	traced := http.Header{"X-Api-Key": {"alice-key"}, "X-Request-Id": {"trace-123"}}
+	steps := []step{
+		{method: http.MethodGet, path: prefix + "/healthz", status: 200},
+		{method: http.MethodGet, path: prefix + "/api/v1/users", status: 200},
+		{method: http.MethodGet, path: prefix + "/api/v1/admin/features", status: 401},
+		{method: http.MethodGet, path: prefix + "/api/v1/admin/features", header: http.Header{"X-Api-Key": {"wrong"}}, status: 401},
+		{method: http.MethodGet, path: prefix + "/api/v1/admin/features", header: auth, status: 200},
+		{method: http.MethodPost, path: prefix + "/api/v1/users", body: `{"name":"Ada","email":"ada@example.com"}`, header: traced, status: 201},
+		{method: http.MethodPost, path: prefix + "/api/v1/users", body: `{"name":"Ada","email":"ada@example.com"}`, header: auth, status: 409},
+		{method: http.MethodPost, path: prefix + "/api/v1/users", body: `{"name":`, header: auth, status: 400},
+		{method: http.MethodGet, path: prefix + "/api/v1/users/1", header: traced, status: 200},
+		{method: http.MethodGet, path: prefix + "/api/v1/users/abc", header: auth, status: 400},
+		{method: http.MethodGet, path: prefix + "/api/v1/users/999", header: auth, status: 404},
+		{method: http.MethodGet, path: prefix + "/api/v1/users?limit=10", header: auth, status: 200},
+		{method: http.MethodDelete, path: prefix + "/api/v1/users/1", header: auth, status: 200},
+		{method: http.MethodGet, path: prefix + "/api/v1/nope", header: auth, status: 404},
+		{method: http.MethodPost, path: prefix + "/api/v1/users", body: `{"name":"Bo","email":"bo@example.com"}`, header: auth, status: 201},
+		{method: http.MethodHead, path: prefix + "/api/v1/users/2", header: auth, status: 200},
+		{method: http.MethodHead, path: prefix + "/api/v1/admin/features", header: auth, status: 404},
+		{method: http.MethodPost, path: prefix + "/api/v1/users/2", header: auth, status: 404},
+		{method: http.MethodPut, path: prefix + "/api/v1/users/2/labels", body: `{"example.com/team":"payments"}`, header: auth, status: 200},
+		{method: http.MethodDelete, path: prefix + "/api/v1/users/2/labels/example.com/team", header: auth, status: 204},
+	}
+
+	cfg := Config{APIKeys: keys}
+	std, mux := standalone(t, cfg), embedded(t, cfg)
+	for _, st := range steps {
+		want, got := run(std, st), run(mux, st)
+		if !reflect.DeepEqual(got.Body, want.Body) || got.Status != want.Status {
+			t.Errorf("%s %s: embedded %d %v, gin %d %v", st.method, st.path, got.Status, got.Body, want.Status, want.Body)
+		}
+		if got.Status != st.status {
+			t.Errorf("%s %s: %d, want %d", st.method, st.path, got.Status, st.status)
+		}
+		if want.RequestID == "" || got.RequestID == "" {
+			t.Errorf("%s %s: no X-Request-ID (gin %q, embedded %q)", st.method, st.path, want.RequestID, got.RequestID)
+		}
+		if id := st.header.Get("X-Request-Id"); id != "" && (got.RequestID != id || want.RequestID != id) {
+			t.Errorf("%s %s: caller's request ID not kept (gin %q, embedded %q)", st.method, st.path, want.RequestID, got.RequestID)
+		}
+	}
+}
+
+// The host keeps everything outside the prefix.
+func TestMountLeavesHostRoutes(t *testing.T) {
+	mux := embedded(t, Config{})
+	for _, path := range []string{"/", "/healthz", "/api/v1/users", "/users-apix/api/v1/users"} {
+		if r := run(mux, step{method: http.MethodGet, path: path}); r.Status != http.StatusTeapot {
+			t.Errorf("GET %s: %d, want the host's handler", path, r.Status)
+		}
+	}
+	if r := run(mux, step{method: http.MethodGet, path: prefix + "/api/v1/users"}); r.Status != http.StatusOK {
+		t.Errorf("GET under prefix: %d", r.Status)
+	}
+}
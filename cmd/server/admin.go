@@ -0,0 +1,39 @@
+package main
+
+import (
+	"expvar"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+
+	"go-k8s-demo/internal/admin"
+	"go-k8s-demo/internal/config"
+)
+
+// startAdmin starts the pprof and debug listener on ADMIN_PORT, or returns
+// nil with ADMIN_PORT=0. The pool's statistics are published as the
+// db_pool expvar variable.
+func startAdmin(cfg config.Config, pool *pgxpool.Pool) *admin.Server {
+	if cfg.AdminPort == 0 {
+		return nil
+	}
+	expvar.Publish("db_pool", expvar.Func(func() any {
+		st := pool.Stat()
+		return map[string]any{
+			"total_conns":         st.TotalConns(),
+			"idle_conns":          st.IdleConns(),
+			"acquired_conns":      st.AcquiredConns(),
+			"constructing_conns":  st.ConstructingConns(),
+			"max_conns":           st.MaxConns(),
+			"acquire_count":       st.AcquireCount(),
+			"empty_acquire_count": st.EmptyAcquireCount(),
+			"acquire_duration_ms": st.AcquireDuration().Milliseconds(),
+		}
+	}))
+
+	a := admin.New(cfg.AdminAddr(), log.Logger)
+	if err := a.Start(); err != nil {
+		log.Fatal().Err(err).Msg("failed to start admin server")
+	}
+	return a
+}
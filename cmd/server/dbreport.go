@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// runDBReport implements the dbreport subcommand: print a one-shot
+// database statistics report to stdout and return.
+func runDBReport(ctx context.Context, repo *repository.Repository, args []string) error {
+	fs := flag.NewFlagSet("dbreport", flag.ContinueOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	timeout := fs.Duration("timeout", 30*time.Second, "upper bound for gathering the report")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("-format must be text or json, got %q", *format)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	rep := repo.Report(ctx)
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rep)
+	}
+	return rep.WriteText(os.Stdout)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"go-k8s-demo/internal/config"
+	"go-k8s-demo/internal/logmask"
+)
+
+// setupLogMasking routes the global logger through a logmask.Masker and
+// returns it, or nil with LOG_MASK=false. With LOG_MASK_RULES_FILE the
+// rules come from that file and SIGHUP re-reads it; a file that no longer
+// parses keeps the previous rules.
+func setupLogMasking(cfg config.Config) *logmask.Masker {
+	if !cfg.LogMask {
+		return nil
+	}
+	rules := logmask.DefaultRules()
+	if cfg.LogMaskRulesFile != "" {
+		var err error
+		if rules, err = logmask.LoadRules(cfg.LogMaskRulesFile); err != nil {
+			log.Fatal().Err(err).Msg("invalid LOG_MASK_RULES_FILE")
+		}
+	}
+	masker, err := logmask.New(rules)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid LOG_MASK_RULES_FILE")
+	}
+	masker.SetStrict(cfg.LogMaskStrict)
+	log.Logger = log.Output(masker.Writer(zerolog.ConsoleWriter{Out: os.Stderr}))
+
+	if cfg.LogMaskRulesFile != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				rules, err := logmask.LoadRules(cfg.LogMaskRulesFile)
+				if err == nil {
+					err = masker.SetRules(rules)
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("Keeping the previous log masking rules")
+					continue
+				}
+				log.Info().Int("rules", len(rules)).Msg("Reloaded log masking rules")
+			}
+		}()
+	}
+	return masker
+}
@@ -3,10 +3,9 @@ package main
 import (
 	"context"
 	"errors"
-	"net/http"
+	"math/rand/v2"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 	"time"
 
@@ -14,298 +13,381 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-)
-
-// User represents a database entity.
-// In real projects you would place this in domain/models.
-type User struct {
-	ID    int64  `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
-}
-
-// Repository provides DB methods.
-// In real code you'd separate interface & implementation, but for demo we keep it compact.
-type Repository struct {
-	db *pgxpool.Pool
-}
 
-// NewRepository constructs a new repo.
-func NewRepository(db *pgxpool.Pool) *Repository {
-	return &Repository{db: db}
-}
+	"go-k8s-demo/internal/config"
+	"go-k8s-demo/internal/dsn"
+	"go-k8s-demo/internal/features"
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/migrate"
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/server"
+	"go-k8s-demo/internal/timing"
+	"go-k8s-demo/migrations"
+)
 
 // ---------------------------------------------------------
-// DATABASE METHODS
+// MAIN ENTRYPOINT
 // ---------------------------------------------------------
 
-func (r *Repository) GetAllUsers(ctx context.Context) ([]User, error) {
-	rows, err := r.db.Query(ctx, "SELECT id, name, email FROM users ORDER BY id")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var users []User
+func main() {
+	// Zerolog pretty print for local dev, JSON in containers
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
-	for rows.Next() {
-		var u User
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email); err != nil {
-			return nil, err
-		}
-		users = append(users, u)
+	// Every setting but the database target and the feature presets; see
+	// internal/config.
+	appCfg, err := config.Load(os.LookupEnv)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid configuration")
 	}
+	zerolog.SetGlobalLevel(appCfg.LogLevel)
 
-	return users, rows.Err()
-}
+	// Emails, tokens and credential fields are masked in every event.
+	masker := setupLogMasking(appCfg)
 
-func (r *Repository) GetUserByID(ctx context.Context, id int64) (*User, error) {
-	var u User
-	err := r.db.QueryRow(ctx, "SELECT id, name, email FROM users WHERE id=$1", id).
-		Scan(&u.ID, &u.Name, &u.Email)
-	if err != nil {
-		return nil, err
+	log.Info().Str("profile", appCfg.Profile).Interface("settings", appCfg.Settings).Strs("overridden", appCfg.Overridden).Msg("Resolved configuration")
+	for _, w := range appCfg.Warnings {
+		log.Warn().Str("profile", appCfg.Profile).Msg(w)
 	}
-	return &u, nil
-}
 
-func (r *Repository) CreateUser(ctx context.Context, name, email string) (int64, error) {
-	// Demonstrates use of transactions — good practice for write operations.
-	tx, err := r.db.Begin(ctx)
+	// DATABASE_URL (URL or key=value form), or DB_HOST/DB_PORT/... parts.
+	dbURL, err := dsn.FromEnv(os.Getenv)
 	if err != nil {
-		return 0, err
+		log.Fatal().Err(err).Msg("invalid database configuration")
 	}
-	defer tx.Rollback(ctx)
 
-	var id int64
-	err = tx.QueryRow(ctx,
-		"INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id",
-		name, email,
-	).Scan(&id)
+	// Startup (connecting, the dbreport subcommand) ends early on SIGTERM.
+	startup, stopStartup := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stopStartup()
 
+	poolCfg, err := pgxpool.ParseConfig(dbURL)
 	if err != nil {
-		return 0, err
+		log.Fatal().Err(err).Msg("invalid DATABASE_URL")
 	}
-
-	if err := tx.Commit(ctx); err != nil {
-		return 0, err
+	log.Info().
+		Str("target", dsn.Redact(dbURL)).
+		Str("host", poolCfg.ConnConfig.Host).
+		Uint16("port", poolCfg.ConnConfig.Port).
+		Str("database", poolCfg.ConnConfig.Database).
+		Str("user", poolCfg.ConnConfig.User).
+		Msg("Database connection target")
+
+	// SERVER_TIMING=true reports per-request db/cache/render durations
+	// in a Server-Timing header; the tracer feeds the db entry.
+	if appCfg.ServerTiming {
+		poolCfg.ConnConfig.Tracer = timing.PgxTracer{}
 	}
-
-	return id, nil
-}
-
-func (r *Repository) UpdateUser(ctx context.Context, id int64, name, email string) error {
-	cmd, err := r.db.Exec(ctx,
-		"UPDATE users SET name=$1, email=$2 WHERE id=$3",
-		name, email, id,
-	)
-	if err != nil {
-		return err
+	if appCfg.DBMaxConns > 0 {
+		poolCfg.MaxConns = appCfg.DBMaxConns
 	}
-
-	if cmd.RowsAffected() == 0 {
-		return errors.New("no rows updated")
+	if appCfg.DBMinConns > 0 {
+		poolCfg.MinConns = appCfg.DBMinConns
 	}
 
-	return nil
-}
-
-func (r *Repository) DeleteUser(ctx context.Context, id int64) error {
-	cmd, err := r.db.Exec(ctx, "DELETE FROM users WHERE id=$1", id)
-	if err != nil {
-		return err
+	// Tag our sessions so pg_stat_activity (and the dbreport subcommand)
+	// can tell them apart; an application_name in DATABASE_URL wins.
+	if _, ok := poolCfg.ConnConfig.RuntimeParams["application_name"]; !ok {
+		poolCfg.ConnConfig.RuntimeParams["application_name"] = "go-k8s-demo"
 	}
 
-	if cmd.RowsAffected() == 0 {
-		return errors.New("no rows deleted")
+	// Create pgxpool
+	dbpool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create DB pool")
 	}
 
-	return nil
-}
-
-// ---------------------------------------------------------
-// HANDLERS
-// ---------------------------------------------------------
-
-func registerRoutes(r *gin.Engine, repo *Repository) {
-
-	r.GET("/healthz", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
-	})
-
-	r.GET("/readyz", func(c *gin.Context) {
-		// Simple readiness probe that checks DB connectivity.
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		defer cancel()
-
-		if err := repo.db.Ping(ctx); err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false})
+	// Verify DB connectivity, waiting for a database that is still starting.
+	if err := pingWithRetry(startup, dbpool, appCfg.DBConnectRetries, appCfg.DBConnectMaxWait); err != nil {
+		if startup.Err() != nil {
+			log.Info().Msg("Interrupted while connecting to the database")
 			return
 		}
+		log.Fatal().Err(err).Msg("database not reachable")
+	}
 
-		c.JSON(http.StatusOK, gin.H{"ready": true})
-	})
+	log.Info().Msg("Connected to Postgres")
 
-	r.GET("/users", func(c *gin.Context) {
-		users, err := repo.GetAllUsers(c.Request.Context())
-		if err != nil {
-			log.Error().Err(err).Msg("failed to get users")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
-			return
-		}
-		c.JSON(http.StatusOK, users)
-	})
+	reg := metrics.NewRegistry()
+	metrics.RegisterPool(reg, dbpool.Stat)
+	if masker != nil {
+		masker.RegisterMetrics(reg)
+	}
 
-	r.GET("/users/:id", func(c *gin.Context) {
-		id, err := parseIDParam(c)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
-			return
-		}
+	// Every multi-row query is capped at MAX_QUERY_ROWS rows. Transactions
+	// slower than SLOW_TX_WARN are logged, with the sessions they block once
+	// they pass SLOW_TX_SNAPSHOT.
+	repo := repository.New(dbpool,
+		repository.WithMaxRows(appCfg.MaxQueryRows),
+		repository.WithSlowTx(appCfg.SlowTxWarn, appCfg.SlowTxSnapshot),
+		repository.WithMetrics(reg),
+	)
 
-		u, err := repo.GetUserByID(c.Request.Context(), id)
+	// "server dbreport [-format text|json] [-timeout 30s]" prints database
+	// statistics for a performance ticket and exits without serving.
+	if len(os.Args) > 1 && os.Args[1] == "dbreport" {
+		err := runDBReport(startup, repo, os.Args[2:])
+		dbpool.Close()
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, u)
-	})
-
-	r.POST("/users", func(c *gin.Context) {
-		var payload struct {
-			Name  string `json:"name" binding:"required"`
-			Email string `json:"email" binding:"required,email"`
-		}
-
-		if err := c.ShouldBindJSON(&payload); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
-			return
+			log.Fatal().Err(err).Msg("database report failed")
 		}
+		return
+	}
 
-		id, err := repo.CreateUser(c.Request.Context(), payload.Name, payload.Email)
+	// "server rollback -to <version>" undoes the migrations above version
+	// and exits; a server still running the newer schema must be stopped.
+	if len(os.Args) > 1 && os.Args[1] == "rollback" {
+		err := runRollback(startup, dbpool, os.Args[2:])
+		dbpool.Close()
 		if err != nil {
-			log.Error().Err(err).Msg("failed to create user")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
-			return
+			log.Fatal().Err(err).Msg("database rollback failed")
 		}
+		return
+	}
 
-		c.JSON(http.StatusCreated, gin.H{"id": id})
-	})
-
-	r.PUT("/users/:id", func(c *gin.Context) {
-		id, err := parseIDParam(c)
+	// "server migrate status [-format text|json]" reports the schema
+	// version and pending migrations without applying them, and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(startup, dbpool, os.Args[2:])
+		dbpool.Close()
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
-			return
-		}
-
-		var payload struct {
-			Name  string `json:"name" binding:"required"`
-			Email string `json:"email" binding:"required,email"`
+			log.Fatal().Err(err).Msg("migration status failed")
 		}
+		return
+	}
 
-		if err := c.ShouldBindJSON(&payload); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
-			return
-		}
-
-		if err := repo.UpdateUser(c.Request.Context(), id, payload.Name, payload.Email); err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"updated": true})
-	})
-
-	r.DELETE("/users/:id", func(c *gin.Context) {
-		id, err := parseIDParam(c)
+	// RUN_MIGRATIONS=true applies the embedded migrations before serving,
+	// replacing the Flyway Job; either way /readyz reports the version.
+	// Replicas that find another one migrating wait for it, up to
+	// MIGRATION_WAIT_TIMEOUT.
+	var schemaVersion int
+	if appCfg.RunMigrations {
+		schemaVersion, err = migrate.Up(startup, dbpool, migrations.FS, migrate.WithWaitTimeout(appCfg.MigrationWaitTimeout))
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
-			return
+			if startup.Err() != nil {
+				log.Info().Msg("Interrupted while migrating the database")
+				return
+			}
+			if errors.Is(err, migrate.ErrWaitTimeout) {
+				log.Fatal().Err(err).Dur("timeout", appCfg.MigrationWaitTimeout).Msg("another replica is still migrating the database")
+			}
+			log.Fatal().Err(err).Msg("database migration failed")
 		}
-
-		if err := repo.DeleteUser(c.Request.Context(), id); err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
-		}
-
-		c.JSON(http.StatusOK, gin.H{"deleted": true})
-	})
-}
-
-func parseIDParam(c *gin.Context) (int64, error) {
-	return strconv.ParseInt(c.Param("id"), 10, 64)
-}
-
-// ---------------------------------------------------------
-// MAIN ENTRYPOINT
-// ---------------------------------------------------------
-
-func main() {
-	// Zerolog pretty print for local dev, JSON in containers
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal().Msg("DATABASE_URL is required")
+		log.Info().Int("version", schemaVersion).Msg("Database schema is up to date")
+	} else if schemaVersion, err = migrate.Version(startup, dbpool); err != nil {
+		log.Warn().Err(err).Msg("could not read the schema version")
 	}
 
-	ctx := context.Background()
-
-	// Create pgxpool
-	dbpool, err := pgxpool.New(ctx, dbURL)
+	// ENVIRONMENT picks the feature preset; see internal/features.
+	feats, err := features.Resolve(os.Getenv)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to create DB pool")
+		log.Fatal().Err(err).Msg("invalid feature configuration")
 	}
+	log.Info().Str("environment", feats.Environment).Str("features", feats.String()).Strs("overridden", feats.Overridden).Msg("Resolved features")
 
-	// Verify DB connectivity
-	if err := dbpool.Ping(ctx); err != nil {
-		log.Fatal().Err(err).Msg("database not reachable")
+	if feats.On(features.GinDebug) {
+		gin.SetMode(gin.DebugMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
 	}
 
-	log.Info().Msg("Connected to Postgres")
-
-	repo := NewRepository(dbpool)
-
-	// Gin in release mode by default
-	gin.SetMode(gin.ReleaseMode)
-	router := gin.New()
-
-	// Logging middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	t := appCfg.Tunables
+	cfg := server.Config{
+		Addr:              appCfg.Addr(),
+		ManagementAddr:    appCfg.ManagementAddr(), // "" with MANAGEMENT_ON_MAIN_PORT=true
+		ReadHeaderTimeout: appCfg.ReadHeaderTimeout,
+		ReadTimeout:       appCfg.ReadTimeout,
+		WriteTimeout:      appCfg.WriteTimeout,
+		IdleTimeout:       appCfg.IdleTimeout,
+		ReadinessTimeout:  appCfg.ReadinessTimeout,
+		SchemaVersion:     schemaVersion,
+
+		BasePath:             t.BasePath,
+		TrustForwardedPrefix: t.TrustForwardedPrefix,
+		LegacyRoutes:         t.LegacyRoutes,
+
+		ListCacheTTL:      t.ListCacheTTL,
+		ListCacheMaxBytes: t.ListCacheMaxBytes,
+
+		TableCheckInterval: t.TableCheckInterval,
+		TableRowsWarn:      t.TableRowsWarn,
+		TableBytesWarn:     t.TableBytesWarn,
+		TableRowsHardCap:   t.TableRowsHardCap,
+
+		ClockSkewInterval:  t.ClockSkewInterval,
+		ClockSkewThreshold: t.ClockSkewThreshold,
+
+		StrictRowLimit: t.StrictRowLimit,
+		MaxUserID:      t.MaxUserID,
+		MaxBodyBytes:   t.MaxBodyBytes,
+
+		TrustedProxies:        t.TrustedProxies,
+		CORSAllowedOrigins:    t.CORSAllowedOrigins,
+		CORSAllowedMethods:    t.CORSAllowedMethods,
+		CORSAllowedHeaders:    t.CORSAllowedHeaders,
+		CORSMaxAge:            t.CORSMaxAge,
+		CORSAllowCredentials:  t.CORSAllowCredentials,
+		RateLimitRPS:          t.RateLimitRPS,
+		RateLimitBurst:        t.RateLimitBurst,
+		RateLimitSyncInterval: t.RateLimitSyncInterval,
+
+		RequestTimeout: t.RequestTimeout,
+		DeadlineHeader: t.DeadlineHeader,
+		DeadlineMin:    t.DeadlineMin,
+		DeadlineMax:    t.DeadlineMax,
+
+		ViewFlushInterval: t.ViewFlushInterval,
+		ViewBatchSize:     t.ViewBatchSize,
+
+		ServerTiming: t.ServerTiming,
+
+		IdempotencyKeyTTL: t.IdempotencyKeyTTL,
+		DuplicateWindow:   t.DuplicateWindow,
+		StrictIdempotency: t.StrictIdempotency,
+		RetryHeader:       t.RetryHeader,
+		RequireIfMatch:    t.RequireIfMatch,
+
+		ScalingConcurrency:    int64(appCfg.ScalingConcurrency),
+		ScalingWeightInFlight: t.ScalingWeightInFlight,
+		ScalingWeightPool:     t.ScalingWeightPool,
+
+		JournalPath:         t.JournalPath,
+		JournalMaxBytes:     t.JournalMaxBytes,
+		JournalSync:         t.JournalSync,
+		JournalSyncInterval: t.JournalSyncInterval,
+
+		Features: feats,
+		Process:  appCfg.Effective(),
+		Docs:     feats.On(features.Docs),
+		DemoUI:   feats.On(features.DemoUI),
+
+		BrownoutHigh:   t.BrownoutHigh,
+		BrownoutLow:    t.BrownoutLow,
+		BrownoutWindow: t.BrownoutWindow,
+		MemoryHeadroom: t.MemoryHeadroom,
+
+		// Without a token key source and API keys the Auth routes are open.
+		JWTSecret:   t.JWTSecret,
+		JWKSURL:     t.JWKSURL,
+		JWKSRefresh: t.JWKSRefresh,
+		JWTKeysFile: t.JWTKeysFile,
+		JWTKeyGrace: t.JWTKeyGrace,
+		JWTIssuer:   t.JWTIssuer,
+		JWTAudience: t.JWTAudience,
+		JWTLeeway:   t.JWTLeeway,
+		APIKeys:     t.APIKeys,
+		APIKeysFile: t.APIKeysFile,
+
+		JWTLeewayFromSkew: t.JWTLeewayFromSkew,
+		JWTLeewaySkewCap:  t.JWTLeewaySkewCap,
+
+		ShareLinkSecret:     t.ShareLinkSecret,
+		ShareLinkDefaultTTL: t.ShareLinkDefaultTTL,
+		ShareLinkMaxTTL:     t.ShareLinkMaxTTL,
+
+		RetentionInterval:  t.RetentionInterval,
+		ShareLinkRetention: t.ShareLinkRetention,
+		RetentionBatchSize: t.RetentionBatchSize,
+
+		ReadOnlyMode:          t.ReadOnlyMode,
+		ReadOnlyProbeInterval: t.ReadOnlyProbeInterval,
+		WorkerStaleFactor:     t.WorkerStaleFactor,
+
+		ProbeLogSample:      t.ProbeLogSample,
+		ProbeLogDebug:       t.ProbeLogDebug,
+		ProbeFailureHistory: t.ProbeFailureHistory,
+	}
 
-	registerRoutes(router, repo)
+	srv, err := server.New(cfg, server.WithRepository(repo), server.WithMetrics(reg))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to build server")
+	}
 
-	// Server with graceful shutdown
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: router,
+	stopStartup()
+	// pprof, expvar and the runtime log level on localhost:ADMIN_PORT.
+	adminSrv := startAdmin(appCfg, dbpool)
+	var adminErr <-chan error
+	if adminSrv != nil {
+		adminErr = adminSrv.Err()
+	}
+	if err := srv.Start(); err != nil {
+		log.Fatal().Err(err).Msg("failed to start server")
 	}
 
+	// SIGHUP re-reads API_KEYS_FILE and JWT_KEYS_FILE (or refetches the
+	// JWKS) after a key rotation.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		log.Info().Msg("Server starting on :8080")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal().Err(err).Msg("server crashed")
+		for range hup {
+			if err := srv.ReloadAPIKeys(); err != nil {
+				log.Error().Err(err).Msg("failed to reload API keys; keeping the previous ones")
+			}
+			if err := srv.ReloadJWTKeys(); err != nil {
+				log.Error().Err(err).Msg("failed to reload JWT keys; keeping the previous ones")
+			}
 		}
 	}()
 
 	// Wait for SIGINT/SIGTERM
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
-	<-quit
+	select {
+	case <-quit:
+	case err := <-srv.Err():
+		log.Fatal().Err(err).Msg("server crashed")
+	case err := <-adminErr:
+		log.Fatal().Err(err).Msg("admin server crashed")
+	}
+
+	// Fail readiness first and keep serving while endpoints catch up; a
+	// second signal skips the rest of the drain.
+	srv.Drain()
+	if appCfg.ShutdownDrain > 0 {
+		log.Info().Dur("drain", appCfg.ShutdownDrain).Msg("Draining before shutdown...")
+		select {
+		case <-time.After(appCfg.ShutdownDrain):
+		case <-quit:
+		}
+	}
 
 	log.Info().Msg("Shutting down server...")
 
-	// Graceful shutdown
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Graceful shutdown; the pool is closed only once it has returned.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), appCfg.ShutdownTimeout)
 	defer cancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("server forced to shutdown")
 	}
+	// The admin server goes last, so a hanging shutdown can be profiled.
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("admin server forced to shutdown")
+		}
+	}
 
 	dbpool.Close()
 	log.Info().Msg("Server exited cleanly")
 }
+
+// pingWithRetry pings the database, retrying up to retries times with
+// exponential backoff from 500ms capped at maxWait, each delay jittered
+// down by up to half so restarted replicas don't retry in lockstep.
+func pingWithRetry(ctx context.Context, pool interface{ Ping(context.Context) error }, retries int, maxWait time.Duration) error {
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := pool.Ping(ctx)
+		if err == nil || attempt > retries || ctx.Err() != nil {
+			return err
+		}
+
+		wait := min(delay, maxWait)
+		wait -= rand.N(wait / 2)
+		log.Warn().Err(err).Int("attempt", attempt).Int("retries", retries).Dur("retry_in", wait).Msg("database not reachable yet")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
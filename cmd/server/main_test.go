@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/config"
+	"go-k8s-demo/internal/migrate"
+)
+
+// flakyPinger fails the first p.failures pings, then succeeds.
+type flakyPinger struct {
+	failures int
+	calls    int
+}
+
+func (p *flakyPinger) Ping(ctx context.Context) error {
+	p.calls++
+	if p.calls <= p.failures {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestPingWithRetry(t *testing.T) {
+	ctx := context.Background()
+
+	p := &flakyPinger{failures: 2}
+	if err := pingWithRetry(ctx, p, 3, time.Millisecond); err != nil || p.calls != 3 {
+		t.Errorf("recovering database: %v after %d pings, want success on the third", err, p.calls)
+	}
+
+	p = &flakyPinger{failures: 100}
+	if err := pingWithRetry(ctx, p, 2, time.Millisecond); err == nil || p.calls != 3 {
+		t.Errorf("unreachable database: %v after %d pings, want an error after 3", err, p.calls)
+	}
+
+	p = &flakyPinger{failures: 100}
+	if err := pingWithRetry(ctx, p, 0, time.Millisecond); err == nil || p.calls != 1 {
+		t.Errorf("no retries: %v after %d pings", err, p.calls)
+	}
+
+	// A signal while waiting ends the retries at once.
+	p = &flakyPinger{failures: 100}
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := pingWithRetry(cancelled, p, 10, time.Hour); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("interrupted: %v after %v", err, time.Since(start))
+	}
+}
+
+func TestStartAdminDisabled(t *testing.T) {
+	// ADMIN_PORT=0 starts nothing and needs no pool.
+	if a := startAdmin(config.Config{AdminPort: 0}, nil); a != nil {
+		t.Errorf("admin server started on %s with ADMIN_PORT=0", a.Addr())
+	}
+}
+
+func TestWriteMigrationStatus(t *testing.T) {
+	var b strings.Builder
+	st := &migrate.Status{
+		Version:   9,
+		Latest:    11,
+		Pending:   []migrate.Pending{{Version: 10, Name: "V10__a.sql"}, {Version: 11, Name: "V11__b.sql"}},
+		Migrating: &migrate.Progress{PID: 42, Applying: 10, ElapsedSeconds: 3.5},
+	}
+	if err := writeMigrationStatus(&b, st); err != nil {
+		t.Fatal(err)
+	}
+	want := "Schema version 9, latest 11\nPending  V10__a.sql\nPending  V11__b.sql\nSession 42 holds the migration lock, applying version 10 (3.5s in its transaction)\n"
+	if b.String() != want {
+		t.Errorf("got\n%swant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := writeMigrationStatus(&b, &migrate.Status{Version: 11, Latest: 11}); err != nil || b.String() != "Schema version 11, latest 11\nNothing pending\n" {
+		t.Errorf("up to date: %q, %v", b.String(), err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"go-k8s-demo/internal/migrate"
+	"go-k8s-demo/migrations"
+)
+
+// runMigrate implements the migrate subcommand. "migrate status" prints
+// the schema version, the embedded migrations the database lacks and
+// the progress of a migration under way, without applying anything.
+func runMigrate(ctx context.Context, pool *pgxpool.Pool, args []string) error {
+	if len(args) == 0 || args[0] != "status" {
+		return errors.New("usage: migrate status [-format text|json]")
+	}
+	fs := flag.NewFlagSet("migrate status", flag.ContinueOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("-format must be text or json, got %q", *format)
+	}
+
+	st, err := migrate.Inspect(ctx, pool, migrations.FS)
+	if err != nil {
+		return err
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+	return writeMigrationStatus(os.Stdout, st)
+}
+
+func writeMigrationStatus(w io.Writer, st *migrate.Status) error {
+	if _, err := fmt.Fprintf(w, "Schema version %d, latest %d\n", st.Version, st.Latest); err != nil {
+		return err
+	}
+	if len(st.Pending) == 0 {
+		fmt.Fprintln(w, "Nothing pending")
+	}
+	for _, p := range st.Pending {
+		fmt.Fprintf(w, "Pending  %s\n", p.Name)
+	}
+	if m := st.Migrating; m != nil {
+		fmt.Fprintf(w, "Session %d holds the migration lock", m.PID)
+		if m.Applying > 0 {
+			fmt.Fprintf(w, ", applying version %d", m.Applying)
+		}
+		fmt.Fprintf(w, " (%.1fs in its transaction)\n", m.ElapsedSeconds)
+	}
+	return nil
+}
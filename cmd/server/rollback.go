@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+
+	"go-k8s-demo/internal/migrate"
+	"go-k8s-demo/migrations"
+)
+
+// runRollback implements the rollback subcommand: undo the embedded
+// migrations above -to and return.
+func runRollback(ctx context.Context, pool *pgxpool.Pool, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	to := fs.Int("to", -1, "schema version to roll back to; 0 undoes every migration")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *to < 0 {
+		return errors.New("-to is required")
+	}
+	version, err := migrate.Down(ctx, pool, migrations.FS, *to)
+	log.Info().Int("version", version).Msg("Database schema version after rollback")
+	return err
+}
@@ -27,9 +27,9 @@ echo "Waiting for Postgres to be ready (this may take a few minutes on first run
 kubectl wait --for=condition=ready pod -l app=postgres -n go-k8s-demo --timeout=180s
 
 echo "Running database migrations..."
-# Create ConfigMap from actual migration files
+# Create ConfigMap from actual migration files (the SQL only, not embed.go)
 kubectl delete configmap flyway-migrations -n go-k8s-demo --ignore-not-found
-kubectl create configmap flyway-migrations -n go-k8s-demo --from-file=migrations/
+kubectl create configmap flyway-migrations -n go-k8s-demo $(for f in migrations/*.sql; do printf -- '--from-file=%s ' "$f"; done)
 
 kubectl delete job flyway-migration -n go-k8s-demo --ignore-not-found
 kubectl apply -f k8s/flyway-job.yaml
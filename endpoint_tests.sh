@@ -16,6 +16,9 @@ echo ""
 echo -e "${YELLOW}🔌 Starting port-forward to API service...${NC}"
 kubectl port-forward -n go-k8s-demo service/api 8080:80 > /dev/null 2>&1 &
 PORT_FORWARD_PID=$!
+# Probes live on the management port, which the Service does not expose.
+kubectl port-forward -n go-k8s-demo deploy/api 9090 > /dev/null 2>&1 &
+MGMT_FORWARD_PID=$!
 
 # Function to cleanup port-forward on exit
 cleanup() {
@@ -23,6 +26,7 @@ cleanup() {
         echo ""
         echo -e "${YELLOW}🛑 Stopping port-forward...${NC}"
         kill $PORT_FORWARD_PID 2>/dev/null
+        kill $MGMT_FORWARD_PID 2>/dev/null
     fi
 }
 trap cleanup EXIT
@@ -30,7 +34,7 @@ trap cleanup EXIT
 # Wait for port-forward to be ready
 echo -e "${YELLOW}⏳ Waiting for port-forward to be ready...${NC}"
 for i in {1..10}; do
-    if curl -s http://localhost:8080/healthz > /dev/null 2>&1; then
+    if curl -s http://localhost:9090/healthz > /dev/null 2>&1 && curl -s http://localhost:8080/api/v1/errors > /dev/null 2>&1; then
         echo -e "${GREEN}✅ Port-forward ready!${NC}"
         echo ""
         break
@@ -54,7 +58,7 @@ fi
 
 # 1. Health Check
 echo -e "${BLUE}[1] Testing /healthz${NC}"
-RESPONSE=$(curl -s http://localhost:8080/healthz)
+RESPONSE=$(curl -s http://localhost:9090/healthz)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -65,7 +69,7 @@ echo ""
 
 # 2. Readiness Check
 echo -e "${BLUE}[2] Testing /readyz${NC}"
-RESPONSE=$(curl -s http://localhost:8080/readyz)
+RESPONSE=$(curl -s http://localhost:9090/readyz)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -76,7 +80,7 @@ echo ""
 
 # 3. Get all users
 echo -e "${BLUE}[3] GET /users - List all users${NC}"
-RESPONSE=$(curl -s http://localhost:8080/users)
+RESPONSE=$(curl -s http://localhost:8080/api/v1/users)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -87,7 +91,7 @@ echo ""
 
 # 4. Get specific user
 echo -e "${BLUE}[4] GET /users/1 - Get specific user${NC}"
-RESPONSE=$(curl -s http://localhost:8080/users/1)
+RESPONSE=$(curl -s http://localhost:8080/api/v1/users/1)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -99,7 +103,7 @@ echo ""
 # 5. Create new user
 echo -e "${BLUE}[5] POST /users - Create new user${NC}"
 echo -e "${YELLOW}Request body: {\"name\":\"Charlie\",\"email\":\"charlie@example.com\"}${NC}"
-RESPONSE=$(curl -s -X POST http://localhost:8080/users \
+RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/users \
   -H "Content-Type: application/json" \
   -d '{"name":"Charlie","email":"charlie@example.com"}')
 if [ "$JQ_AVAILABLE" = true ]; then
@@ -112,7 +116,7 @@ echo ""
 
 # 6. Get all users after creation
 echo -e "${BLUE}[6] GET /users - Verify user was created${NC}"
-RESPONSE=$(curl -s http://localhost:8080/users)
+RESPONSE=$(curl -s http://localhost:8080/api/v1/users)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -124,7 +128,7 @@ echo ""
 # 7. Update user
 echo -e "${BLUE}[7] PUT /users/3 - Update user${NC}"
 echo -e "${YELLOW}Request body: {\"name\":\"Charles\",\"email\":\"charles@example.com\"}${NC}"
-RESPONSE=$(curl -s -X PUT http://localhost:8080/users/3 \
+RESPONSE=$(curl -s -X PUT http://localhost:8080/api/v1/users/3 \
   -H "Content-Type: application/json" \
   -d '{"name":"Charles","email":"charles@example.com"}')
 if [ "$JQ_AVAILABLE" = true ]; then
@@ -137,7 +141,7 @@ echo ""
 
 # 8. Get all users after update
 echo -e "${BLUE}[8] GET /users - Verify user was updated${NC}"
-RESPONSE=$(curl -s http://localhost:8080/users)
+RESPONSE=$(curl -s http://localhost:8080/api/v1/users)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -148,7 +152,7 @@ echo ""
 
 # 9. Delete user
 echo -e "${BLUE}[9] DELETE /users/3 - Delete user${NC}"
-RESPONSE=$(curl -s -X DELETE http://localhost:8080/users/3)
+RESPONSE=$(curl -s -X DELETE http://localhost:8080/api/v1/users/3)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
@@ -159,7 +163,7 @@ echo ""
 
 # 10. Get all users after deletion
 echo -e "${BLUE}[10] GET /users - Verify user was deleted${NC}"
-RESPONSE=$(curl -s http://localhost:8080/users)
+RESPONSE=$(curl -s http://localhost:8080/api/v1/users)
 if [ "$JQ_AVAILABLE" = true ]; then
     echo "$RESPONSE" | jq '.'
 else
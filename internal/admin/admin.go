@@ -0,0 +1,138 @@
+// Package admin is the debug listener: net/http/pprof profiles, expvar
+// variables and the runtime log level. It is a plain net/http server on
+// a port of its own, apart from the API router, so debugging requests
+// skip its middleware (access log, auth, rate limits) and a wedged API
+// can still be profiled.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Server serves the debug endpoints.
+type Server struct {
+	log      zerolog.Logger
+	srv      *http.Server
+	listener net.Listener
+	errc     chan error
+}
+
+// New builds the debug server for addr.
+func New(addr string, log zerolog.Logger) *Server {
+	s := &Server{log: log, errc: make(chan error, 1)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("GET /debug/loglevel", s.getLogLevel)
+	mux.HandleFunc("PUT /debug/loglevel", s.setLogLevel)
+
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		// No WriteTimeout: CPU profiles and traces stream for as long as
+		// their seconds parameter asks.
+		IdleTimeout: time.Minute,
+	}
+	return s
+}
+
+// Addr returns the bound address once Start has succeeded.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.srv.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+// Start binds the listener and serves in the background. Bind errors are
+// returned; later serve errors arrive on Err.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+
+	go func() {
+		s.log.Info().Str("addr", s.Addr()).Msg("Admin server starting")
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.errc <- err
+		}
+	}()
+	return nil
+}
+
+// Err delivers the error of a server that stopped serving on its own.
+func (s *Server) Err() <-chan error {
+	return s.errc
+}
+
+// Shutdown stops the server, waiting within ctx for running requests; a
+// CPU profile still streaming is cut off when ctx ends.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = s.srv.Close()
+	}
+	return err
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevel{Level: zerolog.GlobalLevel().String()})
+}
+
+// setLogLevel changes the process-wide level until the next change or
+// restart; LOG_LEVEL applies again after a restart.
+func (s *Server) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevel
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": `body must be {"level": "debug"}`})
+		return
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(strings.TrimSpace(req.Level)))
+	if err != nil || level == zerolog.NoLevel {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "level must be one of trace, debug, info, warn, error, fatal, panic or disabled"})
+		return
+	}
+
+	// The change is logged under the more verbose of the two levels, so
+	// it shows up whichever way it went.
+	previous := zerolog.GlobalLevel()
+	logChange := func() {
+		s.log.Info().Str("from", previous.String()).Str("to", level.String()).Msg("Log level changed")
+	}
+	if level > previous {
+		logChange()
+		zerolog.SetGlobalLevel(level)
+	} else {
+		zerolog.SetGlobalLevel(level)
+		logChange()
+	}
+	writeJSON(w, http.StatusOK, logLevel{Level: level.String()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func startServer(t *testing.T) (*Server, *bytes.Buffer) {
+	t.Helper()
+	var logs bytes.Buffer
+	s := New("127.0.0.1:0", zerolog.New(&logs))
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	prev := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(prev) })
+	return s, &logs
+}
+
+func call(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	s, _ := startServer(t)
+	base := "http://" + s.Addr()
+
+	for path, want := range map[string]string{
+		"/debug/pprof/":                  "goroutine",
+		"/debug/pprof/goroutine?debug=1": "goroutine profile",
+		"/debug/pprof/cmdline":           "admin.test",
+		"/debug/vars":                    `"memstats"`,
+		"/debug/pprof/heap?debug=1":      "heap profile",
+		"/debug/pprof/symbol?0x0":        "num_symbols",
+		"/debug/pprof/trace?seconds=0.1": "",
+		"/debug/loglevel":                `"level"`,
+	} {
+		code, body := call(t, http.MethodGet, base+path, "")
+		if code != http.StatusOK || !strings.Contains(body, want) {
+			t.Errorf("GET %s: %d %.80q, want %q", path, code, body, want)
+		}
+	}
+	if code, _ := call(t, http.MethodGet, base+"/api/v1/users", ""); code != http.StatusNotFound {
+		t.Errorf("API route on the admin listener: %d", code)
+	}
+	if code, _ := call(t, http.MethodPost, base+"/debug/vars", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /debug/vars: %d", code)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	s, logs := startServer(t)
+	url := "http://" + s.Addr() + "/debug/loglevel"
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	code, body := call(t, http.MethodPut, url, `{"level":" DEBUG "}`)
+	if code != http.StatusOK || zerolog.GlobalLevel() != zerolog.DebugLevel {
+		t.Fatalf("PUT debug: %d %s, level %s", code, body, zerolog.GlobalLevel())
+	}
+	var got logLevel
+	_, body = call(t, http.MethodGet, url, "")
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.Level != "debug" {
+		t.Errorf("GET after PUT: %s", body)
+	}
+
+	// Both directions are logged: going quieter logs before the change.
+	if code, _ := call(t, http.MethodPut, url, `{"level":"error"}`); code != http.StatusOK {
+		t.Fatalf("PUT error: %d", code)
+	}
+	for _, want := range []string{`"from":"info","to":"debug"`, `"from":"debug","to":"error"`} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs lack %s:\n%s", want, logs)
+		}
+	}
+
+	for _, bad := range []string{`{"level":"verbose"}`, `{"level":""}`, `debug`, `{"level":"` + strings.Repeat("x", 2<<10) + `"}`} {
+		if code, _ := call(t, http.MethodPut, url, bad); code != http.StatusBadRequest {
+			t.Errorf("PUT %.30s: %d, want 400", bad, code)
+		}
+	}
+	if zerolog.GlobalLevel() != zerolog.ErrorLevel {
+		t.Errorf("a rejected PUT changed the level to %s", zerolog.GlobalLevel())
+	}
+	// Debug requests themselves are not logged.
+	if strings.Contains(logs.String(), "/debug/") {
+		t.Errorf("requests logged:\n%s", logs)
+	}
+}
+
+func TestStartAndShutdown(t *testing.T) {
+	s, _ := startServer(t)
+	if taken := New(s.Addr(), zerolog.Nop()); taken.Start() == nil {
+		taken.Shutdown(context.Background())
+		t.Fatal("second Start on a bound address succeeded")
+	}
+
+	// A streaming CPU profile is cut off when the shutdown deadline ends.
+	done := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://" + s.Addr() + "/debug/pprof/profile?seconds=30")
+		if err == nil {
+			_, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("profile still running after shutdown")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("shutdown took %s", d)
+	}
+	if _, err := http.Get("http://" + s.Addr() + "/debug/vars"); err == nil {
+		t.Error("still serving after shutdown")
+	}
+	select {
+	case err := <-s.Err():
+		t.Errorf("shutdown reported as a serve error: %v", err)
+	default:
+	}
+}
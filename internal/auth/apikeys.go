@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// APIKeys holds the accepted API keys as SHA-256 hashes, so neither the
+// environment nor the mounted file contains a usable credential. Entries
+// are "name:hex-sha256", separated by commas or newlines; in a file,
+// lines starting with # are comments. A hash is made with
+//
+//	printf %s "$KEY" | sha256sum
+type APIKeys struct {
+	inline string
+	path   string
+
+	mu   sync.RWMutex
+	keys []apiKey
+}
+
+type apiKey struct {
+	name string
+	hash []byte
+}
+
+// LoadAPIKeys parses inline (e.g. the API_KEYS variable) and the file at
+// path; either may be empty. The file is read again by Reload.
+func LoadAPIKeys(inline, path string) (*APIKeys, error) {
+	k := &APIKeys{inline: inline, path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads the key file, e.g. on SIGHUP after a rotation. On error
+// the previous keys stay in effect.
+func (k *APIKeys) Reload() error {
+	text := k.inline
+	if k.path != "" {
+		data, err := os.ReadFile(k.path)
+		if err != nil {
+			return fmt.Errorf("auth: read API keys: %w", err)
+		}
+		text += "\n" + string(data)
+	}
+	keys, err := parseAPIKeys(text)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+func parseAPIKeys(text string) ([]apiKey, error) {
+	var (
+		keys []apiKey
+		errs []error
+		seen = make(map[string]bool)
+	)
+	sc := bufio.NewScanner(strings.NewReader(strings.ReplaceAll(text, ",", "\n")))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, digest, ok := strings.Cut(line, ":")
+		name, digest = strings.TrimSpace(name), strings.TrimSpace(digest)
+		hash, err := hex.DecodeString(digest)
+		switch {
+		case !ok || name == "":
+			errs = append(errs, fmt.Errorf("auth: API key entry %q is not name:sha256", line))
+			continue
+		case err != nil || len(hash) != sha256.Size:
+			errs = append(errs, fmt.Errorf("auth: API key %q: the hash must be 64 hex digits of SHA-256", name))
+			continue
+		case seen[name]:
+			errs = append(errs, fmt.Errorf("auth: API key %q is listed twice", name))
+			continue
+		}
+		seen[name] = true
+		keys = append(keys, apiKey{name: name, hash: hash})
+	}
+	return keys, errors.Join(errs...)
+}
+
+// Len is the number of accepted keys.
+func (k *APIKeys) Len() int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.keys)
+}
+
+// Match returns the name of the key presented. Every hash is compared in
+// constant time and none is skipped, so timing reveals neither the key
+// nor which entry matched.
+func (k *APIKeys) Match(presented string) (name string, ok bool) {
+	sum := sha256.Sum256([]byte(presented))
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if subtle.ConstantTimeCompare(sum[:], key.hash) == 1 {
+			name, ok = key.name, true
+		}
+	}
+	return name, ok
+}
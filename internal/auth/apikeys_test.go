@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func keyEntry(name, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return name + ":" + hex.EncodeToString(sum[:])
+}
+
+func TestAPIKeysMatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	file := "# batch jobs\n" + keyEntry("reports", "r-key") + "\n\n  " + keyEntry("export", "e-key") + "  \n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadAPIKeys(keyEntry("ci", "c-key")+" , "+keyEntry("ops", "o-key"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys.Len() != 4 {
+		t.Errorf("Len = %d, want 4", keys.Len())
+	}
+	for presented, want := range map[string]string{"c-key": "ci", "o-key": "ops", "r-key": "reports", "e-key": "export"} {
+		if name, ok := keys.Match(presented); !ok || name != want {
+			t.Errorf("Match(%q) = %q, %v; want %q", presented, name, ok, want)
+		}
+	}
+	// The hash itself is not a key.
+	sum := sha256.Sum256([]byte("c-key"))
+	for _, presented := range []string{"", "C-KEY", "c-key ", hex.EncodeToString(sum[:])} {
+		if name, ok := keys.Match(presented); ok {
+			t.Errorf("Match(%q) = %q", presented, name)
+		}
+	}
+}
+
+func TestAPIKeysErrors(t *testing.T) {
+	for inline, want := range map[string]string{
+		"ci":                            `"ci" is not name:sha256`,
+		":" + strings.Repeat("a", 64):   "is not name:sha256",
+		"ci:abc":                        `"ci": the hash must be 64 hex digits`,
+		"ci:" + strings.Repeat("z", 64): "64 hex digits",
+		keyEntry("ci", "a") + "," + keyEntry("ci", "b"): `"ci" is listed twice`,
+	} {
+		if _, err := LoadAPIKeys(inline, ""); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadAPIKeys(%q) = %v, want an error containing %q", inline, err, want)
+		}
+	}
+	// Every bad entry is reported, not only the first.
+	_, err := LoadAPIKeys("a,b:c", "")
+	if err == nil || !strings.Contains(err.Error(), `"a"`) || !strings.Contains(err.Error(), `"b"`) {
+		t.Errorf("two bad entries: %v", err)
+	}
+	if _, err := LoadAPIKeys("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing key file accepted")
+	}
+}
+
+// A rotation swaps keys on Reload; a broken file keeps the previous ones.
+func TestAPIKeysReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	write := func(text string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(keyEntry("batch", "old-key"))
+	keys, err := LoadAPIKeys(keyEntry("ci", "c-key"), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(keyEntry("batch", "new-key"))
+	if err := keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys.Match("old-key"); ok {
+		t.Error("rotated-out key still accepted")
+	}
+	if name, ok := keys.Match("new-key"); !ok || name != "batch" {
+		t.Errorf("new key: %q, %v", name, ok)
+	}
+	if _, ok := keys.Match("c-key"); !ok {
+		t.Error("inline key lost on reload")
+	}
+
+	write("batch:not-a-hash")
+	if err := keys.Reload(); err == nil {
+		t.Error("broken file accepted")
+	}
+	if _, ok := keys.Match("new-key"); !ok || keys.Len() != 2 {
+		t.Errorf("keys after a failed reload: %d", keys.Len())
+	}
+}
@@ -0,0 +1,338 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefetch limits how often an unknown key id refetches the JWKS, so
+// tokens with made-up key ids cannot hammer the identity provider.
+const minRefetch = time.Minute
+
+type publicKey struct {
+	kid    string
+	alg    string
+	kty    string
+	public any // *rsa.PublicKey, *ecdsa.PublicKey, or []byte for HMAC
+	// notAfter ends the key's use: its exp member, or the end of the
+	// grace window once it left the set. Zero means no end.
+	notAfter time.Time
+}
+
+func (k publicKey) usable(now time.Time) bool {
+	return k.notAfter.IsZero() || now.Before(k.notAfter)
+}
+
+// KeyInfo describes a verification key without its material, for
+// GET /admin/auth/keys.
+type KeyInfo struct {
+	ID        string     `json:"kid"`
+	Type      string     `json:"kty"`
+	Algorithm string     `json:"alg,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+	// Retired keys left the set and verify only until NotAfter.
+	Retired bool `json:"retired"`
+}
+
+// keySet caches the keys published at a JWKS URL or kept in a JWK Set
+// file. A key that leaves the set on a refetch or reload stays usable
+// for the grace window, so tokens it signed before a rotation keep
+// verifying until they expire.
+type keySet struct {
+	url     string
+	file    string
+	refresh time.Duration
+	grace   time.Duration
+	client  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]publicKey
+	retired map[string]publicKey
+	fetched time.Time
+}
+
+func newKeySet(url, file string, refresh, grace time.Duration) *keySet {
+	return &keySet{url: url, file: file, refresh: refresh, grace: grace,
+		client: &http.Client{Timeout: 5 * time.Second}, retired: make(map[string]publicKey)}
+}
+
+// get returns the keys a token with key id kid may be signed with: the
+// key with that id, or for an empty kid every usable key. Fetching
+// happens under the lock, so concurrent requests wait for one fetch
+// instead of each starting their own. A key file is only read again by
+// reload.
+func (k *keySet) get(kid string, now time.Time) ([]publicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	age := time.Since(k.fetched)
+	keys := k.lookup(kid, now)
+	if k.keys == nil || (k.url != "" && (age >= k.refresh || (len(keys) == 0 && age >= minRefetch))) {
+		if err := k.load(); err != nil {
+			if k.keys == nil {
+				return nil, fmt.Errorf("%w: %v", ErrKeysUnavailable, err)
+			}
+			// Keep serving the keys we have until the next refresh; an
+			// unknown key id still retries after minRefetch.
+			k.fetched = time.Now()
+		}
+		keys = k.lookup(kid, now)
+	}
+	if len(keys) == 0 {
+		return nil, ErrInvalid
+	}
+	return keys, nil
+}
+
+func (k *keySet) lookup(kid string, now time.Time) []publicKey {
+	if kid != "" {
+		for _, set := range []map[string]publicKey{k.keys, k.retired} {
+			if key, ok := set[kid]; ok && key.usable(now) {
+				return []publicKey{key}
+			}
+		}
+		return nil
+	}
+	var keys []publicKey
+	for _, set := range []map[string]publicKey{k.keys, k.retired} {
+		for _, key := range set {
+			if key.usable(now) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// reload fetches the set now, e.g. after a rotation. On error the
+// previous keys stay in effect.
+func (k *keySet) reload() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.load()
+}
+
+// load replaces the keys with a fresh copy of the set. Keys missing from
+// it are retired for the grace window; retired keys past it are dropped.
+// Called with k.mu held.
+func (k *keySet) load() error {
+	var keys map[string]publicKey
+	var err error
+	if k.file != "" {
+		keys, err = k.read()
+	} else {
+		keys, err = k.fetch()
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for kid, old := range k.keys {
+		if _, ok := keys[kid]; !ok {
+			if end := now.Add(k.grace); old.notAfter.IsZero() || end.Before(old.notAfter) {
+				old.notAfter = end
+			}
+			k.retired[kid] = old
+		}
+	}
+	for kid, old := range k.retired {
+		if _, back := keys[kid]; back || !old.usable(now) {
+			delete(k.retired, kid)
+		}
+	}
+	k.keys = keys
+	k.fetched = now
+	return nil
+}
+
+// list describes the current and retired keys, ordered by key id.
+func (k *keySet) list(now time.Time) []KeyInfo {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var out []KeyInfo
+	for i, set := range []map[string]publicKey{k.keys, k.retired} {
+		for _, key := range set {
+			if !key.usable(now) {
+				continue
+			}
+			info := KeyInfo{ID: key.kid, Type: key.kty, Algorithm: key.alg, Retired: i == 1}
+			if !key.notAfter.IsZero() {
+				t := key.notAfter
+				info.NotAfter = &t
+			}
+			out = append(out, info)
+		}
+	}
+	slices.SortFunc(out, func(a, b KeyInfo) int { return strings.Compare(a.ID, b.ID) })
+	return out
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+	// Exp is not part of RFC 7517: the Unix time after which the key no
+	// longer verifies, for retiring a key on a schedule.
+	Exp int64 `json:"exp"`
+}
+
+// fetch reads the JWKS from the identity provider. It publishes public
+// keys only; symmetric ones are skipped.
+func (k *keySet) fetch() (map[string]publicKey, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", k.url, resp.Status)
+	}
+	keys, _, err := parseKeySet(http.MaxBytesReader(nil, resp.Body, 1<<20), false)
+	return keys, err
+}
+
+// read loads the key file. Unlike a fetched set, the operator wrote it:
+// a key that can't be used is an error rather than skipped, and HMAC
+// ("oct") keys are allowed.
+func (k *keySet) read() (map[string]publicKey, error) {
+	f, err := os.Open(k.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, skipped, err := parseKeySet(io.LimitReader(f, 1<<20), true)
+	if err == nil && len(skipped) > 0 {
+		err = errors.Join(skipped...)
+	}
+	if err == nil && len(keys) == 0 {
+		err = errors.New("no keys")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", k.file, err)
+	}
+	return keys, nil
+}
+
+// parseKeySet decodes a JWK Set. Keys of unsupported types, for
+// encryption or with an alg their curve doesn't sign with are skipped,
+// and reported, rather than failing the whole set.
+func parseKeySet(r io.Reader, symmetric bool) (map[string]publicKey, []error, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, nil, fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	var skipped []error
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		if j.Kty == "oct" && !symmetric {
+			continue
+		}
+		key, err := j.key()
+		if err == nil {
+			if _, dup := keys[j.Kid]; dup {
+				err = errors.New("duplicate key id")
+			}
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Errorf("key %q: %w", j.Kid, err))
+			continue
+		}
+		keys[j.Kid] = key
+	}
+	return keys, skipped, nil
+}
+
+// ecCurves maps each supported curve to the one algorithm that signs
+// with it (RFC 7518, section 3.4).
+var ecCurves = map[string]struct {
+	curve elliptic.Curve
+	alg   string
+}{
+	"P-256": {elliptic.P256(), "ES256"},
+	"P-384": {elliptic.P384(), "ES384"},
+	"P-521": {elliptic.P521(), "ES512"},
+}
+
+// key decodes j. An EC key without alg gets its curve's algorithm, and
+// one whose alg names another curve's is rejected.
+func (j jwk) key() (publicKey, error) {
+	key, err := j.material()
+	key.kid, key.kty = j.Kid, j.Kty
+	if j.Exp != 0 {
+		key.notAfter = time.Unix(j.Exp, 0)
+	}
+	return key, err
+}
+
+func (j jwk) material() (publicKey, error) {
+	enc := base64.RawURLEncoding
+	switch j.Kty {
+	case "oct":
+		secret, err := enc.DecodeString(j.K)
+		if err != nil || len(secret) == 0 {
+			return publicKey{}, fmt.Errorf("invalid HMAC key")
+		}
+		if j.Alg != "" && !strings.HasPrefix(j.Alg, "HS") {
+			return publicKey{}, fmt.Errorf("alg %s does not use an HMAC key", j.Alg)
+		}
+		return publicKey{alg: j.Alg, public: secret}, nil
+	case "RSA":
+		n, err := enc.DecodeString(j.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := enc.DecodeString(j.E)
+		if err != nil || len(e) > 4 {
+			return publicKey{}, fmt.Errorf("invalid RSA exponent")
+		}
+		return publicKey{alg: j.Alg, public: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	case "EC":
+		ec, ok := ecCurves[j.Crv]
+		if !ok {
+			return publicKey{}, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		if j.Alg != "" && j.Alg != ec.alg {
+			return publicKey{}, fmt.Errorf("alg %s does not sign with %s", j.Alg, j.Crv)
+		}
+		x, err := enc.DecodeString(j.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		y, err := enc.DecodeString(j.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: ec.curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ec.curve.IsOnCurve(pub.X, pub.Y) {
+			return publicKey{}, fmt.Errorf("point is not on %s", j.Crv)
+		}
+		return publicKey{alg: ec.alg, public: pub}, nil
+	}
+	return publicKey{}, fmt.Errorf("unsupported key type %q", j.Kty)
+}
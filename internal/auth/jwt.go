@@ -0,0 +1,303 @@
+// Package auth verifies the credentials callers authenticate with: JWT
+// bearer tokens and, for service-to-service callers, API keys.
+//
+// Tokens are signed either with a shared HMAC secret (HS256, HS384,
+// HS512), with keys published at a JWKS URL (RS256, RS384, RS512, ES256,
+// ES384, ES512), or with any of those kept in a JWK Set file. Only the
+// standard library is used; the subset of RFC 7519 and RFC 7515
+// implemented is what an API gateway or identity provider issues for
+// service access.
+//
+// Keys rotate without a restart: a token is checked against the key its
+// kid names, and a key that leaves the JWKS or the file still verifies
+// for a grace window, so tokens issued before the rotation keep working
+// until they expire. The service only verifies; issuers sign with the
+// newest key.
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Errors Verify reports; every other problem with a token is ErrInvalid.
+// ErrKeysUnavailable says nothing about the token: the JWKS could not be
+// fetched.
+var (
+	ErrInvalid         = errors.New("token is malformed or its signature does not match")
+	ErrKeysUnavailable = errors.New("signing keys are unavailable")
+	ErrExpired         = errors.New("token has expired")
+	ErrNotYet          = errors.New("token is not valid yet")
+	ErrAudience        = errors.New("token is for another audience")
+	ErrIssuer          = errors.New("token is from another issuer")
+)
+
+// Config selects how tokens are verified. Exactly one of Secret, JWKSURL
+// and KeysFile is set.
+type Config struct {
+	Secret  string
+	JWKSURL string
+	// JWKSRefresh is how long fetched keys are used before they are
+	// fetched again; an unknown key id refetches sooner.
+	JWKSRefresh time.Duration
+	// KeysFile is a JWK Set of HMAC ("oct"), RSA or EC keys, read at
+	// startup and again by Reload. A key may carry an exp member, the
+	// Unix time it stops verifying.
+	KeysFile string
+	// KeyGrace is how long a key that left the JWKS or KeysFile keeps
+	// verifying; it should be at least the longest token lifetime.
+	KeyGrace time.Duration
+
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway absorbs clock skew when checking exp and nbf.
+	Leeway time.Duration
+	// SkewLeeway, when set, is added to Leeway on every check, for skew
+	// measured while running.
+	SkewLeeway func() time.Duration
+}
+
+// Claims are the registered claims the service reads.
+type Claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+}
+
+// audience is the aud claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Verifier checks tokens against its Config.
+type Verifier struct {
+	cfg    Config
+	secret []byte
+	keys   *keySet
+}
+
+// ErrStaticKey is returned by Reload for a Verifier with a fixed Secret.
+var ErrStaticKey = errors.New("auth: the HMAC secret is fixed; rotate keys with a keys file or JWKS")
+
+// NewVerifier validates cfg and reads KeysFile. JWKS keys are fetched on
+// first use, so a slow identity provider does not hold up startup.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	sources := 0
+	for _, s := range []string{cfg.Secret, cfg.JWKSURL, cfg.KeysFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return nil, errors.New("auth: set only one of a secret, a JWKS URL and a keys file")
+	case sources == 0:
+		return nil, errors.New("auth: a secret, a JWKS URL or a keys file is required")
+	}
+	v := &Verifier{cfg: cfg}
+	if cfg.Secret != "" {
+		v.secret = []byte(cfg.Secret)
+		return v, nil
+	}
+	if cfg.JWKSRefresh <= 0 {
+		cfg.JWKSRefresh = time.Hour
+	}
+	if cfg.KeyGrace <= 0 {
+		cfg.KeyGrace = time.Hour
+	}
+	v.keys = newKeySet(cfg.JWKSURL, cfg.KeysFile, cfg.JWKSRefresh, cfg.KeyGrace)
+	if cfg.KeysFile != "" {
+		if err := v.keys.reload(); err != nil {
+			return nil, fmt.Errorf("auth: read keys: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// Reload reads KeysFile again, or fetches the JWKS now, e.g. after a
+// rotation. Keys that are gone verify for KeyGrace more; on error the
+// previous keys stay in effect.
+func (v *Verifier) Reload() error {
+	if v.keys == nil {
+		return ErrStaticKey
+	}
+	if err := v.keys.reload(); err != nil {
+		return fmt.Errorf("auth: reload keys: %w", err)
+	}
+	return nil
+}
+
+// Keys describes the verification keys in use at now, never their
+// material. A Secret is listed as one HMAC key without an id; a JWKS not
+// fetched yet has no keys.
+func (v *Verifier) Keys(now time.Time) []KeyInfo {
+	if v.keys == nil {
+		return []KeyInfo{{Type: "oct"}}
+	}
+	return v.keys.list(now)
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verify checks the signature before anything else, so a tampered token
+// is reported as invalid even if it also claims to be expired.
+func (v *Verifier) Verify(token string, now time.Time) (Claims, error) {
+	var claims Claims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrInvalid
+	}
+	enc := base64.RawURLEncoding
+	rawHeader, err := enc.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrInvalid
+	}
+	var h header
+	if err := json.Unmarshal(rawHeader, &h); err != nil {
+		return claims, ErrInvalid
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrInvalid
+	}
+	if err := v.checkSignature(h, []byte(parts[0]+"."+parts[1]), sig, now); err != nil {
+		return claims, err
+	}
+
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrInvalid
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalid
+	}
+	return claims, v.checkClaims(claims, now)
+}
+
+func (v *Verifier) checkClaims(c Claims, now time.Time) error {
+	d := v.cfg.Leeway
+	if v.cfg.SkewLeeway != nil {
+		d += v.cfg.SkewLeeway()
+	}
+	leeway := int64(d / time.Second)
+	if c.ExpiresAt == 0 {
+		// Tokens that never expire are not accepted.
+		return ErrInvalid
+	}
+	if now.Unix() >= c.ExpiresAt+leeway {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Unix() < c.NotBefore-leeway {
+		return ErrNotYet
+	}
+	if v.cfg.Issuer != "" && c.Issuer != v.cfg.Issuer {
+		return ErrIssuer
+	}
+	if v.cfg.Audience != "" && !slices.Contains(c.Audience, v.cfg.Audience) {
+		return ErrAudience
+	}
+	return nil
+}
+
+func (v *Verifier) checkSignature(h header, signed, sig []byte, now time.Time) error {
+	hf, ok := hashes[h.Alg[min(len(h.Alg), 2):]]
+	if !ok {
+		return ErrInvalid
+	}
+	if v.secret != nil {
+		if !verifyWith(publicKey{public: v.secret}, h.Alg, hf, signed, sig) {
+			return ErrInvalid
+		}
+		return nil
+	}
+
+	keys, err := v.keys.get(h.Kid, now)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if verifyWith(key, h.Alg, hf, signed, sig) {
+			return nil
+		}
+	}
+	return ErrInvalid
+}
+
+// verifyWith reports whether sig is key's signature of signed under alg.
+func verifyWith(key publicKey, alg string, hf hashFunc, signed, sig []byte) bool {
+	if key.alg != "" && key.alg != alg {
+		return false
+	}
+	family := alg[:2]
+	if secret, ok := key.public.([]byte); ok {
+		if family != "HS" {
+			return false
+		}
+		m := hmac.New(hf.new, secret)
+		m.Write(signed)
+		return hmac.Equal(sig, m.Sum(nil))
+	}
+
+	d := hf.new()
+	d.Write(signed)
+	digest := d.Sum(nil)
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		return family == "RS" && rsa.VerifyPKCS1v15(pub, hf.id, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS carries r and s as fixed-size big-endian halves.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if family != "ES" || len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+type hashFunc struct {
+	id  crypto.Hash
+	new func() hash.Hash
+}
+
+// hashes is keyed by the size suffix of the alg header.
+var hashes = map[string]hashFunc{
+	"256": {crypto.SHA256, sha256.New},
+	"384": {crypto.SHA384, sha512.New384},
+	"512": {crypto.SHA512, sha512.New},
+}
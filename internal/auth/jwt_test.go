@@ -0,0 +1,391 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var b64 = base64.RawURLEncoding
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b64.EncodeToString(b)
+}
+
+// sign builds a token over claims with key: an HMAC secret, an RSA or an
+// ECDSA private key.
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	signed := segment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(t, claims)
+	hf := hashes[alg[2:]]
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		m := hmac.New(hf.new, k)
+		m.Write([]byte(signed))
+		sig = m.Sum(nil)
+	case *rsa.PrivateKey:
+		d := hf.new()
+		d.Write([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hf.id, d.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		d := hf.new()
+		d.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, d.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func claims(now time.Time, extra map[string]any) map[string]any {
+	c := map[string]any{"sub": "alice", "iss": "https://idp.example.com", "aud": "users-api", "exp": now.Add(time.Hour).Unix()}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	secret := []byte("s3cret")
+	v, err := NewVerifier(Config{Secret: string(secret), Issuer: "https://idp.example.com", Audience: "users-api", Leeway: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	got, err := v.Verify(sign(t, "HS256", "", secret, claims(now, nil)), now)
+	if err != nil || got.Subject != "alice" {
+		t.Fatalf("valid token: %+v, %v", got, err)
+	}
+	if _, err := v.Verify(sign(t, "HS512", "", secret, claims(now, map[string]any{"aud": []string{"other", "users-api"}})), now); err != nil {
+		t.Errorf("aud array containing ours: %v", err)
+	}
+
+	valid := sign(t, "HS256", "", secret, claims(now, nil))
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + segment(t, claims(now, map[string]any{"sub": "mallory"})) + "." + parts[2]
+
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"expired":         {sign(t, "HS256", "", secret, claims(now, map[string]any{"exp": now.Add(-time.Minute).Unix()})), ErrExpired},
+		"within leeway":   {sign(t, "HS256", "", secret, claims(now, map[string]any{"exp": now.Add(-10 * time.Second).Unix()})), nil},
+		"not yet":         {sign(t, "HS256", "", secret, claims(now, map[string]any{"nbf": now.Add(time.Minute).Unix()})), ErrNotYet},
+		"wrong audience":  {sign(t, "HS256", "", secret, claims(now, map[string]any{"aud": "billing"})), ErrAudience},
+		"wrong issuer":    {sign(t, "HS256", "", secret, claims(now, map[string]any{"iss": "https://evil.example.com"})), ErrIssuer},
+		"no exp":          {sign(t, "HS256", "", secret, claims(now, map[string]any{"exp": 0})), ErrInvalid},
+		"tampered claims": {tampered, ErrInvalid},
+		"other secret":    {sign(t, "HS256", "", []byte("guess"), claims(now, nil)), ErrInvalid},
+		"alg none":        {segment(t, map[string]string{"alg": "none"}) + "." + parts[1] + ".", ErrInvalid},
+		"two parts":       {parts[0] + "." + parts[1], ErrInvalid},
+	} {
+		if _, err := v.Verify(tc.token, now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+// SkewLeeway adds to Leeway on every check, so a skew measured later
+// applies to the next token.
+func TestVerifySkewLeeway(t *testing.T) {
+	secret := []byte("s3cret")
+	var skew time.Duration
+	v, err := NewVerifier(Config{Secret: string(secret), Leeway: 30 * time.Second, SkewLeeway: func() time.Duration { return skew }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expired := sign(t, "HS256", "", secret, claims(now, map[string]any{"exp": now.Add(-time.Minute).Unix()}))
+	early := sign(t, "HS256", "", secret, claims(now, map[string]any{"nbf": now.Add(time.Minute).Unix()}))
+
+	if _, err := v.Verify(expired, now); !errors.Is(err, ErrExpired) {
+		t.Errorf("without skew: %v", err)
+	}
+	skew = 45 * time.Second
+	if _, err := v.Verify(expired, now); err != nil {
+		t.Errorf("expired within leeway and skew: %v", err)
+	}
+	if _, err := v.Verify(early, now); err != nil {
+		t.Errorf("not yet valid within leeway and skew: %v", err)
+	}
+}
+
+func jwkEC(kid, alg string, pub *ecdsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "alg": alg, "crv": pub.Curve.Params().Name,
+		"x": b64.EncodeToString(pub.X.Bytes()), "y": b64.EncodeToString(pub.Y.Bytes())}
+}
+
+func jwkRSA(kid string, pub *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "alg": "RS256",
+		"n": b64.EncodeToString(pub.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}
+}
+
+// jwksServer serves the keys keys holds at the time of each request.
+func jwksServer(t *testing.T, keys *atomic.Value) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": keys.Load()})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	mismatched, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var keys atomic.Value
+	keys.Store([]map[string]string{
+		jwkRSA("rsa", &rsaKey.PublicKey),
+		jwkEC("p256", "", &p256.PublicKey),
+		jwkEC("p384", "ES384", &p384.PublicKey),
+		jwkEC("mismatched", "ES512", &mismatched.PublicKey),
+		{"kty": "oct", "kid": "sym", "k": "c2VjcmV0"},
+	})
+	srv := jwksServer(t, &keys)
+	v, err := NewVerifier(Config{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c := claims(now, nil)
+
+	for name, tc := range map[string]struct {
+		token string
+		want  error
+	}{
+		"RS256":                    {sign(t, "RS256", "rsa", rsaKey, c), nil},
+		"ES256 derived from crv":   {sign(t, "ES256", "p256", p256, c), nil},
+		"ES384":                    {sign(t, "ES384", "p384", p384, c), nil},
+		"ES384 on a P-256 key":     {sign(t, "ES384", "p256", p256, c), ErrInvalid},
+		"alg naming another crv":   {sign(t, "ES512", "mismatched", mismatched, c), ErrInvalid},
+		"RS384 on an RS256 key":    {sign(t, "RS384", "rsa", rsaKey, c), ErrInvalid},
+		"HMAC with the JWKS":       {sign(t, "HS256", "sym", []byte("secret"), c), ErrInvalid},
+		"signed by another key":    {sign(t, "ES256", "p256", mismatched, c), ErrInvalid},
+		"unknown kid":              {sign(t, "ES256", "nope", p256, c), ErrInvalid},
+		"expired, valid signature": {sign(t, "RS256", "rsa", rsaKey, claims(now, map[string]any{"exp": now.Add(-time.Hour).Unix()})), ErrExpired},
+	} {
+		if _, err := v.Verify(tc.token, now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", name, err, tc.want)
+		}
+	}
+}
+
+func TestJWKSUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	v, err := NewVerifier(Config{JWKSURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := v.Verify(sign(t, "ES256", "k", key, claims(time.Now(), nil)), time.Now()); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("got %v, want ErrKeysUnavailable", err)
+	}
+}
+
+func TestNewVerifierNeedsOneKeySource(t *testing.T) {
+	if _, err := NewVerifier(Config{}); err == nil {
+		t.Error("no key source accepted")
+	}
+	if _, err := NewVerifier(Config{Secret: "s", JWKSURL: "http://idp"}); err == nil {
+		t.Error("both key sources accepted")
+	}
+}
+
+func writeKeys(t *testing.T, path string, keys ...map[string]any) {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func octKey(kid string, secret []byte) map[string]any {
+	return map[string]any{"kty": "oct", "kid": kid, "alg": "HS256", "k": b64.EncodeToString(secret)}
+}
+
+func TestKeysFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	oldSecret, newSecret := []byte("old-secret"), []byte("new-secret")
+	writeKeys(t, path, octKey("2026-09", oldSecret))
+	v, err := NewVerifier(Config{KeysFile: path, KeyGrace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// Issued before the rotation and valid for another day.
+	inFlight := sign(t, "HS256", "2026-09", oldSecret, claims(now, map[string]any{"exp": now.Add(24 * time.Hour).Unix()}))
+	if _, err := v.Verify(inFlight, now); err != nil {
+		t.Fatalf("before rotation: %v", err)
+	}
+
+	// The new key is added and becomes the one issuers sign with, then
+	// the old one is removed.
+	writeKeys(t, path, octKey("2026-09", oldSecret), octKey("2026-10", newSecret))
+	if err := v.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	fresh := sign(t, "HS256", "2026-10", newSecret, claims(now, map[string]any{"exp": now.Add(24 * time.Hour).Unix()}))
+	writeKeys(t, path, octKey("2026-10", newSecret))
+	if err := v.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for name, token := range map[string]string{"in-flight": inFlight, "fresh": fresh} {
+		if _, err := v.Verify(token, now); err != nil {
+			t.Errorf("%s token after rotation: %v", name, err)
+		}
+	}
+	// Without a kid every usable key is tried.
+	if _, err := v.Verify(sign(t, "HS256", "", oldSecret, claims(now, nil)), now); err != nil {
+		t.Errorf("token without a kid: %v", err)
+	}
+	// The kid decides: the old key doesn't verify a token naming the new one.
+	if _, err := v.Verify(sign(t, "HS256", "2026-10", oldSecret, claims(now, nil)), now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("old key under the new kid: %v", err)
+	}
+
+	keys := v.Keys(now)
+	if len(keys) != 2 || keys[0].ID != "2026-09" || !keys[0].Retired || keys[0].NotAfter == nil || keys[1].Retired || keys[1].NotAfter != nil {
+		t.Errorf("keys = %+v, want 2026-09 retired with a not-after and 2026-10 current", keys)
+	}
+	listed, _ := json.Marshal(keys)
+	if strings.Contains(string(listed), b64.EncodeToString(newSecret)) || strings.Contains(string(listed), `"k"`) {
+		t.Errorf("key listing leaks material: %s", listed)
+	}
+
+	// Past the grace window only the new key verifies.
+	later := now.Add(time.Hour + time.Minute)
+	if _, err := v.Verify(inFlight, later); !errors.Is(err, ErrInvalid) {
+		t.Errorf("in-flight token after the grace window: %v", err)
+	}
+	if _, err := v.Verify(fresh, later); err != nil {
+		t.Errorf("fresh token after the grace window: %v", err)
+	}
+	if keys := v.Keys(later); len(keys) != 1 {
+		t.Errorf("keys after the grace window: %+v", keys)
+	}
+}
+
+func TestKeysFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys.json")
+	if _, err := NewVerifier(Config{KeysFile: path}); err == nil {
+		t.Error("missing file accepted")
+	}
+	for name, keys := range map[string][]map[string]any{
+		"empty":          {},
+		"bad secret":     {{"kty": "oct", "kid": "a", "k": "!!"}},
+		"duplicate kid":  {octKey("a", []byte("x")), octKey("a", []byte("y"))},
+		"RSA alg on oct": {{"kty": "oct", "kid": "a", "alg": "RS256", "k": "eA"}},
+	} {
+		writeKeys(t, path, keys...)
+		if _, err := NewVerifier(Config{KeysFile: path}); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	// A broken file on reload keeps the keys in effect.
+	secret := []byte("s")
+	writeKeys(t, path, octKey("a", secret))
+	v, err := NewVerifier(Config{KeysFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Reload(); err == nil {
+		t.Error("reloading a broken file succeeded")
+	}
+	if _, err := v.Verify(sign(t, "HS256", "a", secret, claims(time.Now(), nil)), time.Now()); err != nil {
+		t.Errorf("after a failed reload: %v", err)
+	}
+
+	// A key whose exp has passed no longer verifies.
+	expired := octKey("a", secret)
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	writeKeys(t, path, expired, octKey("b", secret))
+	if err := v.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(sign(t, "HS256", "a", secret, claims(time.Now(), nil)), time.Now()); !errors.Is(err, ErrInvalid) {
+		t.Errorf("key past its exp: %v", err)
+	}
+
+	if err := (&Verifier{secret: secret}).Reload(); !errors.Is(err, ErrStaticKey) {
+		t.Errorf("reloading a secret: %v", err)
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var keys atomic.Value
+	keys.Store([]map[string]string{jwkEC("old", "", &oldKey.PublicKey)})
+	srv := jwksServer(t, &keys)
+	v, err := NewVerifier(Config{JWKSURL: srv.URL, KeyGrace: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c := claims(now, map[string]any{"exp": now.Add(24 * time.Hour).Unix()})
+	inFlight := sign(t, "ES256", "old", oldKey, c)
+	if _, err := v.Verify(inFlight, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// The identity provider swaps keys in one step.
+	keys.Store([]map[string]string{jwkEC("new", "", &newKey.PublicKey)})
+	if err := v.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(sign(t, "ES256", "new", newKey, c), now); err != nil {
+		t.Errorf("token from the new key: %v", err)
+	}
+	if _, err := v.Verify(inFlight, now); err != nil {
+		t.Errorf("in-flight token after the JWKS dropped its key: %v", err)
+	}
+	if _, err := v.Verify(inFlight, now.Add(2*time.Hour)); !errors.Is(err, ErrInvalid) {
+		t.Errorf("in-flight token after the grace window: %v", err)
+	}
+}
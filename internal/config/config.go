@@ -0,0 +1,300 @@
+// Package config reads the settings of the server binary: the listener
+// and its timeouts, shutdown, the database pool and logging, and the
+// tunables of the server's features (see tunables.go). The database
+// target (internal/dsn) and the ENVIRONMENT feature presets
+// (internal/features) have loaders of their own.
+//
+// Every variable is optional. PROFILE picks a consistent set of defaults
+// for the sizing knobs (see profiles.go) and each variable overrides its
+// profile value. Load reports all invalid values at once so a bad
+// deployment fails on its first start with the full list.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Config is the process configuration. The listener and shutdown
+// durations are never zero after Load: an unbounded timeout is exactly
+// what a slowloris client wants.
+type Config struct {
+	// Profile is the PROFILE the defaults came from (default standard).
+	Profile string
+
+	// HTTPPort is the API listen port (HTTP_PORT, default 8080).
+	HTTPPort int
+	// ManagementPort serves the health probes and /metrics apart from the
+	// API (MANAGEMENT_PORT, default 9090). ManagementOnMainPort keeps them
+	// on HTTPPort instead, for deployments whose probes and scrape
+	// configs still point there (MANAGEMENT_ON_MAIN_PORT, default false).
+	ManagementPort       int
+	ManagementOnMainPort bool
+	// AdminPort is the loopback port of the pprof and debug listener
+	// (ADMIN_PORT, default 6060; 0 disables it).
+	AdminPort int
+
+	// Timeouts of the http.Server: READ_HEADER_TIMEOUT, READ_TIMEOUT,
+	// WRITE_TIMEOUT and IDLE_TIMEOUT (5s, 15s, 30s and 60s in the standard
+	// profile). WRITE_TIMEOUT must exceed the slowest route budget or those
+	// responses are cut off.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// ShutdownDrain is how long the server keeps serving, with /readyz
+	// failing, between SIGTERM and shutdown so endpoints can stop routing
+	// to the pod (SHUTDOWN_DRAIN_SECONDS, 5; 0 shuts down at once).
+	ShutdownDrain time.Duration
+	// ShutdownTimeout bounds graceful shutdown after the drain
+	// (SHUTDOWN_TIMEOUT, 5s). Drain plus timeout should stay below the
+	// pod's terminationGracePeriodSeconds.
+	ShutdownTimeout time.Duration
+	// ReadinessTimeout bounds the database ping of /readyz
+	// (READINESS_TIMEOUT, 1s).
+	ReadinessTimeout time.Duration
+
+	// DBMaxConns and DBMinConns size the pgx pool (DB_MAX_CONNS,
+	// DB_MIN_CONNS); zero keeps pgx's defaults, as the standard profile
+	// does.
+	DBMaxConns int32
+	DBMinConns int32
+
+	// MaxQueryRows caps every multi-row query (MAX_QUERY_ROWS, 1000 in the
+	// standard profile).
+	MaxQueryRows int
+	// ScalingConcurrency is the number of in-flight requests one replica
+	// is sized for; GET /scaling reports pressure against it
+	// (SCALING_CONCURRENCY, 100 in the standard profile).
+	ScalingConcurrency int
+
+	// DBConnectRetries is how many times the startup ping is retried before
+	// giving up (DB_CONNECT_RETRIES, 10); DBConnectMaxWait caps the
+	// exponential backoff between attempts (DB_CONNECT_MAX_WAIT, 30s).
+	DBConnectRetries int
+	DBConnectMaxWait time.Duration
+
+	// RunMigrations applies the embedded migrations before listening
+	// (RUN_MIGRATIONS, default false: the Flyway Job owns the schema).
+	// MigrationWaitTimeout bounds how long a replica waits while another
+	// one migrates before it gives up (MIGRATION_WAIT_TIMEOUT, 10m; 0
+	// waits until it is stopped).
+	RunMigrations        bool
+	MigrationWaitTimeout time.Duration
+
+	// LogLevel hides less severe events (LOG_LEVEL, default info).
+	LogLevel zerolog.Level
+
+	// LogMask masks emails, bearer tokens and credential fields in every
+	// log event (LOG_MASK, default true). LogMaskRulesFile replaces the
+	// built-in rules with a JSON rule list, re-read on SIGHUP
+	// (LOG_MASK_RULES_FILE); LogMaskStrict drops events that are not JSON
+	// objects instead of masking them as plain text (LOG_MASK_STRICT).
+	LogMask          bool
+	LogMaskRulesFile string
+	LogMaskStrict    bool
+
+	Tunables
+
+	// Settings is every variable Load read with its effective value,
+	// Overridden the sorted subset that was set in the environment, and
+	// Warnings the combinations that are valid but probably a mistake.
+	Settings   map[string]string
+	Overridden []string
+	Warnings   []string
+}
+
+// Addr is the listen address for HTTPPort.
+func (c Config) Addr() string {
+	return ":" + strconv.Itoa(c.HTTPPort)
+}
+
+// ManagementAddr is the listen address for ManagementPort, or empty when
+// the probes stay on the API port.
+func (c Config) ManagementAddr() string {
+	if c.ManagementOnMainPort {
+		return ""
+	}
+	return ":" + strconv.Itoa(c.ManagementPort)
+}
+
+// AdminAddr is the listen address for AdminPort. It is loopback only:
+// profiles are reached with kubectl port-forward, never through a Service.
+func (c Config) AdminAddr() string {
+	return "localhost:" + strconv.Itoa(c.AdminPort)
+}
+
+// Load reads the configuration through lookupEnv (os.LookupEnv), applying
+// the PROFILE defaults for unset variables.
+func Load(lookupEnv func(string) (string, bool)) (Config, error) {
+	r := reader{lookupEnv: lookupEnv, settings: make(map[string]string)}
+	profile, _ := lookupEnv("PROFILE")
+	name := strings.ToLower(strings.TrimSpace(profile))
+	if name == "" {
+		name = Standard
+	}
+	p, ok := profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("PROFILE must be small, standard or high-throughput, got %q", name)
+	}
+
+	cfg := Config{
+		Profile:              name,
+		HTTPPort:             r.int("HTTP_PORT", 8080, 1, math.MaxUint16),
+		ManagementPort:       r.int("MANAGEMENT_PORT", 9090, 1, math.MaxUint16),
+		ManagementOnMainPort: r.bool("MANAGEMENT_ON_MAIN_PORT", false),
+		AdminPort:            r.int("ADMIN_PORT", 6060, 0, math.MaxUint16),
+		ReadHeaderTimeout:    r.duration("READ_HEADER_TIMEOUT", p.ReadHeaderTimeout),
+		ReadTimeout:          r.duration("READ_TIMEOUT", p.ReadTimeout),
+		WriteTimeout:         r.duration("WRITE_TIMEOUT", p.WriteTimeout),
+		IdleTimeout:          r.duration("IDLE_TIMEOUT", p.IdleTimeout),
+		ShutdownDrain:        time.Duration(r.int("SHUTDOWN_DRAIN_SECONDS", int(p.ShutdownDrain/time.Second), 0, 3600)) * time.Second,
+		ShutdownTimeout:      r.duration("SHUTDOWN_TIMEOUT", p.ShutdownTimeout),
+		ReadinessTimeout:     r.duration("READINESS_TIMEOUT", p.ReadinessTimeout),
+		DBMaxConns:           int32(r.int("DB_MAX_CONNS", int(p.DBMaxConns), 0, math.MaxInt32)),
+		DBMinConns:           int32(r.int("DB_MIN_CONNS", int(p.DBMinConns), 0, math.MaxInt32)),
+		DBConnectRetries:     r.int("DB_CONNECT_RETRIES", 10, 0, 1000),
+		DBConnectMaxWait:     r.duration("DB_CONNECT_MAX_WAIT", 30*time.Second),
+		MaxQueryRows:         r.int("MAX_QUERY_ROWS", p.MaxQueryRows, 1, math.MaxInt32),
+		ScalingConcurrency:   r.int("SCALING_CONCURRENCY", p.ScalingConcurrency, 1, math.MaxInt32),
+		RunMigrations:        r.bool("RUN_MIGRATIONS", false),
+		MigrationWaitTimeout: r.optDuration("MIGRATION_WAIT_TIMEOUT", 10*time.Minute),
+		LogLevel:             r.level("LOG_LEVEL", zerolog.InfoLevel),
+		LogMask:              r.bool("LOG_MASK", true),
+		LogMaskRulesFile:     r.string("LOG_MASK_RULES_FILE", ""),
+		LogMaskStrict:        r.bool("LOG_MASK_STRICT", false),
+	}
+	cfg.Tunables = r.tunables(p)
+
+	if cfg.ReadHeaderTimeout > cfg.ReadTimeout {
+		r.fail("READ_HEADER_TIMEOUT (%s) must not exceed READ_TIMEOUT (%s)", cfg.ReadHeaderTimeout, cfg.ReadTimeout)
+	}
+	if !cfg.ManagementOnMainPort && cfg.ManagementPort == cfg.HTTPPort {
+		r.fail("MANAGEMENT_PORT (%d) must differ from HTTP_PORT; set MANAGEMENT_ON_MAIN_PORT=true to share it", cfg.ManagementPort)
+	}
+	if cfg.AdminPort == cfg.HTTPPort || (!cfg.ManagementOnMainPort && cfg.AdminPort == cfg.ManagementPort) {
+		r.fail("ADMIN_PORT (%d) must differ from HTTP_PORT and MANAGEMENT_PORT", cfg.AdminPort)
+	}
+	if cfg.DBMaxConns > 0 && cfg.DBMinConns > cfg.DBMaxConns {
+		r.fail("DB_MIN_CONNS (%d) must not exceed DB_MAX_CONNS (%d)", cfg.DBMinConns, cfg.DBMaxConns)
+	}
+	cfg.Settings = r.settings
+	cfg.Settings["PROFILE"] = name
+	cfg.Overridden = r.overridden
+	sort.Strings(cfg.Overridden)
+	cfg.Warnings = cfg.check()
+	return cfg, errors.Join(r.errs...)
+}
+
+// reader parses variables, collecting every problem instead of stopping
+// at the first, and records the value each one resolved to.
+type reader struct {
+	lookupEnv  func(string) (string, bool)
+	errs       []error
+	settings   map[string]string
+	overridden []string
+}
+
+func (r *reader) fail(format string, args ...any) {
+	r.errs = append(r.errs, fmt.Errorf(format, args...))
+}
+
+func (r *reader) lookup(key string) (string, bool) {
+	v, _ := r.lookupEnv(key)
+	v = strings.TrimSpace(v)
+	if v != "" {
+		r.overridden = append(r.overridden, key)
+	}
+	return v, v != ""
+}
+
+// string reads a value as is.
+func (r *reader) string(key, def string) string {
+	v, ok := r.lookup(key)
+	if !ok {
+		v = def
+	}
+	r.settings[key] = v
+	return v
+}
+
+// duration reads a positive duration such as "30s".
+func (r *reader) duration(key string, def time.Duration) (d time.Duration) {
+	defer func() { r.settings[key] = d.String() }()
+	v, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		r.fail("%s must be a positive duration such as 30s, got %q", key, v)
+		return def
+	}
+	return d
+}
+
+// int reads an integer in [lo, hi].
+func (r *reader) int(key string, def, lo, hi int) (n int) {
+	defer func() { r.settings[key] = strconv.Itoa(n) }()
+	v, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < lo || n > hi {
+		r.fail("%s must be an integer between %d and %d, got %q", key, lo, hi, v)
+		return def
+	}
+	return n
+}
+
+// bool reads true or false (or anything strconv.ParseBool accepts).
+func (r *reader) bool(key string, def bool) (b bool) {
+	defer func() { r.settings[key] = strconv.FormatBool(b) }()
+	v, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		r.fail("%s must be true or false, got %q", key, v)
+		return def
+	}
+	return b
+}
+
+func (r *reader) level(key string, def zerolog.Level) (l zerolog.Level) {
+	defer func() { r.settings[key] = l.String() }()
+	v, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	l, err := zerolog.ParseLevel(strings.ToLower(v))
+	if err != nil || l == zerolog.NoLevel {
+		r.fail("%s must be one of trace, debug, info, warn, error, fatal, panic or disabled, got %q", key, v)
+		return def
+	}
+	return l
+}
+
+// Effective is the resolved configuration as logged at startup and served
+// by /admin/config.
+type Effective struct {
+	Profile    string            `json:"profile"`
+	Settings   map[string]string `json:"settings"`
+	Overridden []string          `json:"overridden,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+}
+
+// Effective returns the resolved configuration.
+func (c Config) Effective() Effective {
+	return Effective{Profile: c.Profile, Settings: c.Settings, Overridden: c.Overridden, Warnings: c.Warnings}
+}
@@ -0,0 +1,260 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/journal"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load(env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != Standard || cfg.HTTPPort != 8080 || cfg.WriteTimeout != 30*time.Second {
+		t.Errorf("process defaults: %+v", cfg)
+	}
+	tu := cfg.Tunables
+	if !tu.LegacyRoutes || tu.RateLimitBurst != 20 || tu.DeadlineHeader != "X-Request-Timeout" ||
+		tu.JournalSync != journal.SyncInterval || tu.ListCacheTTL != 0 || tu.DuplicateWindow != 10*time.Second || tu.MaxBodyBytes != 1<<20 ||
+		tu.MemoryHeadroom != 0.2 || tu.JWTLeewayFromSkew || tu.JWTLeewaySkewCap != 2*time.Minute {
+		t.Errorf("tunable defaults: %+v", tu)
+	}
+	if len(cfg.Overridden) != 0 {
+		t.Errorf("overridden without any variable: %v", cfg.Overridden)
+	}
+}
+
+func TestLoadTunables(t *testing.T) {
+	cfg, err := Load(env(map[string]string{
+		"ENABLE_LEGACY_ROUTES": "false",
+		"LIST_CACHE_TTL":       "2s",
+		"DUPLICATE_WINDOW":     "0",
+		"TRUSTED_PROXIES":      " 10.0.0.0/8, ,192.168.0.1",
+		"RATE_LIMIT_RPS":       "2.5",
+		"DEADLINE_HEADER":      "",
+		"JOURNAL_SYNC":         "always",
+		"SHARE_LINK_SECRET":    "hunter2",
+		"JWT_LEEWAY_FROM_SKEW": "true",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu := cfg.Tunables
+	if tu.LegacyRoutes || tu.ListCacheTTL != 2*time.Second || tu.DuplicateWindow != 0 || tu.RateLimitRPS != 2.5 || tu.JournalSync != journal.SyncAlways ||
+		!tu.JWTLeewayFromSkew {
+		t.Errorf("overrides not applied: %+v", tu)
+	}
+	if !slices.Equal(tu.TrustedProxies, []string{"10.0.0.0/8", "192.168.0.1"}) {
+		t.Errorf("TRUSTED_PROXIES = %q", tu.TrustedProxies)
+	}
+	// Set but empty turns client deadlines off.
+	if tu.DeadlineHeader != "" || !slices.Contains(cfg.Overridden, "DEADLINE_HEADER") {
+		t.Errorf("empty DEADLINE_HEADER: %q", tu.DeadlineHeader)
+	}
+	// Settings are logged and served: secrets only say that they are set.
+	if tu.ShareLinkSecret != "hunter2" || cfg.Settings["SHARE_LINK_SECRET"] != "(set)" || cfg.Settings["JWT_SECRET"] != "" {
+		t.Errorf("secret settings: %q %q", cfg.Settings["SHARE_LINK_SECRET"], cfg.Settings["JWT_SECRET"])
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	_, err := Load(env(map[string]string{
+		"REQUEST_TIMEOUT": "0",
+		"RATE_LIMIT_RPS":  "-1",
+		"JOURNAL_SYNC":    "sometimes",
+		"DEADLINE_MIN":    "2m",
+		"JWT_SECRET":      "s",
+		"JWT_JWKS_URL":    "https://idp/jwks",
+		"READ_TIMEOUT":    "soon",
+		"MEMORY_HEADROOM": "0.6",
+	}))
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{"REQUEST_TIMEOUT", "RATE_LIMIT_RPS", "JOURNAL_SYNC", "DEADLINE_MIN (2m0s) must not exceed", "only one of JWT_SECRET", "READ_TIMEOUT", "MEMORY_HEADROOM (0.6) must be below 0.5"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadProcessSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		vars  map[string]string
+		check func(Config) bool
+	}{
+		{"defaults", nil, func(c Config) bool {
+			return c.Addr() == ":8080" && c.ManagementAddr() == ":9090" && c.AdminAddr() == "localhost:6060" &&
+				c.ReadHeaderTimeout == 5*time.Second && c.ReadTimeout == 15*time.Second && c.IdleTimeout == time.Minute &&
+				c.ShutdownDrain == 5*time.Second && c.ShutdownTimeout == 5*time.Second && c.ReadinessTimeout == time.Second &&
+				c.DBMaxConns == 0 && c.DBConnectRetries == 10 && c.DBConnectMaxWait == 30*time.Second && c.LogLevel == zerolog.InfoLevel && !c.RunMigrations && c.MigrationWaitTimeout == 10*time.Minute && c.LogMask
+		}},
+		{"port", map[string]string{"HTTP_PORT": "9000"}, func(c Config) bool { return c.Addr() == ":9000" }},
+		{"timeouts", map[string]string{"READ_TIMEOUT": "1m", "WRITE_TIMEOUT": "90s", "IDLE_TIMEOUT": "2m"}, func(c Config) bool {
+			return c.ReadTimeout == time.Minute && c.WriteTimeout == 90*time.Second && c.IdleTimeout == 2*time.Minute
+		}},
+		{"shutdown", map[string]string{"SHUTDOWN_DRAIN_SECONDS": "0", "SHUTDOWN_TIMEOUT": "20s"}, func(c Config) bool {
+			return c.ShutdownDrain == 0 && c.ShutdownTimeout == 20*time.Second
+		}},
+		{"pool", map[string]string{"DB_MAX_CONNS": "20", "DB_MIN_CONNS": "2"}, func(c Config) bool {
+			return c.DBMaxConns == 20 && c.DBMinConns == 2
+		}},
+		{"connect retries", map[string]string{"DB_CONNECT_RETRIES": "0", "DB_CONNECT_MAX_WAIT": "2s"}, func(c Config) bool {
+			return c.DBConnectRetries == 0 && c.DBConnectMaxWait == 2*time.Second
+		}},
+		{"migration wait", map[string]string{"RUN_MIGRATIONS": "true", "MIGRATION_WAIT_TIMEOUT": "0"}, func(c Config) bool {
+			return c.RunMigrations && c.MigrationWaitTimeout == 0
+		}},
+		{"log level in any case", map[string]string{"LOG_LEVEL": "DEBUG"}, func(c Config) bool { return c.LogLevel == zerolog.DebugLevel }},
+		{"surrounding space", map[string]string{"HTTP_PORT": " 8081 ", "RUN_MIGRATIONS": " true"}, func(c Config) bool {
+			return c.HTTPPort == 8081 && c.RunMigrations
+		}},
+		{"blank means unset", map[string]string{"HTTP_PORT": "  "}, func(c Config) bool { return c.HTTPPort == 8080 }},
+		{"management on the main port", map[string]string{"MANAGEMENT_ON_MAIN_PORT": "true", "MANAGEMENT_PORT": "8080"}, func(c Config) bool {
+			return c.ManagementAddr() == ""
+		}},
+		{"small profile", map[string]string{"PROFILE": "Small"}, func(c Config) bool { return c.Profile == Small }},
+	}
+	for _, tt := range tests {
+		cfg, err := Load(env(tt.vars))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(cfg) {
+			t.Errorf("%s: %+v", tt.name, cfg)
+		}
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		vars map[string]string
+		want string
+	}{
+		{map[string]string{"HTTP_PORT": "0"}, "HTTP_PORT must be an integer between 1 and 65535"},
+		{map[string]string{"HTTP_PORT": "70000"}, "HTTP_PORT must be an integer"},
+		{map[string]string{"HTTP_PORT": "http"}, "HTTP_PORT must be an integer"},
+		{map[string]string{"SHUTDOWN_TIMEOUT": "5"}, "SHUTDOWN_TIMEOUT must be a positive duration such as 30s"},
+		{map[string]string{"WRITE_TIMEOUT": "-1s"}, "WRITE_TIMEOUT must be a positive duration"},
+		{map[string]string{"SHUTDOWN_DRAIN_SECONDS": "-1"}, "SHUTDOWN_DRAIN_SECONDS must be an integer"},
+		{map[string]string{"DB_MAX_CONNS": "many"}, "DB_MAX_CONNS must be an integer"},
+		{map[string]string{"DB_CONNECT_RETRIES": "-1"}, "DB_CONNECT_RETRIES must be an integer"},
+		{map[string]string{"DB_CONNECT_MAX_WAIT": "0"}, "DB_CONNECT_MAX_WAIT must be a positive duration"},
+		{map[string]string{"RUN_MIGRATIONS": "yes"}, "RUN_MIGRATIONS must be true or false"},
+		{map[string]string{"LOG_LEVEL": "verbose"}, "LOG_LEVEL must be one of"},
+		{map[string]string{"PROFILE": "huge"}, "PROFILE must be small, standard or high-throughput"},
+		{map[string]string{"READ_HEADER_TIMEOUT": "20s", "READ_TIMEOUT": "10s"}, "READ_HEADER_TIMEOUT (20s) must not exceed READ_TIMEOUT (10s)"},
+		{map[string]string{"MANAGEMENT_PORT": "8080"}, "MANAGEMENT_PORT (8080) must differ from HTTP_PORT"},
+		{map[string]string{"ADMIN_PORT": "9090"}, "ADMIN_PORT (9090) must differ"},
+		{map[string]string{"DB_MAX_CONNS": "2", "DB_MIN_CONNS": "5"}, "DB_MIN_CONNS (5) must not exceed DB_MAX_CONNS (2)"},
+	}
+	for _, tt := range tests {
+		_, err := Load(env(tt.vars))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%v) = %v, want an error containing %q", tt.vars, err, tt.want)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	tests := []struct {
+		profile string
+		want    profile
+	}{
+		{"", profiles[Standard]},
+		{"small", profile{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second, 2 * time.Second, 5 * time.Second, time.Second, 4, 0, 500, 25, 256 << 10, 5 * time.Second, 0, 10}},
+		{"standard", profile{5 * time.Second, 15 * time.Second, 30 * time.Second, time.Minute, 5 * time.Second, 5 * time.Second, time.Second, 0, 0, 1000, 100, 1 << 20, 10 * time.Second, 0, 20}},
+		{" High-Throughput ", profile{5 * time.Second, 15 * time.Second, 30 * time.Second, 2 * time.Minute, 10 * time.Second, 15 * time.Second, time.Second, 40, 10, 1000, 400, 2 << 20, 10 * time.Second, 0, 50}},
+	}
+	for _, tt := range tests {
+		cfg, err := Load(env(map[string]string{"PROFILE": tt.profile}))
+		if err != nil {
+			t.Errorf("PROFILE=%q: %v", tt.profile, err)
+			continue
+		}
+		got := profile{cfg.ReadHeaderTimeout, cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout, cfg.ShutdownDrain, cfg.ShutdownTimeout,
+			cfg.ReadinessTimeout, cfg.DBMaxConns, cfg.DBMinConns, cfg.MaxQueryRows, cfg.ScalingConcurrency,
+			cfg.MaxBodyBytes, cfg.RequestTimeout, cfg.RateLimitRPS, cfg.RateLimitBurst}
+		if got != tt.want {
+			t.Errorf("PROFILE=%q resolved to %+v, want %+v", tt.profile, got, tt.want)
+		}
+		if cfg.Settings["PROFILE"] != cfg.Profile || len(cfg.Overridden) != 0 || len(cfg.Warnings) != 0 {
+			t.Errorf("PROFILE=%q: profile %q, overridden %v, warnings %v", tt.profile, cfg.Settings["PROFILE"], cfg.Overridden, cfg.Warnings)
+		}
+	}
+}
+
+// A variable wins over its profile value and only that value changes.
+func TestProfileOverrides(t *testing.T) {
+	cfg, err := Load(env(map[string]string{"PROFILE": HighThroughput, "DB_MAX_CONNS": "60", "IDLE_TIMEOUT": "90s", "RATE_LIMIT_RPS": "20"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := profiles[HighThroughput]
+	if cfg.DBMaxConns != 60 || cfg.IdleTimeout != 90*time.Second || cfg.DBMinConns != p.DBMinConns || cfg.ScalingConcurrency != p.ScalingConcurrency {
+		t.Errorf("overrides: %+v", cfg)
+	}
+	if cfg.RateLimitRPS != 20 || cfg.RateLimitBurst != p.RateLimitBurst || cfg.MaxBodyBytes != p.MaxBodyBytes || cfg.RequestTimeout != p.RequestTimeout {
+		t.Errorf("tunable overrides: %+v", cfg.Tunables)
+	}
+	if !slices.Equal(cfg.Overridden, []string{"DB_MAX_CONNS", "IDLE_TIMEOUT", "RATE_LIMIT_RPS"}) {
+		t.Errorf("overridden = %v", cfg.Overridden)
+	}
+	if cfg.Settings["DB_MAX_CONNS"] != "60" || cfg.Settings["SCALING_CONCURRENCY"] != "400" {
+		t.Errorf("settings %v", cfg.Settings)
+	}
+	eff := cfg.Effective()
+	if eff.Profile != HighThroughput || !slices.Equal(eff.Overridden, cfg.Overridden) || eff.Settings["IDLE_TIMEOUT"] != cfg.Settings["IDLE_TIMEOUT"] {
+		t.Errorf("effective %+v", eff)
+	}
+}
+
+func TestProfileWarnings(t *testing.T) {
+	tests := []struct {
+		vars map[string]string
+		want string
+	}{
+		{map[string]string{"PROFILE": Small, "SCALING_CONCURRENCY": "100"}, "SCALING_CONCURRENCY (100) is more than 10 times DB_MAX_CONNS (4)"},
+		{map[string]string{"WRITE_TIMEOUT": "8s", "READ_TIMEOUT": "6s"}, "WRITE_TIMEOUT (8s) is below"},
+		{map[string]string{"READ_TIMEOUT": "40s"}, "READ_TIMEOUT (40s) exceeds WRITE_TIMEOUT (30s)"},
+		{map[string]string{"PROFILE": Small, "WRITE_TIMEOUT": "4s", "READ_TIMEOUT": "3s", "READ_HEADER_TIMEOUT": "2s"}, "WRITE_TIMEOUT (4s) is below the 5s write route budget"},
+		{map[string]string{"PROFILE": Small, "RATE_LIMIT_RPS": "25"}, "RATE_LIMIT_BURST (10) is below RATE_LIMIT_RPS (25)"},
+		{map[string]string{"PROFILE": HighThroughput, "SHUTDOWN_TIMEOUT": "25s"}, "exceeds the 30s termination grace period"},
+	}
+	for _, tt := range tests {
+		cfg, err := Load(env(tt.vars))
+		if err != nil {
+			t.Errorf("%v: %v", tt.vars, err)
+			continue
+		}
+		if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], tt.want) {
+			t.Errorf("%v: warnings %q, want one containing %q", tt.vars, cfg.Warnings, tt.want)
+		}
+	}
+	// A shorter REQUEST_TIMEOUT is the budget the write timeout has to cover.
+	if cfg, _ := Load(env(map[string]string{"WRITE_TIMEOUT": "8s", "READ_TIMEOUT": "6s", "REQUEST_TIMEOUT": "8s"})); len(cfg.Warnings) != 0 {
+		t.Errorf("warnings with REQUEST_TIMEOUT within WRITE_TIMEOUT: %q", cfg.Warnings)
+	}
+	if cfg, _ := Load(env(map[string]string{"RATE_LIMIT_RPS": "20"})); len(cfg.Warnings) != 0 {
+		t.Errorf("warnings with RATE_LIMIT_BURST at RATE_LIMIT_RPS: %q", cfg.Warnings)
+	}
+	// Without a pool limit pgx picks the size; there is nothing to compare.
+	if cfg, _ := Load(env(map[string]string{"SCALING_CONCURRENCY": "10000"})); len(cfg.Warnings) != 0 {
+		t.Errorf("warnings without DB_MAX_CONNS: %q", cfg.Warnings)
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Profiles.
+const (
+	Small          = "small"
+	Standard       = "standard"
+	HighThroughput = "high-throughput"
+)
+
+// profile is the set of sizing defaults PROFILE selects. The values are
+// chosen together: pool size, concurrency, body limit, rate limit and
+// timeouts of one profile are consistent with each other, which
+// individual overrides may not be.
+type profile struct {
+	ReadHeaderTimeout  time.Duration
+	ReadTimeout        time.Duration
+	WriteTimeout       time.Duration
+	IdleTimeout        time.Duration
+	ShutdownDrain      time.Duration
+	ShutdownTimeout    time.Duration
+	ReadinessTimeout   time.Duration
+	DBMaxConns         int32
+	DBMinConns         int32
+	MaxQueryRows       int
+	ScalingConcurrency int
+	MaxBodyBytes       int64
+	RequestTimeout     time.Duration
+	// Rate limiting stays off in every profile: per-client buckets need
+	// TRUSTED_PROXIES, or every client behind the ingress shares one.
+	// The bursts are what it gets once RATE_LIMIT_RPS turns it on.
+	RateLimitRPS   float64
+	RateLimitBurst int
+}
+
+var profiles = map[string]profile{
+	// small: a single replica with a few connections, e.g. kind or a
+	// review environment.
+	Small: {
+		ReadHeaderTimeout:  5 * time.Second,
+		ReadTimeout:        10 * time.Second,
+		WriteTimeout:       20 * time.Second,
+		IdleTimeout:        30 * time.Second,
+		ShutdownDrain:      2 * time.Second,
+		ShutdownTimeout:    5 * time.Second,
+		ReadinessTimeout:   time.Second,
+		DBMaxConns:         4,
+		DBMinConns:         0,
+		MaxQueryRows:       500,
+		ScalingConcurrency: 25,
+		MaxBodyBytes:       256 << 10,
+		RequestTimeout:     5 * time.Second,
+		RateLimitBurst:     10,
+	},
+	// standard: the historical defaults.
+	Standard: {
+		ReadHeaderTimeout:  5 * time.Second,
+		ReadTimeout:        15 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		IdleTimeout:        60 * time.Second,
+		ShutdownDrain:      5 * time.Second,
+		ShutdownTimeout:    5 * time.Second,
+		ReadinessTimeout:   time.Second,
+		MaxQueryRows:       1000,
+		ScalingConcurrency: 100,
+		MaxBodyBytes:       1 << 20,
+		RequestTimeout:     10 * time.Second,
+		RateLimitBurst:     20,
+	},
+	// high-throughput: a large pool kept warm and long keep-alives for
+	// busy replicas behind a load balancer.
+	HighThroughput: {
+		ReadHeaderTimeout:  5 * time.Second,
+		ReadTimeout:        15 * time.Second,
+		WriteTimeout:       30 * time.Second,
+		IdleTimeout:        120 * time.Second,
+		ShutdownDrain:      10 * time.Second,
+		ShutdownTimeout:    15 * time.Second,
+		ReadinessTimeout:   time.Second,
+		DBMaxConns:         40,
+		DBMinConns:         10,
+		MaxQueryRows:       1000,
+		ScalingConcurrency: 400,
+		MaxBodyBytes:       2 << 20,
+		RequestTimeout:     10 * time.Second,
+		RateLimitBurst:     50,
+	},
+}
+
+const (
+	// slowestRouteBudget is the server's write route budget, which
+	// REQUEST_TIMEOUT can only shorten; a WRITE_TIMEOUT below the shorter
+	// of the two cuts those responses off before their own deadline.
+	slowestRouteBudget = 10 * time.Second
+	// gracePeriod is terminationGracePeriodSeconds of k8s/api-deployment.yaml.
+	gracePeriod = 30 * time.Second
+	// requestsPerConn is how many in-flight requests per pooled connection
+	// still queue acceptably on pool acquisition.
+	requestsPerConn = 10
+)
+
+// check lists valid but inconsistent combinations, typically an override
+// that no longer fits the rest of its profile.
+func (c Config) check() []string {
+	var warnings []string
+	if budget := min(slowestRouteBudget, c.Tunables.RequestTimeout); c.WriteTimeout < budget {
+		warnings = append(warnings, fmt.Sprintf("WRITE_TIMEOUT (%s) is below the %s write route budget; slow writes are cut off", c.WriteTimeout, budget))
+	}
+	if c.ReadTimeout > c.WriteTimeout {
+		warnings = append(warnings, fmt.Sprintf("READ_TIMEOUT (%s) exceeds WRITE_TIMEOUT (%s); a slow upload leaves no time for the response", c.ReadTimeout, c.WriteTimeout))
+	}
+	if c.DBMaxConns > 0 && c.ScalingConcurrency > requestsPerConn*int(c.DBMaxConns) {
+		warnings = append(warnings, fmt.Sprintf("SCALING_CONCURRENCY (%d) is more than %d times DB_MAX_CONNS (%d); requests will queue for connections long before the replica scales out", c.ScalingConcurrency, requestsPerConn, c.DBMaxConns))
+	}
+	if rps := c.Tunables.RateLimitRPS; rps > 0 && float64(c.Tunables.RateLimitBurst) < rps {
+		warnings = append(warnings, fmt.Sprintf("RATE_LIMIT_BURST (%d) is below RATE_LIMIT_RPS (%g); clients within the rate are throttled whenever their requests bunch up within a second", c.Tunables.RateLimitBurst, rps))
+	}
+	if c.ShutdownDrain+c.ShutdownTimeout > gracePeriod {
+		warnings = append(warnings, fmt.Sprintf("SHUTDOWN_DRAIN_SECONDS plus SHUTDOWN_TIMEOUT (%s) exceeds the %s termination grace period; the pod is killed mid-shutdown", c.ShutdownDrain+c.ShutdownTimeout, gracePeriod))
+	}
+	return warnings
+}
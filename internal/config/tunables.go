@@ -0,0 +1,377 @@
+package config
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-k8s-demo/internal/journal"
+)
+
+// Tunables are the settings of the server's features, passed through to
+// server.Config. Zero disables most features; the defaults are the ones
+// each feature documents in the README.
+type Tunables struct {
+	// BasePath mounts the API under a prefix (BASE_PATH), honouring
+	// X-Forwarded-Prefix in links with TRUST_FORWARDED_PREFIX=true.
+	BasePath             string
+	TrustForwardedPrefix bool
+	// LegacyRoutes keeps the unversioned paths, marked deprecated
+	// (ENABLE_LEGACY_ROUTES, default true).
+	LegacyRoutes bool
+
+	// ListCacheTTL enables the GET /users cache (LIST_CACHE_TTL, off),
+	// holding up to ListCacheMaxBytes (LIST_CACHE_MAX_BYTES, 8 MiB).
+	ListCacheTTL      time.Duration
+	ListCacheMaxBytes int
+
+	// Table growth monitoring every TableCheckInterval
+	// (TABLE_CHECK_INTERVAL, 1m); TABLE_ROWS_WARN, TABLE_BYTES_WARN and
+	// TABLE_ROWS_HARD_CAP are off at zero.
+	TableCheckInterval time.Duration
+	TableRowsWarn      int64
+	TableBytesWarn     int64
+	TableRowsHardCap   int64
+
+	// ClockSkewInterval and ClockSkewThreshold (CLOCK_SKEW_INTERVAL, 5m;
+	// CLOCK_SKEW_THRESHOLD, 5s) watch the clock against the database's.
+	ClockSkewInterval  time.Duration
+	ClockSkewThreshold time.Duration
+
+	// StrictRowLimit answers 422 at the row cap (STRICT_ROW_LIMIT).
+	StrictRowLimit bool
+	// MaxUserID is the largest id looked up (MAX_USER_ID, the SERIAL
+	// maximum).
+	MaxUserID int64
+	// MaxBodyBytes limits write bodies (MAX_BODY_BYTES, 1 MiB in the
+	// standard profile).
+	MaxBodyBytes int64
+
+	// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For is
+	// believed (TRUSTED_PROXIES, comma-separated).
+	TrustedProxies []string
+	// CORS: CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS and
+	// CORS_ALLOWED_HEADERS (comma-separated), CORS_MAX_AGE (10m) and
+	// CORS_ALLOW_CREDENTIALS.
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSMaxAge           time.Duration
+	CORSAllowCredentials bool
+	// RateLimitRPS enables per-client token buckets (RATE_LIMIT_RPS, off)
+	// of RateLimitBurst tokens (RATE_LIMIT_BURST, 20 in the standard
+	// profile), shared through the
+	// database every RateLimitSyncInterval (RATE_LIMIT_SYNC_INTERVAL, off).
+	RateLimitRPS          float64
+	RateLimitBurst        int
+	RateLimitSyncInterval time.Duration
+
+	// RequestTimeout caps every route budget (REQUEST_TIMEOUT, 10s in the
+	// standard profile).
+	RequestTimeout time.Duration
+	// DeadlineHeader carries the client's deadline (DEADLINE_HEADER,
+	// X-Request-Timeout; set but empty ignores it), clamped to
+	// [DeadlineMin, DeadlineMax] (DEADLINE_MIN, 100ms; DEADLINE_MAX, 1m).
+	DeadlineHeader string
+	DeadlineMin    time.Duration
+	DeadlineMax    time.Duration
+
+	// ViewFlushInterval batches view increments (VIEW_FLUSH_INTERVAL,
+	// off) up to ViewBatchSize (VIEW_BATCH_SIZE, 100).
+	ViewFlushInterval time.Duration
+	ViewBatchSize     int
+
+	// ServerTiming emits Server-Timing headers (SERVER_TIMING).
+	ServerTiming bool
+
+	// Duplicate protection of POST routes: IDEMPOTENCY_KEY_TTL (24h),
+	// DUPLICATE_WINDOW (10s, 0 disables), STRICT_IDEMPOTENCY and
+	// RETRY_INDICATOR_HEADER.
+	IdempotencyKeyTTL time.Duration
+	DuplicateWindow   time.Duration
+	StrictIdempotency bool
+	RetryHeader       string
+	// RequireIfMatch answers 428 to writes without If-Match
+	// (REQUIRE_IF_MATCH).
+	RequireIfMatch bool
+
+	// Weights of the GET /scaling pressure components
+	// (SCALING_WEIGHT_IN_FLIGHT, SCALING_WEIGHT_DB_POOL; 1 each).
+	ScalingWeightInFlight float64
+	ScalingWeightPool     float64
+	// BrownoutHigh enables load shedding (BROWNOUT_HIGH, off);
+	// BROWNOUT_LOW and BROWNOUT_WINDOW (30s) tune it.
+	BrownoutHigh   float64
+	BrownoutLow    float64
+	BrownoutWindow time.Duration
+	// MemoryHeadroom is the free share of GOMEMLIMIT below which the
+	// export and batch create shrink their batches (MEMORY_HEADROOM, 0.2;
+	// 0 disables).
+	MemoryHeadroom float64
+
+	// JournalPath enables the request journal (JOURNAL_PATH, off) of up
+	// to JournalMaxBytes (JOURNAL_MAX_BYTES, 16 MiB), synced per
+	// JournalSync (JOURNAL_SYNC, interval) every JournalSyncInterval
+	// (JOURNAL_SYNC_INTERVAL, 1s).
+	JournalPath         string
+	JournalMaxBytes     int64
+	JournalSync         journal.SyncPolicy
+	JournalSyncInterval time.Duration
+
+	// Bearer tokens: one of JWT_SECRET, JWT_JWKS_URL (refetched every
+	// JWT_JWKS_REFRESH, 1h) or JWT_KEYS_FILE; JWT_KEY_GRACE (1h),
+	// JWT_ISSUER, JWT_AUDIENCE and JWT_LEEWAY (30s), which
+	// JWT_LEEWAY_FROM_SKEW (off) widens by the clock skew above
+	// CLOCK_SKEW_THRESHOLD, up to JWT_LEEWAY_SKEW_CAP (2m). API_KEYS and
+	// API_KEYS_FILE list hashed API keys.
+	JWTSecret   string
+	JWKSURL     string
+	JWKSRefresh time.Duration
+	JWTKeysFile string
+	JWTKeyGrace time.Duration
+	JWTIssuer   string
+	JWTAudience string
+	JWTLeeway   time.Duration
+	APIKeys     string
+	APIKeysFile string
+
+	JWTLeewayFromSkew bool
+	JWTLeewaySkewCap  time.Duration
+
+	// ShareLinkSecret enables share links (SHARE_LINK_SECRET), valid for
+	// SHARE_LINK_DEFAULT_TTL (1h) up to SHARE_LINK_MAX_TTL (7 days).
+	ShareLinkSecret     string
+	ShareLinkDefaultTTL time.Duration
+	ShareLinkMaxTTL     time.Duration
+
+	// Retention of consumed share links: RETENTION_INTERVAL (1h, 0
+	// disables), SHARE_LINK_RETENTION (24h) and RETENTION_BATCH_SIZE
+	// (1000).
+	RetentionInterval  time.Duration
+	ShareLinkRetention time.Duration
+	RetentionBatchSize int
+
+	// ReadOnlyMode starts with writes rejected (READ_ONLY_MODE); the
+	// database is probed every READ_ONLY_PROBE_INTERVAL (10s).
+	ReadOnlyMode          bool
+	ReadOnlyProbeInterval time.Duration
+	// WorkerStaleFactor is how many intervals a worker may miss before
+	// /readyz reports it (WORKER_STALE_FACTOR, 3).
+	WorkerStaleFactor float64
+
+	// Probe access logging: PROBE_LOG_SAMPLE (log one in N, 0 none),
+	// PROBE_LOG_DEBUG and PROBE_FAILURE_HISTORY (50).
+	ProbeLogSample      uint64
+	ProbeLogDebug       bool
+	ProbeFailureHistory int
+
+	// SlowTxWarn logs slower transactions (SLOW_TX_WARN, 1s) and
+	// SlowTxSnapshot the sessions they block (SLOW_TX_SNAPSHOT, 5s); 0
+	// disables either.
+	SlowTxWarn     time.Duration
+	SlowTxSnapshot time.Duration
+}
+
+// tunables reads the Tunables, with the defaults of profile p where it
+// has them, and checks the ones that depend on each other.
+func (r *reader) tunables(p profile) Tunables {
+	t := Tunables{
+		BasePath:             r.string("BASE_PATH", ""),
+		TrustForwardedPrefix: r.bool("TRUST_FORWARDED_PREFIX", false),
+		LegacyRoutes:         r.bool("ENABLE_LEGACY_ROUTES", true),
+
+		ListCacheTTL:      r.optDuration("LIST_CACHE_TTL", 0),
+		ListCacheMaxBytes: r.int("LIST_CACHE_MAX_BYTES", 8<<20, 1, math.MaxInt),
+
+		TableCheckInterval: r.duration("TABLE_CHECK_INTERVAL", time.Minute),
+		TableRowsWarn:      r.int64("TABLE_ROWS_WARN", 0),
+		TableBytesWarn:     r.int64("TABLE_BYTES_WARN", 0),
+		TableRowsHardCap:   r.int64("TABLE_ROWS_HARD_CAP", 0),
+
+		ClockSkewInterval:  r.duration("CLOCK_SKEW_INTERVAL", 5*time.Minute),
+		ClockSkewThreshold: r.duration("CLOCK_SKEW_THRESHOLD", 5*time.Second),
+
+		StrictRowLimit: r.bool("STRICT_ROW_LIMIT", false),
+		MaxUserID:      int64(r.int("MAX_USER_ID", math.MaxInt32, 1, math.MaxInt)),
+		MaxBodyBytes:   int64(r.int("MAX_BODY_BYTES", int(p.MaxBodyBytes), 1, math.MaxInt)),
+
+		TrustedProxies:        r.list("TRUSTED_PROXIES"),
+		CORSAllowedOrigins:    r.list("CORS_ALLOWED_ORIGINS"),
+		CORSAllowedMethods:    r.list("CORS_ALLOWED_METHODS"),
+		CORSAllowedHeaders:    r.list("CORS_ALLOWED_HEADERS"),
+		CORSMaxAge:            r.optDuration("CORS_MAX_AGE", 10*time.Minute),
+		CORSAllowCredentials:  r.bool("CORS_ALLOW_CREDENTIALS", false),
+		RateLimitRPS:          r.float("RATE_LIMIT_RPS", p.RateLimitRPS),
+		RateLimitBurst:        r.int("RATE_LIMIT_BURST", p.RateLimitBurst, 1, math.MaxInt32),
+		RateLimitSyncInterval: r.optDuration("RATE_LIMIT_SYNC_INTERVAL", 0),
+
+		RequestTimeout: r.duration("REQUEST_TIMEOUT", p.RequestTimeout),
+		DeadlineHeader: r.setString("DEADLINE_HEADER", "X-Request-Timeout"),
+		DeadlineMin:    r.duration("DEADLINE_MIN", 100*time.Millisecond),
+		DeadlineMax:    r.duration("DEADLINE_MAX", time.Minute),
+
+		ViewFlushInterval: r.optDuration("VIEW_FLUSH_INTERVAL", 0),
+		ViewBatchSize:     r.int("VIEW_BATCH_SIZE", 100, 1, math.MaxInt32),
+
+		ServerTiming: r.bool("SERVER_TIMING", false),
+
+		IdempotencyKeyTTL: r.duration("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		DuplicateWindow:   r.optDuration("DUPLICATE_WINDOW", 10*time.Second),
+		StrictIdempotency: r.bool("STRICT_IDEMPOTENCY", false),
+		RetryHeader:       r.string("RETRY_INDICATOR_HEADER", ""),
+		RequireIfMatch:    r.bool("REQUIRE_IF_MATCH", false),
+
+		ScalingWeightInFlight: r.float("SCALING_WEIGHT_IN_FLIGHT", 1),
+		ScalingWeightPool:     r.float("SCALING_WEIGHT_DB_POOL", 1),
+		BrownoutHigh:          r.float("BROWNOUT_HIGH", 0),
+		BrownoutLow:           r.float("BROWNOUT_LOW", 0),
+		BrownoutWindow:        r.duration("BROWNOUT_WINDOW", 30*time.Second),
+		MemoryHeadroom:        r.float("MEMORY_HEADROOM", 0.2),
+
+		JournalPath:         r.string("JOURNAL_PATH", ""),
+		JournalMaxBytes:     int64(r.int("JOURNAL_MAX_BYTES", 16<<20, 1, math.MaxInt)),
+		JournalSync:         r.syncPolicy("JOURNAL_SYNC"),
+		JournalSyncInterval: r.duration("JOURNAL_SYNC_INTERVAL", time.Second),
+
+		JWTSecret:   r.secret("JWT_SECRET"),
+		JWKSURL:     r.string("JWT_JWKS_URL", ""),
+		JWKSRefresh: r.duration("JWT_JWKS_REFRESH", time.Hour),
+		JWTKeysFile: r.string("JWT_KEYS_FILE", ""),
+		JWTKeyGrace: r.duration("JWT_KEY_GRACE", time.Hour),
+		JWTIssuer:   r.string("JWT_ISSUER", ""),
+		JWTAudience: r.string("JWT_AUDIENCE", ""),
+		JWTLeeway:   r.optDuration("JWT_LEEWAY", 30*time.Second),
+		APIKeys:     r.secret("API_KEYS"),
+		APIKeysFile: r.string("API_KEYS_FILE", ""),
+
+		JWTLeewayFromSkew: r.bool("JWT_LEEWAY_FROM_SKEW", false),
+		JWTLeewaySkewCap:  r.duration("JWT_LEEWAY_SKEW_CAP", 2*time.Minute),
+
+		ShareLinkSecret:     r.secret("SHARE_LINK_SECRET"),
+		ShareLinkDefaultTTL: r.duration("SHARE_LINK_DEFAULT_TTL", time.Hour),
+		ShareLinkMaxTTL:     r.duration("SHARE_LINK_MAX_TTL", 7*24*time.Hour),
+
+		RetentionInterval:  r.optDuration("RETENTION_INTERVAL", time.Hour),
+		ShareLinkRetention: r.duration("SHARE_LINK_RETENTION", 24*time.Hour),
+		RetentionBatchSize: r.int("RETENTION_BATCH_SIZE", 1000, 1, math.MaxInt32),
+
+		ReadOnlyMode:          r.bool("READ_ONLY_MODE", false),
+		ReadOnlyProbeInterval: r.duration("READ_ONLY_PROBE_INTERVAL", 10*time.Second),
+		WorkerStaleFactor:     r.float("WORKER_STALE_FACTOR", 3),
+
+		ProbeLogSample:      uint64(r.int("PROBE_LOG_SAMPLE", 0, 0, math.MaxInt)),
+		ProbeLogDebug:       r.bool("PROBE_LOG_DEBUG", false),
+		ProbeFailureHistory: r.int("PROBE_FAILURE_HISTORY", 50, 0, math.MaxInt32),
+
+		SlowTxWarn:     r.optDuration("SLOW_TX_WARN", time.Second),
+		SlowTxSnapshot: r.optDuration("SLOW_TX_SNAPSHOT", 5*time.Second),
+	}
+
+	if t.DeadlineMin > t.DeadlineMax {
+		r.fail("DEADLINE_MIN (%s) must not exceed DEADLINE_MAX (%s)", t.DeadlineMin, t.DeadlineMax)
+	}
+	if t.ShareLinkDefaultTTL > t.ShareLinkMaxTTL {
+		r.fail("SHARE_LINK_DEFAULT_TTL (%s) must not exceed SHARE_LINK_MAX_TTL (%s)", t.ShareLinkDefaultTTL, t.ShareLinkMaxTTL)
+	}
+	if t.BrownoutHigh > 0 && t.BrownoutLow >= t.BrownoutHigh {
+		r.fail("BROWNOUT_LOW (%g) must be below BROWNOUT_HIGH (%g)", t.BrownoutLow, t.BrownoutHigh)
+	}
+	if t.MemoryHeadroom >= 0.5 {
+		r.fail("MEMORY_HEADROOM (%g) must be below 0.5; batches grow back at twice the headroom", t.MemoryHeadroom)
+	}
+	sources := 0
+	for _, s := range []string{t.JWTSecret, t.JWKSURL, t.JWTKeysFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		r.fail("set only one of JWT_SECRET, JWT_JWKS_URL and JWT_KEYS_FILE")
+	}
+	return t
+}
+
+// optDuration reads a duration that may be 0, which turns the feature off.
+func (r *reader) optDuration(key string, def time.Duration) (d time.Duration) {
+	defer func() { r.settings[key] = d.String() }()
+	v, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		r.fail("%s must be a duration such as 30s, or 0 to disable, got %q", key, v)
+		return def
+	}
+	return d
+}
+
+// int64 reads a non-negative integer where zero usually disables.
+func (r *reader) int64(key string, def int64) int64 {
+	return int64(r.int(key, int(def), 0, math.MaxInt))
+}
+
+// float reads a finite, non-negative number.
+func (r *reader) float(key string, def float64) (f float64) {
+	defer func() { r.settings[key] = strconv.FormatFloat(f, 'g', -1, 64) }()
+	v, ok := r.lookup(key)
+	if !ok {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		r.fail("%s must be a non-negative number, got %q", key, v)
+		return def
+	}
+	return f
+}
+
+// list reads a comma-separated list, dropping empty entries.
+func (r *reader) list(key string) []string {
+	v, _ := r.lookup(key)
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	r.settings[key] = strings.Join(out, ",")
+	return out
+}
+
+// setString is string for a variable whose empty value means something:
+// def applies only while it is unset.
+func (r *reader) setString(key, def string) string {
+	v, set := r.lookupEnv(key)
+	v = strings.TrimSpace(v)
+	if set {
+		r.overridden = append(r.overridden, key)
+	} else {
+		v = def
+	}
+	r.settings[key] = v
+	return v
+}
+
+// secret reads a value that Settings, which is logged and served at
+// /admin/config, must not show.
+func (r *reader) secret(key string) string {
+	v, ok := r.lookup(key)
+	r.settings[key] = ""
+	if ok {
+		r.settings[key] = "(set)"
+	}
+	return v
+}
+
+func (r *reader) syncPolicy(key string) journal.SyncPolicy {
+	v, _ := r.lookup(key)
+	p, err := journal.ParseSyncPolicy(v)
+	if err != nil {
+		r.fail("%s must be always, interval or never, got %q", key, v)
+		p = journal.SyncInterval
+	}
+	r.settings[key] = string(p)
+	return p
+}
@@ -0,0 +1,98 @@
+package dsn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeAccepts(t *testing.T) {
+	for in, want := range map[string]string{
+		"postgres://app:secret@db:5432/app?sslmode=require":          "postgres://app:secret@db:5432/app?sslmode=require",
+		"  postgresql://app@db/app\n":                                "postgresql://app@db/app",
+		"host=db user=app password='p w' dbname=app sslmode=disable": "host=db user=app password='p w' dbname=app sslmode=disable",
+		"POSTGRES://db/app":                                          "postgres://db/app",
+	} {
+		if got, err := Normalize(in); err != nil || got != want {
+			t.Errorf("Normalize(%q) = %q, %v", in, got, err)
+		}
+	}
+}
+
+// The catalogue of mistakes operators actually paste, and what they are
+// told about each.
+func TestNormalizeRejects(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                                   "connection string is empty",
+		"mysql://app@db/app":                 `unsupported scheme "mysql"; did you mean postgres://?`,
+		"postgress://app@db/app":             `unsupported scheme "postgress"; did you mean postgres://?`,
+		"jdbc:postgresql://db:5432/app":      `JDBC URLs are not supported`,
+		"postgres://app:my secret@db/app":    "URL contains whitespace",
+		"postgres://app:p%zz@db/app":         "malformed URL",
+		"postgres://db/app?sslmode=required": `invalid sslmode "required"`,
+		"host=db dbname=app sslmode=on":      `invalid sslmode "on"`,
+		"db.internal:5432/app":               "not a postgres:// URL or key=value DSN",
+		"postgres://db:notaport/app":         `invalid port ":notaport"`,
+		"host=db port=notaport":              "invalid connection string",
+	} {
+		_, err := Normalize(in)
+		if err == nil {
+			t.Errorf("Normalize(%q) accepted", in)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Normalize(%q) = %q, want it to mention %q", in, err, want)
+		}
+	}
+}
+
+// Error messages never echo the password back.
+func TestErrorsHidePassword(t *testing.T) {
+	for _, in := range []string{
+		"postgres://app:hunter2@db/app?sslmode=nope",
+		"postgres://app:hunter2%zz@db/app",
+		"host=db password=hunter2 port=notaport",
+	} {
+		if _, err := Normalize(in); err == nil || strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("Normalize(%q) = %v", in, err)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+
+	got, err := FromEnv(env(map[string]string{"DATABASE_URL": " postgres://db/app ", "DB_HOST": "ignored"}))
+	if err != nil || got != "postgres://db/app" {
+		t.Errorf("DATABASE_URL: %q, %v", got, err)
+	}
+
+	got, err = FromEnv(env(map[string]string{"DB_HOST": "db", "DB_NAME": "app", "DB_USER": "app", "DB_PASSWORD": "p@ss/word", "DB_SSLMODE": "require"}))
+	if err != nil || got != "postgres://app:p%40ss%2Fword@db:5432/app?sslmode=require" {
+		t.Errorf("DB_* vars: %q, %v", got, err)
+	}
+
+	for vars, want := range map[string]map[string]string{
+		"DATABASE_URL or DB_HOST is required": {},
+		"DATABASE_URL: unsupported scheme":    {"DATABASE_URL": "http://db/app"},
+		"DB_* settings: invalid sslmode":      {"DB_HOST": "db", "DB_SSLMODE": "strict"},
+	} {
+		if _, err := FromEnv(env(want)); err == nil || !strings.HasPrefix(err.Error(), vars) {
+			t.Errorf("FromEnv(%v) = %v, want %q", want, err, vars)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for in, want := range map[string]string{
+		"postgres://app:hunter2@db/app":        "postgres://app:xxxxx@db/app",
+		"host=db password=hunter2 user=app":    "host=db password=xxxxx user=app",
+		"host=db password='hunter 2' user=app": "host=db password=xxxxx user=app",
+		"host=db user=app":                     "host=db user=app",
+	} {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
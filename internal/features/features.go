@@ -0,0 +1,114 @@
+// Package features resolves which optional, environment-sensitive
+// features are on, from a single ENVIRONMENT preset plus explicit
+// per-feature overrides.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Environments.
+const (
+	Production  = "production"
+	Staging     = "staging"
+	Development = "development"
+)
+
+// Feature names, as used in the matrix and its env overrides.
+const (
+	GinDebug = "gin_debug" // gin debug mode: route listing, verbose warnings
+	Docs     = "docs"      // /docs pages
+	DemoUI   = "demo_ui"   // embedded /ui
+)
+
+// overrideEnv maps each feature to the variable that overrides its preset.
+var overrideEnv = map[string]string{
+	GinDebug: "GIN_DEBUG",
+	Docs:     "ENABLE_DOCS",
+	DemoUI:   "ENABLE_DEMO_UI",
+}
+
+var presets = map[string]map[string]bool{
+	Production:  {GinDebug: false, Docs: false, DemoUI: false},
+	Staging:     {GinDebug: false, Docs: true, DemoUI: false},
+	Development: {GinDebug: true, Docs: true, DemoUI: true},
+}
+
+// unsafeInProduction lists features that leak internals or accept
+// unauthenticated input; production refuses them without FORCE_UNSAFE_FEATURES.
+var unsafeInProduction = []string{GinDebug, DemoUI}
+
+// Matrix is the resolved feature set.
+type Matrix struct {
+	Environment string          `json:"environment"`
+	Features    map[string]bool `json:"features"`
+	// Overridden lists features whose value came from an override.
+	Overridden []string `json:"overridden,omitempty"`
+}
+
+// On reports whether feature is enabled.
+func (m Matrix) On(feature string) bool {
+	return m.Features[feature]
+}
+
+// String renders the matrix for the startup log, e.g.
+// "demo_ui=off docs=on gin_debug=off".
+func (m Matrix) String() string {
+	names := make([]string, 0, len(m.Features))
+	for name := range m.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		state := "off"
+		if m.Features[name] {
+			state = "on"
+		}
+		parts[i] = name + "=" + state
+	}
+	return strings.Join(parts, " ")
+}
+
+// Resolve builds the matrix from ENVIRONMENT (default production) and the
+// per-feature overrides read through getenv.
+func Resolve(getenv func(string) string) (Matrix, error) {
+	env := strings.ToLower(strings.TrimSpace(getenv("ENVIRONMENT")))
+	if env == "" {
+		env = Production
+	}
+	preset, ok := presets[env]
+	if !ok {
+		return Matrix{}, fmt.Errorf("ENVIRONMENT must be production, staging or development, got %q", env)
+	}
+
+	m := Matrix{Environment: env, Features: make(map[string]bool, len(preset))}
+	for name, on := range preset {
+		m.Features[name] = on
+	}
+	for name, key := range overrideEnv {
+		v := getenv(key)
+		if v == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return Matrix{}, fmt.Errorf("%s must be true or false, got %q", key, v)
+		}
+		m.Features[name] = on
+		m.Overridden = append(m.Overridden, name)
+	}
+	sort.Strings(m.Overridden)
+
+	if env == Production && getenv("FORCE_UNSAFE_FEATURES") != "true" {
+		for _, name := range unsafeInProduction {
+			if m.Features[name] {
+				return Matrix{}, fmt.Errorf("%s is not allowed in production; set FORCE_UNSAFE_FEATURES=true to override", overrideEnv[name])
+			}
+		}
+	}
+	return m, nil
+}
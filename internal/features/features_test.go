@@ -0,0 +1,76 @@
+package features
+
+import (
+	"strings"
+	"testing"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestPresets(t *testing.T) {
+	tests := []struct {
+		env, want string
+	}{
+		{"", "demo_ui=off docs=off gin_debug=off"},
+		{"production", "demo_ui=off docs=off gin_debug=off"},
+		{"staging", "demo_ui=off docs=on gin_debug=off"},
+		{"development", "demo_ui=on docs=on gin_debug=on"},
+		{" Development ", "demo_ui=on docs=on gin_debug=on"},
+	}
+	for _, tt := range tests {
+		m, err := Resolve(env(map[string]string{"ENVIRONMENT": tt.env}))
+		if err != nil {
+			t.Errorf("%q: %v", tt.env, err)
+			continue
+		}
+		if m.String() != tt.want || len(m.Overridden) != 0 {
+			t.Errorf("%q = %s (overridden %v), want %s", tt.env, m, m.Overridden, tt.want)
+		}
+	}
+	if m, _ := Resolve(env(nil)); m.Environment != Production {
+		t.Errorf("default environment %q, want production", m.Environment)
+	}
+	if _, err := Resolve(env(map[string]string{"ENVIRONMENT": "qa"})); err == nil {
+		t.Error("unknown environment accepted")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	m, err := Resolve(env(map[string]string{"ENVIRONMENT": "development", "ENABLE_DOCS": "false", "GIN_DEBUG": "true"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.On(Docs) || !m.On(GinDebug) || !m.On(DemoUI) {
+		t.Errorf("matrix %s", m)
+	}
+	if strings.Join(m.Overridden, ",") != "docs,gin_debug" {
+		t.Errorf("overridden %v, want docs and gin_debug", m.Overridden)
+	}
+
+	m, err = Resolve(env(map[string]string{"ENVIRONMENT": "production", "ENABLE_DOCS": "1"}))
+	if err != nil || !m.On(Docs) {
+		t.Errorf("docs in production: %s, %v", m, err)
+	}
+	if _, err := Resolve(env(map[string]string{"ENABLE_DOCS": "sometimes"})); err == nil || !strings.Contains(err.Error(), "ENABLE_DOCS") {
+		t.Errorf("malformed override: %v", err)
+	}
+}
+
+// Production refuses the unsafe features unless forced.
+func TestUnsafeInProduction(t *testing.T) {
+	for _, key := range []string{"GIN_DEBUG", "ENABLE_DEMO_UI"} {
+		_, err := Resolve(env(map[string]string{key: "true"}))
+		if err == nil || !strings.Contains(err.Error(), key) || !strings.Contains(err.Error(), "FORCE_UNSAFE_FEATURES") {
+			t.Errorf("%s in production: %v", key, err)
+		}
+		m, err := Resolve(env(map[string]string{key: "true", "FORCE_UNSAFE_FEATURES": "true"}))
+		if err != nil || len(m.Overridden) != 1 || !m.On(m.Overridden[0]) {
+			t.Errorf("%s forced: %s, %v", key, m, err)
+		}
+		if _, err := Resolve(env(map[string]string{"ENVIRONMENT": "staging", key: "true"})); err != nil {
+			t.Errorf("%s in staging: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package journal is an append-only log of mutating requests, kept so that
+// after a crash (typically an OOM kill) we can tell which operations were
+// in flight.
+//
+// Each request writes a "begin" record before the handler runs and an
+// "end" record afterwards, one JSON line per record. Every record is a
+// single write(2) on an O_APPEND file, so it survives the process dying;
+// the sync policy only decides how hard we try to survive the node dying.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/supervisor"
+)
+
+// SyncPolicy controls when the journal file is fsynced.
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync after every begin record
+	SyncInterval SyncPolicy = "interval" // fsync from Run every interval
+	SyncNever    SyncPolicy = "never"    // leave it to the kernel
+)
+
+// ParseSyncPolicy validates a policy name; empty means SyncInterval.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(s); p {
+	case "":
+		return SyncInterval, nil
+	case SyncAlways, SyncInterval, SyncNever:
+		return p, nil
+	default:
+		return "", fmt.Errorf("journal: unknown sync policy %q (want always, interval or never)", s)
+	}
+}
+
+// Entry is one journal record.
+type Entry struct {
+	Time        time.Time `json:"t"`
+	ID          string    `json:"id"`
+	Op          string    `json:"op"` // "begin" or "end"
+	Route       string    `json:"route,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Actor       string    `json:"actor,omitempty"`
+	PayloadHash string    `json:"payload_sha256,omitempty"`
+	Status      int       `json:"status,omitempty"`
+}
+
+// Journal appends records to path, rotating to path+".1" when the file
+// grows past maxBytes. Begin records of operations still open are carried
+// over into the new file, so path alone is enough for recovery. Write
+// failures are logged, never returned: the journal must not take requests
+// down with it.
+type Journal struct {
+	path     string
+	maxBytes int64
+	policy   SyncPolicy
+	log      zerolog.Logger
+
+	runID string
+	seq   atomic.Uint64
+
+	mu    sync.Mutex
+	f     *os.File
+	size  int64
+	dirty bool
+	open  map[string][]byte // begin lines of unfinished operations
+}
+
+// Open recovers the journal left by the previous run, returning every
+// operation that began but never ended, then starts a fresh file.
+func Open(path string, maxBytes int64, policy SyncPolicy, logger zerolog.Logger) (*Journal, []Entry, error) {
+	interrupted, err := incomplete(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(interrupted, func(i, j int) bool { return interrupted[i].Time.Before(interrupted[j].Time) })
+
+	if err := os.Remove(path + ".1"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("journal: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("journal: %w", err)
+	}
+
+	j := &Journal{
+		path:     path,
+		maxBytes: maxBytes,
+		policy:   policy,
+		log:      logger,
+		runID:    fmt.Sprintf("%x", time.Now().UnixNano()),
+		f:        f,
+		open:     make(map[string][]byte),
+	}
+	return j, interrupted, nil
+}
+
+// incomplete returns the begin records in file p without a matching end.
+// Torn last lines from a crash mid-write are ignored.
+func incomplete(p string) ([]Entry, error) {
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("journal: %w", err)
+	}
+	defer f.Close()
+
+	open := make(map[string]Entry)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		var e Entry
+		if json.Unmarshal(sc.Bytes(), &e) != nil {
+			continue
+		}
+		switch e.Op {
+		case "begin":
+			open[e.ID] = e
+		case "end":
+			delete(open, e.ID)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("journal: reading %s: %w", p, err)
+	}
+
+	out := make([]Entry, 0, len(open))
+	for _, e := range open {
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// Begin records the start of an operation and returns its id for Complete.
+func (j *Journal) Begin(route, requestID, actor string, payload []byte) string {
+	sum := sha256.Sum256(payload)
+	id := fmt.Sprintf("%s-%d", j.runID, j.seq.Add(1))
+	j.append(Entry{
+		Time:        time.Now().UTC(),
+		ID:          id,
+		Op:          "begin",
+		Route:       route,
+		RequestID:   requestID,
+		Actor:       actor,
+		PayloadHash: hex.EncodeToString(sum[:]),
+	}, j.policy == SyncAlways)
+	return id
+}
+
+// Complete records that the operation id finished with status.
+func (j *Journal) Complete(id string, status int) {
+	j.append(Entry{Time: time.Now().UTC(), ID: id, Op: "end", Status: status}, false)
+}
+
+func (j *Journal) append(e Entry, sync bool) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		j.log.Warn().Err(err).Msg("journal: encode failed")
+		return
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return
+	}
+
+	if e.Op == "end" {
+		delete(j.open, e.ID)
+	}
+	if j.maxBytes > 0 && j.size+int64(len(line)) > j.maxBytes {
+		j.rotate()
+		if j.f == nil {
+			return
+		}
+	}
+	if e.Op == "begin" {
+		j.open[e.ID] = line
+	}
+
+	n, err := j.f.Write(line)
+	j.size += int64(n)
+	j.dirty = true
+	if err != nil {
+		j.log.Warn().Err(err).Msg("journal: write failed")
+		return
+	}
+	if sync {
+		j.syncLocked()
+	}
+}
+
+// rotate moves the current file to path.1 and starts a new one with the
+// begin records of still-open operations; called with mu held.
+func (j *Journal) rotate() {
+	j.syncLocked()
+	if err := j.f.Close(); err != nil {
+		j.log.Warn().Err(err).Msg("journal: close failed")
+	}
+	if err := os.Rename(j.path, j.path+".1"); err != nil {
+		j.log.Warn().Err(err).Msg("journal: rotate failed")
+	}
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		j.log.Error().Err(err).Msg("journal: reopen failed; journaling stopped")
+		j.f = nil
+		return
+	}
+	j.f, j.size = f, 0
+
+	for _, line := range j.open {
+		n, err := j.f.Write(line)
+		j.size += int64(n)
+		if err != nil {
+			j.log.Warn().Err(err).Msg("journal: carry-over write failed")
+			return
+		}
+	}
+}
+
+func (j *Journal) syncLocked() {
+	if !j.dirty || j.f == nil {
+		return
+	}
+	if err := j.f.Sync(); err != nil {
+		j.log.Warn().Err(err).Msg("journal: fsync failed")
+	}
+	j.dirty = false
+}
+
+// Run fsyncs every interval until ctx is cancelled; only useful with
+// SyncInterval.
+func (j *Journal) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			j.syncLocked()
+			j.mu.Unlock()
+			supervisor.Beat(ctx)
+		}
+	}
+}
+
+// Close syncs and closes the file. A clean shutdown leaves no open
+// operations behind, so the next Open reports nothing.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	j.syncLocked()
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
@@ -0,0 +1,88 @@
+package journal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func open(t *testing.T, path string, maxBytes int64) (*Journal, []Entry) {
+	t.Helper()
+	j, interrupted, err := Open(path, maxBytes, SyncAlways, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, interrupted
+}
+
+// A process killed between Begin and Complete leaves the begin record
+// behind; the next Open reports it, and only it.
+func TestCrashBetweenBeginAndComplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, interrupted := open(t, path, 0)
+	if len(interrupted) != 0 {
+		t.Fatalf("fresh journal reported %v", interrupted)
+	}
+	done := j.Begin("POST /users", "req-1", "alice", []byte(`{"name":"Ada"}`))
+	j.Begin("DELETE /users/:id", "req-2", "bob", []byte(`{}`))
+	j.Complete(done, 201)
+
+	// The crash: no Close, and a record torn mid-write.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"t":"2026-01-01T00:00:00Z","id":"x","op":"beg`)
+	f.Close()
+
+	j2, interrupted := open(t, path, 0)
+	sum := sha256.Sum256([]byte(`{}`))
+	if len(interrupted) != 1 {
+		t.Fatalf("interrupted = %+v, want the DELETE alone", interrupted)
+	}
+	if e := interrupted[0]; e.Route != "DELETE /users/:id" || e.RequestID != "req-2" || e.Actor != "bob" || e.PayloadHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("interrupted entry %+v", e)
+	}
+
+	// A clean shutdown leaves nothing to report.
+	if err := j2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, interrupted := open(t, path, 0); len(interrupted) != 0 {
+		t.Errorf("after a clean shutdown: %+v", interrupted)
+	}
+}
+
+// Rotation carries open operations into the new file, so one that began
+// long before the crash is still found.
+func TestRotationKeepsOpenOperations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, _ := open(t, path, 1024)
+	j.Begin("POST /users/batch", "long", "alice", nil)
+	for range 50 {
+		j.Complete(j.Begin("PATCH /users/:id", "short", "bob", nil), 200)
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("journal never rotated: %v", err)
+	}
+
+	_, interrupted := open(t, path, 1024)
+	if len(interrupted) != 1 || interrupted[0].RequestID != "long" {
+		t.Errorf("interrupted = %+v, want the batch request", interrupted)
+	}
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	for in, want := range map[string]SyncPolicy{"": SyncInterval, "always": SyncAlways, "interval": SyncInterval, "never": SyncNever} {
+		if got, err := ParseSyncPolicy(in); err != nil || got != want {
+			t.Errorf("ParseSyncPolicy(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseSyncPolicy("sometimes"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
@@ -0,0 +1,272 @@
+// Package logmask masks personal data and credentials in log output. It
+// sits between zerolog and the real output as an io.Writer, so it sees
+// every event whichever code path logged it:
+//
+//	masker := logmask.New(logmask.DefaultRules())
+//	log.Logger = log.Output(masker.Writer(os.Stderr))
+//
+// Each event is decoded and walked, nested objects and arrays included.
+// Key rules replace the whole value of matching field names; value rules
+// rewrite matching text in any string, the message included. Text such as
+// "password=hunter2" or an embedded JSON document inside a string is
+// masked for key rules too. Events that are not JSON objects cannot be
+// walked: they get the value rules only, or are dropped in strict mode.
+//
+// Rules can be swapped at runtime with SetRules.
+package logmask
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+// Redacted replaces masked values when a rule has no Replace text.
+const Redacted = "[REDACTED]"
+
+// Rule is one masking rule. Exactly one of Key and Value is set.
+type Rule struct {
+	// Name labels the rule in metrics.
+	Name string `json:"name"`
+	// Key matches field names whose whole value is masked.
+	Key string `json:"key,omitempty"`
+	// Value matches text inside string values.
+	Value string `json:"value,omitempty"`
+	// Replace is the replacement for Key rules, and for Value rules the
+	// regexp.ReplaceAllString template; empty means Redacted.
+	Replace string `json:"replace,omitempty"`
+}
+
+// DefaultRules masks emails, bearer tokens and the values of credential
+// fields.
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "credential_field", Key: `(?i)(password|passwd|secret|token|authorization|api[_-]?key|cookie)`},
+		{Name: "email", Value: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Replace: "[EMAIL]"},
+		{Name: "bearer_token", Value: `(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`, Replace: "Bearer " + Redacted},
+	}
+}
+
+type compiledRule struct {
+	Rule
+	key, value *regexp.Regexp
+}
+
+// embeddedPair finds key=value and "key":"value" pairs inside strings.
+var embeddedPair = regexp.MustCompile(`("?)([A-Za-z_][A-Za-z0-9_.-]*)("?\s*[:=]\s*"?)([^"&,;\s}]+)`)
+
+// Masker applies a rule set; it is safe for concurrent use.
+type Masker struct {
+	rules  atomic.Pointer[[]compiledRule]
+	strict atomic.Bool
+	// Set once metrics are registered, while events are being written.
+	masked  atomic.Pointer[metrics.CounterVec]
+	dropped atomic.Pointer[metrics.CounterVec]
+}
+
+// New returns a Masker with rules; see SetRules.
+func New(rules []Rule) (*Masker, error) {
+	m := &Masker{}
+	if err := m.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetRules replaces the rule set. Invalid rules leave the current set in
+// place.
+func (m *Masker) SetRules(rules []Rule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			return fmt.Errorf("logmask: rule %d has no name", i)
+		}
+		if (r.Key == "") == (r.Value == "") {
+			return fmt.Errorf("logmask: rule %q needs exactly one of key and value", r.Name)
+		}
+		if r.Replace == "" {
+			r.Replace = Redacted
+		}
+		cr := compiledRule{Rule: r}
+		var err error
+		if r.Key != "" {
+			cr.key, err = regexp.Compile(r.Key)
+		} else {
+			cr.value, err = regexp.Compile(r.Value)
+		}
+		if err != nil {
+			return fmt.Errorf("logmask: rule %q: %w", r.Name, err)
+		}
+		compiled = append(compiled, cr)
+	}
+	m.rules.Store(&compiled)
+	return nil
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("logmask: %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// SetStrict makes the Masker drop events it cannot walk instead of
+// passing them on with only the value rules applied.
+func (m *Masker) SetStrict(strict bool) { m.strict.Store(strict) }
+
+// RegisterMetrics counts maskings per rule and dropped events on reg.
+// Events before the call are not counted.
+func (m *Masker) RegisterMetrics(reg *metrics.Registry) {
+	m.masked.Store(reg.Counter("log_maskings_total", "Values masked in log events, by rule.", "rule"))
+	m.dropped.Store(reg.Counter("log_events_dropped_total", "Log events dropped by strict masking because they were not JSON objects.", "reason"))
+}
+
+// Writer returns an io.Writer that masks each event before writing it to
+// w. zerolog writes one event per Write call.
+func (m *Masker) Writer(w io.Writer) io.Writer {
+	return &writer{m: m, out: w}
+}
+
+type writer struct {
+	m   *Masker
+	out io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	masked, ok := w.m.Mask(p)
+	if !ok {
+		return len(p), nil
+	}
+	if _, err := w.out.Write(masked); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Mask returns the masked event, or false when strict mode drops it. An
+// event no rule touched comes back unchanged, byte for byte.
+func (m *Masker) Mask(event []byte) ([]byte, bool) {
+	rules := *m.rules.Load()
+
+	var doc map[string]any
+	dec := json.NewDecoder(bytes.NewReader(event))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil || doc == nil {
+		if m.strict.Load() {
+			if c := m.dropped.Load(); c != nil {
+				c.With("not_json").Inc()
+			}
+			return nil, false
+		}
+		s := string(event)
+		if masked, changed := m.maskString(rules, s); changed {
+			return []byte(masked), true
+		}
+		return event, true
+	}
+
+	if !m.walk(rules, doc) {
+		return event, true
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		// Unreachable for decoded JSON; never pass the original on.
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+// walk masks v in place and reports whether anything changed.
+func (m *Masker) walk(rules []compiledRule, v any) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if r, ok := keyRule(rules, k); ok {
+				v[k] = r.Replace
+				m.count(r.Name)
+				changed = true
+				continue
+			}
+			if s, ok := val.(string); ok {
+				if masked, c := m.maskString(rules, s); c {
+					v[k], changed = masked, true
+				}
+				continue
+			}
+			changed = m.walk(rules, val) || changed
+		}
+	case []any:
+		for i, val := range v {
+			if s, ok := val.(string); ok {
+				if masked, c := m.maskString(rules, s); c {
+					v[i], changed = masked, true
+				}
+				continue
+			}
+			changed = m.walk(rules, val) || changed
+		}
+	}
+	return changed
+}
+
+// maskString applies the value rules, then key rules to key=value pairs
+// embedded in s.
+func (m *Masker) maskString(rules []compiledRule, s string) (string, bool) {
+	changed := false
+	for _, r := range rules {
+		if r.value == nil || !r.value.MatchString(s) {
+			continue
+		}
+		n := len(r.value.FindAllStringIndex(s, -1))
+		s = r.value.ReplaceAllString(s, r.Replace)
+		m.add(r.Name, n)
+		changed = true
+	}
+	if !strings.Contains(s, "=") && !strings.Contains(s, `":`) {
+		return s, changed
+	}
+	s = embeddedPair.ReplaceAllStringFunc(s, func(pair string) string {
+		g := embeddedPair.FindStringSubmatch(pair)
+		r, ok := keyRule(rules, g[2])
+		if !ok || g[4] == r.Replace {
+			return pair
+		}
+		m.count(r.Name)
+		changed = true
+		return g[1] + g[2] + g[3] + r.Replace
+	})
+	return s, changed
+}
+
+func keyRule(rules []compiledRule, key string) (compiledRule, bool) {
+	for _, r := range rules {
+		if r.key != nil && r.key.MatchString(key) {
+			return r, true
+		}
+	}
+	return compiledRule{}, false
+}
+
+func (m *Masker) count(rule string) { m.add(rule, 1) }
+
+func (m *Masker) add(rule string, n int) {
+	if c := m.masked.Load(); c != nil {
+		c.With(rule).Add(float64(n))
+	}
+}
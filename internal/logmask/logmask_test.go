@@ -0,0 +1,242 @@
+package logmask
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+func newMasker(t testing.TB, rules []Rule) *Masker {
+	t.Helper()
+	m, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMaskLeakyEvents(t *testing.T) {
+	m := newMasker(t, DefaultRules())
+	tests := []struct {
+		name, event string
+		leaks       []string
+		want        []string
+	}{
+		{"credential field", `{"level":"info","password":"hunter2","user":"ada"}`,
+			[]string{"hunter2"}, []string{`"password":"[REDACTED]"`, `"user":"ada"`}},
+		{"field name in any case", `{"X-API-Key":"k-123","Authorization":"Basic YWRh"}`,
+			[]string{"k-123", "YWRh"}, []string{`"X-API-Key":"[REDACTED]"`}},
+		{"non-string credential", `{"token":{"raw":"abc","exp":1}}`,
+			[]string{"abc"}, []string{`"token":"[REDACTED]"`}},
+		{"email in the message", `{"message":"created ada@example.com and grace@example.org"}`,
+			[]string{"ada@example.com", "grace@example.org"}, []string{`"created [EMAIL] and [EMAIL]"`}},
+		{"bearer token", `{"header":"bearer eyJhbGciOi.eyJzdWIi.sig"}`,
+			[]string{"eyJhbGciOi"}, []string{`"Bearer [REDACTED]"`}},
+		{"nested objects and arrays", `{"req":{"body":{"users":[{"email":"ada@example.com","secret":"s1"},"bo@example.com"]}}}`,
+			[]string{"ada@example.com", "s1", "bo@example.com"}, []string{`"secret":"[REDACTED]"`, `"[EMAIL]"`}},
+		{"key=value in a string", `{"query":"user=ada&password=hunter2&page=2"}`,
+			[]string{"hunter2"}, []string{"user=ada&password=[REDACTED]&page=2"}},
+		{"JSON document in a string", `{"body":"{\"name\":\"Ada\",\"api_key\":\"k-123\"}"}`,
+			[]string{"k-123"}, []string{`\"api_key\":\"[REDACTED]\"`, `\"name\":\"Ada\"`}},
+		{"plain text", `password=hunter2 sent by ada@example.com`,
+			[]string{"hunter2", "ada@example.com"}, []string{"password=[REDACTED] sent by [EMAIL]"}},
+	}
+	for _, tt := range tests {
+		out, ok := m.Mask([]byte(tt.event))
+		if !ok {
+			t.Errorf("%s: dropped", tt.name)
+			continue
+		}
+		for _, leak := range tt.leaks {
+			if bytes.Contains(out, []byte(leak)) {
+				t.Errorf("%s: %q leaked in %s", tt.name, leak, out)
+			}
+		}
+		for _, want := range tt.want {
+			if !bytes.Contains(out, []byte(want)) {
+				t.Errorf("%s: %s lacks %s", tt.name, out, want)
+			}
+		}
+	}
+}
+
+// Events without anything to mask pass through byte for byte, numbers
+// and key order included.
+func TestMaskUnchanged(t *testing.T) {
+	m := newMasker(t, DefaultRules())
+	for _, event := range []string{
+		`{"level":"info","status":200,"latency":0.000123456789,"id":12345678901234567890,"path":"/users?limit=10"}` + "\n",
+		"not json at all\n",
+		`{"message":"password=[REDACTED]"}`,
+	} {
+		if out, ok := m.Mask([]byte(event)); !ok || string(out) != event {
+			t.Errorf("Mask(%q) = %q, %v", event, out, ok)
+		}
+	}
+}
+
+func TestStrictDropsUnwalkableEvents(t *testing.T) {
+	m := newMasker(t, DefaultRules())
+	reg := metrics.NewRegistry()
+	m.RegisterMetrics(reg)
+	m.SetStrict(true)
+
+	var out bytes.Buffer
+	w := m.Writer(&out)
+	for _, event := range []string{"password=hunter2\n", "[1,2]\n", `{"ok":true}` + "\n"} {
+		if n, err := w.Write([]byte(event)); err != nil || n != len(event) {
+			t.Errorf("Write(%q) = %d, %v", event, n, err)
+		}
+	}
+	if out.String() != `{"ok":true}`+"\n" {
+		t.Errorf("output %q, want only the JSON object", out.String())
+	}
+	if s := scrape(reg); !strings.Contains(s, `log_events_dropped_total{reason="not_json"} 2`) {
+		t.Errorf("drops not counted:\n%s", s)
+	}
+}
+
+func TestMaskingMetrics(t *testing.T) {
+	m := newMasker(t, DefaultRules())
+	m.Mask([]byte(`{"before":"ada@example.com"}`)) // not counted yet
+	reg := metrics.NewRegistry()
+	m.RegisterMetrics(reg)
+	m.Mask([]byte(`{"a":"ada@example.com, bo@example.com","password":"x","q":"token=abc"}`))
+	s := scrape(reg)
+	for _, want := range []string{`log_maskings_total{rule="email"} 2`, `log_maskings_total{rule="credential_field"} 2`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("metrics lack %s:\n%s", want, s)
+		}
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	m := newMasker(t, DefaultRules())
+	for name, rules := range map[string][]Rule{
+		"unnamed":      {{Key: "x"}},
+		"neither":      {{Name: "r"}},
+		"both":         {{Name: "r", Key: "x", Value: "y"}},
+		"bad regexp":   {{Name: "r", Value: "("}},
+		"bad key expr": {{Name: "r", Key: "[a-"}},
+	} {
+		if err := m.SetRules(rules); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	// The rejected sets left the defaults in place.
+	if out, _ := m.Mask([]byte(`{"password":"p"}`)); !bytes.Contains(out, []byte(Redacted)) {
+		t.Errorf("defaults lost after invalid rules: %s", out)
+	}
+
+	if err := m.SetRules([]Rule{{Name: "ssn", Value: `\b\d{3}-\d{2}-(\d{4})\b`, Replace: "***-**-$1"}, {Name: "pin", Key: "^pin$", Replace: "****"}}); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := m.Mask([]byte(`{"ssn":"123-45-6789","pin":"1234","password":"p"}`))
+	if !bytes.Contains(out, []byte(`"ssn":"***-**-6789"`)) || !bytes.Contains(out, []byte(`"pin":"****"`)) || !bytes.Contains(out, []byte(`"password":"p"`)) {
+		t.Errorf("new rules not in effect: %s", out)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(path, []byte(`[{"name":"card","value":"\\b\\d{16}\\b","replace":"[CARD]"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil || len(rules) != 1 || rules[0].Replace != "[CARD]" {
+		t.Fatalf("LoadRules = %+v, %v", rules, err)
+	}
+	os.WriteFile(path, []byte(`{"name":"card"}`), 0o600)
+	if _, err := LoadRules(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("malformed file: %v", err)
+	}
+	if _, err := LoadRules(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
+
+// Through zerolog, as the server wires it, with rules swapped while
+// events are written; run with -race.
+func TestWriterWithZerolog(t *testing.T) {
+	m := newMasker(t, DefaultRules())
+	var mu sync.Mutex
+	var out bytes.Buffer
+	logger := zerolog.New(m.Writer(lockedWriter{&mu, &out}))
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				logger.Info().Str("email", "ada@example.com").Str("api_key", "k-1").Msg("signed in")
+			}
+		}()
+	}
+	for range 10 {
+		m.SetRules(DefaultRules())
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 200 {
+		t.Fatalf("%d lines, want 200", len(lines))
+	}
+	for _, line := range lines {
+		var e map[string]string
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if e["email"] != "[EMAIL]" || e["api_key"] != Redacted || e["message"] != "signed in" {
+			t.Fatalf("event %v", e)
+		}
+	}
+}
+
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func scrape(reg *metrics.Registry) string {
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+// accessEvent is what the access log writes for every request, the hot
+// path the masker sits on.
+var accessEvent = []byte(`{"level":"info","request_id":"5f0c2b8e-3c1d-4a8e-9b7e-2f6d1c0a9e41","method":"GET","path":"/api/v1/users?limit=20&name=ada","status":200,"latency":0.001234,"bytes":5120,"client_ip":"10.1.2.3","user_agent":"Mozilla/5.0","time":"2026-10-14T07:00:00Z","message":"request"}` + "\n")
+
+func BenchmarkMaskAccessEvent(b *testing.B) {
+	m := newMasker(b, DefaultRules())
+	b.ReportAllocs()
+	for range b.N {
+		m.Mask(accessEvent)
+	}
+}
+
+func BenchmarkMaskLeakyEvent(b *testing.B) {
+	m := newMasker(b, DefaultRules())
+	event := []byte(`{"level":"warn","email":"ada@example.com","password":"hunter2","query":"token=abc&page=2","message":"sign in failed for ada@example.com"}` + "\n")
+	b.ReportAllocs()
+	for range b.N {
+		m.Mask(event)
+	}
+}
@@ -0,0 +1,141 @@
+// Package metrics keeps process metrics on a Prometheus registry and
+// serves them with promhttp. It is a thin layer over
+// github.com/prometheus/client_golang that keeps registration to one line
+// per metric:
+//
+//	reqs := reg.Counter("http_requests_total", "Requests served.", "route", "status")
+//	reqs.With("/users", "200").Inc()
+//
+// Label values are passed in the order the label names were declared.
+// Funcs (GaugeFunc, CounterFunc) are sampled on every scrape, for values
+// that already live elsewhere; Collector registers anything else the
+// client library can collect, such as the pool statistics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds, suited to request
+// latencies from sub-millisecond cache hits to timed-out writes.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds every metric of a process, along with the Go runtime and
+// process metrics. The zero value is not usable; call NewRegistry.
+type Registry struct {
+	reg     *prometheus.Registry
+	handler http.Handler
+}
+
+// NewRegistry returns a Registry with only the runtime and process
+// metrics. Registering a name twice panics.
+func NewRegistry() *Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	// Like promhttp.Handler, on this registry rather than the global one,
+	// so every server and test gets its own.
+	handler := promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	return &Registry{reg: reg, handler: handler}
+}
+
+// Collector registers c, for metrics the other methods do not cover.
+func (r *Registry) Collector(c prometheus.Collector) {
+	r.reg.MustRegister(c)
+}
+
+// Counter registers a monotonically increasing value per label set.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, labels)
+	r.reg.MustRegister(c)
+	return &CounterVec{vec: c}
+}
+
+// Gauge registers a value that can go up and down per label set, for
+// values the caller samples itself.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, labels)
+	r.reg.MustRegister(g)
+	return &GaugeVec{vec: g}
+}
+
+// Histogram registers a distribution per label set; nil buckets means
+// DefaultBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: help, Buckets: buckets}, labels)
+	r.reg.MustRegister(h)
+	return &HistogramVec{vec: h}
+}
+
+// GaugeFunc registers a value that can go up and down, read from fn on
+// every scrape.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, fn))
+}
+
+// CounterFunc registers a cumulative value read from fn on every scrape.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.reg.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, fn))
+}
+
+// Handler serves every registered metric, in whichever exposition format
+// the scraper asks for, and counts its own scrapes.
+func (r *Registry) Handler() http.Handler {
+	return r.handler
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec *prometheus.CounterVec
+}
+
+// Counter is one labeled series of a CounterVec.
+type Counter struct {
+	c prometheus.Counter
+}
+
+// With returns the series for the label values, creating it at zero. It
+// panics when the number of values does not match the labels.
+func (c *CounterVec) With(values ...string) *Counter {
+	return &Counter{c: c.vec.WithLabelValues(values...)}
+}
+
+// Inc adds one.
+func (c *Counter) Inc() { c.c.Inc() }
+
+// Add adds v, which must not be negative.
+func (c *Counter) Add(v float64) { c.c.Add(v) }
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec *prometheus.GaugeVec
+}
+
+// Gauge is one labeled series of a GaugeVec.
+type Gauge struct {
+	g prometheus.Gauge
+}
+
+// With returns the series for the label values, creating it at zero.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{g: g.vec.WithLabelValues(values...)}
+}
+
+// Set replaces the value.
+func (g *Gauge) Set(v float64) { g.g.Set(v) }
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec *prometheus.HistogramVec
+}
+
+// Observe records v in the series for the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.vec.WithLabelValues(values...).Observe(v)
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	return w.Body.String()
+}
+
+// withoutRuntime drops the runtime, process and promhttp families every
+// Registry has.
+func withoutRuntime(out string) string {
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(out, "\n") {
+		name := strings.TrimPrefix(strings.TrimPrefix(line, "# HELP "), "# TYPE ")
+		if !strings.HasPrefix(name, "go_") && !strings.HasPrefix(name, "process_") && !strings.HasPrefix(name, "promhttp_") {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	reqs := reg.Counter("http_requests_total", "Requests served.", "route", "status")
+	reqs.With("/users", "200").Inc()
+	reqs.With("/users", "200").Add(2)
+	reqs.With(`/odd"path\`, "500").Inc()
+	reg.Gauge("queue_depth", "Queued jobs.", "queue").With("views").Set(4.5)
+	lat := reg.Histogram("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		lat.Observe(v, "/users")
+	}
+	reg.GaugeFunc("pool_idle", "Idle connections.", func() float64 { return 7 })
+	reg.CounterFunc("waits_total", "Waits.", func() float64 { return 1.25 })
+
+	// Families come sorted by name.
+	want := `# HELP http_requests_total Requests served.
+# TYPE http_requests_total counter
+http_requests_total{route="/odd\"path\\",status="500"} 1
+http_requests_total{route="/users",status="200"} 3
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/users",le="0.1"} 2
+latency_seconds_bucket{route="/users",le="1"} 3
+latency_seconds_bucket{route="/users",le="+Inf"} 4
+latency_seconds_sum{route="/users"} 3.65
+latency_seconds_count{route="/users"} 4
+# HELP pool_idle Idle connections.
+# TYPE pool_idle gauge
+pool_idle 7
+# HELP queue_depth Queued jobs.
+# TYPE queue_depth gauge
+queue_depth{queue="views"} 4.5
+# HELP waits_total Waits.
+# TYPE waits_total counter
+waits_total 1.25
+`
+	out := scrape(t, reg)
+	if got := withoutRuntime(out); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	for _, family := range []string{"go_goroutines", "process_start_time_seconds", "promhttp_metric_handler_requests_total"} {
+		if !strings.Contains(out, "# TYPE "+family+" ") {
+			t.Errorf("no %s", family)
+		}
+	}
+}
+
+func TestRegistrationMistakesPanic(t *testing.T) {
+	for name, fn := range map[string]func(){
+		"duplicate name": func() {
+			reg := NewRegistry()
+			reg.Counter("x_total", "X.")
+			reg.GaugeFunc("x_total", "X.", func() float64 { return 0 })
+		},
+		"missing label value": func() {
+			NewRegistry().Counter("y_total", "Y.", "route", "status").With("/users")
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+// Run with -race: series are created and updated while being scraped.
+func TestConcurrentUpdates(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("c_total", "C.", "worker")
+	h := reg.Histogram("h_seconds", "H.", nil, "worker")
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			label := string(rune('a' + w))
+			for range 100 {
+				c.With(label).Inc()
+				h.Observe(0.01, label)
+			}
+		}()
+	}
+	for range 10 {
+		scrape(t, reg)
+	}
+	wg.Wait()
+	out := scrape(t, reg)
+	for w := range 8 {
+		if line := `c_total{worker="` + string(rune('a'+w)) + `"} 100`; !strings.Contains(out, line) {
+			t.Errorf("missing %s", line)
+		}
+	}
+}
+
+// The pool statistics come from one snapshot per scrape; the pool is
+// never connected, so it is empty.
+func TestRegisterPool(t *testing.T) {
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost:1/none?pool_max_conns=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	reg := NewRegistry()
+	calls := 0
+	RegisterPool(reg, func() *pgxpool.Stat { calls++; return pool.Stat() })
+
+	out := scrape(t, reg)
+	for _, line := range []string{
+		"pgxpool_acquired_conns 0",
+		"pgxpool_idle_conns 0",
+		"pgxpool_total_conns 0",
+		"pgxpool_max_conns 3",
+		"# TYPE pgxpool_acquire_wait_seconds_total counter\npgxpool_acquire_wait_seconds_total 0",
+		"# TYPE pgxpool_empty_acquire_total counter\npgxpool_empty_acquire_total 0",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("stat called %d times in a scrape", calls)
+	}
+}
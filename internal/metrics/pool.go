@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterPool exports the connection pool statistics stat returns, e.g.
+// pool.Stat.
+func RegisterPool(r *Registry, stat func() *pgxpool.Stat) {
+	r.Collector(&poolCollector{stat: stat})
+}
+
+var (
+	poolAcquired = prometheus.NewDesc("pgxpool_acquired_conns", "Connections currently checked out of the pool.", nil, nil)
+	poolIdle     = prometheus.NewDesc("pgxpool_idle_conns", "Idle connections in the pool.", nil, nil)
+	poolTotal    = prometheus.NewDesc("pgxpool_total_conns", "Connections in the pool, acquired, idle or being established.", nil, nil)
+	poolMax      = prometheus.NewDesc("pgxpool_max_conns", "Maximum size of the pool.", nil, nil)
+	poolWait     = prometheus.NewDesc("pgxpool_acquire_wait_seconds_total", "Time spent waiting for a connection because none was idle.", nil, nil)
+	poolEmpty    = prometheus.NewDesc("pgxpool_empty_acquire_total", "Acquires that had to wait for a connection.", nil, nil)
+)
+
+// poolCollector takes one snapshot of the pool per scrape, so the gauges
+// it reports agree with each other.
+type poolCollector struct {
+	stat func() *pgxpool.Stat
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{poolAcquired, poolIdle, poolTotal, poolMax, poolWait, poolEmpty} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotal, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMax, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolWait, prometheus.CounterValue, s.EmptyAcquireWaitTime().Seconds())
+	ch <- prometheus.MustNewConstMetric(poolEmpty, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+}
@@ -0,0 +1,552 @@
+// Package migrate applies the embedded SQL migrations at startup, as an
+// alternative to the Flyway Job. Files are named like Flyway's versioned
+// migrations (V<version>__<description>.sql) and each one runs in its own
+// transaction, recorded in schema_migrations with its checksum. A
+// migration may have an undo script, U<version>__<description>.sql as in
+// Flyway Teams, which Down runs to roll it back.
+//
+// A Postgres advisory lock serializes replicas that start together: the
+// first one to take it migrates while the others poll for it, for up to
+// the wait timeout, then find nothing to do. When the one migrating dies
+// its session ends, the failed migration rolls back with it and the next
+// replica to take the lock applies it instead. Inspect reports the
+// progress from any session.
+// A database that Flyway migrated before is adopted: the successful
+// versions of flyway_schema_history count as applied.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// lockKey is the pg_advisory_lock key held while migrating; any constant
+// works as long as nothing else in the database uses it.
+const lockKey int64 = 0x676f6b3864656d6f // "gok8demo"
+
+// ErrWaitTimeout is returned by Up when another session held the
+// migration lock for longer than the wait timeout.
+var ErrWaitTimeout = errors.New("timed out waiting for another replica's migration")
+
+// Option configures Up.
+type Option func(*options)
+
+type options struct {
+	wait time.Duration
+	poll time.Duration
+}
+
+// WithWaitTimeout bounds how long Up waits while another replica
+// migrates; zero waits for as long as ctx allows.
+func WithWaitTimeout(d time.Duration) Option {
+	return func(o *options) { o.wait = d }
+}
+
+// WithPollInterval sets how often a waiting Up tries the lock again,
+// every second by default.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.poll = d
+		}
+	}
+}
+
+// Migration is one versioned migration file and its undo script, if any.
+// The checksum covers the migration only, so adding an undo script later
+// doesn't invalidate an applied version.
+type Migration struct {
+	Version     int
+	Description string
+	Name        string
+	SQL         string
+	Checksum    string
+	Undo        string
+}
+
+var (
+	fileName    = regexp.MustCompile(`^([VU])(\d+)__(.+)\.sql$`)
+	underscores = regexp.MustCompile(`_+`)
+)
+
+// Load reads the migrations of fsys in version order. Files that don't
+// look like migrations are ignored; two files with the same version, or
+// an undo script without its migration, are an error.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var out []Migration
+	seen := make(map[string]string)
+	undo := make(map[int]string)
+	for _, e := range entries {
+		m := fileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.Atoi(m[2])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migrate: %s: invalid version", e.Name())
+		}
+		key := m[1] + strconv.Itoa(version)
+		if other, ok := seen[key]; ok {
+			return nil, fmt.Errorf("migrate: %s and %s share version %d", other, e.Name(), version)
+		}
+		seen[key] = e.Name()
+
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if m[1] == "U" {
+			undo[version] = string(b)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		out = append(out, Migration{
+			Version:     version,
+			Description: underscores.ReplaceAllString(m[3], " "),
+			Name:        e.Name(),
+			SQL:         string(b),
+			Checksum:    hex.EncodeToString(sum[:]),
+		})
+	}
+	for i := range out {
+		if sql, ok := undo[out[i].Version]; ok {
+			out[i].Undo = sql
+			delete(undo, out[i].Version)
+		}
+	}
+	for v := range undo {
+		return nil, fmt.Errorf("migrate: %s has no migration to undo", seen["U"+strconv.Itoa(v)])
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Up applies every migration of fsys that the database doesn't have yet
+// and returns the resulting schema version. It fails without applying
+// anything when an applied migration's file has changed since, or when
+// the database is ahead of the binary's migrations. While another
+// session holds the lock, Up waits for it to finish, up to
+// WithWaitTimeout; ctx bounds the wait as well as the migrations.
+func Up(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, opts ...Option) (int, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+	o := options{poll: time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	version := 0
+	err = locked(ctx, pool, o.waitLock, func(conn *pgx.Conn, applied map[int]string) error {
+		if err := verify(migrations, applied); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; !ok {
+				start := time.Now()
+				if err := apply(ctx, conn, m); err != nil {
+					return fmt.Errorf("migrate: %s: %w", m.Name, err)
+				}
+				log.Info().Int("version", m.Version).Str("migration", m.Name).Dur("took", time.Since(start)).Msg("Applied migration")
+			}
+			version = m.Version
+		}
+		return nil
+	})
+	return version, err
+}
+
+// Down rolls the database back to version target by running the undo
+// scripts of the applied migrations above it, newest first, each in its
+// own transaction. It fails without rolling anything back when one of
+// them has no undo script, or for the same reasons as Up. The returned
+// version is the one the database is left at, also on error.
+func Down(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS, target int) (int, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return 0, err
+	}
+	version := 0
+	err = locked(ctx, pool, lock, func(conn *pgx.Conn, applied map[int]string) error {
+		if err := verify(migrations, applied); err != nil {
+			return err
+		}
+		steps, err := rollbackPlan(migrations, applied, target)
+		if err != nil {
+			return err
+		}
+		version = highest(applied)
+		var flyway bool
+		if err := conn.QueryRow(ctx, "SELECT to_regclass('flyway_schema_history') IS NOT NULL").Scan(&flyway); err != nil {
+			return err
+		}
+		for _, m := range steps {
+			start := time.Now()
+			if err := undo(ctx, conn, m, flyway); err != nil {
+				return fmt.Errorf("migrate: undo %s: %w", m.Name, err)
+			}
+			log.Info().Int("version", m.Version).Str("migration", m.Name).Dur("took", time.Since(start)).Msg("Rolled back migration")
+			delete(applied, m.Version)
+			version = highest(applied)
+		}
+		return nil
+	})
+	return version, err
+}
+
+// rollbackPlan returns the applied migrations above target, newest first.
+func rollbackPlan(migrations []Migration, applied map[int]string, target int) ([]Migration, error) {
+	if target < 0 {
+		return nil, fmt.Errorf("migrate: invalid target version %d", target)
+	}
+	var steps []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Undo == "" {
+			return nil, fmt.Errorf("migrate: %s has no undo script", m.Name)
+		}
+		steps = append(steps, m)
+	}
+	return steps, nil
+}
+
+func highest(applied map[int]string) int {
+	version := 0
+	for v := range applied {
+		version = max(version, v)
+	}
+	return version
+}
+
+// verify fails when an applied migration's file has changed since, or
+// when the database has a version fsys doesn't.
+func verify(migrations []Migration, applied map[int]string) error {
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		if sum, ok := applied[m.Version]; ok && sum != "" && sum != m.Checksum {
+			return fmt.Errorf("migrate: %s was changed after it was applied", m.Name)
+		}
+	}
+	for v := range applied {
+		if !known[v] {
+			return fmt.Errorf("migrate: database has version %d, which this binary doesn't know", v)
+		}
+	}
+	return nil
+}
+
+// lock takes the migration lock, blocking until it is free.
+func lock(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey)
+	return err
+}
+
+// waitLock takes the migration lock, polling for it while another
+// session holds it, for up to o.wait.
+func (o options) waitLock(ctx context.Context, conn *pgx.Conn) error {
+	var ok bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&ok); err != nil || ok {
+		return err
+	}
+	start := time.Now()
+	log.Info().Dur("timeout", o.wait).Msg("Another replica is migrating the database; waiting for it")
+
+	var timeout <-chan time.Time
+	if o.wait > 0 {
+		timer := time.NewTimer(o.wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(o.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("%w after %s", ErrWaitTimeout, o.wait)
+		case <-ticker.C:
+		}
+		if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&ok); err != nil || ok {
+			if ok {
+				log.Info().Dur("waited", time.Since(start)).Msg("The other replica let go of the migration lock")
+			}
+			return err
+		}
+	}
+}
+
+// locked runs fn on a connection holding the migration lock, taken by
+// take, with schema_migrations created, Flyway's history adopted and the
+// applied versions read.
+func locked(ctx context.Context, pool *pgxpool.Pool, take func(context.Context, *pgx.Conn) error, fn func(conn *pgx.Conn, applied map[int]string) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if err := take(ctx, conn.Conn()); err != nil {
+		return fmt.Errorf("migrate: lock: %w", err)
+	}
+	defer func() {
+		// Unlock even when ctx is done; a failed unlock ends the session
+		// so the lock can't outlive it.
+		unlockCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if _, err := conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", lockKey); err != nil {
+			conn.Conn().Close(unlockCtx)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version     INT PRIMARY KEY,
+			description TEXT NOT NULL,
+			checksum    TEXT,
+			applied_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+		return fmt.Errorf("migrate: create schema_migrations: %w", err)
+	}
+	if err := adoptFlyway(ctx, conn.Conn()); err != nil {
+		return fmt.Errorf("migrate: adopt flyway_schema_history: %w", err)
+	}
+
+	applied, err := appliedChecksums(ctx, conn.Conn())
+	if err != nil {
+		return err
+	}
+	return fn(conn.Conn(), applied)
+}
+
+func apply(ctx context.Context, conn *pgx.Conn, m Migration) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		// Without arguments Exec uses the simple protocol, which accepts
+		// the several statements a migration file usually has.
+		if _, err := tx.Exec(ctx, m.SQL); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx,
+			"INSERT INTO schema_migrations (version, description, checksum) VALUES ($1, $2, $3)",
+			m.Version, m.Description, m.Checksum)
+		return err
+	})
+}
+
+// undo rolls m back and forgets it. The version also leaves Flyway's
+// history, so that neither a later adoption nor Flyway itself still
+// counts it as applied.
+func undo(ctx context.Context, conn *pgx.Conn, m Migration, flyway bool) error {
+	return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, m.Undo); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version); err != nil {
+			return err
+		}
+		if flyway {
+			_, err := tx.Exec(ctx, "DELETE FROM flyway_schema_history WHERE version = $1", strconv.Itoa(m.Version))
+			return err
+		}
+		return nil
+	})
+}
+
+// adoptFlyway copies the versions Flyway applied into an empty
+// schema_migrations, without checksums since Flyway computes its own.
+func adoptFlyway(ctx context.Context, conn *pgx.Conn) error {
+	var exists bool
+	err := conn.QueryRow(ctx, `
+		SELECT to_regclass('flyway_schema_history') IS NOT NULL
+		   AND NOT EXISTS (SELECT 1 FROM schema_migrations)`).Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+	tag, err := conn.Exec(ctx, `
+		INSERT INTO schema_migrations (version, description, applied_at)
+		SELECT version::int, description, installed_on
+		FROM flyway_schema_history
+		WHERE success AND version ~ '^[0-9]+$'
+		ON CONFLICT (version) DO NOTHING`)
+	if err == nil && tag.RowsAffected() > 0 {
+		log.Info().Int64("versions", tag.RowsAffected()).Msg("Adopted migrations applied by Flyway")
+	}
+	return err
+}
+
+func appliedChecksums(ctx context.Context, conn *pgx.Conn) (map[int]string, error) {
+	rows, err := conn.Query(ctx, "SELECT version, COALESCE(checksum, '') FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		applied[version] = checksum
+	}
+	return applied, rows.Err()
+}
+
+// Version reports the schema version without migrating: the highest
+// version in schema_migrations, or in flyway_schema_history for a
+// database only Flyway has migrated. It is zero for an empty database.
+func Version(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	// The tables are probed first: a query naming a missing table fails
+	// even in a branch that would not run.
+	var ours, flyway bool
+	err := pool.QueryRow(ctx, `
+		SELECT to_regclass('schema_migrations') IS NOT NULL,
+		       to_regclass('flyway_schema_history') IS NOT NULL`).Scan(&ours, &flyway)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	if ours {
+		if err := pool.QueryRow(ctx, "SELECT COALESCE(max(version), 0) FROM schema_migrations").Scan(&version); err != nil || version > 0 {
+			return version, err
+		}
+	}
+	if flyway {
+		err = pool.QueryRow(ctx, `
+			SELECT COALESCE(max(version::int), 0)
+			FROM flyway_schema_history
+			WHERE success AND version ~ '^[0-9]+$'`).Scan(&version)
+	}
+	return version, err
+}
+
+// Status is where the database stands against a set of migrations, as
+// GET /admin/migrations and the migrate status subcommand report it.
+type Status struct {
+	// Version is the schema version, Latest the highest of the
+	// migrations; Pending lists those not applied yet, in order.
+	Version int       `json:"version"`
+	Latest  int       `json:"latest"`
+	Pending []Pending `json:"pending"`
+	// Migrating is set while a session holds the migration lock.
+	Migrating *Progress `json:"migrating,omitempty"`
+}
+
+// Pending is a migration the database does not have.
+type Pending struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+}
+
+// Progress is the session holding the migration lock. Applying is the
+// first pending version, the one it works on while it migrates, and
+// Elapsed how long its current transaction has been running.
+type Progress struct {
+	PID            uint32  `json:"pid"`
+	Applying       int     `json:"applying,omitempty"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+}
+
+// Inspect reports the status of the database against the migrations of
+// fsys without taking the lock, so it answers while another session
+// migrates. A database Flyway migrated reports its Flyway versions.
+func Inspect(ctx context.Context, pool *pgxpool.Pool, fsys fs.FS) (*Status, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+	st := &Status{Version: highest(applied), Pending: []Pending{}}
+	for _, m := range migrations {
+		st.Latest = m.Version
+		if _, ok := applied[m.Version]; !ok {
+			st.Pending = append(st.Pending, Pending{Version: m.Version, Name: m.Name})
+		}
+	}
+
+	var (
+		pid     uint32
+		elapsed float64
+	)
+	err = pool.QueryRow(ctx, `
+		SELECT l.pid, COALESCE(EXTRACT(EPOCH FROM now() - a.xact_start), 0)::float8
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+		  AND l.classid = ($1::bigint >> 32)::oid AND l.objid = ($1::bigint & 4294967295)::oid`,
+		lockKey).Scan(&pid, &elapsed)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+	case err != nil:
+		return nil, err
+	default:
+		st.Migrating = &Progress{PID: pid, ElapsedSeconds: elapsed}
+		if len(st.Pending) > 0 {
+			st.Migrating.Applying = st.Pending[0].Version
+		}
+	}
+	return st, nil
+}
+
+// appliedVersions reads the applied versions from schema_migrations, or
+// from flyway_schema_history when there are none there yet.
+func appliedVersions(ctx context.Context, pool *pgxpool.Pool) (map[int]string, error) {
+	var ours, flyway bool
+	err := pool.QueryRow(ctx, `
+		SELECT to_regclass('schema_migrations') IS NOT NULL,
+		       to_regclass('flyway_schema_history') IS NOT NULL`).Scan(&ours, &flyway)
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]string)
+	if ours {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Release()
+		if applied, err = appliedChecksums(ctx, conn.Conn()); err != nil || len(applied) > 0 {
+			return applied, err
+		}
+	}
+	if flyway {
+		rows, err := pool.Query(ctx, "SELECT version::int FROM flyway_schema_history WHERE success AND version ~ '^[0-9]+$'")
+		if err != nil {
+			return nil, err
+		}
+		versions, err := pgx.CollectRows(rows, pgx.RowTo[int])
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range versions {
+			applied[v] = ""
+		}
+	}
+	return applied, nil
+}
@@ -0,0 +1,381 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"go-k8s-demo/migrations"
+)
+
+func files(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys[name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func TestLoadPairsUndoScripts(t *testing.T) {
+	got, err := Load(files("V2__add_b.sql", "U1__create_a.sql", "V1__create_a.sql", "README.md", "V10__add_c.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Version != 1 || got[1].Version != 2 || got[2].Version != 10 {
+		t.Fatalf("got %+v, want versions 1, 2, 10", got)
+	}
+	if got[0].Description != "create a" || got[0].Undo != "-- U1__create_a.sql" || got[1].Undo != "" {
+		t.Errorf("got %+v", got)
+	}
+	if got[0].Checksum == "" || got[0].Checksum == got[1].Checksum {
+		t.Errorf("checksums %q and %q", got[0].Checksum, got[1].Checksum)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"shared version": files("V1__a.sql", "V1__b.sql"),
+		"two undos":      files("V1__a.sql", "U1__a.sql", "U1__b.sql"),
+		"orphan undo":    files("V1__a.sql", "U2__b.sql"),
+		"version zero":   files("V0__a.sql"),
+	} {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestEmbeddedMigrationsCanBeUndone(t *testing.T) {
+	all, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range all {
+		if m.Version != i+1 {
+			t.Errorf("%s: version %d, want %d", m.Name, m.Version, i+1)
+		}
+		if m.Undo == "" {
+			t.Errorf("%s has no undo script", m.Name)
+		}
+	}
+}
+
+func TestRollbackPlan(t *testing.T) {
+	all := []Migration{
+		{Version: 1, Name: "V1", Undo: "u1"},
+		{Version: 2, Name: "V2"},
+		{Version: 3, Name: "V3", Undo: "u3"},
+		{Version: 4, Name: "V4", Undo: "u4"},
+	}
+	applied := map[int]string{1: "", 2: "", 3: "", 4: ""}
+
+	steps, err := rollbackPlan(all, applied, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Version != 4 || steps[1].Version != 3 {
+		t.Errorf("to 2: %+v, want 4 then 3", steps)
+	}
+	if steps, err := rollbackPlan(all, applied, 4); err != nil || len(steps) != 0 {
+		t.Errorf("to the current version: %+v %v", steps, err)
+	}
+	// Rolling back over V2 would leave the database half undone.
+	if _, err := rollbackPlan(all, applied, 0); err == nil || !strings.Contains(err.Error(), "V2") {
+		t.Errorf("over a migration without undo: %v", err)
+	}
+	// Versions never applied are skipped.
+	if steps, err := rollbackPlan(all, map[int]string{1: "", 3: ""}, 2); err != nil || len(steps) != 1 || steps[0].Version != 3 {
+		t.Errorf("with gaps: %+v %v", steps, err)
+	}
+	if _, err := rollbackPlan(all, applied, -1); err == nil {
+		t.Error("negative target accepted")
+	}
+}
+
+// scratchPool returns a pool on an empty scratch schema of the database
+// at TEST_DATABASE_URL, dropped when the test ends, and skips the test
+// without one.
+func scratchPool(t *testing.T, ctx context.Context) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close(context.Background()) })
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE") })
+
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+// TestUpAndDown applies every embedded migration to a scratch schema,
+// rolls them all back and applies them again.
+func TestUpAndDown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx)
+
+	all, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := all[len(all)-1].Version
+	tableExists := func(name string) bool {
+		t.Helper()
+		var ok bool
+		if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", name).Scan(&ok); err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if v, err := Up(ctx, pool, migrations.FS); err != nil || v != latest {
+		t.Fatalf("Up: version %d, %v; want %d", v, err, latest)
+	}
+	if !tableExists("user_labels") {
+		t.Fatal("user_labels missing after Up")
+	}
+
+	if v, err := Down(ctx, pool, migrations.FS, 4); err != nil || v != 4 {
+		t.Fatalf("Down to 4: version %d, %v", v, err)
+	}
+	if tableExists("user_labels") || !tableExists("share_link_uses") {
+		t.Error("Down to 4 left the wrong tables")
+	}
+	if v, err := Version(ctx, pool); err != nil || v != 4 {
+		t.Errorf("Version after Down: %d, %v", v, err)
+	}
+
+	if v, err := Down(ctx, pool, migrations.FS, 0); err != nil || v != 0 {
+		t.Fatalf("Down to 0: version %d, %v", v, err)
+	}
+	if tableExists("users") {
+		t.Error("users survived a full rollback")
+	}
+
+	// The undo scripts leave nothing behind that a fresh Up trips over.
+	if v, err := Up(ctx, pool, migrations.FS); err != nil || v != latest {
+		t.Fatalf("Up after Down: version %d, %v", v, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	all := []Migration{{Version: 1, Name: "V1__a.sql", Checksum: "aa"}, {Version: 2, Name: "V2__b.sql", Checksum: "bb"}}
+	for name, tt := range map[string]struct {
+		applied map[int]string
+		err     string
+	}{
+		"fresh":              {map[int]string{}, ""},
+		"partly applied":     {map[int]string{1: "aa"}, ""},
+		"adopted, no sum":    {map[int]string{1: "", 2: ""}, ""},
+		"edited after apply": {map[int]string{1: "aa", 2: "changed"}, "V2__b.sql was changed"},
+		"database ahead":     {map[int]string{1: "aa", 2: "bb", 3: "cc"}, "version 3"},
+	} {
+		err := verify(all, tt.applied)
+		if (tt.err == "") != (err == nil) || err != nil && !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: %v, want %q", name, err, tt.err)
+		}
+	}
+}
+
+// Replicas starting together take turns on the advisory lock: each ends
+// at the latest version and every migration is applied once.
+func TestConcurrentUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx)
+	all, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const replicas = 4
+	errs := make(chan error, replicas)
+	for range replicas {
+		go func() {
+			v, err := Up(ctx, pool, migrations.FS)
+			if err == nil && v != all[len(all)-1].Version {
+				err = fmt.Errorf("ended at version %d", v)
+			}
+			errs <- err
+		}()
+	}
+	for range replicas {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	var rows int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM schema_migrations").Scan(&rows); err != nil || rows != len(all) {
+		t.Errorf("schema_migrations has %d rows, %v; want %d", rows, err, len(all))
+	}
+}
+
+// holdLock takes the migration lock on a session of its own, standing in
+// for another replica, and starts the first migration's transaction
+// there; crash ends the session without committing.
+func holdLock(t *testing.T, ctx context.Context, pool *pgxpool.Pool) (pid uint32, crash func()) {
+	t.Helper()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, "BEGIN; CREATE TABLE users (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	crash = func() {
+		once.Do(func() {
+			conn.Conn().Close(context.Background())
+			conn.Release()
+		})
+	}
+	t.Cleanup(crash)
+	return conn.Conn().PgConn().PID(), crash
+}
+
+// A replica that finds another one migrating gives up after the wait
+// timeout, without touching the schema.
+func TestUpWaitTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx)
+	holdLock(t, ctx, pool)
+
+	_, err := Up(ctx, pool, migrations.FS, WithWaitTimeout(100*time.Millisecond), WithPollInterval(10*time.Millisecond))
+	if !errors.Is(err, ErrWaitTimeout) {
+		t.Fatalf("Up: %v, want ErrWaitTimeout", err)
+	}
+	if v, err := Version(ctx, pool); err != nil || v != 0 {
+		t.Errorf("version %d, %v; want 0", v, err)
+	}
+}
+
+// While the leader migrates, Inspect shows its progress and a follower
+// waits; when the leader dies mid-migration its transaction rolls back
+// and the follower applies everything itself.
+func TestFollowerTakesOverFromFailedLeader(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx)
+	all, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := all[len(all)-1].Version
+	leader, crash := holdLock(t, ctx, pool)
+
+	type result struct {
+		version int
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := Up(ctx, pool, migrations.FS, WithWaitTimeout(30*time.Second), WithPollInterval(10*time.Millisecond))
+		done <- result{v, err}
+	}()
+
+	st, err := Inspect(ctx, pool, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Migrating == nil || st.Migrating.PID != leader || st.Migrating.Applying != 1 || st.Version != 0 || len(st.Pending) != len(all) {
+		t.Errorf("while the leader migrates: %+v, migrating %+v", st, st.Migrating)
+	}
+	select {
+	case r := <-done:
+		t.Fatalf("the follower did not wait: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	crash()
+	if r := <-done; r.err != nil || r.version != latest {
+		t.Fatalf("follower: version %d, %v; want %d", r.version, r.err, latest)
+	}
+	st, err = Inspect(ctx, pool, migrations.FS)
+	if err != nil || st.Migrating != nil || len(st.Pending) != 0 || st.Version != latest || st.Latest != latest {
+		t.Errorf("after the takeover: %+v, %v", st, err)
+	}
+}
+
+// A migration edited after it was applied stops Up before anything runs.
+func TestUpRejectsChangedMigration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx)
+
+	fsys := fstest.MapFS{"V1__create_a.sql": {Data: []byte("CREATE TABLE a (id INT)")}}
+	if v, err := Up(ctx, pool, fsys); err != nil || v != 1 {
+		t.Fatalf("Up: %d, %v", v, err)
+	}
+	fsys["V1__create_a.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE a (id BIGINT)")}
+	fsys["V2__create_b.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE b (id INT)")}
+	if _, err := Up(ctx, pool, fsys); err == nil || !strings.Contains(err.Error(), "changed after it was applied") {
+		t.Fatalf("Up with an edited migration: %v", err)
+	}
+	if v, err := Version(ctx, pool); err != nil || v != 1 {
+		t.Errorf("version %d, %v; want 1, nothing applied", v, err)
+	}
+}
+
+// A database Flyway migrated is adopted: its successful versions are not
+// applied again and the rest are.
+func TestUpAdoptsFlyway(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx)
+
+	if _, err := pool.Exec(ctx, `
+		CREATE TABLE flyway_schema_history (
+			installed_rank INT PRIMARY KEY, version TEXT, description TEXT,
+			installed_on TIMESTAMPTZ NOT NULL DEFAULT now(), success BOOLEAN NOT NULL);
+		INSERT INTO flyway_schema_history VALUES
+			(1, '1', 'create a', now(), true),
+			(2, '2', 'create b', now(), false);
+		CREATE TABLE a (id INT)`); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := Version(ctx, pool); err != nil || v != 1 {
+		t.Errorf("Version of a Flyway database: %d, %v", v, err)
+	}
+
+	fsys := fstest.MapFS{
+		"V1__create_a.sql": {Data: []byte("CREATE TABLE a (id INT)")},
+		"V2__create_b.sql": {Data: []byte("CREATE TABLE b (id INT)")},
+	}
+	if v, err := Up(ctx, pool, fsys); err != nil || v != 2 {
+		t.Fatalf("Up: %d, %v", v, err)
+	}
+	var b bool
+	if err := pool.QueryRow(ctx, "SELECT to_regclass('b') IS NOT NULL").Scan(&b); err != nil || !b {
+		t.Errorf("the failed Flyway version was not applied: %v", err)
+	}
+}
@@ -0,0 +1,497 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// advisorWindow is how far back the index advisor counts queries.
+	advisorWindow = time.Hour
+	// advisorSlots divides the window; a slot's counts expire together.
+	advisorSlots = 12
+	// maxQueryShapes bounds the distinct shapes counted at once. Filters
+	// and sort fields are few, so only collations and sort orders add up;
+	// queries of shapes beyond the bound are counted as untracked.
+	maxQueryShapes = 256
+)
+
+// queryShape is what the index advisor keeps of a users query: which
+// filters it used and its order, never their values.
+type queryShape struct {
+	metadata, labels, name, email bool
+	includeDeleted                bool
+	sort                          Sort
+}
+
+func shapeOf(f UserFilter, sort Sort) queryShape {
+	return queryShape{
+		metadata:       len(f.Metadata) > 0,
+		labels:         len(f.Labels) > 0,
+		name:           f.Name != "",
+		email:          f.Email != "",
+		includeDeleted: f.IncludeDeleted,
+		sort:           sort,
+	}
+}
+
+// key identifies the shape in the counters.
+func (s queryShape) key() string {
+	var b strings.Builder
+	for _, on := range []bool{s.metadata, s.labels, s.name, s.email, s.includeDeleted} {
+		if on {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	b.WriteString(s.sort.String())
+	if c := s.sort.Collation(); c != "" {
+		b.WriteString(" " + c)
+	}
+	return b.String()
+}
+
+func (s queryShape) filters() []string {
+	filters := []string{}
+	for _, f := range []struct {
+		on   bool
+		name string
+	}{{s.metadata, "metadata"}, {s.labels, "labels"}, {s.name, "name"}, {s.email, "email"}} {
+		if f.on {
+			filters = append(filters, f.name)
+		}
+	}
+	return filters
+}
+
+// shapeCounter counts query shapes over a rolling window of
+// advisorSlots slots; observing is a map increment under a mutex.
+type shapeCounter struct {
+	mu      sync.Mutex
+	slotLen time.Duration
+	slots   [advisorSlots]shapeSlot
+	shapes  map[string]queryShape
+}
+
+type shapeSlot struct {
+	start     time.Time
+	counts    map[string]int64
+	untracked int64
+}
+
+func newShapeCounter(window time.Duration) *shapeCounter {
+	return &shapeCounter{slotLen: window / advisorSlots, shapes: make(map[string]queryShape)}
+}
+
+// observe counts one query of shape s at now.
+func (c *shapeCounter) observe(s queryShape, now time.Time) {
+	key := s.key()
+	start := now.Truncate(c.slotLen)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slot := &c.slots[start.UnixNano()/int64(c.slotLen)%advisorSlots]
+	if !slot.start.Equal(start) {
+		*slot = shapeSlot{start: start, counts: make(map[string]int64)}
+	}
+	if _, ok := c.shapes[key]; !ok {
+		if len(c.shapes) >= maxQueryShapes {
+			c.prune(now)
+		}
+		if len(c.shapes) >= maxQueryShapes {
+			slot.untracked++
+			return
+		}
+		c.shapes[key] = s
+	}
+	slot.counts[key]++
+}
+
+// counted returns the shapes counted within the window before now with
+// their counts, and how many queries were not counted for lack of room.
+func (c *shapeCounter) counted(now time.Time) (shapes []queryShape, counts []int64, untracked int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	totals, untracked := c.totals(now)
+	for key, n := range totals {
+		shapes = append(shapes, c.shapes[key])
+		counts = append(counts, n)
+	}
+	return shapes, counts, untracked
+}
+
+// totals sums the slots still inside the window.
+func (c *shapeCounter) totals(now time.Time) (totals map[string]int64, untracked int64) {
+	oldest := now.Truncate(c.slotLen).Add(-c.slotLen * (advisorSlots - 1))
+	totals = make(map[string]int64)
+	for _, slot := range c.slots {
+		if slot.start.Before(oldest) {
+			continue
+		}
+		for key, n := range slot.counts {
+			totals[key] += n
+		}
+		untracked += slot.untracked
+	}
+	return totals, untracked
+}
+
+// prune forgets the shapes no slot in the window counts any more.
+func (c *shapeCounter) prune(now time.Time) {
+	totals, _ := c.totals(now)
+	for key := range c.shapes {
+		if totals[key] == 0 {
+			delete(c.shapes, key)
+		}
+	}
+}
+
+// observe is the advisor's hook in the query builder: every users query
+// passes its filter and order here before it runs.
+func (r *Repository) observe(filter UserFilter, sort Sort) {
+	r.shapes.observe(shapeOf(filter, sort), time.Now())
+}
+
+// IndexAdvice lists the query shapes of the last WindowSeconds that ran
+// at least MinCount times and that no index supports, with the indexes
+// that would. The statements are suggestions; nothing runs them.
+type IndexAdvice struct {
+	GeneratedAt   time.Time     `json:"generated_at"`
+	WindowSeconds float64       `json:"window_seconds"`
+	MinCount      int64         `json:"min_count"`
+	Indexes       []string      `json:"indexes"`
+	Untracked     int64         `json:"untracked_queries"`
+	Shapes        []ShapeAdvice `json:"shapes"`
+}
+
+// ShapeAdvice is one filter and sort combination without index support.
+type ShapeAdvice struct {
+	Filters        []string          `json:"filters"`
+	Sort           string            `json:"sort,omitempty"`
+	Collation      string            `json:"collation,omitempty"`
+	IncludeDeleted bool              `json:"include_deleted,omitempty"`
+	Count          int64             `json:"count"`
+	Missing        []IndexSuggestion `json:"missing"`
+}
+
+// IndexSuggestion is an index one part of a shape lacks.
+type IndexSuggestion struct {
+	For       string `json:"for"`
+	Statement string `json:"statement"`
+	Note      string `json:"note,omitempty"`
+}
+
+// IndexAdvice matches the shapes observed in the window against the
+// indexes in pg_indexes and reports those seen at least minCount times
+// that lack an index.
+func (r *Repository) IndexAdvice(ctx context.Context, minCount int64) (*IndexAdvice, error) {
+	var defs []indexDef
+	err := pgx.BeginTxFunc(ctx, r.db, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+			SELECT tablename, indexname, indexdef FROM pg_indexes
+			WHERE schemaname = current_schema() AND tablename IN ('users', 'user_labels')
+			ORDER BY tablename, indexname`)
+		if err != nil {
+			return err
+		}
+		var table, name, def string
+		_, err = pgx.ForEachRow(rows, []any{&table, &name, &def}, func() error {
+			defs = append(defs, parseIndexDef(table, name, def))
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	shapes, counts, untracked := r.shapes.counted(now)
+	advice := adviseIndexes(shapes, counts, defs, minCount)
+	advice.GeneratedAt = now.UTC()
+	advice.WindowSeconds = (r.shapes.slotLen * advisorSlots).Seconds()
+	advice.Untracked = untracked
+	return advice, nil
+}
+
+// adviseIndexes is the matching behind IndexAdvice, most frequent shape
+// first.
+func adviseIndexes(shapes []queryShape, counts []int64, defs []indexDef, minCount int64) *IndexAdvice {
+	advice := &IndexAdvice{MinCount: minCount, Indexes: []string{}, Shapes: []ShapeAdvice{}}
+	for _, d := range defs {
+		advice.Indexes = append(advice.Indexes, d.name)
+	}
+	for i, s := range shapes {
+		if counts[i] < minCount {
+			continue
+		}
+		missing := s.missing(defs)
+		if len(missing) == 0 {
+			continue
+		}
+		advice.Shapes = append(advice.Shapes, ShapeAdvice{
+			Filters:        s.filters(),
+			Sort:           s.sort.String(),
+			Collation:      s.sort.Collation(),
+			IncludeDeleted: s.includeDeleted,
+			Count:          counts[i],
+			Missing:        missing,
+		})
+	}
+	slices.SortFunc(advice.Shapes, func(a, b ShapeAdvice) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(fmt.Sprint(a.Filters, a.Sort, a.Collation), fmt.Sprint(b.Filters, b.Sort, b.Collation))
+	})
+	return advice
+}
+
+// missing lists an index for each part of s no index in defs supports.
+func (s queryShape) missing(defs []indexDef) []IndexSuggestion {
+	predicate := ""
+	if !s.includeDeleted {
+		predicate = " WHERE " + active
+	}
+	var out []IndexSuggestion
+	if s.metadata && !s.supported(defs, "users", func(d indexDef) bool {
+		return d.method == "gin" && len(d.columns) > 0 && d.columns[0].expr == "metadata"
+	}) {
+		out = append(out, IndexSuggestion{
+			For:       "metadata filter",
+			Statement: "CREATE INDEX CONCURRENTLY users_metadata_idx ON users USING GIN (metadata jsonb_path_ops)" + predicate,
+		})
+	}
+	if s.labels && !s.supported(defs, "user_labels", func(d indexDef) bool {
+		return d.method == "btree" && len(d.columns) > 0 && d.columns[0].expr == "key"
+	}) {
+		out = append(out, IndexSuggestion{
+			For:       "label filter",
+			Statement: "CREATE INDEX CONCURRENTLY user_labels_key_value_idx ON user_labels (key, value, user_id)",
+		})
+	}
+	for _, field := range []struct {
+		on     bool
+		column string
+	}{{s.name, "name"}, {s.email, "email"}} {
+		if field.on && !s.supported(defs, "users", func(d indexDef) bool { return d.trigram(field.column) }) {
+			out = append(out, IndexSuggestion{
+				For:       field.column + " search",
+				Statement: fmt.Sprintf("CREATE INDEX CONCURRENTLY users_%s_trgm_idx ON users USING GIN (%s gin_trgm_ops)%s", field.column, field.column, predicate),
+				Note:      "ILIKE '%...%' needs a trigram index; it requires CREATE EXTENSION pg_trgm",
+			})
+		}
+	}
+	if !s.supported(defs, "users", s.ordersBy) {
+		out = append(out, IndexSuggestion{For: "sort", Statement: s.sortIndex() + predicate})
+	}
+	return out
+}
+
+// supported reports whether an index on table that covers every row the
+// shape can match satisfies ok.
+func (s queryShape) supported(defs []indexDef, table string, ok func(indexDef) bool) bool {
+	for _, d := range defs {
+		if d.table != table {
+			continue
+		}
+		// An index limited to active users serves no IncludeDeleted query.
+		if d.predicate != "" && (s.includeDeleted || d.predicate != active) {
+			continue
+		}
+		if ok(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// ordersBy reports whether a scan of d, forwards or backwards, returns
+// rows in the order of the shape's sort keys.
+func (s queryShape) ordersBy(d indexDef) bool {
+	keys := s.sort
+	if len(keys) == 0 {
+		keys = Sort{{Field: "id"}}
+	}
+	if d.method != "btree" || len(d.columns) < len(keys) {
+		return false
+	}
+	backward := keys[0].Desc != d.columns[0].desc
+	for i, k := range keys {
+		col := d.columns[i]
+		if col.expr != sortColumns[k.Field] || col.collation != k.Collation || (k.Desc != col.desc) != backward {
+			return false
+		}
+	}
+	return true
+}
+
+// sortIndex is the CREATE INDEX statement for the shape's order, its
+// columns as orderBy writes them.
+func (s queryShape) sortIndex() string {
+	terms := strings.Split(strings.TrimPrefix(s.sort.orderBy(), " ORDER BY "), ", ")
+	name := []string{"users"}
+	for _, k := range s.sort {
+		name = append(name, k.Field)
+		if k.Collation != "" {
+			name = append(name, strings.ToLower(strings.ReplaceAll(k.Collation, "-", "_")))
+		}
+		if k.Desc {
+			name = append(name, "desc")
+		}
+	}
+	if len(s.sort) == 0 {
+		name = append(name, "id")
+	}
+	name = append(name, "idx")
+	return fmt.Sprintf("CREATE INDEX CONCURRENTLY %s ON users (%s)", strings.Join(name, "_"), strings.Join(terms, ", "))
+}
+
+// indexDef is an index as pg_indexes defines it.
+type indexDef struct {
+	table, name string
+	method      string
+	columns     []indexColumn
+	predicate   string
+}
+
+// indexColumn is one key of an index; expr is the column name, or the
+// parenthesized expression of an expression index.
+type indexColumn struct {
+	expr      string
+	collation string
+	opclass   string
+	desc      bool
+}
+
+func (d indexDef) trigram(column string) bool {
+	if d.method != "gin" && d.method != "gist" {
+		return false
+	}
+	for _, c := range d.columns {
+		if c.expr == column && (c.opclass == "gin_trgm_ops" || c.opclass == "gist_trgm_ops") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIndexDef reads the pg_get_indexdef form of pg_indexes.indexdef,
+// such as
+//
+//	CREATE UNIQUE INDEX users_email_key ON public.users USING btree (email)
+//
+// A definition it cannot read has no columns and supports nothing.
+func parseIndexDef(table, name, def string) indexDef {
+	d := indexDef{table: table, name: name}
+	_, rest, ok := strings.Cut(def, " USING ")
+	if !ok {
+		return d
+	}
+	d.method, rest, _ = strings.Cut(rest, " ")
+	keys, rest, ok := parenthesized(rest)
+	if !ok {
+		return d
+	}
+	for _, key := range splitTop(keys, ',') {
+		d.columns = append(d.columns, parseIndexColumn(key))
+	}
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "INCLUDE ") {
+		_, rest, _ = parenthesized(strings.TrimPrefix(rest, "INCLUDE "))
+		rest = strings.TrimSpace(rest)
+	}
+	if pred, ok := strings.CutPrefix(rest, "WHERE "); ok {
+		for strings.HasPrefix(pred, "(") && strings.HasSuffix(pred, ")") {
+			inner, after, _ := parenthesized(pred)
+			if after != "" {
+				break
+			}
+			pred = inner
+		}
+		d.predicate = pred
+	}
+	return d
+}
+
+func parseIndexColumn(key string) indexColumn {
+	words := splitTop(strings.TrimSpace(key), ' ')
+	if len(words) == 0 {
+		return indexColumn{}
+	}
+	col := indexColumn{expr: words[0]}
+	for i := 1; i < len(words); i++ {
+		switch words[i] {
+		case "COLLATE":
+			if i+1 < len(words) {
+				i++
+				col.collation = strings.Trim(words[i], `"`)
+			}
+		case "DESC":
+			col.desc = true
+		case "ASC":
+		case "NULLS":
+			i++
+		default:
+			col.opclass = words[i]
+		}
+	}
+	return col
+}
+
+// parenthesized splits s, which starts with "(", into the text inside
+// the balanced parentheses and what follows them.
+func parenthesized(s string) (inner, rest string, ok bool) {
+	if !strings.HasPrefix(s, "(") {
+		return "", s, false
+	}
+	depth, quoted := 0, false
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return s[1:i], s[i+1:], true
+			}
+		}
+	}
+	return "", s, false
+}
+
+// splitTop splits s at sep outside of parentheses and double quotes,
+// dropping empty parts.
+func splitTop(s string, sep rune) []string {
+	var parts []string
+	depth, quoted, start := 0, false, 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			if part := strings.TrimSpace(s[start:i]); part != "" {
+				parts = append(parts, part)
+			}
+			start = i + 1
+		}
+	}
+	if part := strings.TrimSpace(s[start:]); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
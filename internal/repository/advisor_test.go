@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// schemaIndexes is pg_indexes for the migrated schema, as Postgres
+// prints it.
+var schemaIndexes = []indexDef{
+	parseIndexDef("users", "users_pkey", "CREATE UNIQUE INDEX users_pkey ON public.users USING btree (id)"),
+	parseIndexDef("users", "users_email_key", "CREATE UNIQUE INDEX users_email_key ON public.users USING btree (email)"),
+	parseIndexDef("users", "users_metadata_idx", "CREATE INDEX users_metadata_idx ON public.users USING gin (metadata jsonb_path_ops)"),
+	parseIndexDef("user_labels", "user_labels_pkey", "CREATE UNIQUE INDEX user_labels_pkey ON public.user_labels USING btree (user_id, key)"),
+	parseIndexDef("user_labels", "user_labels_key_value_idx", "CREATE INDEX user_labels_key_value_idx ON public.user_labels USING btree (key, value, user_id)"),
+}
+
+func TestParseIndexDef(t *testing.T) {
+	tests := []struct {
+		def  string
+		want indexDef
+	}{
+		{"CREATE UNIQUE INDEX users_email_key ON public.users USING btree (email)",
+			indexDef{method: "btree", columns: []indexColumn{{expr: "email"}}}},
+		{`CREATE INDEX users_name_sv_se_desc_idx ON public.users USING btree (name COLLATE "sv-SE" DESC NULLS LAST, id) WHERE (deleted_at IS NULL)`,
+			indexDef{method: "btree", columns: []indexColumn{{expr: "name", collation: "sv-SE", desc: true}, {expr: "id"}}, predicate: "deleted_at IS NULL"}},
+		{"CREATE INDEX users_name_trgm_idx ON public.users USING gin (name gin_trgm_ops) WHERE ((deleted_at IS NULL) AND (id > 0))",
+			indexDef{method: "gin", columns: []indexColumn{{expr: "name", opclass: "gin_trgm_ops"}}, predicate: "(deleted_at IS NULL) AND (id > 0)"}},
+		{"CREATE INDEX users_lower_email_idx ON public.users USING btree (lower(email), id) INCLUDE (name)",
+			indexDef{method: "btree", columns: []indexColumn{{expr: "lower(email)"}, {expr: "id"}}}},
+		{"not an index", indexDef{}},
+	}
+	for _, tt := range tests {
+		got := parseIndexDef("users", "ix", tt.def)
+		tt.want.table, tt.want.name = "users", "ix"
+		if got.method != tt.want.method || !slices.Equal(got.columns, tt.want.columns) || got.predicate != tt.want.predicate {
+			t.Errorf("parseIndexDef(%q) = %+v, want %+v", tt.def, got, tt.want)
+		}
+	}
+}
+
+func mustSort(t *testing.T, spec, collation string) Sort {
+	t.Helper()
+	sort, err := ParseSort(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collation != "" {
+		if sort, err = sort.WithCollation(collation); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return sort
+}
+
+// A synthetic workload against the migrated schema: only the frequent
+// shapes the schema has no index for are reported, each with the index
+// it lacks.
+func TestAdviseIndexes(t *testing.T) {
+	c := newShapeCounter(time.Hour)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	workload := []struct {
+		filter UserFilter
+		sort   Sort
+		n      int
+	}{
+		{UserFilter{}, nil, 500},
+		{UserFilter{Name: "ada"}, nil, 120},
+		{UserFilter{}, mustSort(t, "-email", ""), 80},
+		{UserFilter{}, mustSort(t, "name", "sv-SE"), 40},
+		{UserFilter{Metadata: map[string]string{"team": "a"}, Labels: map[string]string{"env": "prod"}}, nil, 30},
+		{UserFilter{IncludeDeleted: true}, mustSort(t, "email", ""), 20},
+		{UserFilter{Email: "example"}, mustSort(t, "-name", ""), 3},
+	}
+	for i, w := range workload {
+		for j := range w.n {
+			c.observe(shapeOf(w.filter, w.sort), now.Add(time.Duration(i*w.n+j)*time.Millisecond))
+		}
+	}
+	shapes, counts, _ := c.counted(now.Add(time.Minute))
+	advice := adviseIndexes(shapes, counts, schemaIndexes, 10)
+
+	var got []string
+	for _, s := range advice.Shapes {
+		for _, m := range s.Missing {
+			got = append(got, fmt.Sprintf("%d %v %s: %s", s.Count, s.Filters, m.For, m.Statement))
+		}
+	}
+	want := []string{
+		`120 [name] name search: CREATE INDEX CONCURRENTLY users_name_trgm_idx ON users USING GIN (name gin_trgm_ops) WHERE deleted_at IS NULL`,
+		`40 [] sort: CREATE INDEX CONCURRENTLY users_name_sv_se_idx ON users (name COLLATE "sv-SE", id) WHERE deleted_at IS NULL`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("advice:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !slices.Contains(advice.Indexes, "users_email_key") {
+		t.Errorf("indexes %v", advice.Indexes)
+	}
+
+	// The rare shape shows with a lower threshold, missing both indexes.
+	advice = adviseIndexes(shapes, counts, schemaIndexes, 1)
+	last := advice.Shapes[len(advice.Shapes)-1]
+	if last.Count != 3 || last.Sort != "-name" || len(last.Missing) != 2 {
+		t.Errorf("rare shape %+v", last)
+	}
+}
+
+func TestAdviseIndexesPartialIndex(t *testing.T) {
+	defs := []indexDef{parseIndexDef("users", "users_name_idx", "CREATE INDEX users_name_idx ON public.users USING btree (name DESC, id DESC) WHERE (deleted_at IS NULL)")}
+	for _, tt := range []struct {
+		filter UserFilter
+		spec   string
+		ok     bool
+	}{
+		{UserFilter{}, "name", true},  // a backward scan
+		{UserFilter{}, "-name", true}, // a forward scan
+		{UserFilter{}, "name,-id", false},
+		{UserFilter{}, "email", false},
+		{UserFilter{IncludeDeleted: true}, "name", false},
+	} {
+		s := shapeOf(tt.filter, mustSort(t, tt.spec, ""))
+		if got := s.supported(defs, "users", s.ordersBy); got != tt.ok {
+			t.Errorf("%+v sort %s: supported %v, want %v", tt.filter, tt.spec, got, tt.ok)
+		}
+	}
+}
+
+func TestShapeCounterWindow(t *testing.T) {
+	c := newShapeCounter(time.Hour)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	byName := mustSort(t, "name", "")
+	c.observe(shapeOf(UserFilter{}, byName), start)
+	c.observe(shapeOf(UserFilter{}, byName), start.Add(30*time.Minute))
+	c.observe(shapeOf(UserFilter{Name: "x"}, nil), start.Add(30*time.Minute))
+
+	total := func(now time.Time) int64 {
+		_, counts, _ := c.counted(now)
+		var n int64
+		for _, v := range counts {
+			n += v
+		}
+		return n
+	}
+	if n := total(start.Add(40 * time.Minute)); n != 3 {
+		t.Errorf("inside the window: %d queries, want 3", n)
+	}
+	if n := total(start.Add(65 * time.Minute)); n != 2 {
+		t.Errorf("after the first slot expired: %d queries, want 2", n)
+	}
+	if n := total(start.Add(2 * time.Hour)); n != 0 {
+		t.Errorf("after the window: %d queries, want 0", n)
+	}
+}
+
+func TestShapeCounterBound(t *testing.T) {
+	c := newShapeCounter(time.Hour)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Sort orders of up to three fields, collations and a few filters
+	// give more shapes than the bound.
+	var sorts []Sort
+	for _, spec := range []string{"id", "name", "email", "name,email", "email,name", "name,id", "id,name", "email,id", "id,email", "name,email,id", "name,id,email", "email,name,id", "email,id,name", "id,name,email", "id,email,name"} {
+		for _, desc := range []string{"", "-"} {
+			sort := mustSort(t, desc+spec, "")
+			sorts = append(sorts, sort)
+			for _, collation := range SupportedCollations() {
+				if s, err := sort.WithCollation(collation); err == nil {
+					sorts = append(sorts, s)
+				}
+			}
+		}
+	}
+	filters := []UserFilter{{}, {Name: "a"}, {Email: "a"}, {Name: "a", Email: "a"}, {IncludeDeleted: true}}
+	for _, f := range filters {
+		for _, s := range sorts {
+			c.observe(shapeOf(f, s), now)
+		}
+	}
+	if len(filters)*len(sorts) <= maxQueryShapes {
+		t.Fatalf("only %d shapes", len(filters)*len(sorts))
+	}
+	shapes, _, untracked := c.counted(now)
+	if len(shapes) != maxQueryShapes || int(untracked) != len(filters)*len(sorts)-maxQueryShapes {
+		t.Errorf("%d shapes, %d untracked", len(shapes), untracked)
+	}
+
+	// Once the window moves on the old shapes make room again.
+	later := now.Add(2 * time.Hour)
+	c.observe(shapeOf(UserFilter{}, nil), later)
+	if shapes, _, untracked := c.counted(later); len(shapes) != 1 || untracked != 0 {
+		t.Errorf("after the window: %d shapes, %d untracked", len(shapes), untracked)
+	}
+}
+
+// The query methods feed the advisor, and against the migrated schema it
+// asks for a trigram index for name search.
+func TestIndexAdvice(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	repo := New(scratchPool(t, ctx, nil))
+	for range 3 {
+		if _, _, err := repo.GetUsers(ctx, UserFilter{Name: "ada"}, nil, 10, 0); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.CountUsers(ctx, UserFilter{Labels: map[string]string{"env": "prod"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	advice, err := repo.IndexAdvice(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(advice.Shapes) != 1 || advice.Shapes[0].Count != 3 || len(advice.Shapes[0].Missing) != 1 ||
+		!strings.Contains(advice.Shapes[0].Missing[0].Statement, "gin_trgm_ops") {
+		t.Errorf("advice %+v", advice.Shapes)
+	}
+	if !slices.Contains(advice.Indexes, "user_labels_key_value_idx") || advice.WindowSeconds != advisorWindow.Seconds() {
+		t.Errorf("advice %+v", advice)
+	}
+}
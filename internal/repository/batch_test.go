@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// batchStore is the part of both stores CreateUsers is tested through.
+type batchStore interface {
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	CreateUsers(ctx context.Context, users []NewUser, batch BatchSize) ([]int64, error)
+	CountUsers(ctx context.Context, filter UserFilter) (int64, error)
+}
+
+// testCreateUsers checks ids in input order and that one taken email
+// rolls the whole batch back, naming its index, also when the users go
+// out in several batches.
+func testCreateUsers(t *testing.T, ctx context.Context, store batchStore) {
+	t.Helper()
+	first, err := store.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := store.CreateUsers(ctx, []NewUser{
+		{Name: "Grace", Email: "grace@example.com"},
+		{Name: "Alan", Email: "alan@example.com", Metadata: map[string]any{"team": "core"}},
+	}, nil)
+	if err != nil || !slices.Equal(ids, []int64{first.ID + 1, first.ID + 2}) {
+		t.Fatalf("CreateUsers = %v, %v", ids, err)
+	}
+
+	_, err = store.CreateUsers(ctx, []NewUser{
+		{Name: "Bo", Email: "bo@example.com"},
+		{Name: "Cy", Email: "cy@example.com"},
+		{Name: "Ada again", Email: "ada@example.com"},
+	}, nil)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || batchErr.Index != 2 || !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Fatalf("batch with a taken email: %v", err)
+	}
+	_, err = store.CreateUsers(ctx, []NewUser{{Name: "Bo", Email: "bo@example.com"}, {Name: "Bo", Email: "bo@example.com"}}, nil)
+	if !errors.As(err, &batchErr) || batchErr.Index != 1 {
+		t.Fatalf("batch repeating an email: %v", err)
+	}
+	if n, err := store.CountUsers(ctx, UserFilter{}); err != nil || n != 3 {
+		t.Errorf("%d users, %v; want 3, the failed batches rolled back", n, err)
+	}
+
+	var sizes []int
+	pairs := func() int { sizes = append(sizes, 2); return 2 }
+	five := []NewUser{
+		{Name: "Bo", Email: "bo@example.com"},
+		{Name: "Cy", Email: "cy@example.com"},
+		{Name: "Di", Email: "di@example.com"},
+		{Name: "Ed", Email: "ed@example.com"},
+		{Name: "Flo", Email: "flo@example.com"},
+	}
+	taken := slices.Clone(five)
+	taken[3].Email = "grace@example.com"
+	if _, err := store.CreateUsers(ctx, taken, pairs); !errors.As(err, &batchErr) || batchErr.Index != 3 {
+		t.Fatalf("batches with a taken email in the second: %v", err)
+	}
+	if n, err := store.CountUsers(ctx, UserFilter{}); err != nil || n != 3 {
+		t.Errorf("%d users, %v; want 3, the earlier batch rolled back too", n, err)
+	}
+	sizes = nil
+	ids, err = store.CreateUsers(ctx, five, pairs)
+	if err != nil || len(ids) != 5 || !slices.IsSorted(ids) || len(sizes) != 3 {
+		t.Errorf("in batches of 2: ids %v, %v, asked %d times", ids, err, len(sizes))
+	}
+}
+
+func TestMemoryCreateUsers(t *testing.T) {
+	testCreateUsers(t, context.Background(), NewMemory())
+}
+
+func TestCreateUsers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testCreateUsers(t, ctx, New(scratchPool(t, ctx, nil)))
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DeletePlan is what DeleteUser or HardDeleteUser would do to a user.
+type DeletePlan struct {
+	// User is the user as it is now.
+	User *User `json:"user"`
+	Hard bool  `json:"hard"`
+	// Removed counts the rows each table would lose. A soft delete keeps
+	// every row and only sets deleted_at, so it removes nothing.
+	Removed map[string]int64 `json:"removed"`
+}
+
+// errDryRun makes withTx roll back a transaction that succeeded.
+var errDryRun = errors.New("dry run")
+
+// PlanDeleteUser runs the delete HardDeleteUser (hard) or DeleteUser
+// would, with the same checks and errors, in a transaction that is always
+// rolled back, and reports what it did.
+func (r *Repository) PlanDeleteUser(ctx context.Context, id, version int64, hard bool) (*DeletePlan, error) {
+	plan := &DeletePlan{Hard: hard, Removed: map[string]int64{}}
+	err := r.withTx(ctx, "plan_delete_user", func(tx pgx.Tx) error {
+		u, err := scanUser(tx.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id=$1 FOR UPDATE", id))
+		switch {
+		case errors.Is(err, pgx.ErrNoRows), err == nil && !hard && u.DeletedAt != nil:
+			return ErrUserNotFound
+		case err != nil:
+			return err
+		case version != 0 && u.Version != version:
+			return ErrVersionConflict
+		}
+		plan.User = &u
+
+		if !hard {
+			_, err = tx.Exec(ctx, "UPDATE users SET deleted_at=now(), version=version+1 WHERE id=$1", id)
+			if err != nil {
+				return err
+			}
+			return errDryRun
+		}
+
+		var labels, views int64
+		err = tx.QueryRow(ctx,
+			"SELECT (SELECT count(*) FROM user_labels WHERE user_id=$1), (SELECT count(*) FROM user_views WHERE user_id=$1)", id,
+		).Scan(&labels, &views)
+		if err != nil {
+			return err
+		}
+		// The real DELETE, so a constraint or read-only failure shows up
+		// exactly as it would.
+		if _, err := tx.Exec(ctx, "DELETE FROM users WHERE id=$1", id); err != nil {
+			return err
+		}
+		plan.Removed["users"] = 1
+		plan.Removed["user_labels"] = labels
+		plan.Removed["user_views"] = views
+		return errDryRun
+	})
+	if errors.Is(err, errDryRun) {
+		return plan, nil
+	}
+	return nil, writeErr(err)
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// planStore is the part of both stores delete plans are tested through.
+type planStore interface {
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	ReplaceLabels(ctx context.Context, id, version int64, labels map[string]string) (map[string]string, error)
+	IncrementViews(ctx context.Context, id, n int64) (int64, error)
+	GetViews(ctx context.Context, id int64) (int64, error)
+	PlanDeleteUser(ctx context.Context, id, version int64, hard bool) (*DeletePlan, error)
+}
+
+// testPlanDeleteUser checks the plans and errors of both deletes and that
+// planning leaves the user, its labels and its views as they were.
+func testPlanDeleteUser(t *testing.T, ctx context.Context, store planStore) {
+	t.Helper()
+	u, err := store.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ReplaceLabels(ctx, u.ID, 0, map[string]string{"team": "core", "env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.IncrementViews(ctx, u.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	before, err := store.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	soft, err := store.PlanDeleteUser(ctx, u.ID, before.Version, false)
+	if err != nil || soft.Hard || len(soft.Removed) != 0 || soft.User.Email != "ada@example.com" {
+		t.Fatalf("soft plan = %+v, %v", soft, err)
+	}
+	hard, err := store.PlanDeleteUser(ctx, u.ID, 0, true)
+	if err != nil || !hard.Hard || fmt.Sprint(hard.Removed) != "map[user_labels:2 user_views:1 users:1]" {
+		t.Fatalf("hard plan = %+v, %v", hard, err)
+	}
+	if _, err := store.PlanDeleteUser(ctx, u.ID, before.Version+1, false); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("plan at a stale version: %v", err)
+	}
+	if _, err := store.PlanDeleteUser(ctx, u.ID+100, 0, true); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("plan for a missing user: %v", err)
+	}
+
+	after, err := store.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("user gone after planning: %v", err)
+	}
+	if after.Version != before.Version || after.DeletedAt != nil || len(after.Labels) != 2 {
+		t.Errorf("user changed by planning: %+v, was %+v", after, before)
+	}
+	if n, err := store.GetViews(ctx, u.ID); err != nil || n != 3 {
+		t.Errorf("views after planning = %d, %v; want 3", n, err)
+	}
+}
+
+func TestMemoryPlanDeleteUser(t *testing.T) {
+	testPlanDeleteUser(t, context.Background(), NewMemory())
+}
+
+func TestPlanDeleteUser(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testPlanDeleteUser(t, ctx, New(scratchPool(t, ctx, nil)))
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TestMetadataFilterUsesIndex checks, against a scratch schema of the
+// database at TEST_DATABASE_URL, that the metadata @> filter can be
+// answered from the GIN index.
+func TestMetadataFilterUsesIndex(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	// A handful of rows would be seq-scanned anyway; the question is
+	// whether the index can serve the filter at all.
+	pool := scratchPool(t, ctx, map[string]string{"enable_seqscan": "off"})
+
+	repo := New(pool)
+	for i, team := range []string{"platform", "web", "data"} {
+		if _, err := repo.CreateUser(ctx, "User", fmt.Sprintf("u%d@example.com", i), map[string]any{"team": team}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conds, args := UserFilter{Metadata: map[string]string{"team": "platform"}}.conditions()
+	rows, err := pool.Query(ctx, "EXPLAIN SELECT id FROM users"+where(conds), args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := strings.Join(plan, "\n"); !strings.Contains(text, "users_metadata_idx") {
+		t.Errorf("metadata filter does not use users_metadata_idx:\n%s", text)
+	}
+}
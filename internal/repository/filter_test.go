@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainsPattern(t *testing.T) {
+	for in, want := range map[string]string{
+		"ada":        "%ada%",
+		"100%":       `%100\%%`,
+		"a_b":        `%a\_b%`,
+		`back\slash`: `%back\\slash%`,
+		"O'Brien":    "%O'Brien%",
+	} {
+		if got := containsPattern(in); got != want {
+			t.Errorf("containsPattern(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Search text only ever reaches the query as a bind parameter.
+func TestFilterConditionsBindSearchText(t *testing.T) {
+	f := UserFilter{Name: "'; DROP TABLE users; --", Email: "50%"}
+	conds, args := f.conditions()
+	sql := strings.Join(conds, " AND ")
+	if strings.Contains(sql, "DROP") || strings.Contains(sql, "50") {
+		t.Errorf("search text in the SQL: %s", sql)
+	}
+	want := []string{active, `name ILIKE $1 ESCAPE '\'`, `email ILIKE $2 ESCAPE '\'`}
+	if !slices.Equal(conds, want) || fmt.Sprint(args) != `[%'; DROP TABLE users; --% %50\%%]` {
+		t.Errorf("conditions %q with %q", conds, args)
+	}
+	if conds, args := (UserFilter{IncludeDeleted: true}).conditions(); len(conds) != 0 || len(args) != 0 {
+		t.Errorf("an empty filter renders %q %v", conds, args)
+	}
+}
+
+// searchStore is the part of both stores the search filters go through.
+type searchStore interface {
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	GetUsers(ctx context.Context, filter UserFilter, sort Sort, limit, offset int) ([]User, bool, error)
+}
+
+func testSearchFilters(t *testing.T, ctx context.Context, store searchStore) {
+	t.Helper()
+	for _, u := range [][2]string{
+		{"Ada Lovelace", "ada@example.com"},
+		{"100% Grace", "grace@example.com"},
+		{"Seán O'Brien", "sean_o@example.org"},
+		{"Alan Turing", "alan@example.org"},
+	} {
+		if _, err := store.CreateUser(ctx, u[0], u[1], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		filter UserFilter
+		want   []string
+	}{
+		{UserFilter{}, []string{"Ada Lovelace", "100% Grace", "Seán O'Brien", "Alan Turing"}},
+		{UserFilter{Name: "ada"}, []string{"Ada Lovelace"}},
+		{UserFilter{Name: "A"}, []string{"Ada Lovelace", "100% Grace", "Alan Turing"}},
+		{UserFilter{Email: "example.org"}, []string{"Seán O'Brien", "Alan Turing"}},
+		{UserFilter{Name: "a", Email: ".com"}, []string{"Ada Lovelace", "100% Grace"}},
+		// Wildcards and quotes match themselves.
+		{UserFilter{Name: "%"}, []string{"100% Grace"}},
+		{UserFilter{Name: "'"}, []string{"Seán O'Brien"}},
+		{UserFilter{Email: "_"}, []string{"Seán O'Brien"}},
+		{UserFilter{Name: `\`}, nil},
+		{UserFilter{Name: "seán"}, []string{"Seán O'Brien"}},
+	} {
+		users, _, err := store.GetUsers(ctx, tt.filter, nil, 100, 0)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt.filter, err)
+		}
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		if !slices.Equal(names, tt.want) {
+			t.Errorf("%+v: %q, want %q", tt.filter, names, tt.want)
+		}
+	}
+}
+
+func TestMemorySearchFilters(t *testing.T) {
+	testSearchFilters(t, context.Background(), NewMemory())
+}
+
+func TestSearchFilters(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testSearchFilters(t, ctx, New(scratchPool(t, ctx, nil)))
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// table is a users table under concurrent writes: a sorted id set.
+type table struct {
+	mu   sync.Mutex
+	ids  []int64
+	next int64
+}
+
+// batch is the keyset query: the first n ids above after, in order.
+func (tb *table) batch(after int64, n int) []int64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	i, _ := slices.BinarySearch(tb.ids, after+1)
+	return slices.Clone(tb.ids[i:min(i+n, len(tb.ids))])
+}
+
+func (tb *table) delete(id int64) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	i, ok := slices.BinarySearch(tb.ids, id)
+	if ok {
+		tb.ids = slices.Delete(tb.ids, i, i+1)
+	}
+	return ok
+}
+
+func (tb *table) insert() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.next++
+	tb.ids = append(tb.ids, tb.next)
+}
+
+// While rows are deleted and inserted concurrently, the walk processes no
+// row twice and skips none that existed for the whole run.
+func TestWalkKeysetUnderConcurrentWrites(t *testing.T) {
+	for seed := range uint64(50) {
+		rng := rand.New(rand.NewPCG(seed, seed))
+		rows, batchSize := 1+rng.IntN(300), 1+rng.IntN(40)
+		tb := &table{next: int64(rows)}
+		for id := range int64(rows) {
+			tb.ids = append(tb.ids, id+1)
+		}
+
+		ctx, stop := context.WithCancel(context.Background())
+		deleted := make(map[int64]bool)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// A bounded number of writes, so the walk can catch up.
+			for range rows {
+				if ctx.Err() != nil {
+					return
+				}
+				if id := 1 + rng.Int64N(int64(rows)); tb.delete(id) {
+					deleted[id] = true
+				}
+				tb.insert()
+				runtime.Gosched()
+			}
+		}()
+
+		seen := make(map[int64]int)
+		err := walkKeyset(context.Background(), batchSize, 0, func(_ context.Context, after int64) (int, int64, error) {
+			ids := tb.batch(after, batchSize)
+			for _, id := range ids {
+				seen[id]++
+			}
+			runtime.Gosched()
+			if len(ids) == 0 {
+				return 0, after, nil
+			}
+			return len(ids), ids[len(ids)-1], nil
+		})
+		stop()
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+
+		for id, n := range seen {
+			if n > 1 {
+				t.Errorf("seed %d: row %d processed %d times", seed, id, n)
+			}
+		}
+		for id := range int64(rows) {
+			if id++; !deleted[id] && seen[id] == 0 {
+				t.Errorf("seed %d (%d rows, batches of %d): row %d skipped", seed, rows, batchSize, id)
+			}
+		}
+	}
+}
+
+// An exact multiple of the batch size ends on an empty batch.
+func TestWalkKeysetLastBatch(t *testing.T) {
+	tb := &table{ids: []int64{1, 2, 3, 4, 5, 6}}
+	var batches [][]int64
+	err := walkKeyset(context.Background(), 3, 2, func(_ context.Context, after int64) (int, int64, error) {
+		ids := tb.batch(after, 3)
+		batches = append(batches, ids)
+		if len(ids) == 0 {
+			return 0, after, nil
+		}
+		return len(ids), ids[len(ids)-1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Starting after 2: {3,4,5} is full, {6} is short and ends the walk.
+	if len(batches) != 2 || !slices.Equal(batches[0], []int64{3, 4, 5}) || !slices.Equal(batches[1], []int64{6}) {
+		t.Errorf("batches = %v", batches)
+	}
+}
+
+func TestWalkKeysetStops(t *testing.T) {
+	full := func(_ context.Context, after int64) (int, int64, error) { return 10, after + 10, nil }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := walkKeyset(ctx, 10, 0, func(ctx context.Context, after int64) (int, int64, error) {
+		if calls++; calls == 2 {
+			cancel()
+		}
+		return full(ctx, after)
+	})
+	if !errors.Is(err, context.Canceled) || calls != 2 {
+		t.Errorf("cancelled walk: %v after %d batches", err, calls)
+	}
+
+	boom := errors.New("boom")
+	if err := walkKeyset(context.Background(), 10, 0, func(context.Context, int64) (int, int64, error) { return 0, 0, boom }); err != boom {
+		t.Errorf("failing batch: %v", err)
+	}
+	if err := walkKeyset(context.Background(), 0, 0, full); err == nil {
+		t.Error("batch size 0 accepted")
+	}
+}
+
+// eachStore is the part of both stores EachUser is tested through.
+type eachStore interface {
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	EachUser(ctx context.Context, filter UserFilter, sort Sort, batch BatchSize, fn func(User) error) error
+}
+
+// testEachUserBatches checks that a walk in batches whose size changes
+// as it goes still yields every user once, in order.
+func testEachUserBatches(t *testing.T, ctx context.Context, store eachStore) {
+	t.Helper()
+	for i := range 7 {
+		if _, err := store.CreateUser(ctx, fmt.Sprintf("User %d", 7-i), fmt.Sprintf("u%d@example.com", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	byName, _ := ParseSort("name")
+	sizes := []int{3, 1, 2}
+	changing := func() int {
+		n := sizes[0]
+		sizes = append(sizes[1:], n)
+		return n
+	}
+	for _, batch := range []BatchSize{nil, changing, func() int { return 1 }, func() int { return 100 }} {
+		var names []string
+		err := store.EachUser(ctx, UserFilter{}, byName, batch, func(u User) error {
+			names = append(names, u.Name)
+			return nil
+		})
+		if err != nil || !slices.Equal(names, []string{"User 1", "User 2", "User 3", "User 4", "User 5", "User 6", "User 7"}) {
+			t.Errorf("EachUser = %v, %v", names, err)
+		}
+	}
+}
+
+func TestMemoryEachUserBatches(t *testing.T) {
+	testEachUserBatches(t, context.Background(), NewMemory())
+}
+
+func TestEachUserBatches(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testEachUserBatches(t, ctx, New(scratchPool(t, ctx, nil)))
+}
@@ -0,0 +1,643 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a map-backed UserRepository for exercising the HTTP layer
+// without Postgres. It mirrors the pgx implementation's observable
+// behavior: ids count up from 1, emails are unique (soft-deleted users
+// included), missing or soft-deleted users yield ErrUserNotFound and
+// multi-row reads stop at the row cap. Metadata goes through a JSON round
+// trip, so numbers come back as float64 just like they do from JSONB.
+type Memory struct {
+	maxRows int
+
+	mu     sync.RWMutex
+	nextID int64
+	users  map[int64]User
+	views  map[int64]int64
+	shares map[string]time.Time // nonce -> expiry
+	limits map[string]time.Time // rate-limit key -> full again
+}
+
+// NewMemory returns an empty in-memory repository. WithMaxRows applies.
+func NewMemory(opts ...Option) *Memory {
+	cfg := &Repository{maxRows: DefaultMaxRows}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return &Memory{
+		maxRows: cfg.maxRows,
+		nextID:  1,
+		users:   make(map[int64]User),
+		views:   make(map[int64]int64),
+		shares:  make(map[string]time.Time),
+		limits:  make(map[string]time.Time),
+	}
+}
+
+func (m *Memory) Ping(ctx context.Context) error { return ctx.Err() }
+
+// PoolStats reports an idle pool of one connection.
+func (m *Memory) PoolStats() (acquired, max int32) { return 0, 1 }
+
+func (m *Memory) ReadOnly(ctx context.Context) (bool, error) { return false, ctx.Err() }
+
+func (m *Memory) Now(ctx context.Context) (time.Time, error) { return time.Now(), ctx.Err() }
+
+// TableStats counts the encoded size of every user as its on-disk size,
+// and the size of the columns for the other tables.
+func (m *Memory) TableStats(ctx context.Context) ([]TableStat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	users := TableStat{Table: "users", Rows: int64(len(m.users))}
+	labels := TableStat{Table: "user_labels"}
+	for _, u := range m.users {
+		b, _ := json.Marshal(u)
+		users.Bytes += int64(len(b))
+		for k, v := range u.Labels {
+			labels.Rows++
+			labels.Bytes += int64(4 + len(k) + len(v))
+		}
+	}
+	views := TableStat{Table: "user_views", Rows: int64(len(m.views)), Bytes: int64(12 * len(m.views))}
+	shares := TableStat{Table: "share_link_uses", Rows: int64(len(m.shares))}
+	for nonce := range m.shares {
+		shares.Bytes += int64(len(nonce) + 20)
+	}
+	limits := TableStat{Table: "rate_limit_buckets", Rows: int64(len(m.limits))}
+	for key := range m.limits {
+		limits.Bytes += int64(len(key) + 8)
+	}
+	return []TableStat{users, views, shares, labels, limits}, ctx.Err()
+}
+
+func (m *Memory) GetUsers(ctx context.Context, filter UserFilter, sort Sort, limit, offset int) (users []User, truncated bool, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := m.matching(filter, 0)
+	slices.SortFunc(matched, sort.comparer())
+	matched = matched[min(offset, len(matched)):]
+	if limit > 0 {
+		matched = matched[:min(limit, len(matched))]
+	}
+	if len(matched) > m.maxRows {
+		matched, truncated = matched[:m.maxRows], true
+	}
+	return matched, truncated, ctx.Err()
+}
+
+func (m *Memory) GetUsersAfter(ctx context.Context, filter UserFilter, afterID int64, limit int) ([]User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	matched := m.matching(filter, afterID)
+	return matched[:min(limit, m.maxRows, len(matched))], ctx.Err()
+}
+
+func (m *Memory) CountUsers(ctx context.Context, filter UserFilter) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return int64(len(m.matching(filter, 0))), ctx.Err()
+}
+
+// EachUser works on a snapshot, so fn may call back into m.
+func (m *Memory) EachUser(ctx context.Context, filter UserFilter, sort Sort, batch BatchSize, fn func(User) error) error {
+	m.mu.RLock()
+	matched := m.matching(filter, 0)
+	m.mu.RUnlock()
+	slices.SortFunc(matched, sort.comparer())
+	for len(matched) > 0 {
+		n := batch.next(len(matched))
+		for _, u := range matched[:n] {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		matched = matched[n:]
+	}
+	return nil
+}
+
+// matching returns copies of the users above afterID that match filter,
+// in id order; callers hold m.mu.
+func (m *Memory) matching(filter UserFilter, afterID int64) []User {
+	var out []User
+	for id, u := range m.users {
+		if id > afterID && (filter.IncludeDeleted || u.DeletedAt == nil) && filter.matches(u) {
+			out = append(out, cloneUser(u))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// matches is the metadata @> filter check for string values, plus the
+// label selectors and the ILIKE substring filters.
+func (f UserFilter) matches(u User) bool {
+	if !containsFold(u.Name, f.Name) || !containsFold(u.Email, f.Email) {
+		return false
+	}
+	for k, v := range f.Metadata {
+		if got, ok := u.Metadata[k].(string); !ok || got != v {
+			return false
+		}
+	}
+	for k, v := range f.Labels {
+		if got, ok := u.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.active(id)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	u = cloneUser(u)
+	return &u, ctx.Err()
+}
+
+func (m *Memory) UserExists(ctx context.Context, id int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.active(id)
+	return ok, ctx.Err()
+}
+
+func (m *Memory) CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	md, err := cloneMetadata(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.emailTaken(email, 0) {
+		return nil, ErrEmailAlreadyExists
+	}
+	id := m.nextID
+	m.nextID++
+	now := memNow()
+	u := User{ID: id, Name: name, Email: email, Metadata: md, Labels: map[string]string{}, CreatedAt: now, UpdatedAt: now, Version: 1}
+	m.users[id] = u
+	u = cloneUser(u)
+	return &u, nil
+}
+
+// memNow is the database clock stand-in: now() at TIMESTAMPTZ precision.
+func memNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// CreateUsers asks batch for its batch sizes like Repository, but every
+// user is checked before any is inserted.
+func (m *Memory) CreateUsers(ctx context.Context, users []NewUser, batch BatchSize) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	mds := make([]map[string]any, len(users))
+	for i, u := range users {
+		md := u.Metadata
+		if md == nil {
+			md = map[string]any{}
+		}
+		var err error
+		if mds[i], err = cloneMetadata(md); err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool, len(users))
+	for i, u := range users {
+		if seen[u.Email] || m.emailTaken(u.Email, 0) {
+			return nil, &BatchError{Index: i, Err: ErrEmailAlreadyExists}
+		}
+		seen[u.Email] = true
+	}
+	ids := make([]int64, len(users))
+	now := memNow()
+	for start := 0; start < len(users); {
+		end := start + batch.next(len(users)-start)
+		for i := start; i < end; i++ {
+			u := users[i]
+			ids[i] = m.nextID
+			m.nextID++
+			m.users[ids[i]] = User{ID: ids[i], Name: u.Name, Email: u.Email, Metadata: mds[i], Labels: map[string]string{}, CreatedAt: now, UpdatedAt: now, Version: 1}
+		}
+		start = end
+	}
+	return ids, nil
+}
+
+func (m *Memory) UpdateUser(ctx context.Context, id, version int64, name, email string, metadata map[string]any) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var md map[string]any
+	if metadata != nil {
+		var err error
+		if md, err = cloneMetadata(metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return nil, ErrVersionConflict
+	}
+	if m.emailTaken(email, id) {
+		return nil, ErrEmailAlreadyExists
+	}
+	u.Name, u.Email = name, email
+	if md != nil {
+		u.Metadata = md
+	}
+	return m.store(u), nil
+}
+
+func (m *Memory) PatchUser(ctx context.Context, id, version int64, name, email *string) (*User, error) {
+	if name == nil && email == nil {
+		return nil, errors.New("repository: PatchUser needs at least one field")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return nil, ErrVersionConflict
+	}
+	if email != nil && m.emailTaken(*email, id) {
+		return nil, ErrEmailAlreadyExists
+	}
+	if name != nil {
+		u.Name = *name
+	}
+	if email != nil {
+		u.Email = *email
+	}
+	return m.store(u), nil
+}
+
+// store saves an updated u, bumping UpdatedAt like the users trigger and
+// Version like every UPDATE, and returns a copy; callers hold m.mu.
+func (m *Memory) store(u User) *User {
+	u.UpdatedAt = memNow()
+	u.Version++
+	m.users[u.ID] = u
+	u = cloneUser(u)
+	return &u
+}
+
+// emailTaken reports whether a user other than id has email; callers
+// hold m.mu.
+func (m *Memory) emailTaken(email string, id int64) bool {
+	for other, u := range m.users {
+		if other != id && u.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, id, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return ErrVersionConflict
+	}
+	now := memNow()
+	u.DeletedAt = &now
+	m.store(u)
+	return nil
+}
+
+// HardDeleteUser also drops the user's views, like the ON DELETE CASCADE.
+func (m *Memory) HardDeleteUser(ctx context.Context, id, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return ErrVersionConflict
+	}
+	delete(m.users, id)
+	delete(m.views, id)
+	return nil
+}
+
+func (m *Memory) RestoreUser(ctx context.Context, id int64) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if u.DeletedAt == nil {
+		u = cloneUser(u)
+		return &u, nil
+	}
+	u.DeletedAt = nil
+	return m.store(u), nil
+}
+
+func (m *Memory) PlanDeleteUser(ctx context.Context, id, version int64, hard bool) (*DeletePlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	u, ok := m.users[id]
+	if !ok || !hard && u.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return nil, ErrVersionConflict
+	}
+	u = cloneUser(u)
+	plan := &DeletePlan{User: &u, Hard: hard, Removed: map[string]int64{}}
+	if hard {
+		plan.Removed["users"] = 1
+		plan.Removed["user_labels"] = int64(len(u.Labels))
+		plan.Removed["user_views"] = 0
+		if _, ok := m.views[id]; ok {
+			plan.Removed["user_views"] = 1
+		}
+	}
+	return plan, nil
+}
+
+// active returns user id unless it is missing or soft-deleted; callers
+// hold m.mu.
+func (m *Memory) active(id int64) (User, bool) {
+	u, ok := m.users[id]
+	return u, ok && u.DeletedAt == nil
+}
+
+func (m *Memory) PatchMetadata(ctx context.Context, id, version int64, set map[string]any, del []string, check func(map[string]any) error) (map[string]any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return nil, ErrVersionConflict
+	}
+
+	merged, err := cloneMetadata(u.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if merged == nil {
+		merged = map[string]any{}
+	}
+	for k, v := range set {
+		merged[k] = v
+	}
+	for _, k := range del {
+		delete(merged, k)
+	}
+	if err := check(merged); err != nil {
+		return nil, err
+	}
+	if merged, err = cloneMetadata(merged); err != nil {
+		return nil, err
+	}
+
+	u.Metadata = merged
+	return m.store(u).Metadata, nil
+}
+
+func (m *Memory) ReplaceLabels(ctx context.Context, id, version int64, labels map[string]string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return nil, ErrVersionConflict
+	}
+	u.Labels = maps.Clone(labels)
+	if u.Labels == nil {
+		u.Labels = map[string]string{}
+	}
+	return m.store(u).Labels, nil
+}
+
+func (m *Memory) PatchLabels(ctx context.Context, id, version int64, set map[string]string, del []string, check func(map[string]string) error) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return nil, ErrVersionConflict
+	}
+
+	merged := maps.Clone(u.Labels)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+	maps.Copy(merged, set)
+	for _, k := range del {
+		delete(merged, k)
+	}
+	if err := check(merged); err != nil {
+		return nil, err
+	}
+
+	u.Labels = merged
+	return m.store(u).Labels, nil
+}
+
+func (m *Memory) DeleteLabel(ctx context.Context, id, version int64, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.active(id)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if version != 0 && u.Version != version {
+		return ErrVersionConflict
+	}
+	if _, ok := u.Labels[key]; !ok {
+		return ErrLabelNotFound
+	}
+	u.Labels = maps.Clone(u.Labels)
+	delete(u.Labels, key)
+	m.store(u)
+	return nil
+}
+
+func (m *Memory) IncrementViews(ctx context.Context, id, n int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.active(id); !ok {
+		return 0, ErrUserNotFound
+	}
+	m.views[id] += n
+	return m.views[id], nil
+}
+
+func (m *Memory) GetViews(ctx context.Context, id int64) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.active(id); !ok {
+		return 0, ErrUserNotFound
+	}
+	return m.views[id], ctx.Err()
+}
+
+func (m *Memory) ConsumeShareLink(ctx context.Context, nonce string, userID int64, expiresAt time.Time) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, used := m.shares[nonce]; used {
+		return false, nil
+	}
+	m.shares[nonce] = expiresAt
+	return true, nil
+}
+
+// PurgeShareLinkUses uses the local clock; there is no database one.
+func (m *Memory) PurgeShareLinkUses(ctx context.Context, expiredFor time.Duration, limit int) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-expiredFor)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for nonce, expires := range m.shares {
+		if n == int64(limit) {
+			break
+		}
+		if expires.Before(cutoff) {
+			delete(m.shares, nonce)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) SyncRateLimits(ctx context.Context, buckets map[string]time.Time, now time.Time) (map[string]time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, t := range buckets {
+		if t.After(m.limits[key]) {
+			m.limits[key] = t
+		}
+	}
+	merged := make(map[string]time.Time, len(m.limits))
+	for key, t := range m.limits {
+		if !t.After(now) {
+			delete(m.limits, key)
+			continue
+		}
+		merged[key] = t
+	}
+	return merged, nil
+}
+
+func cloneUser(u User) User {
+	u.Metadata, _ = cloneMetadata(u.Metadata)
+	u.Labels = maps.Clone(u.Labels)
+	if u.DeletedAt != nil {
+		t := *u.DeletedAt
+		u.DeletedAt = &t
+	}
+	return u
+}
+
+// cloneMetadata deep-copies metadata through JSON, which is also what
+// storing it as JSONB does to its values.
+func cloneMetadata(md map[string]any) (map[string]any, error) {
+	if md == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(md)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	err = json.Unmarshal(b, &out)
+	return out, err
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestMemoryErrorSemantics(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	ada, err := m.CreateUser(ctx, "Ada", "ada@example.com", map[string]any{"level": 3})
+	if err != nil || ada.ID != 1 || ada.Version != 1 {
+		t.Fatalf("first create: %+v, %v", ada, err)
+	}
+	grace, err := m.CreateUser(ctx, "Grace", "grace@example.com", nil)
+	if err != nil || grace.ID != 2 {
+		t.Fatalf("second create: %+v, %v", grace, err)
+	}
+
+	// Metadata comes back the way JSONB returns it.
+	if got, _ := m.GetUserByID(ctx, 1); got.Metadata["level"] != float64(3) {
+		t.Errorf("metadata level = %#v, want float64(3)", got.Metadata["level"])
+	}
+	// Callers cannot reach into the stored row.
+	ada.Metadata["level"] = "tampered"
+	if got, _ := m.GetUserByID(ctx, 1); got.Metadata["level"] != float64(3) {
+		t.Errorf("stored user shares its metadata map with the caller")
+	}
+
+	if _, err := m.CreateUser(ctx, "Ada again", "ada@example.com", nil); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("duplicate create: %v", err)
+	}
+	if _, err := m.UpdateUser(ctx, 2, 0, "Grace", "ada@example.com", nil); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("update to a taken email: %v", err)
+	}
+	email := "ada@example.com"
+	if _, err := m.PatchUser(ctx, 2, 0, nil, &email); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("patch to a taken email: %v", err)
+	}
+	if _, err := m.UpdateUser(ctx, 1, 0, "Ada", "ada@example.com", nil); err != nil {
+		t.Errorf("update keeping its own email: %v", err)
+	}
+
+	if _, err := m.UpdateUser(ctx, 2, 99, "Grace", "grace@example.com", nil); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("stale version: %v", err)
+	}
+
+	for name, op := range map[string]func(id int64) error{
+		"get":    func(id int64) error { _, err := m.GetUserByID(ctx, id); return err },
+		"update": func(id int64) error { _, err := m.UpdateUser(ctx, id, 0, "X", "x@example.com", nil); return err },
+		"patch":  func(id int64) error { n := "X"; _, err := m.PatchUser(ctx, id, 0, &n, nil); return err },
+		"delete": func(id int64) error { return m.DeleteUser(ctx, id, 0) },
+	} {
+		if err := op(42); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("%s of a missing user: %v", name, err)
+		}
+	}
+
+	// Soft-deleted users are gone for reads and writes but keep their
+	// email, like the unique constraint does.
+	if err := m.DeleteUser(ctx, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetUserByID(ctx, 2); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("get after delete: %v", err)
+	}
+	if err := m.DeleteUser(ctx, 2, 0); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("second delete: %v", err)
+	}
+	if _, err := m.CreateUser(ctx, "Grace", "grace@example.com", nil); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("reusing a soft-deleted user's email: %v", err)
+	}
+	if u, err := m.RestoreUser(ctx, 2); err != nil || u.Email != "grace@example.com" {
+		t.Errorf("restore: %+v, %v", u, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := m.GetUserByID(cancelled, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled get: %v", err)
+	}
+}
+
+// Run with -race: concurrent writers get distinct ids and exactly one
+// wins each email.
+func TestMemoryConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	const writers, emails = 16, 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ids := map[int64]bool{}
+	taken := 0
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range emails {
+				u, err := m.CreateUser(ctx, "User", fmt.Sprintf("user%d@example.com", e), nil)
+				mu.Lock()
+				switch {
+				case errors.Is(err, ErrEmailAlreadyExists):
+					taken++
+				case err != nil:
+					t.Errorf("writer %d: %v", w, err)
+				case ids[u.ID]:
+					t.Errorf("id %d handed out twice", u.ID)
+				default:
+					ids[u.ID] = true
+				}
+				mu.Unlock()
+				if u != nil {
+					m.GetUserByID(ctx, u.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(ids) != emails || taken != writers*emails-emails {
+		t.Errorf("%d users created and %d rejected, want %d and %d", len(ids), taken, emails, writers*emails-emails)
+	}
+}
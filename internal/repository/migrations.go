@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+
+	"go-k8s-demo/internal/migrate"
+	"go-k8s-demo/migrations"
+)
+
+// Migrations reports the database against the migrations embedded in
+// the binary, without taking the migration lock.
+func (r *Repository) Migrations(ctx context.Context) (*migrate.Status, error) {
+	return migrate.Inspect(ctx, r.db, migrations.FS)
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Field describes one persisted User field. UserFields is the single
+// definition the select list, scan targets and any future exporters or
+// schema generators derive from; adding a column means adding a tagged
+// field to User (plus a migration).
+type Field struct {
+	Column string // users table column, from the db tag
+	JSON   string // JSON name, from the json tag
+	// Expr computes a field kept outside the users table, selected AS
+	// Column; such fields are read-only here. Empty for users columns.
+	Expr  string
+	Type  reflect.Type
+	index int
+}
+
+// derivedFields are the select expressions of fields stored in other
+// tables, by db tag.
+var derivedFields = map[string]string{
+	"labels": "COALESCE((SELECT jsonb_object_agg(l.key, l.value) FROM user_labels l WHERE l.user_id = users.id), '{}'::jsonb)",
+}
+
+// UserFields lists User's persisted fields in declaration order.
+var UserFields = describe(reflect.TypeOf(User{}))
+
+// userColumns is the column list every user query selects, in scanUser order.
+var userColumns = columnList(UserFields)
+
+// describe builds the descriptor from db and json struct tags. Every
+// field must have both, so the API and the table cannot drift apart
+// silently; a mismatch panics at package init.
+func describe(t reflect.Type) []Field {
+	var fields []Field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		column := sf.Tag.Get("db")
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if column == "" || name == "" || name == "-" {
+			panic("repository: " + t.Name() + "." + sf.Name + " needs both db and json tags")
+		}
+		fields = append(fields, Field{Column: column, JSON: name, Expr: derivedFields[column], Type: sf.Type, index: i})
+	}
+	return fields
+}
+
+func columnList(fields []Field) string {
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = f.Column
+		if f.Expr != "" {
+			cols[i] = f.Expr + " AS " + f.Column
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+// Value returns u's value of the field.
+func (f Field) Value(u *User) any {
+	return reflect.ValueOf(u).Elem().Field(f.index).Interface()
+}
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row pgx.Row) (User, error) {
+	var u User
+	v := reflect.ValueOf(&u).Elem()
+	targets := make([]any, len(UserFields))
+	for i, f := range UserFields {
+		targets[i] = v.Field(f.index).Addr().Interface()
+	}
+	err := row.Scan(targets...)
+	// pgx returns timestamptz in the local zone; the API speaks UTC.
+	u.CreatedAt, u.UpdatedAt = u.CreatedAt.UTC(), u.UpdatedAt.UTC()
+	if u.DeletedAt != nil {
+		t := u.DeletedAt.UTC()
+		u.DeletedAt = &t
+	}
+	return u, err
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"io/fs"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/migrations"
+)
+
+// UserFields mirrors User's tags in declaration order.
+func TestUserFieldsMatchStruct(t *testing.T) {
+	typ := reflect.TypeOf(User{})
+	if len(UserFields) != typ.NumField() {
+		t.Fatalf("%d fields described, User has %d", len(UserFields), typ.NumField())
+	}
+	for i, f := range UserFields {
+		sf := typ.Field(i)
+		json, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if f.Column != sf.Tag.Get("db") || f.JSON != json || f.Type != sf.Type {
+			t.Errorf("UserFields[%d] = %+v, User.%s has db %q json %q %v", i, f, sf.Name, sf.Tag.Get("db"), json, sf.Type)
+		}
+	}
+
+	u := User{ID: 7, Name: "Ada", Email: "ada@example.com", Version: 3}
+	for _, f := range UserFields {
+		want := reflect.ValueOf(u).FieldByIndex([]int{f.index}).Interface()
+		if got := f.Value(&u); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s.Value = %v, want %v", f.Column, got, want)
+		}
+	}
+}
+
+// The select list names every field once, in scan order.
+func TestUserColumns(t *testing.T) {
+	want := make([]string, len(UserFields))
+	for i, f := range UserFields {
+		want[i] = f.Column
+		if f.Expr != "" {
+			want[i] = f.Expr + " AS " + f.Column
+		}
+	}
+	if userColumns != strings.Join(want, ", ") {
+		t.Errorf("userColumns = %s\nwant %s", userColumns, strings.Join(want, ", "))
+	}
+	for column := range derivedFields {
+		if !slices.ContainsFunc(UserFields, func(f Field) bool { return f.Column == column }) {
+			t.Errorf("derived field %q is not a User field", column)
+		}
+	}
+}
+
+var (
+	createUsers = regexp.MustCompile(`(?is)CREATE TABLE users \((.*?)\);`)
+	alterUsers  = regexp.MustCompile(`(?i)ALTER TABLE users\b`)
+	addColumn   = regexp.MustCompile(`(?i)ADD COLUMN (?:IF NOT EXISTS )?(\w+)`)
+	dropColumn  = regexp.MustCompile(`(?i)DROP COLUMN (?:IF EXISTS )?(\w+)`)
+)
+
+// Every stored field has a column in the migrated users table and every
+// column has a field, so a new column means a field plus a migration.
+func TestUserFieldsMatchMigrations(t *testing.T) {
+	columns := map[string]bool{}
+	files, err := fs.Glob(migrations.FS, "V*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		b, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sql := string(b)
+		if m := createUsers.FindStringSubmatch(sql); m != nil {
+			for _, line := range strings.Split(m[1], ",") {
+				if col := strings.Fields(line); len(col) > 0 {
+					columns[col[0]] = true
+				}
+			}
+		}
+		for _, stmt := range strings.Split(sql, ";") {
+			if !alterUsers.MatchString(stmt) {
+				continue
+			}
+			for _, m := range addColumn.FindAllStringSubmatch(stmt, -1) {
+				columns[m[1]] = true
+			}
+			for _, m := range dropColumn.FindAllStringSubmatch(stmt, -1) {
+				delete(columns, m[1])
+			}
+		}
+	}
+	if len(columns) == 0 {
+		t.Fatal("found no users columns in the migrations")
+	}
+
+	for _, f := range UserFields {
+		switch {
+		case f.Expr != "" && columns[f.Column]:
+			t.Errorf("%s is derived but also a users column", f.Column)
+		case f.Expr == "" && !columns[f.Column]:
+			t.Errorf("User field %s has no users column in the migrations", f.Column)
+		}
+		delete(columns, f.Column)
+	}
+	for column := range columns {
+		t.Errorf("users column %s has no User field", column)
+	}
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// rateLimitSyncer is the part of both stores the rate limiter syncs with.
+type rateLimitSyncer interface {
+	SyncRateLimits(ctx context.Context, buckets map[string]time.Time, now time.Time) (map[string]time.Time, error)
+}
+
+// testSyncRateLimits checks that buckets merge by the later full time,
+// come back to every caller, and are purged once full.
+func testSyncRateLimits(t *testing.T, ctx context.Context, store rateLimitSyncer) {
+	t.Helper()
+	now := time.Now().Truncate(time.Microsecond)
+	if _, err := store.SyncRateLimits(ctx, map[string]time.Time{
+		"read a": now.Add(time.Minute),
+		"read b": now.Add(time.Second),
+		"full":   now.Add(-time.Second),
+	}, now); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.SyncRateLimits(ctx, map[string]time.Time{
+		"read a": now.Add(time.Second), // an earlier time loses
+		"read b": now.Add(time.Hour),
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Time{"read a": now.Add(time.Minute), "read b": now.Add(time.Hour)}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for key, w := range want {
+		if !got[key].Equal(w) {
+			t.Errorf("%s: %v, want %v", key, got[key], w)
+		}
+	}
+
+	// Once full a bucket is gone.
+	if got, err := store.SyncRateLimits(ctx, nil, now.Add(2*time.Minute)); err != nil || len(got) != 1 {
+		t.Errorf("two minutes later: %v, %v; want read b alone", got, err)
+	}
+}
+
+func TestMemorySyncRateLimits(t *testing.T) {
+	testSyncRateLimits(t, context.Background(), NewMemory())
+}
+
+func TestSyncRateLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testSyncRateLimits(t, ctx, New(scratchPool(t, ctx, nil)))
+}
@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// reportTables are the tables the database report covers.
+var reportTables = []string{"users", "user_views", "user_labels", "share_link_uses"}
+
+// DBReport is a point-in-time snapshot of the database for attaching to
+// performance issues. Every section is gathered on its own: one that
+// fails (missing view, missing privilege, older Postgres) is left empty
+// and explained in Errors instead of failing the report.
+type DBReport struct {
+	GeneratedAt     time.Time         `json:"generated_at"`
+	ServerVersion   string            `json:"server_version"`
+	ApplicationName string            `json:"application_name"`
+	Tables          []TableReport     `json:"tables"`
+	Indexes         []IndexReport     `json:"indexes"`
+	CacheHit        *CacheHitReport   `json:"cache_hit,omitempty"`
+	Connections     map[string]int64  `json:"connections"`
+	LongestQueries  []QueryReport     `json:"longest_queries"`
+	Errors          map[string]string `json:"errors,omitempty"`
+}
+
+// TableReport combines size and pg_stat_user_tables for one table.
+type TableReport struct {
+	Name            string     `json:"name"`
+	TotalBytes      int64      `json:"total_bytes"`
+	TableBytes      int64      `json:"table_bytes"`
+	IndexBytes      int64      `json:"index_bytes"`
+	LiveRows        int64      `json:"live_rows"`
+	DeadRows        int64      `json:"dead_rows"`
+	SeqScans        int64      `json:"seq_scans"`
+	IndexScans      int64      `json:"index_scans"`
+	LastVacuum      *time.Time `json:"last_vacuum,omitempty"`
+	LastAutovacuum  *time.Time `json:"last_autovacuum,omitempty"`
+	LastAnalyze     *time.Time `json:"last_analyze,omitempty"`
+	LastAutoanalyze *time.Time `json:"last_autoanalyze,omitempty"`
+	AutovacuumCount int64      `json:"autovacuum_count"`
+}
+
+// IndexReport is one row of pg_stat_user_indexes with the index size.
+type IndexReport struct {
+	Table  string `json:"table"`
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	Scans  int64  `json:"scans"`
+	Unused bool   `json:"unused"`
+}
+
+// CacheHitReport is the share of block reads served from shared buffers;
+// nil ratios mean nothing was read yet.
+type CacheHitReport struct {
+	Table *float64 `json:"table"`
+	Index *float64 `json:"index"`
+}
+
+// QueryReport is a running statement of this application, with literals
+// redacted.
+type QueryReport struct {
+	PID       int32   `json:"pid"`
+	State     string  `json:"state"`
+	Seconds   float64 `json:"seconds"`
+	WaitEvent string  `json:"wait_event,omitempty"`
+	Query     string  `json:"query"`
+}
+
+// Report gathers a DBReport from the statistics views. Each section runs
+// in its own READ ONLY transaction so a failing one cannot abort the
+// rest; bound the whole run with ctx.
+func (r *Repository) Report(ctx context.Context) *DBReport {
+	rep := &DBReport{GeneratedAt: time.Now().UTC(), Connections: map[string]int64{}}
+	section := func(name string, fn func(ctx context.Context, tx pgx.Tx, rep *DBReport) error) {
+		err := pgx.BeginTxFunc(ctx, r.db, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+			return fn(ctx, tx, rep)
+		})
+		if err == nil {
+			return
+		}
+		if rep.Errors == nil {
+			rep.Errors = make(map[string]string)
+		}
+		rep.Errors[name] = err.Error()
+	}
+
+	section("server", reportServer)
+	section("tables", reportTableStats)
+	section("indexes", reportIndexes)
+	section("cache_hit", reportCacheHit)
+	section("connections", reportConnections)
+	section("longest_queries", reportQueries)
+	return rep
+}
+
+func reportServer(ctx context.Context, tx pgx.Tx, rep *DBReport) error {
+	return tx.QueryRow(ctx,
+		"SELECT current_setting('server_version'), current_setting('application_name')",
+	).Scan(&rep.ServerVersion, &rep.ApplicationName)
+}
+
+func reportTableStats(ctx context.Context, tx pgx.Tx, rep *DBReport) error {
+	rows, err := tx.Query(ctx, `
+		SELECT relname, pg_total_relation_size(relid), pg_relation_size(relid), pg_indexes_size(relid),
+		       n_live_tup, n_dead_tup, seq_scan, COALESCE(idx_scan, 0),
+		       last_vacuum, last_autovacuum, last_analyze, last_autoanalyze, autovacuum_count
+		FROM pg_stat_user_tables
+		WHERE schemaname = current_schema() AND relname = ANY($1)
+		ORDER BY relname`, reportTables)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t TableReport
+		if err := rows.Scan(&t.Name, &t.TotalBytes, &t.TableBytes, &t.IndexBytes,
+			&t.LiveRows, &t.DeadRows, &t.SeqScans, &t.IndexScans,
+			&t.LastVacuum, &t.LastAutovacuum, &t.LastAnalyze, &t.LastAutoanalyze, &t.AutovacuumCount); err != nil {
+			return err
+		}
+		rep.Tables = append(rep.Tables, t)
+	}
+	return rows.Err()
+}
+
+func reportIndexes(ctx context.Context, tx pgx.Tx, rep *DBReport) error {
+	rows, err := tx.Query(ctx, `
+		SELECT relname, indexrelname, pg_relation_size(indexrelid), idx_scan
+		FROM pg_stat_user_indexes
+		WHERE schemaname = current_schema() AND relname = ANY($1)
+		ORDER BY relname, indexrelname`, reportTables)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ix IndexReport
+		if err := rows.Scan(&ix.Table, &ix.Name, &ix.Bytes, &ix.Scans); err != nil {
+			return err
+		}
+		ix.Unused = ix.Scans == 0
+		rep.Indexes = append(rep.Indexes, ix)
+	}
+	return rows.Err()
+}
+
+func reportCacheHit(ctx context.Context, tx pgx.Tx, rep *DBReport) error {
+	var hit CacheHitReport
+	err := tx.QueryRow(ctx, `
+		SELECT sum(heap_blks_hit)::float8 / NULLIF(sum(heap_blks_hit) + sum(heap_blks_read), 0),
+		       sum(idx_blks_hit)::float8 / NULLIF(sum(idx_blks_hit) + sum(idx_blks_read), 0)
+		FROM pg_statio_user_tables
+		WHERE schemaname = current_schema() AND relname = ANY($1)`, reportTables,
+	).Scan(&hit.Table, &hit.Index)
+	if err != nil {
+		return err
+	}
+	rep.CacheHit = &hit
+	return nil
+}
+
+func reportConnections(ctx context.Context, tx pgx.Tx, rep *DBReport) error {
+	rows, err := tx.Query(ctx, `
+		SELECT COALESCE(state, 'unknown'), count(*)
+		FROM pg_stat_activity
+		WHERE datname = current_database()
+		GROUP BY 1`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var state string
+		var n int64
+		if err := rows.Scan(&state, &n); err != nil {
+			return err
+		}
+		rep.Connections[state] = n
+	}
+	return rows.Err()
+}
+
+func reportQueries(ctx context.Context, tx pgx.Tx, rep *DBReport) error {
+	rows, err := tx.Query(ctx, `
+		SELECT pid, COALESCE(state, ''), EXTRACT(EPOCH FROM now() - query_start)::float8,
+		       COALESCE(wait_event, ''), query
+		FROM pg_stat_activity
+		WHERE application_name = current_setting('application_name')
+		  AND pid <> pg_backend_pid() AND state <> 'idle' AND query_start IS NOT NULL
+		ORDER BY query_start
+		LIMIT 10`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var q QueryReport
+		if err := rows.Scan(&q.PID, &q.State, &q.Seconds, &q.WaitEvent, &q.Query); err != nil {
+			return err
+		}
+		q.Query = RedactQuery(q.Query)
+		rep.LongestQueries = append(rep.LongestQueries, q)
+	}
+	return rows.Err()
+}
+
+var (
+	stringLiteral  = regexp.MustCompile(`(?s)[EeBbXxNn]?'(?:[^']|'')*'|\$([A-Za-z_]*)\$.*?\$([A-Za-z_]*)\$`)
+	numericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?(?:[eE][-+]?\d+)?\b`)
+)
+
+// RedactQuery replaces string, dollar-quoted and numeric literals with ?
+// so customer data never ends up in a report. Placeholders ($1) are kept.
+func RedactQuery(q string) string {
+	q = stringLiteral.ReplaceAllString(q, "?")
+	var b strings.Builder
+	last := 0
+	for _, m := range numericLiteral.FindAllStringIndex(q, -1) {
+		if m[0] > 0 && q[m[0]-1] == '$' {
+			continue
+		}
+		b.WriteString(q[last:m[0]])
+		b.WriteString("?")
+		last = m[1]
+	}
+	b.WriteString(q[last:])
+	return b.String()
+}
+
+// WriteText renders the report for humans.
+func (rep *DBReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	p := func(format string, args ...any) { fmt.Fprintf(tw, format, args...) }
+
+	p("Database report, %s\n", rep.GeneratedAt.Format(time.RFC3339))
+	p("Postgres %s, application_name %q\n\n", rep.ServerVersion, rep.ApplicationName)
+
+	p("TABLE\tTOTAL\tTABLE\tINDEXES\tLIVE\tDEAD\tSEQ SCANS\tIDX SCANS\tLAST AUTOVACUUM\n")
+	for _, t := range rep.Tables {
+		p("%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n", t.Name, t.TotalBytes, t.TableBytes, t.IndexBytes,
+			t.LiveRows, t.DeadRows, t.SeqScans, t.IndexScans, formatReportTime(t.LastAutovacuum))
+	}
+
+	p("\nINDEX\tTABLE\tBYTES\tSCANS\n")
+	for _, ix := range rep.Indexes {
+		note := ""
+		if ix.Unused {
+			note = " (unused)"
+		}
+		p("%s\t%s\t%d\t%d%s\n", ix.Name, ix.Table, ix.Bytes, ix.Scans, note)
+	}
+
+	if rep.CacheHit != nil {
+		p("\nCache hit ratio: tables %s, indexes %s\n", formatRatio(rep.CacheHit.Table), formatRatio(rep.CacheHit.Index))
+	}
+
+	p("\nCONNECTION STATE\tCOUNT\n")
+	for state, n := range rep.Connections {
+		p("%s\t%d\n", state, n)
+	}
+
+	p("\nPID\tSTATE\tSECONDS\tWAIT\tQUERY\n")
+	for _, q := range rep.LongestQueries {
+		p("%d\t%s\t%.1f\t%s\t%s\n", q.PID, q.State, q.Seconds, q.WaitEvent, strings.Join(strings.Fields(q.Query), " "))
+	}
+
+	if len(rep.Errors) > 0 {
+		p("\nSECTION\tNOT AVAILABLE\n")
+		for name, msg := range rep.Errors {
+			p("%s\t%s\n", name, msg)
+		}
+	}
+	return tw.Flush()
+}
+
+func formatReportTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatRatio(r *float64) string {
+	if r == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f%%", *r*100)
+}
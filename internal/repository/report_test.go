@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"SELECT * FROM users WHERE email = 'ada@example.com'", "SELECT * FROM users WHERE email = ?"},
+		{"SELECT * FROM users WHERE name = 'O''Brien' AND id = 42", "SELECT * FROM users WHERE name = ? AND id = ?"},
+		{"SELECT E'line\\n', B'1010', X'ff', N'x'", "SELECT ?, ?, ?, ?"},
+		{"SELECT $$Ada's$$, $tag$multi\nline$tag$", "SELECT ?, ?"},
+		{"SELECT 3.14, 1e10, 2.5E-3", "SELECT ?, ?, ?"},
+		{"UPDATE users SET name=$1 WHERE id=$2", "UPDATE users SET name=$1 WHERE id=$2"},
+		{"SELECT id FROM user_views LIMIT 10 OFFSET 20", "SELECT id FROM user_views LIMIT ? OFFSET ?"},
+		// Digits inside identifiers are not literals.
+		{"SELECT col1 FROM t2", "SELECT col1 FROM t2"},
+	}
+	for _, tt := range tests {
+		if got := RedactQuery(tt.in); got != tt.want {
+			t.Errorf("RedactQuery(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReportWriteText(t *testing.T) {
+	vacuumed := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ratio := 0.995
+	rep := &DBReport{
+		GeneratedAt:     vacuumed,
+		ServerVersion:   "16.2",
+		ApplicationName: "go-k8s-demo",
+		Tables:          []TableReport{{Name: "users", TotalBytes: 8192, LastAutovacuum: &vacuumed}, {Name: "user_labels"}},
+		Indexes:         []IndexReport{{Table: "users", Name: "users_email_key", Scans: 7}, {Table: "users", Name: "users_name_idx", Unused: true}},
+		CacheHit:        &CacheHitReport{Table: &ratio},
+		Connections:     map[string]int64{"active": 2},
+		LongestQueries:  []QueryReport{{PID: 12, State: "active", Seconds: 1.25, Query: "SELECT ?\n  FROM users"}},
+		Errors:          map[string]string{"longest_queries": "permission denied for view pg_stat_activity"},
+	}
+	var buf bytes.Buffer
+	if err := rep.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`Postgres 16.2, application_name "go-k8s-demo"`,
+		"2026-01-02T03:04:05Z",
+		"never",
+		"Cache hit ratio: tables 99.50%, indexes n/a",
+		"SELECT ? FROM users",
+		"longest_queries  permission denied",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report lacks %q:\n%s", want, out)
+		}
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "users_") && strings.HasSuffix(line, "(unused)") != strings.HasPrefix(line, "users_name_idx") {
+			t.Errorf("unused marker wrong: %q", line)
+		}
+	}
+}
+
+// Against a real database every section is filled in and no literal of a
+// running statement reaches the report.
+func TestReport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx, map[string]string{"application_name": "report-test"})
+	repo := New(pool)
+	if _, err := repo.CreateUser(ctx, "Ada", "ada@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A statement of this application still running while the report
+	// is gathered.
+	done := make(chan error, 1)
+	go func() {
+		_, err := pool.Exec(ctx, "SELECT pg_sleep(2), 'secret-literal'")
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	rep := repo.Report(ctx)
+	if len(rep.Errors) != 0 {
+		t.Fatalf("sections failed: %v", rep.Errors)
+	}
+	if rep.ServerVersion == "" || rep.ApplicationName != "report-test" || rep.CacheHit == nil || len(rep.Connections) == 0 {
+		t.Errorf("report %+v", rep)
+	}
+	tables := map[string]bool{}
+	for _, tr := range rep.Tables {
+		tables[tr.Name] = true
+	}
+	for _, name := range reportTables {
+		if !tables[name] {
+			t.Errorf("table %s missing from %+v", name, rep.Tables)
+		}
+	}
+	if len(rep.Indexes) == 0 {
+		t.Error("no indexes reported")
+	}
+	found := false
+	for _, q := range rep.LongestQueries {
+		if strings.Contains(q.Query, "secret-literal") {
+			t.Errorf("literal kept: %s", q.Query)
+		}
+		found = found || strings.Contains(q.Query, "pg_sleep(?)")
+	}
+	if !found {
+		t.Errorf("running statement not reported: %+v", rep.LongestQueries)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,1046 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/requestctx"
+)
+
+// ErrUserNotFound is returned when the referenced user does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrReadOnly is returned by writes the database refuses because it is
+// read-only (e.g. a promoted replica during a DR drill).
+var ErrReadOnly = errors.New("database is read-only")
+
+// ErrLabelNotFound is returned when deleting a label the user does not have.
+var ErrLabelNotFound = errors.New("label not found")
+
+// ErrEmailAlreadyExists is returned when a create or update would give two
+// users the same email.
+var ErrEmailAlreadyExists = errors.New("email already in use")
+
+// ErrVersionConflict is returned when a conditional write names a version
+// the user no longer has.
+var ErrVersionConflict = errors.New("user version conflict")
+
+// ErrCollationUnavailable is returned when a sort names a collation the
+// database lacks and more rows match than the repository will order in
+// Go.
+var ErrCollationUnavailable = errors.New("collation not available for a result this large")
+
+// SQLSTATE codes the repository translates into typed errors.
+const (
+	sqlstateForeignKeyViolation    = "23503"
+	sqlstateUniqueViolation        = "23505"
+	sqlstateReadOnlySQLTransaction = "25006"
+)
+
+// usersEmailKey is the unique constraint V1 puts on users.email.
+const usersEmailKey = "users_email_key"
+
+// writeErr translates errors common to every write.
+func writeErr(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch {
+	case pgErr.Code == sqlstateReadOnlySQLTransaction:
+		return ErrReadOnly
+	case pgErr.Code == sqlstateUniqueViolation && pgErr.ConstraintName == usersEmailKey:
+		return ErrEmailAlreadyExists
+	}
+	return err
+}
+
+// User represents a database entity.
+// In real projects you would place this in domain/models.
+// Every field is persisted: db names its column (see UserFields).
+// CreatedAt and UpdatedAt are set by the database and never written here.
+// Version counts writes to the users row (name, email, metadata); labels
+// live in their own table and leave it alone. DeletedAt is set while the
+// user is soft-deleted; every query skips such users unless it says so.
+type User struct {
+	ID        int64             `json:"id" db:"id"`
+	Name      string            `json:"name" db:"name"`
+	Email     string            `json:"email" db:"email"`
+	Metadata  map[string]any    `json:"metadata" db:"metadata"`
+	Labels    map[string]string `json:"labels" db:"labels"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
+	Version   int64             `json:"version" db:"version"`
+	DeletedAt *time.Time        `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+// UserFilter narrows GetUsers and CountUsers. The zero value matches every user.
+type UserFilter struct {
+	// Metadata matches users whose metadata contains all of these
+	// key/value pairs (metadata @> filter).
+	Metadata map[string]string
+	// Labels matches users carrying every one of these labels.
+	Labels map[string]string
+	// Name and Email match users whose name or email contains the text,
+	// ignoring case; empty matches everyone.
+	Name  string
+	Email string
+	// IncludeDeleted also matches soft-deleted users.
+	IncludeDeleted bool
+}
+
+// active is the condition every query but the IncludeDeleted ones and
+// HardDeleteUser puts on users.
+const active = "deleted_at IS NULL"
+
+// conditions renders the filter as SQL conditions with their positional
+// arguments.
+func (f UserFilter) conditions() (conds []string, args []any) {
+	if !f.IncludeDeleted {
+		conds = append(conds, active)
+	}
+	if len(f.Metadata) > 0 {
+		args = append(args, f.Metadata)
+		conds = append(conds, fmt.Sprintf("metadata @> $%d", len(args)))
+	}
+	for _, key := range slices.Sorted(maps.Keys(f.Labels)) {
+		args = append(args, key, f.Labels[key])
+		conds = append(conds, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM user_labels l WHERE l.user_id = users.id AND l.key = $%d AND l.value = $%d)",
+			len(args)-1, len(args)))
+	}
+	if f.Name != "" {
+		args = append(args, containsPattern(f.Name))
+		conds = append(conds, fmt.Sprintf(`name ILIKE $%d ESCAPE '\'`, len(args)))
+	}
+	if f.Email != "" {
+		args = append(args, containsPattern(f.Email))
+		conds = append(conds, fmt.Sprintf(`email ILIKE $%d ESCAPE '\'`, len(args)))
+	}
+	return conds, args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern is the LIKE pattern for text containing s, with the
+// wildcards in s matching themselves.
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+func where(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// DefaultMaxRows is the safety cap on rows any multi-row query returns.
+const DefaultMaxRows = 1000
+
+// Repository provides DB methods.
+// In real code you'd separate interface & implementation, but for demo we keep it compact.
+type Repository struct {
+	db      *pgxpool.Pool
+	maxRows int
+
+	slowTxWarn     time.Duration
+	slowTxSnapshot time.Duration
+	slowTxTotal    *metrics.CounterVec
+	truncatedTotal *metrics.CounterVec
+
+	// collations caches which of SupportedCollations the database has.
+	collationsMu sync.Mutex
+	collations   map[string]bool
+
+	// shapes counts the filter and sort combinations queries use, for
+	// IndexAdvice.
+	shapes *shapeCounter
+}
+
+// Option configures a Repository.
+type Option func(*Repository)
+
+// WithMaxRows overrides DefaultMaxRows.
+func WithMaxRows(n int) Option {
+	return func(r *Repository) {
+		if n > 0 {
+			r.maxRows = n
+		}
+	}
+}
+
+// New constructs a new repo.
+func New(db *pgxpool.Pool, opts ...Option) *Repository {
+	r := &Repository{db: db, maxRows: DefaultMaxRows, shapes: newShapeCounter(advisorWindow)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// logger is the request-scoped logger carried by ctx (it adds the request
+// ID), or the global one for calls outside a request.
+func logger(ctx context.Context) *zerolog.Logger {
+	if l, ok := requestctx.Logger(ctx); ok {
+		return l
+	}
+	return &log.Logger
+}
+
+// capLimit is the LIMIT of a query asking for limit rows, zero meaning
+// all of them: never more than one row past the cap, so queryUsers can
+// tell the cap cut the result short.
+func (r *Repository) capLimit(limit int) int {
+	if limit <= 0 || limit > r.maxRows {
+		return r.maxRows + 1
+	}
+	return limit
+}
+
+// queryUsers runs a multi-row user query whose LIMIT came from capLimit
+// and returns at most the row cap. truncated reports that more rows
+// matched than were returned; name identifies the query in the warning
+// log and the truncation metric.
+func (r *Repository) queryUsers(ctx context.Context, name, query string, args ...any) (users []User, truncated bool, err error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if len(users) == r.maxRows {
+			truncated = true
+			break
+		}
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, false, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if truncated {
+		if r.truncatedTotal != nil {
+			r.truncatedTotal.With(name).Inc()
+		}
+		logger(ctx).Warn().Str("query", name).Int("max_rows", r.maxRows).Msg("query result truncated at row cap")
+	}
+	return users, truncated, nil
+}
+
+// hasCollation reports whether the database has the named collation,
+// which migration V10 creates only where the server was built with ICU.
+// Collations don't come and go while the server runs, so each answer is
+// looked up once.
+func (r *Repository) hasCollation(ctx context.Context, name string) (bool, error) {
+	r.collationsMu.Lock()
+	defer r.collationsMu.Unlock()
+	if ok, cached := r.collations[name]; cached {
+		return ok, nil
+	}
+	var ok bool
+	err := r.db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pg_collation WHERE collname = $1 AND pg_collation_is_visible(oid))", name).Scan(&ok)
+	if err != nil {
+		return false, err
+	}
+	if r.collations == nil {
+		r.collations = make(map[string]bool)
+	}
+	r.collations[name] = ok
+	return ok, nil
+}
+
+// sortInGo reports whether sort needs a collation the database lacks, so
+// that the caller must order the rows itself.
+func (r *Repository) sortInGo(ctx context.Context, sort Sort) (bool, error) {
+	c := sort.Collation()
+	if c == "" {
+		return false, nil
+	}
+	ok, err := r.hasCollation(ctx, c)
+	return !ok, err
+}
+
+// collatedUsers is GetUsers for a collation the database lacks: it reads
+// every matching row, up to the row cap, and orders them in Go.
+func (r *Repository) collatedUsers(ctx context.Context, conds []string, args []any, sort Sort) ([]User, error) {
+	args = append(args, r.capLimit(0))
+	query := "SELECT " + userColumns + " FROM users" + where(conds) + fmt.Sprintf(" LIMIT $%d", len(args))
+	users, truncated, err := r.queryUsers(ctx, "GetUsersCollated", query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if truncated {
+		return nil, ErrCollationUnavailable
+	}
+	slices.SortFunc(users, sort.comparer())
+	return users, nil
+}
+
+// ---------------------------------------------------------
+// DATABASE METHODS
+// ---------------------------------------------------------
+
+// Ping verifies DB connectivity; used by the readiness probe.
+func (r *Repository) Ping(ctx context.Context) error {
+	return r.db.Ping(ctx)
+}
+
+// PoolStats reports how many pooled connections are in use and the
+// pool's maximum size.
+func (r *Repository) PoolStats() (acquired, max int32) {
+	st := r.db.Stat()
+	return st.AcquiredConns(), st.MaxConns()
+}
+
+// ReadOnly reports whether new transactions are read-only, e.g. because
+// the server is a standby or default_transaction_read_only is on.
+func (r *Repository) ReadOnly(ctx context.Context) (bool, error) {
+	var setting string
+	err := r.db.QueryRow(ctx, "SELECT current_setting('transaction_read_only')").Scan(&setting)
+	return setting == "on", err
+}
+
+// Now returns the database server's current time.
+func (r *Repository) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	err := r.db.QueryRow(ctx, "SELECT now()").Scan(&now)
+	return now, err
+}
+
+// GetUsers returns users matching filter in sort order (by id when sort
+// is nil), skipping the first offset. A positive limit bounds the page; otherwise only the row cap
+// does, and truncated is set when it cut the result short. A collation
+// the database lacks is applied in Go, which fails with
+// ErrCollationUnavailable past the row cap.
+func (r *Repository) GetUsers(ctx context.Context, filter UserFilter, sort Sort, limit, offset int) (users []User, truncated bool, err error) {
+	r.observe(filter, sort)
+	conds, args := filter.conditions()
+	if inGo, err := r.sortInGo(ctx, sort); err != nil || inGo {
+		if err != nil {
+			return nil, false, err
+		}
+		users, err := r.collatedUsers(ctx, conds, args, sort)
+		if err != nil {
+			return nil, false, err
+		}
+		users = users[min(offset, len(users)):]
+		if limit > 0 && limit < len(users) {
+			users = users[:limit]
+		}
+		return users, false, nil
+	}
+	args = append(args, r.capLimit(limit))
+	query := "SELECT " + userColumns + " FROM users" + where(conds) + sort.orderBy() + fmt.Sprintf(" LIMIT $%d", len(args))
+	if offset > 0 {
+		args = append(args, offset)
+		query += fmt.Sprintf(" OFFSET $%d", len(args))
+	}
+
+	return r.queryUsers(ctx, "GetUsers", query, args...)
+}
+
+// GetUsersAfter returns up to limit users matching filter with ids above
+// afterID, in id order. Unlike OFFSET, the cost does not grow with how far
+// into the table the page is.
+func (r *Repository) GetUsersAfter(ctx context.Context, filter UserFilter, afterID int64, limit int) ([]User, error) {
+	r.observe(filter, nil)
+	conds, args := filter.conditions()
+	args = append(args, afterID)
+	conds = append(conds, fmt.Sprintf("id > $%d", len(args)))
+	args = append(args, r.capLimit(limit))
+	query := "SELECT " + userColumns + " FROM users" + where(conds) + fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+
+	users, _, err := r.queryUsers(ctx, "GetUsersAfter", query, args...)
+	return users, err
+}
+
+// EachUser streams every user matching filter, in sort order, to fn one
+// row at a time, so exports don't hold the result in memory. The row cap
+// does not apply; an error from fn stops the walk and is returned. With a
+// batch size the rows are fetched through a cursor, batch() rows per
+// FETCH. A collation the database lacks is applied in Go, as in GetUsers.
+func (r *Repository) EachUser(ctx context.Context, filter UserFilter, sort Sort, batch BatchSize, fn func(User) error) error {
+	r.observe(filter, sort)
+	conds, args := filter.conditions()
+	if inGo, err := r.sortInGo(ctx, sort); err != nil || inGo {
+		if err != nil {
+			return err
+		}
+		users, err := r.collatedUsers(ctx, conds, args, sort)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	query := "SELECT " + userColumns + " FROM users" + where(conds) + sort.orderBy()
+	if batch == nil {
+		rows, err := r.db.Query(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		_, err = eachRow(rows, fn)
+		return err
+	}
+	return pgx.BeginTxFunc(ctx, r.db, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DECLARE each_user NO SCROLL CURSOR FOR "+query, args...); err != nil {
+			return err
+		}
+		for {
+			// FETCH takes no parameters; the count is an int.
+			rows, err := tx.Query(ctx, fmt.Sprintf("FETCH FORWARD %d FROM each_user", batch.next(math.MaxInt32)))
+			if err != nil {
+				return err
+			}
+			n, err := eachRow(rows, fn)
+			if err != nil || n == 0 {
+				return err
+			}
+		}
+	})
+}
+
+// eachRow scans rows as users into fn and closes them, returning how
+// many it passed on.
+func eachRow(rows pgx.Rows, fn func(User) error) (int, error) {
+	defer rows.Close()
+	n := 0
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := fn(u); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// CountUsers returns how many users match filter, ignoring the row cap.
+func (r *Repository) CountUsers(ctx context.Context, filter UserFilter) (int64, error) {
+	r.observe(filter, nil)
+	conds, args := filter.conditions()
+	var n int64
+	err := r.db.QueryRow(ctx, "SELECT count(*) FROM users"+where(conds), args...).Scan(&n)
+	return n, err
+}
+
+func (r *Repository) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	u, err := scanUser(r.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id=$1 AND "+active, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UserExists reports whether a user with the given id exists without loading the row.
+func (r *Repository) UserExists(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND "+active+")", id).Scan(&exists)
+	return exists, err
+}
+
+// CreateUser inserts a user; nil metadata is stored as an empty object.
+func (r *Repository) CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error) {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+
+	// Demonstrates use of transactions — good practice for write operations.
+	var u User
+	err := r.withTx(ctx, "create_user", func(tx pgx.Tx) error {
+		var err error
+		u, err = scanUser(tx.QueryRow(ctx,
+			"INSERT INTO users (name, email, metadata) VALUES ($1, $2, $3) RETURNING "+userColumns,
+			name, email, metadata,
+		))
+		return err
+	})
+	if err != nil {
+		return nil, writeErr(err)
+	}
+
+	return &u, nil
+}
+
+// NewUser is one element of a CreateUsers batch.
+type NewUser struct {
+	Name     string
+	Email    string
+	Metadata map[string]any
+}
+
+// BatchError reports which element of a batch failed; errors.Is sees the
+// cause, such as ErrEmailAlreadyExists.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string { return fmt.Sprintf("batch element %d: %v", e.Index, e.Err) }
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// CreateUsers inserts every user or none and returns their ids in input
+// order. The inserts go out as pgx.Batches of batch() users inside one
+// transaction, so each batch costs a single round trip; a nil batch sends
+// them all at once.
+func (r *Repository) CreateUsers(ctx context.Context, users []NewUser, batch BatchSize) ([]int64, error) {
+	ids := make([]int64, len(users))
+	err := r.withTx(ctx, "create_users", func(tx pgx.Tx) error {
+		for start := 0; start < len(users); {
+			end := start + batch.next(len(users)-start)
+			b := &pgx.Batch{}
+			for _, u := range users[start:end] {
+				md := u.Metadata
+				if md == nil {
+					md = map[string]any{}
+				}
+				b.Queue("INSERT INTO users (name, email, metadata) VALUES ($1, $2, $3) RETURNING id", u.Name, u.Email, md)
+			}
+			results := tx.SendBatch(ctx, b)
+			for i := start; i < end; i++ {
+				if err := results.QueryRow().Scan(&ids[i]); err != nil {
+					results.Close()
+					return &BatchError{Index: i, Err: writeErr(err)}
+				}
+			}
+			if err := results.Close(); err != nil {
+				return err
+			}
+			start = end
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(*BatchError); ok {
+			return nil, err
+		}
+		return nil, writeErr(err)
+	}
+	return ids, nil
+}
+
+// UpdateUser replaces name and email, and metadata unless it is nil, and
+// returns the updated user. A non-zero version makes the write
+// conditional: a user at another version gives ErrVersionConflict.
+func (r *Repository) UpdateUser(ctx context.Context, id, version int64, name, email string, metadata map[string]any) (*User, error) {
+	// An untyped nil is sent as SQL NULL so COALESCE keeps the old value.
+	var md any
+	if metadata != nil {
+		md = metadata
+	}
+
+	return r.updateUser(ctx, id, version,
+		"UPDATE users SET name=$1, email=$2, metadata=COALESCE($3, metadata), version=version+1 WHERE id=$4 AND ($5::bigint = 0 OR version=$5) AND "+active+" RETURNING "+userColumns,
+		name, email, md, id, version,
+	)
+}
+
+// PatchUser updates only the fields that are non-nil; at least one must be.
+// version is checked as in UpdateUser.
+func (r *Repository) PatchUser(ctx context.Context, id, version int64, name, email *string) (*User, error) {
+	var sets []string
+	var args []any
+	if name != nil {
+		args = append(args, *name)
+		sets = append(sets, fmt.Sprintf("name=$%d", len(args)))
+	}
+	if email != nil {
+		args = append(args, *email)
+		sets = append(sets, fmt.Sprintf("email=$%d", len(args)))
+	}
+	if len(sets) == 0 {
+		return nil, errors.New("repository: PatchUser needs at least one field")
+	}
+	args = append(args, id, version)
+
+	return r.updateUser(ctx, id, version,
+		fmt.Sprintf("UPDATE users SET %s, version=version+1 WHERE id=$%d AND ($%d::bigint = 0 OR version=$%d) AND %s RETURNING %s",
+			strings.Join(sets, ", "), len(args)-1, len(args), len(args), active, userColumns),
+		args...,
+	)
+}
+
+// updateUser runs an UPDATE ... RETURNING userColumns of user id,
+// conditional on version unless it is zero.
+func (r *Repository) updateUser(ctx context.Context, id, version int64, query string, args ...any) (*User, error) {
+	u, err := scanUser(r.db.QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, r.missing(ctx, id, version)
+	}
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return &u, nil
+}
+
+// DeleteUser soft-deletes user id, conditional on version unless it is
+// zero. The row, its labels and views stay until HardDeleteUser.
+func (r *Repository) DeleteUser(ctx context.Context, id, version int64) error {
+	cmd, err := r.db.Exec(ctx,
+		"UPDATE users SET deleted_at=now(), version=version+1 WHERE id=$1 AND ($2::bigint = 0 OR version=$2) AND "+active,
+		id, version,
+	)
+	if err != nil {
+		return writeErr(err)
+	}
+
+	if cmd.RowsAffected() == 0 {
+		return r.missing(ctx, id, version)
+	}
+
+	return nil
+}
+
+// HardDeleteUser removes user id for good, soft-deleted or not; version
+// is checked as in DeleteUser.
+func (r *Repository) HardDeleteUser(ctx context.Context, id, version int64) error {
+	cmd, err := r.db.Exec(ctx, "DELETE FROM users WHERE id=$1 AND ($2::bigint = 0 OR version=$2)", id, version)
+	if err != nil {
+		return writeErr(err)
+	}
+
+	if cmd.RowsAffected() == 0 {
+		var exists bool
+		if err := r.db.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id=$1)", id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrVersionConflict
+		}
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// RestoreUser undoes DeleteUser and returns the user; restoring a user
+// that is not deleted returns it unchanged.
+func (r *Repository) RestoreUser(ctx context.Context, id int64) (*User, error) {
+	u, err := scanUser(r.db.QueryRow(ctx,
+		"UPDATE users SET deleted_at=NULL, version=version+1 WHERE id=$1 AND deleted_at IS NOT NULL RETURNING "+userColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r.GetUserByID(ctx, id)
+	}
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return &u, nil
+}
+
+// missing explains a conditional write that matched no row: the user is
+// gone, or it exists at another version.
+func (r *Repository) missing(ctx context.Context, id, version int64) error {
+	if version == 0 {
+		return ErrUserNotFound
+	}
+	exists, err := r.UserExists(ctx, id)
+	switch {
+	case err != nil:
+		return err
+	case exists:
+		return ErrVersionConflict
+	}
+	return ErrUserNotFound
+}
+
+// PatchMetadata merges set into the user's metadata and removes the del
+// keys. The merged document is passed to check before it is written, so
+// size limits apply to the result rather than to the patch; a check error
+// is returned unchanged. version is checked as in UpdateUser.
+func (r *Repository) PatchMetadata(ctx context.Context, id, version int64, set map[string]any, del []string, check func(map[string]any) error) (map[string]any, error) {
+	var metadata map[string]any
+	err := r.withTx(ctx, "patch_metadata", func(tx pgx.Tx) error {
+		if err := lockUser(ctx, tx, id, version); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(ctx, "SELECT metadata FROM users WHERE id=$1", id).Scan(&metadata); err != nil {
+			return err
+		}
+
+		if metadata == nil {
+			metadata = map[string]any{}
+		}
+		for k, v := range set {
+			metadata[k] = v
+		}
+		for _, k := range del {
+			delete(metadata, k)
+		}
+
+		if err := check(metadata); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(ctx, "UPDATE users SET metadata=$1, version=version+1 WHERE id=$2", metadata, id)
+		return err
+	})
+	if err != nil {
+		return nil, writeErr(err)
+	}
+
+	return metadata, nil
+}
+
+// lockUser locks the user's row for the rest of tx, serializing label
+// and metadata writes so per-user limits hold under concurrency. A
+// non-zero version must match, as in UpdateUser.
+func lockUser(ctx context.Context, tx pgx.Tx, id, version int64) error {
+	var one int
+	err := tx.QueryRow(ctx,
+		"SELECT 1 FROM users WHERE id=$1 AND ($2::bigint = 0 OR version=$2) AND "+active+" FOR UPDATE", id, version,
+	).Scan(&one)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	if version == 0 {
+		return ErrUserNotFound
+	}
+	var exists bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE id=$1 AND "+active+")", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionConflict
+	}
+	return ErrUserNotFound
+}
+
+// touchUser bumps the version of a user locked by lockUser, so label
+// changes move its ETag like any other write.
+func touchUser(ctx context.Context, tx pgx.Tx, id int64) error {
+	_, err := tx.Exec(ctx, "UPDATE users SET version=version+1 WHERE id=$1", id)
+	return err
+}
+
+// upsertLabels writes labels in one statement.
+func upsertLabels(ctx context.Context, tx pgx.Tx, id int64, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx,
+		`INSERT INTO user_labels (user_id, key, value)
+		 SELECT $1, l.key, l.value FROM jsonb_each_text($2::jsonb) AS l
+		 ON CONFLICT (user_id, key) DO UPDATE SET value = EXCLUDED.value`,
+		id, labels,
+	)
+	return err
+}
+
+// ReplaceLabels makes labels the user's complete label set; version is
+// checked as in UpdateUser.
+func (r *Repository) ReplaceLabels(ctx context.Context, id, version int64, labels map[string]string) (map[string]string, error) {
+	err := r.withTx(ctx, "replace_labels", func(tx pgx.Tx) error {
+		if err := lockUser(ctx, tx, id, version); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM user_labels WHERE user_id=$1", id); err != nil {
+			return err
+		}
+		if err := upsertLabels(ctx, tx, id, labels); err != nil {
+			return err
+		}
+		return touchUser(ctx, tx, id)
+	})
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return labels, nil
+}
+
+// PatchLabels sets the set labels and removes the del keys. Like
+// PatchMetadata, the merged set is passed to check before anything is
+// written, a check error is returned unchanged and version is checked.
+func (r *Repository) PatchLabels(ctx context.Context, id, version int64, set map[string]string, del []string, check func(map[string]string) error) (map[string]string, error) {
+	merged := map[string]string{}
+	err := r.withTx(ctx, "patch_labels", func(tx pgx.Tx) error {
+		if err := lockUser(ctx, tx, id, version); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, "SELECT key, value FROM user_labels WHERE user_id=$1", id)
+		if err != nil {
+			return err
+		}
+		var key, value string
+		if _, err := pgx.ForEachRow(rows, []any{&key, &value}, func() error {
+			merged[key] = value
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		maps.Copy(merged, set)
+		for _, k := range del {
+			delete(merged, k)
+		}
+		if err := check(merged); err != nil {
+			return err
+		}
+
+		if len(del) > 0 {
+			if _, err := tx.Exec(ctx, "DELETE FROM user_labels WHERE user_id=$1 AND key = ANY($2)", id, del); err != nil {
+				return err
+			}
+		}
+		if err := upsertLabels(ctx, tx, id, set); err != nil {
+			return err
+		}
+		return touchUser(ctx, tx, id)
+	})
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return merged, nil
+}
+
+// DeleteLabel removes one label; ErrLabelNotFound if the user lacks it.
+// version is checked as in UpdateUser.
+func (r *Repository) DeleteLabel(ctx context.Context, id, version int64, key string) error {
+	err := r.withTx(ctx, "delete_label", func(tx pgx.Tx) error {
+		if err := lockUser(ctx, tx, id, version); err != nil {
+			return err
+		}
+		cmd, err := tx.Exec(ctx, "DELETE FROM user_labels WHERE user_id=$1 AND key=$2", id, key)
+		if err != nil {
+			return err
+		}
+		if cmd.RowsAffected() == 0 {
+			return ErrLabelNotFound
+		}
+		return touchUser(ctx, tx, id)
+	})
+	return writeErr(err)
+}
+
+// TableStats returns the estimated row count and total on-disk size of
+// each of GrowthTables, in that order.
+func (r *Repository) TableStats(ctx context.Context) ([]TableStat, error) {
+	// reltuples is a planner estimate and costs nothing to read; it is -1
+	// until the table has been analyzed, in which case fall back to count(*).
+	stats := make([]TableStat, len(GrowthTables))
+	for i, table := range GrowthTables {
+		st := TableStat{Table: table}
+		err := r.db.QueryRow(ctx,
+			"SELECT reltuples::bigint, pg_total_relation_size(oid) FROM pg_class WHERE oid = $1::regclass", table,
+		).Scan(&st.Rows, &st.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", table, err)
+		}
+		if st.Rows < 0 {
+			if err := r.db.QueryRow(ctx, "SELECT count(*) FROM "+pgx.Identifier{table}.Sanitize()).Scan(&st.Rows); err != nil {
+				return nil, fmt.Errorf("%s: %w", table, err)
+			}
+		}
+		stats[i] = st
+	}
+	return stats, nil
+}
+
+// IncrementViews adds n to the user's view counter and returns the new total.
+// The UPSERT avoids read-modify-write races between concurrent increments.
+func (r *Repository) IncrementViews(ctx context.Context, id, n int64) (int64, error) {
+	var count int64
+	err := r.db.QueryRow(ctx,
+		`INSERT INTO user_views (user_id, count)
+		 SELECT $1::bigint, $2::bigint WHERE EXISTS (SELECT 1 FROM users WHERE id=$1 AND `+active+`)
+		 ON CONFLICT (user_id) DO UPDATE SET count = user_views.count + EXCLUDED.count
+		 RETURNING count`,
+		id, n,
+	).Scan(&count)
+
+	// No row: the user is unknown or soft-deleted. The foreign key still
+	// catches a user hard-deleted concurrently.
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == sqlstateForeignKeyViolation {
+		return 0, ErrUserNotFound
+	}
+	return count, writeErr(err)
+}
+
+// GetViews returns the user's view counter (zero if never viewed).
+func (r *Repository) GetViews(ctx context.Context, id int64) (int64, error) {
+	var count int64
+	err := r.db.QueryRow(ctx,
+		"SELECT COALESCE(v.count, 0) FROM users u LEFT JOIN user_views v ON v.user_id = u.id WHERE u.id = $1 AND u."+active,
+		id,
+	).Scan(&count)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrUserNotFound
+	}
+	return count, err
+}
+
+// IterateUsers walks users in id order, starting after startAfterID, in
+// batches of batchSize. Each batch is read and handed to fn inside its own
+// transaction, committed when fn returns nil; any error stops the walk.
+//
+// Iteration is keyset based (WHERE id > last), so rows deleted or inserted
+// behind the cursor never shift later pages: no row is seen twice and none
+// that existed for the whole run is skipped. A short batch ends the walk,
+// and ctx is checked between batches.
+func (r *Repository) IterateUsers(ctx context.Context, batchSize int, startAfterID int64, fn func(ctx context.Context, tx pgx.Tx, batch []User) error) error {
+	return walkKeyset(ctx, batchSize, startAfterID, func(ctx context.Context, after int64) (int, int64, error) {
+		return r.iterateBatch(ctx, batchSize, after, fn)
+	})
+}
+
+// walkKeyset drives a keyset walk: batch reads and handles up to
+// batchSize rows with ids above after and returns how many it saw and the
+// last id. The walk ends on the first short batch.
+func walkKeyset(ctx context.Context, batchSize int, after int64, batch func(ctx context.Context, after int64) (n int, last int64, err error)) error {
+	if batchSize <= 0 {
+		return errors.New("batch size must be positive")
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, last, err := batch(ctx, after)
+		if err != nil {
+			return err
+		}
+		if n < batchSize {
+			return nil
+		}
+		after = last
+	}
+}
+
+func (r *Repository) iterateBatch(ctx context.Context, batchSize int, after int64, fn func(ctx context.Context, tx pgx.Tx, batch []User) error) (n int, last int64, err error) {
+	last = after
+	err = r.withTx(ctx, "iterate_users", func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, "SELECT "+userColumns+" FROM users WHERE id > $1 AND "+active+" ORDER BY id LIMIT $2", after, batchSize)
+		if err != nil {
+			return err
+		}
+
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (User, error) {
+			return scanUser(row)
+		})
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		if err := fn(ctx, tx, batch); err != nil {
+			return err
+		}
+		n, last = len(batch), batch[len(batch)-1].ID
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return n, last, nil
+}
+
+// ConsumeShareLink records the use of a one-time share link. It returns
+// false when the nonce was already consumed.
+func (r *Repository) ConsumeShareLink(ctx context.Context, nonce string, userID int64, expiresAt time.Time) (bool, error) {
+	cmd, err := r.db.Exec(ctx,
+		"INSERT INTO share_link_uses (nonce, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (nonce) DO NOTHING",
+		nonce, userID, expiresAt,
+	)
+	if err != nil {
+		return false, writeErr(err)
+	}
+	return cmd.RowsAffected() == 1, nil
+}
+
+// PurgeShareLinkUses deletes up to limit consumed share links that
+// expired more than expiredFor ago by the database clock, oldest first,
+// and returns how many it deleted.
+func (r *Repository) PurgeShareLinkUses(ctx context.Context, expiredFor time.Duration, limit int) (int64, error) {
+	cmd, err := r.db.Exec(ctx,
+		`DELETE FROM share_link_uses WHERE nonce IN (
+		   SELECT nonce FROM share_link_uses WHERE expires_at < now() - $1::interval
+		   ORDER BY expires_at LIMIT $2)`,
+		expiredFor, limit,
+	)
+	if err != nil {
+		return 0, writeErr(err)
+	}
+	return cmd.RowsAffected(), nil
+}
+
+// SyncRateLimits merges buckets, rate-limit keys and when their bucket is
+// full again, into the shared ones, keeping the later time of the two,
+// and returns every bucket not yet full at now. Buckets full by now are
+// purged. now is the caller's clock, the one the times were computed on.
+func (r *Repository) SyncRateLimits(ctx context.Context, buckets map[string]time.Time, now time.Time) (map[string]time.Time, error) {
+	keys := make([]string, 0, len(buckets))
+	fullAt := make([]time.Time, 0, len(buckets))
+	for key, t := range buckets {
+		keys = append(keys, key)
+		fullAt = append(fullAt, t)
+	}
+
+	merged := make(map[string]time.Time)
+	err := r.withTx(ctx, "sync_rate_limits", func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM rate_limit_buckets WHERE full_at <= $1", now); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err := tx.Exec(ctx,
+				`INSERT INTO rate_limit_buckets (key, full_at)
+				 SELECT k, t FROM unnest($1::text[], $2::timestamptz[]) AS b(k, t) WHERE t > $3
+				 ON CONFLICT (key) DO UPDATE SET full_at = GREATEST(rate_limit_buckets.full_at, EXCLUDED.full_at)`,
+				keys, fullAt, now,
+			); err != nil {
+				return err
+			}
+		}
+		rows, err := tx.Query(ctx, "SELECT key, full_at FROM rate_limit_buckets")
+		if err != nil {
+			return err
+		}
+		var (
+			key string
+			t   time.Time
+		)
+		_, err = pgx.ForEachRow(rows, []any{&key, &t}, func() error {
+			merged[key] = t
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, writeErr(err)
+	}
+	return merged, nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestWriteErr(t *testing.T) {
+	other := errors.New("connection reset")
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"email taken", &pgconn.PgError{Code: sqlstateUniqueViolation, ConstraintName: usersEmailKey}, ErrEmailAlreadyExists},
+		{"wrapped", fmt.Errorf("insert: %w", &pgconn.PgError{Code: sqlstateUniqueViolation, ConstraintName: usersEmailKey}), ErrEmailAlreadyExists},
+		{"read-only", &pgconn.PgError{Code: sqlstateReadOnlySQLTransaction}, ErrReadOnly},
+		{"not a pg error", other, other},
+		{"nil", nil, nil},
+	}
+	for _, tt := range tests {
+		if got := writeErr(tt.err); got != tt.want {
+			t.Errorf("%s: writeErr(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+
+	// Other unique constraints are not about the email.
+	for _, pgErr := range []*pgconn.PgError{
+		{Code: sqlstateUniqueViolation, ConstraintName: "user_labels_pkey"},
+		{Code: sqlstateForeignKeyViolation, ConstraintName: usersEmailKey},
+	} {
+		if got := writeErr(pgErr); got != error(pgErr) {
+			t.Errorf("writeErr(%s on %s) = %v, want it unchanged", pgErr.Code, pgErr.ConstraintName, got)
+		}
+	}
+}
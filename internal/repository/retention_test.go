@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// shareLinkPurger is the part of both stores the retention job uses.
+type shareLinkPurger interface {
+	ConsumeShareLink(ctx context.Context, nonce string, userID int64, expiresAt time.Time) (bool, error)
+	PurgeShareLinkUses(ctx context.Context, expiredFor time.Duration, limit int) (int64, error)
+}
+
+// testPurgeShareLinkUses seeds expired and fresh share link uses and
+// checks that exactly the ones past the retention are purged, in batches.
+func testPurgeShareLinkUses(t *testing.T, ctx context.Context, store shareLinkPurger, userID int64) {
+	t.Helper()
+	now := time.Now()
+	seed := map[string]time.Time{
+		"expired-3h": now.Add(-3 * time.Hour),
+		"expired-2h": now.Add(-2 * time.Hour),
+		"expired-5m": now.Add(-5 * time.Minute), // within the retention
+		"fresh":      now.Add(time.Hour),
+	}
+	for nonce, expires := range seed {
+		if ok, err := store.ConsumeShareLink(ctx, nonce, userID, expires); err != nil || !ok {
+			t.Fatalf("seeding %s: %v %v", nonce, ok, err)
+		}
+	}
+
+	if n, err := store.PurgeShareLinkUses(ctx, time.Hour, 1); err != nil || n != 1 {
+		t.Fatalf("first batch: %d, %v; want 1", n, err)
+	}
+	if n, err := store.PurgeShareLinkUses(ctx, time.Hour, 10); err != nil || n != 1 {
+		t.Fatalf("second batch: %d, %v; want the other expired use", n, err)
+	}
+	if n, err := store.PurgeShareLinkUses(ctx, time.Hour, 10); err != nil || n != 0 {
+		t.Fatalf("nothing left to purge: %d, %v", n, err)
+	}
+
+	// Purged nonces can be consumed again; kept ones are still replays.
+	for nonce, expires := range seed {
+		ok, err := store.ConsumeShareLink(ctx, nonce, userID, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged := expires.Before(now.Add(-time.Hour)); ok != purged {
+			t.Errorf("%s: purged = %v, want %v", nonce, ok, purged)
+		}
+	}
+}
+
+func TestMemoryPurgeShareLinkUses(t *testing.T) {
+	testPurgeShareLinkUses(t, context.Background(), NewMemory(), 1)
+}
+
+func TestPurgeShareLinkUses(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	repo := New(scratchPool(t, ctx, nil))
+	u, err := repo.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testPurgeShareLinkUses(t, ctx, repo, u.ID)
+}
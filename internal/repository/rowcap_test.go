@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestCapLimit(t *testing.T) {
+	r := &Repository{maxRows: 10}
+	for limit, want := range map[int]int{0: 11, -1: 11, 1: 1, 10: 10, 11: 11, 500: 11} {
+		if got := r.capLimit(limit); got != want {
+			t.Errorf("capLimit(%d) = %d, want %d", limit, got, want)
+		}
+	}
+}
+
+func TestMemoryRowCap(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(WithMaxRows(3))
+	for i := range 5 {
+		if _, err := m.CreateUser(ctx, "U", fmt.Sprintf("u%d@example.com", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		limit, offset int
+		want          int
+		truncated     bool
+	}{
+		{0, 0, 3, true},
+		{10, 0, 3, true},
+		{3, 0, 3, false},
+		{2, 0, 2, false},
+		{0, 2, 3, false},
+		{0, 3, 2, false},
+	} {
+		users, truncated, err := m.GetUsers(ctx, UserFilter{}, nil, tc.limit, tc.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != tc.want || truncated != tc.truncated {
+			t.Errorf("limit %d offset %d: %d users truncated=%v, want %d truncated=%v",
+				tc.limit, tc.offset, len(users), truncated, tc.want, tc.truncated)
+		}
+	}
+
+	page, err := m.GetUsersAfter(ctx, UserFilter{}, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 3 {
+		t.Errorf("GetUsersAfter returned %d users past the cap of 3", len(page))
+	}
+}
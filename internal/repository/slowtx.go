@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+// snapshotTimeout bounds the pg_stat_activity query of a slow transaction,
+// which needs a second pooled connection and must not pile up behind the
+// very contention it is reporting.
+const snapshotTimeout = 2 * time.Second
+
+// maxBlockedSessions caps how many blocked sessions one snapshot records.
+const maxBlockedSessions = 20
+
+// WithSlowTx logs transactions running longer than warn. Once one has run
+// for snapshot, the sessions it is blocking are captured from pg_locks and
+// pg_stat_activity and attached to the log entry. Zero disables either.
+func WithSlowTx(warn, snapshot time.Duration) Option {
+	return func(r *Repository) {
+		r.slowTxWarn, r.slowTxSnapshot = warn, snapshot
+	}
+}
+
+// WithMetrics registers the repository's metrics on reg.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(r *Repository) {
+		r.slowTxTotal = reg.Counter("db_slow_transactions_total",
+			"Transactions that ran longer than the slow transaction threshold.", "operation")
+		r.truncatedTotal = reg.Counter("db_results_truncated_total",
+			"Multi-row reads cut short by the row cap, by query.", "query")
+	}
+}
+
+// BlockedSession is a backend that was waiting on a lock held by a slow
+// transaction when the snapshot was taken. Query has literals redacted.
+type BlockedSession struct {
+	PID         int32   `json:"pid"`
+	Application string  `json:"application_name"`
+	State       string  `json:"state"`
+	WaitEvent   string  `json:"wait_event,omitempty"`
+	LockType    string  `json:"lock_type,omitempty"`
+	LockMode    string  `json:"lock_mode,omitempty"`
+	Relation    string  `json:"relation,omitempty"`
+	WaitSeconds float64 `json:"wait_seconds"`
+	Query       string  `json:"query"`
+}
+
+// withTx runs fn in a transaction named op, committing when it returns
+// nil, and reports the transaction if it was slow.
+func (r *Repository) withTx(ctx context.Context, op string, fn func(tx pgx.Tx) error) error {
+	start := time.Now()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		blocked []BlockedSession
+		snapErr error
+		snapped chan struct{}
+	)
+	if r.slowTxSnapshot > 0 {
+		pid := tx.Conn().PgConn().PID()
+		snapped = make(chan struct{})
+		timer := time.AfterFunc(r.slowTxSnapshot, func() {
+			defer close(snapped)
+			blocked, snapErr = r.blockedBy(ctx, pid)
+		})
+		defer func() {
+			if timer.Stop() {
+				close(snapped)
+			}
+		}()
+	}
+
+	err = fn(tx)
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	elapsed := time.Since(start)
+
+	if r.slowTxWarn <= 0 || elapsed < r.slowTxWarn {
+		return err
+	}
+	if r.slowTxTotal != nil {
+		r.slowTxTotal.With(op).Inc()
+	}
+	ev := logger(ctx).Warn().Str("operation", op).Dur("duration", elapsed).Bool("committed", err == nil)
+	if snapped != nil && elapsed >= r.slowTxSnapshot {
+		<-snapped
+		if snapErr != nil {
+			ev = ev.AnErr("snapshot_error", snapErr)
+		} else {
+			ev = ev.Interface("blocked", blocked)
+		}
+	}
+	ev.Msg("slow transaction")
+	return err
+}
+
+// blockedBy lists the sessions waiting on locks held by backend pid.
+func (r *Repository) blockedBy(ctx context.Context, pid uint32) ([]BlockedSession, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), snapshotTimeout)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+		SELECT a.pid, COALESCE(a.application_name, ''), COALESCE(a.state, ''), COALESCE(a.wait_event, ''),
+		       COALESCE(l.locktype, ''), COALESCE(l.mode, ''), COALESCE(l.relation::regclass::text, ''),
+		       COALESCE(EXTRACT(EPOCH FROM now() - a.state_change), 0)::float8, COALESCE(a.query, '')
+		FROM pg_stat_activity a
+		LEFT JOIN pg_locks l ON l.pid = a.pid AND NOT l.granted
+		WHERE $1::int = ANY(pg_blocking_pids(a.pid))
+		ORDER BY a.state_change
+		LIMIT $2`, int64(pid), maxBlockedSessions)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (BlockedSession, error) {
+		var b BlockedSession
+		err := row.Scan(&b.PID, &b.Application, &b.State, &b.WaitEvent,
+			&b.LockType, &b.LockMode, &b.Relation, &b.WaitSeconds, &b.Query)
+		b.Query = RedactQuery(b.Query)
+		return b, err
+	})
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/requestctx"
+)
+
+// Two conflicting transactions: one holds a row lock past both
+// thresholds while the other waits for it. The detector reports the
+// holder with the waiter in its snapshot.
+func TestSlowTransactionSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx, map[string]string{"application_name": "slowtx-test"})
+
+	reg := metrics.NewRegistry()
+	repo := New(pool, WithSlowTx(50*time.Millisecond, 200*time.Millisecond), WithMetrics(reg))
+	u, err := repo.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	l := zerolog.New(&logs)
+	ctx = requestctx.SetLogger(ctx, &l)
+
+	waiterDone := make(chan error, 1)
+	err = repo.withTx(ctx, "hold_user", func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT 1 FROM users WHERE id=$1 FOR UPDATE", u.ID); err != nil {
+			return err
+		}
+		go func() {
+			_, err := pool.Exec(ctx, "UPDATE users SET name='Grace' WHERE id=$1", u.ID)
+			waiterDone <- err
+		}()
+		time.Sleep(500 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waiterDone; err != nil {
+		t.Fatalf("the waiting update failed: %v", err)
+	}
+
+	var entry struct {
+		Message   string
+		Operation string
+		Committed bool
+		Blocked   []BlockedSession
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("log %q: %v", logs.String(), err)
+	}
+	if entry.Message != "slow transaction" || entry.Operation != "hold_user" || !entry.Committed {
+		t.Errorf("log entry %+v", entry)
+	}
+	if len(entry.Blocked) != 1 {
+		t.Fatalf("blocked = %+v, want the waiting update", entry.Blocked)
+	}
+	b := entry.Blocked[0]
+	if b.Application != "slowtx-test" || b.WaitEvent == "" || !strings.HasPrefix(b.Query, "UPDATE users") {
+		t.Errorf("blocked session %+v", b)
+	}
+	if strings.Contains(b.Query, "Grace") {
+		t.Errorf("snapshot kept a literal: %s", b.Query)
+	}
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		reg.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+		return w.Body.String()
+	}
+	if out := scrape(); !strings.Contains(out, `db_slow_transactions_total{operation="hold_user"} 1`) {
+		t.Errorf("slow transaction not counted:\n%s", out)
+	}
+
+	// A fast transaction is neither logged nor counted.
+	logs.Reset()
+	if err := repo.withTx(ctx, "fast", func(pgx.Tx) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if logs.Len() != 0 || strings.Contains(scrape(), `operation="fast"`) {
+		t.Errorf("fast transaction reported: %s", logs.String())
+	}
+}
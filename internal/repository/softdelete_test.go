@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// softDeleteStore is the part of both stores soft delete is tested through.
+type softDeleteStore interface {
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	CountUsers(ctx context.Context, filter UserFilter) (int64, error)
+	DeleteUser(ctx context.Context, id, version int64) error
+	HardDeleteUser(ctx context.Context, id, version int64) error
+	RestoreUser(ctx context.Context, id int64) (*User, error)
+}
+
+// testSoftDelete checks that a soft-deleted user is hidden from reads
+// unless asked for, keeps its email reserved and comes back on restore,
+// while a hard delete frees the email for good.
+func testSoftDelete(t *testing.T, ctx context.Context, store softDeleteStore) {
+	t.Helper()
+	ada, err := store.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateUser(ctx, "Grace", "grace@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteUser(ctx, ada.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetUserByID(ctx, ada.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("GetUserByID of a soft-deleted user: %v", err)
+	}
+	if n, err := store.CountUsers(ctx, UserFilter{}); err != nil || n != 1 {
+		t.Errorf("CountUsers = %d, %v; want 1", n, err)
+	}
+	if n, err := store.CountUsers(ctx, UserFilter{IncludeDeleted: true}); err != nil || n != 2 {
+		t.Errorf("CountUsers including deleted = %d, %v; want 2", n, err)
+	}
+	if _, err := store.CreateUser(ctx, "Ada again", "ada@example.com", nil); !errors.Is(err, ErrEmailAlreadyExists) {
+		t.Errorf("reusing a soft-deleted user's email: %v", err)
+	}
+
+	u, err := store.RestoreUser(ctx, ada.ID)
+	if err != nil || u.DeletedAt != nil || u.Email != "ada@example.com" {
+		t.Fatalf("RestoreUser = %+v, %v", u, err)
+	}
+	if _, err := store.GetUserByID(ctx, ada.ID); err != nil {
+		t.Errorf("GetUserByID after restore: %v", err)
+	}
+	if _, err := store.RestoreUser(ctx, ada.ID+100); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("RestoreUser of a missing user: %v", err)
+	}
+
+	// A hard delete works on a soft-deleted user too.
+	if err := store.DeleteUser(ctx, ada.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.HardDeleteUser(ctx, ada.ID, 0); err != nil {
+		t.Fatalf("HardDeleteUser of a soft-deleted user: %v", err)
+	}
+	if n, _ := store.CountUsers(ctx, UserFilter{IncludeDeleted: true}); n != 1 {
+		t.Errorf("%d users including deleted after the hard delete, want 1", n)
+	}
+	if _, err := store.RestoreUser(ctx, ada.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("RestoreUser after the hard delete: %v", err)
+	}
+	if _, err := store.CreateUser(ctx, "Ada again", "ada@example.com", nil); err != nil {
+		t.Errorf("email of a hard-deleted user: %v", err)
+	}
+}
+
+func TestMemorySoftDelete(t *testing.T) {
+	testSoftDelete(t, context.Background(), NewMemory())
+}
+
+func TestSoftDelete(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testSoftDelete(t, ctx, New(scratchPool(t, ctx, nil)))
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// collationNames differ in order between bytewise, German and Swedish
+// comparison: German files Ä and Ö with A and O, Swedish puts Å, Ä, Ö
+// after Z, and bytes put Ä before Å.
+var collationNames = []string{"Zoe", "Örjan", "Åsa", "Olof", "Ärger", "Anna"}
+
+func TestCollatedSort(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	for i, name := range collationNames {
+		if _, err := m.CreateUser(ctx, name, fmt.Sprintf("u%d@example.com", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	byName, err := ParseSort("name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for collation, want := range map[string][]string{
+		"":      {"Anna", "Olof", "Zoe", "Ärger", "Åsa", "Örjan"},
+		"de-DE": {"Anna", "Ärger", "Åsa", "Olof", "Örjan", "Zoe"},
+		"sv-SE": {"Anna", "Olof", "Zoe", "Åsa", "Ärger", "Örjan"},
+	} {
+		sort := byName
+		if collation != "" {
+			if sort, err = byName.WithCollation(collation); err != nil {
+				t.Fatal(err)
+			}
+		}
+		users, _, err := m.GetUsers(ctx, UserFilter{}, sort, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, u := range users {
+			got = append(got, u.Name)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("collation %q: %v, want %v", collation, got, want)
+		}
+	}
+}
+
+func TestWithCollation(t *testing.T) {
+	sort, _ := ParseSort("-name,id")
+	collated, err := sort.WithCollation("sv-SE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collated.orderBy(), ` ORDER BY name COLLATE "sv-SE" DESC, id`; got != want {
+		t.Errorf("orderBy = %q, want %q", got, want)
+	}
+	if sort.Collation() != "" || collated.Collation() != "sv-SE" {
+		t.Errorf("WithCollation changed its receiver or lost the collation")
+	}
+
+	var unknown *UnknownCollationError
+	if _, err := sort.WithCollation("tlh"); !errors.As(err, &unknown) || !strings.Contains(err.Error(), "de-DE, en-US, fr-FR, sv-SE") {
+		t.Errorf("unknown collation: %v", err)
+	}
+	byID, _ := ParseSort("id")
+	if _, err := byID.WithCollation("de-DE"); err == nil {
+		t.Error("a collation on an id sort was accepted")
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Sort
+		orderBy string
+		err     string
+	}{
+		{"", nil, " ORDER BY id", ""},
+		{"name", Sort{{Field: "name"}}, " ORDER BY name, id", ""},
+		{"-name", Sort{{Field: "name", Desc: true}}, " ORDER BY name DESC, id", ""},
+		{"email,-id", Sort{{Field: "email"}, {Field: "id", Desc: true}}, " ORDER BY email, id DESC", ""},
+		{"-id", Sort{{Field: "id", Desc: true}}, " ORDER BY id DESC", ""},
+		{"password", nil, "", `cannot sort by "password"; sortable fields are email, id, name`},
+		{"name;DROP TABLE users", nil, "", "cannot sort by"},
+		{"Name", nil, "", "cannot sort by"},
+		{"name,", nil, "", `cannot sort by ""`},
+		{"--name", nil, "", `cannot sort by "-name"`},
+		{"name,-name", nil, "", `"name" is listed twice`},
+	}
+	for _, tt := range tests {
+		got, err := ParseSort(tt.spec)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseSort(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseSort(%q) = %v, %v; want %v", tt.spec, got, err, tt.want)
+			continue
+		}
+		if got.orderBy() != tt.orderBy || got.String() != tt.spec {
+			t.Errorf("ParseSort(%q): orderBy %q, String %q", tt.spec, got.orderBy(), got.String())
+		}
+	}
+	var unknown *UnknownSortFieldError
+	if _, err := ParseSort("age"); !errors.As(err, &unknown) || unknown.Field != "age" {
+		t.Errorf("unknown field error %v", err)
+	}
+}
+
+// Ties on the sort fields fall back to id in the order they are listed.
+func TestMemorySortTies(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	for _, u := range [][2]string{{"Bo", "b@example.com"}, {"Ada", "z@example.com"}, {"Bo", "a@example.com"}, {"Ada", "y@example.com"}} {
+		if _, err := m.CreateUser(ctx, u[0], u[1], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for spec, want := range map[string][]int64{
+		"":            {1, 2, 3, 4},
+		"-id":         {4, 3, 2, 1},
+		"name":        {2, 4, 1, 3},
+		"-name":       {1, 3, 2, 4},
+		"name,-email": {2, 4, 1, 3},
+		"name,email":  {4, 2, 3, 1},
+		"-name,-id":   {3, 1, 4, 2},
+	} {
+		sort, err := ParseSort(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, _, err := m.GetUsers(ctx, UserFilter{}, sort, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int64
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("sort=%s: %v, want %v", spec, ids, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// UserRepository is everything the HTTP layer needs from storage. The
+// pgx-backed Repository is the production implementation; Memory keeps
+// the same error semantics without a database.
+type UserRepository interface {
+	Ping(ctx context.Context) error
+	PoolStats() (acquired, max int32)
+	ReadOnly(ctx context.Context) (bool, error)
+	Now(ctx context.Context) (time.Time, error)
+	TableStats(ctx context.Context) ([]TableStat, error)
+
+	GetUsers(ctx context.Context, filter UserFilter, sort Sort, limit, offset int) (users []User, truncated bool, err error)
+	GetUsersAfter(ctx context.Context, filter UserFilter, afterID int64, limit int) ([]User, error)
+	CountUsers(ctx context.Context, filter UserFilter) (int64, error)
+	EachUser(ctx context.Context, filter UserFilter, sort Sort, batch BatchSize, fn func(User) error) error
+	GetUserByID(ctx context.Context, id int64) (*User, error)
+	UserExists(ctx context.Context, id int64) (bool, error)
+
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	CreateUsers(ctx context.Context, users []NewUser, batch BatchSize) ([]int64, error)
+	UpdateUser(ctx context.Context, id, version int64, name, email string, metadata map[string]any) (*User, error)
+	PatchUser(ctx context.Context, id, version int64, name, email *string) (*User, error)
+	DeleteUser(ctx context.Context, id, version int64) error
+	HardDeleteUser(ctx context.Context, id, version int64) error
+	RestoreUser(ctx context.Context, id int64) (*User, error)
+	PlanDeleteUser(ctx context.Context, id, version int64, hard bool) (*DeletePlan, error)
+	PatchMetadata(ctx context.Context, id, version int64, set map[string]any, del []string, check func(map[string]any) error) (map[string]any, error)
+
+	ReplaceLabels(ctx context.Context, id, version int64, labels map[string]string) (map[string]string, error)
+	PatchLabels(ctx context.Context, id, version int64, set map[string]string, del []string, check func(map[string]string) error) (map[string]string, error)
+	DeleteLabel(ctx context.Context, id, version int64, key string) error
+
+	IncrementViews(ctx context.Context, id, n int64) (int64, error)
+	GetViews(ctx context.Context, id int64) (int64, error)
+	ConsumeShareLink(ctx context.Context, nonce string, userID int64, expiresAt time.Time) (bool, error)
+	PurgeShareLinkUses(ctx context.Context, expiredFor time.Duration, limit int) (int64, error)
+	SyncRateLimits(ctx context.Context, buckets map[string]time.Time, now time.Time) (map[string]time.Time, error)
+}
+
+// BatchSize returns how many rows a bulk operation handles next. It is
+// asked again before every batch, so the caller can adapt the size while
+// the operation runs; a nil BatchSize handles all rows in one batch.
+type BatchSize func() int
+
+// next is the size of the next batch of at most remaining rows.
+func (b BatchSize) next(remaining int) int {
+	if b == nil {
+		return remaining
+	}
+	return max(1, min(b(), remaining))
+}
+
+// GrowthTables are the tables TableStats reports on: every table the
+// migrations create.
+var GrowthTables = []string{"users", "user_views", "share_link_uses", "user_labels", "rate_limit_buckets"}
+
+// TableStat is the size of one table.
+type TableStat struct {
+	Table string
+	Rows  int64
+	Bytes int64 // on disk, indexes and TOAST included
+}
+
+var (
+	_ UserRepository = (*Repository)(nil)
+	_ UserRepository = (*Memory)(nil)
+)
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// The users trigger bumps updated_at on every UPDATE, including ones
+// issued outside the repository, and leaves created_at alone.
+func TestUpdatedAtTrigger(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	pool := scratchPool(t, ctx, nil)
+	repo := New(pool)
+
+	u, err := repo.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.CreatedAt.IsZero() || !u.UpdatedAt.Equal(u.CreatedAt) {
+		t.Fatalf("created: created_at %v, updated_at %v", u.CreatedAt, u.UpdatedAt)
+	}
+
+	updated, err := repo.UpdateUser(ctx, u.ID, 0, "Ada L", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.CreatedAt.Equal(u.CreatedAt) || !updated.UpdatedAt.After(u.UpdatedAt) {
+		t.Errorf("UpdateUser: created_at %v, updated_at %v (was %v)", updated.CreatedAt, updated.UpdatedAt, u.UpdatedAt)
+	}
+
+	if _, err := pool.Exec(ctx, "UPDATE users SET name = 'Ada K' WHERE id = $1", u.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.CreatedAt.Equal(u.CreatedAt) || !got.UpdatedAt.After(updated.UpdatedAt) {
+		t.Errorf("plain UPDATE: created_at %v, updated_at %v (was %v)", got.CreatedAt, got.UpdatedAt, updated.UpdatedAt)
+	}
+}
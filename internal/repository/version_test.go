@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// versionStore is the part of both stores versions are tested through.
+type versionStore interface {
+	CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*User, error)
+	UpdateUser(ctx context.Context, id, version int64, name, email string, metadata map[string]any) (*User, error)
+	PatchUser(ctx context.Context, id, version int64, name, email *string) (*User, error)
+	DeleteUser(ctx context.Context, id, version int64) error
+}
+
+// testVersions checks that every write bumps the version and that a
+// stale one is a conflict, not a missing user, while zero skips the check.
+func testVersions(t *testing.T, ctx context.Context, store versionStore) {
+	t.Helper()
+	u, err := store.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil || u.Version != 1 {
+		t.Fatalf("CreateUser = %+v, %v", u, err)
+	}
+	if u, err = store.UpdateUser(ctx, u.ID, 1, "Ada L", "ada@example.com", nil); err != nil || u.Version != 2 {
+		t.Fatalf("UpdateUser at the current version = %+v, %v", u, err)
+	}
+	if _, err := store.UpdateUser(ctx, u.ID, 1, "Ada K", "ada@example.com", nil); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("UpdateUser at a stale version: %v", err)
+	}
+	name := "Ada K"
+	if u, err = store.PatchUser(ctx, u.ID, 2, &name, nil); err != nil || u.Version != 3 || u.Name != name {
+		t.Fatalf("PatchUser at the current version = %+v, %v", u, err)
+	}
+	if _, err := store.PatchUser(ctx, u.ID, 2, &name, nil); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("PatchUser at a stale version: %v", err)
+	}
+	if u, err = store.UpdateUser(ctx, u.ID, 0, "Ada", "ada@example.com", nil); err != nil || u.Version != 4 {
+		t.Fatalf("unconditional UpdateUser = %+v, %v", u, err)
+	}
+
+	if err := store.DeleteUser(ctx, u.ID, 3); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("DeleteUser at a stale version: %v", err)
+	}
+	if err := store.DeleteUser(ctx, u.ID+100, 1); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("DeleteUser of a missing user: %v", err)
+	}
+	if err := store.DeleteUser(ctx, u.ID, 4); err != nil {
+		t.Errorf("DeleteUser at the current version: %v", err)
+	}
+	// Once deleted the user is missing, whatever the version.
+	if _, err := store.UpdateUser(ctx, u.ID, 5, "Ada", "ada@example.com", nil); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("UpdateUser of a deleted user: %v", err)
+	}
+}
+
+func TestMemoryVersions(t *testing.T) {
+	testVersions(t, context.Background(), NewMemory())
+}
+
+func TestVersions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	testVersions(t, ctx, New(scratchPool(t, ctx, nil)))
+}
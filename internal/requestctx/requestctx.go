@@ -0,0 +1,80 @@
+// Package requestctx stores request-scoped values on a context.Context
+// under unexported, typed keys.
+//
+// It deliberately avoids gin so the repository and background workers can
+// read the same values. Getters never panic: a missing or mistyped value
+// yields the zero value and ok=false.
+package requestctx
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+type key int
+
+const (
+	requestIDKey key = iota
+	actorKey
+	tenantKey
+	consumerKey
+	loggerKey
+)
+
+func get[T any](ctx context.Context, k key) (T, bool) {
+	v, ok := ctx.Value(k).(T)
+	return v, ok
+}
+
+// SetRequestID returns a copy of ctx carrying the request ID.
+func SetRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID stored by SetRequestID.
+func RequestID(ctx context.Context) (string, bool) {
+	return get[string](ctx, requestIDKey)
+}
+
+// SetActor returns a copy of ctx carrying the authenticated actor (e.g. a JWT subject).
+func SetActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor returns the actor stored by SetActor.
+func Actor(ctx context.Context) (string, bool) {
+	return get[string](ctx, actorKey)
+}
+
+// SetTenant returns a copy of ctx carrying the tenant identifier.
+func SetTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// Tenant returns the tenant stored by SetTenant.
+func Tenant(ctx context.Context) (string, bool) {
+	return get[string](ctx, tenantKey)
+}
+
+// SetConsumer returns a copy of ctx carrying the calling consumer's name
+// (e.g. the name of a matched API key).
+func SetConsumer(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, consumerKey, name)
+}
+
+// Consumer returns the consumer name stored by SetConsumer.
+func Consumer(ctx context.Context) (string, bool) {
+	return get[string](ctx, consumerKey)
+}
+
+// SetLogger returns a copy of ctx carrying a request-scoped logger.
+func SetLogger(ctx context.Context, l *zerolog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// Logger returns the logger stored by SetLogger.
+func Logger(ctx context.Context) (*zerolog.Logger, bool) {
+	l, ok := get[*zerolog.Logger](ctx, loggerKey)
+	return l, ok && l != nil
+}
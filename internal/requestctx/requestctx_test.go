@@ -0,0 +1,45 @@
+package requestctx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestRoundTrip(t *testing.T) {
+	l := zerolog.Nop()
+	ctx := SetRequestID(context.Background(), "req-1")
+	ctx = SetActor(ctx, "alice")
+	ctx = SetTenant(ctx, "acme")
+	ctx = SetConsumer(ctx, "billing")
+	ctx = SetLogger(ctx, &l)
+
+	for name, got := range map[string]func(context.Context) (string, bool){
+		"req-1": RequestID, "alice": Actor, "acme": Tenant, "billing": Consumer,
+	} {
+		if v, ok := got(ctx); !ok || v != name {
+			t.Errorf("got %q, %v, want %q", v, ok, name)
+		}
+	}
+	if got, ok := Logger(ctx); !ok || got != &l {
+		t.Errorf("Logger = %p, %v", got, ok)
+	}
+}
+
+// Missing values, values stored under look-alike keys and a nil logger
+// come back as zero values rather than panicking.
+func TestMissingValues(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "actor", "mallory")
+	ctx = SetLogger(ctx, nil)
+
+	if v, ok := Actor(ctx); ok || v != "" {
+		t.Errorf("Actor = %q, %v", v, ok)
+	}
+	if v, ok := RequestID(ctx); ok || v != "" {
+		t.Errorf("RequestID = %q, %v", v, ok)
+	}
+	if l, ok := Logger(ctx); ok || l != nil {
+		t.Errorf("Logger = %v, %v", l, ok)
+	}
+}
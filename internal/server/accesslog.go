@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// accessLog writes one zerolog event per request: Info below 400 and for
+// client aborts (499), Warn for other 4xx and Error for 5xx. Scrapes of scrapePath are never logged; successful
+// probes the probe log does not sample are dropped, or logged at Debug with
+// ProbeLogDebug.
+func (s *Server) accessLog(scrapePath string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			path := r.URL.Path
+			query := r.URL.RawQuery
+
+			next.ServeHTTP(w, r)
+
+			ex := exchangeOf(r)
+			if ex.route == scrapePath {
+				return
+			}
+			status := ex.w.status
+			var level zerolog.Level
+			switch {
+			case s.probes.skipAccessLog(ex):
+				if !s.cfg.ProbeLogDebug {
+					return
+				}
+				level = zerolog.DebugLevel
+			case status >= http.StatusInternalServerError:
+				level = zerolog.ErrorLevel
+			case status >= http.StatusBadRequest && status != statusClientClosedRequest:
+				level = zerolog.WarnLevel
+			default:
+				level = zerolog.InfoLevel
+			}
+
+			ev := s.reqLog(r).WithLevel(level)
+			if !ev.Enabled() {
+				return
+			}
+			if query != "" {
+				path += "?" + query
+			}
+			ev.Str("method", r.Method).
+				Str("path", path).
+				Int("status", status).
+				Dur("latency", time.Since(start)).
+				Int("bytes", ex.w.size).
+				Str("client_ip", s.clientIP(r)).
+				Str("user_agent", r.UserAgent())
+			if errs := ex.errorsText(); errs != "" {
+				ev.Str("errors", errs)
+			}
+			ev.Msg("request")
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// captureLogs sends s's log to a buffer at level for the rest of the test.
+func captureLogs(t *testing.T, s *Server, level zerolog.Level) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	s.log = zerolog.New(&buf)
+	prev := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(level)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(prev) })
+	return &buf
+}
+
+// accessEntries returns the access log events in buf.
+func accessEntries(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	sc := bufio.NewScanner(bytes.NewReader(buf.Bytes()))
+	for sc.Scan() {
+		var e map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("log line %q: %v", sc.Text(), err)
+		}
+		if e["message"] == "request" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestAccessLog(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+	logs := captureLogs(t, s, zerolog.InfoLevel)
+
+	serve(h, http.MethodGet, "/api/v1/users/1?fields=name", "", "User-Agent", "probe-test/1.0")
+	serve(h, http.MethodGet, "/api/v1/users/99", "")
+	serve(h, http.MethodGet, "/healthz", "")
+	serve(h, http.MethodGet, "/readyz", "")
+
+	entries := accessEntries(t, logs)
+	if len(entries) != 2 {
+		t.Fatalf("%d access log entries, want 2 (probes skipped):\n%s", len(entries), logs)
+	}
+	ok, missing := entries[0], entries[1]
+	for field, want := range map[string]any{
+		"level":      "info",
+		"method":     "GET",
+		"path":       "/api/v1/users/1?fields=name",
+		"status":     float64(200),
+		"user_agent": "probe-test/1.0",
+		"client_ip":  "192.0.2.1",
+	} {
+		if ok[field] != want {
+			t.Errorf("%s = %#v, want %#v", field, ok[field], want)
+		}
+	}
+	for _, field := range []string{"latency", "bytes", "request_id"} {
+		if _, found := ok[field]; !found {
+			t.Errorf("entry lacks %s: %v", field, ok)
+		}
+	}
+	if b, _ := ok["bytes"].(float64); b <= 0 {
+		t.Errorf("bytes = %v", ok["bytes"])
+	}
+	if missing["level"] != "warn" || missing["status"] != float64(404) {
+		t.Errorf("404 entry %v, want level warn", missing)
+	}
+}
+
+func TestAccessLogServerError(t *testing.T) {
+	s, err := New(Config{}, WithRepository(failingRepo{repository.NewMemory(), errors.New("connection refused")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t, s, zerolog.InfoLevel)
+	serve(s.Handler(), http.MethodGet, "/api/v1/users/1", "")
+	entries := accessEntries(t, logs)
+	if len(entries) != 1 || entries[0]["level"] != "error" || entries[0]["status"] != float64(500) {
+		t.Errorf("entries %v, want one at level error", entries)
+	}
+}
+
+func TestAccessLogProbes(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cfg     Config
+		level   zerolog.Level
+		entries int
+		want    string
+	}{
+		{"skipped", Config{}, zerolog.DebugLevel, 0, ""},
+		{"at debug", Config{ProbeLogDebug: true}, zerolog.DebugLevel, 4, "debug"},
+		{"debug filtered out", Config{ProbeLogDebug: true}, zerolog.InfoLevel, 0, ""},
+		{"sampled", Config{ProbeLogSample: 2}, zerolog.InfoLevel, 2, "info"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t, tt.cfg)
+			h := s.Handler()
+			logs := captureLogs(t, s, tt.level)
+			for range 4 {
+				serve(h, http.MethodGet, "/healthz", "")
+			}
+			entries := accessEntries(t, logs)
+			if len(entries) != tt.entries {
+				t.Fatalf("%d entries, want %d:\n%s", len(entries), tt.entries, logs)
+			}
+			for _, e := range entries {
+				if e["level"] != tt.want {
+					t.Errorf("probe logged at %v, want %s", e["level"], tt.want)
+				}
+			}
+		})
+	}
+}
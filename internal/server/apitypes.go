@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// Request and response bodies of the user API. Handlers bind and answer
+// with these types and the OpenAPI document is generated from them, so
+// the two cannot disagree; see openapi.go.
+
+// userRequest is the body of POST /users and PUT /users/:id. Metadata is
+// decoded by decodeMetadata, which tells null from absent.
+type userRequest struct {
+	Name     string          `json:"name" binding:"required"`
+	Email    string          `json:"email" binding:"required,email"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// patchUserRequest is the body of PATCH /users/:id; absent fields are
+// left alone.
+type patchUserRequest struct {
+	Name  *string `json:"name"`
+	Email *string `json:"email" binding:"omitempty,email"`
+}
+
+// shareLinkRequest is the body of POST /admin/users/:id/share-links; an
+// empty body takes every default.
+type shareLinkRequest struct {
+	ExpiresIn string   `json:"expires_in"`
+	Fields    []string `json:"fields"`
+	OneTime   bool     `json:"one_time"`
+}
+
+type readOnlyRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+	// Until is when a maintenance window is expected to end. It only sets
+	// the Retry-After of rejected writes; the mode stays on until switched
+	// off.
+	Until *time.Time `json:"until"`
+}
+
+// errorResponse is the shape of every error, see apiError.
+type errorResponse struct {
+	Error   string       `json:"error"`
+	Code    string       `json:"code"`
+	Details []fieldError `json:"details,omitempty"`
+	// RetryAfterMs is the Retry-After of 429 and retryable 503 errors in
+	// milliseconds.
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+type userPage struct {
+	Users      []repository.User `json:"users"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+type userUpdated struct {
+	// Updated predates the user echo; clients still read it.
+	Updated bool             `json:"updated"`
+	User    *repository.User `json:"user"`
+}
+
+type userDeleted struct {
+	Deleted bool `json:"deleted"`
+}
+
+type deletePlanned struct {
+	DryRun bool                   `json:"dry_run"`
+	Plan   *repository.DeletePlan `json:"plan"`
+}
+
+type usersCreated struct {
+	IDs []int64 `json:"ids"`
+}
+
+type viewCount struct {
+	Views int64 `json:"views"`
+}
+
+type viewQueued struct {
+	Queued bool `json:"queued"`
+}
+
+type userMetadata struct {
+	Metadata map[string]any `json:"metadata"`
+}
+
+type userLabels struct {
+	Labels map[string]string `json:"labels"`
+}
+
+type userDiff struct {
+	ID      int64       `json:"id"`
+	Against int64       `json:"against"`
+	Fields  []fieldDiff `json:"fields"`
+}
+
+type shareLink struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Fields    []string  `json:"fields"`
+	OneTime   bool      `json:"one_time"`
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-k8s-demo/internal/auth"
+	"go-k8s-demo/internal/requestctx"
+	"go-k8s-demo/internal/timing"
+)
+
+// apiKeyHeader carries API keys of service-to-service callers.
+const apiKeyHeader = "X-API-Key"
+
+// authRealm names the protection space in WWW-Authenticate challenges.
+const authRealm = "users"
+
+// authRetryAfter is the Retry-After of auth_unavailable. Until keys have
+// been loaded once every request tries again, so a retry succeeds as soon
+// as the identity provider or key file is back.
+const authRetryAfter = time.Second
+
+// authenticate requires a valid bearer token or API key on Auth routes;
+// either grants access. The token subject becomes the request's actor
+// (recorded by the journal), the key name its consumer, and both are
+// added to the request logger, so the access log line carries them.
+// Without a verifier or keys the routes are open; New warns about that
+// at startup.
+func (s *Server) authenticate() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r, ok := s.checkCredentials(w, r); ok {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// checkCredentials is the check of authenticate, for open routes that
+// require credentials only for some requests. It returns the request
+// carrying the caller, or answers the error and reports false when the
+// request presents no valid credentials.
+func (s *Server) checkCredentials(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if s.auth == nil && s.apiKeys == nil {
+		return r, true
+	}
+
+	// Only the credential check is timed, not the rest of the chain.
+	start := time.Now()
+	presented := r.Header.Get(apiKeyHeader)
+	if presented != "" && s.apiKeys != nil {
+		name, ok := s.apiKeys.Match(presented)
+		timing.Since(r.Context(), "auth", start)
+		if ok {
+			l := s.reqLog(r).With().Str("api_key", name).Logger()
+			return withLogger(r.WithContext(requestctx.SetConsumer(r.Context(), name)), &l), true
+		}
+	}
+
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	bearer := strings.EqualFold(scheme, "Bearer") && token != ""
+	if !bearer || s.auth == nil {
+		// RFC 6750: a request without a token gets a bare challenge.
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`"`)
+		if presented != "" {
+			respondError(w, r, codeInvalidAPIKey, "the API key is not valid")
+			return nil, false
+		}
+		respondError(w, r, codeUnauthorized, "a bearer token or API key is required")
+		return nil, false
+	}
+
+	claims, err := s.auth.Verify(token, time.Now())
+	timing.Since(r.Context(), "auth", start)
+	if errors.Is(err, auth.ErrKeysUnavailable) {
+		s.reqLog(r).Error().Err(err).Msg("failed to fetch JWT signing keys")
+		respondRetry(w, r, codeAuthUnavailable, "token signing keys are unavailable", authRetryAfter)
+		return nil, false
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+authRealm+`", error="invalid_token", error_description="`+err.Error()+`"`)
+		respondError(w, r, codeUnauthorized, err.Error())
+		return nil, false
+	}
+
+	l := s.reqLog(r).With().Str("subject", claims.Subject).Logger()
+	return withLogger(r.WithContext(requestctx.SetActor(r.Context(), claims.Subject)), &l), true
+}
+
+// authKeys serves GET /admin/auth/keys: the ids, algorithms and end of
+// use of the token verification keys, never the keys themselves.
+func (s *Server) authKeys(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		respondError(w, r, codeNotFound, "bearer token authentication is not configured")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, object{"keys": s.auth.Keys(time.Now())})
+}
+
+// reloadAuthKeys serves POST /admin/auth/keys/reload, the same as SIGHUP
+// for the token keys, and answers with the keys now in use.
+func (s *Server) reloadAuthKeys(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		respondError(w, r, codeAuthKeysStatic, "bearer token authentication is not configured")
+		return
+	}
+	err := s.auth.Reload()
+	if errors.Is(err, auth.ErrStaticKey) {
+		respondError(w, r, codeAuthKeysStatic, err.Error())
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to reload JWT keys")
+		respondRetry(w, r, codeAuthUnavailable, err.Error(), authRetryAfter)
+		return
+	}
+	s.reqLog(r).Info().Int("keys", len(s.auth.Keys(time.Now()))).Msg("JWT keys reloaded")
+	writeJSON(w, r, http.StatusOK, object{"keys": s.auth.Keys(time.Now())})
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const testJWTSecret = "test-secret"
+
+func hs256(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	return hs256With(t, testJWTSecret, "", claims)
+}
+
+func hs256With(t *testing.T, secret, kid string, claims map[string]any) string {
+	t.Helper()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT", "kid": kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(signed))
+	return signed + "." + enc.EncodeToString(m.Sum(nil))
+}
+
+func TestWritesRequireAToken(t *testing.T) {
+	s, _ := newTestServer(t, Config{JWTSecret: testJWTSecret, JWTAudience: "users-api", APIKeys: testAPIKeys})
+	h := s.Handler()
+	body := `{"name":"Ada","email":"ada@example.com"}`
+	now := time.Now()
+	valid := hs256(t, map[string]any{"sub": "alice", "aud": "users-api", "exp": now.Add(time.Hour).Unix()})
+
+	w := serve(h, http.MethodPost, "/api/v1/users", body)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Bearer realm="users"` {
+		t.Errorf("no token: %d %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+	for name, token := range map[string]string{
+		"expired":        hs256(t, map[string]any{"sub": "alice", "aud": "users-api", "exp": now.Add(-time.Hour).Unix()}),
+		"wrong audience": hs256(t, map[string]any{"sub": "alice", "aud": "billing", "exp": now.Add(time.Hour).Unix()}),
+		"tampered":       valid[:len(valid)-2] + "xx",
+	} {
+		w := serve(h, http.MethodPost, "/api/v1/users", body, "Authorization", "Bearer "+token)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="invalid_token"`) {
+			t.Errorf("%s: %d %q", name, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users", body, apiKeyHeader, "nope"); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown API key: %d", w.Code)
+	}
+
+	// Reads and probes stay open.
+	for _, path := range []string{"/api/v1/users", "/healthz"} {
+		if w := serve(h, http.MethodGet, path, ""); w.Code != http.StatusOK {
+			t.Errorf("GET %s without a token: %d", path, w.Code)
+		}
+	}
+
+	if w := serve(h, http.MethodPost, "/api/v1/users", body, "Authorization", "Bearer "+valid); w.Code != http.StatusCreated {
+		t.Errorf("valid token: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1", `{"name":"Ada L"}`, apiKeyHeader, aliceKey); w.Code != http.StatusOK {
+		t.Errorf("valid API key: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestReloadAuthKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	writeKeys := func(keys string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(`{"keys":[`+keys+`]}`), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	enc := base64.RawURLEncoding
+	oldKey := `{"kty":"oct","kid":"old","alg":"HS256","k":"` + enc.EncodeToString([]byte("old-secret")) + `"}`
+	newKey := `{"kty":"oct","kid":"new","alg":"HS256","k":"` + enc.EncodeToString([]byte("new-secret")) + `"}`
+	writeKeys(oldKey)
+	s, _ := newTestServer(t, Config{JWTKeysFile: path, JWTKeyGrace: time.Hour})
+	h := s.Handler()
+	exp := time.Now().Add(time.Hour).Unix()
+	oldToken := "Bearer " + hs256With(t, "old-secret", "old", map[string]any{"sub": "ops", "exp": exp})
+	newToken := "Bearer " + hs256With(t, "new-secret", "new", map[string]any{"sub": "ops", "exp": exp})
+
+	if w := serve(h, http.MethodPost, "/api/v1/admin/auth/keys/reload", "", "Authorization", newToken); w.Code != http.StatusUnauthorized {
+		t.Fatalf("new key before the reload: %d", w.Code)
+	}
+	writeKeys(newKey)
+	w := serve(h, http.MethodPost, "/api/v1/admin/auth/keys/reload", "", "Authorization", oldToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("reload: %d %s", w.Code, w.Body)
+	}
+	var listed struct {
+		Keys []struct {
+			Kid      string  `json:"kid"`
+			Retired  bool    `json:"retired"`
+			NotAfter *string `json:"not_after"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Keys) != 2 || listed.Keys[0].Kid != "new" || listed.Keys[1].Kid != "old" || !listed.Keys[1].Retired || listed.Keys[1].NotAfter == nil {
+		t.Errorf("keys after reload: %s", w.Body)
+	}
+
+	// Tokens from before the rotation keep working through the grace window.
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		w := serve(h, http.MethodGet, "/api/v1/admin/auth/keys", "", "Authorization", token)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s token after the reload: %d", name, w.Code)
+		}
+		if strings.Contains(w.Body.String(), `"k"`) || strings.Contains(w.Body.String(), enc.EncodeToString([]byte("new-secret"))) {
+			t.Errorf("listing leaks key material: %s", w.Body)
+		}
+	}
+
+	// A broken file keeps the keys that work.
+	writeKeys(`{"kty":"oct"`)
+	if w := serve(h, http.MethodPost, "/api/v1/admin/auth/keys/reload", "", "Authorization", newToken); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("reloading a broken file: %d, want 503", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/admin/auth/keys", "", "Authorization", newToken); w.Code != http.StatusOK {
+		t.Errorf("after a failed reload: %d", w.Code)
+	}
+}
+
+func TestReloadAuthKeysWithSecret(t *testing.T) {
+	s, _ := newTestServer(t, Config{JWTSecret: testJWTSecret})
+	token := "Bearer " + hs256(t, map[string]any{"sub": "ops", "exp": time.Now().Add(time.Hour).Unix()})
+	if w := serve(s.Handler(), http.MethodPost, "/api/v1/admin/auth/keys/reload", "", "Authorization", token); w.Code != http.StatusConflict {
+		t.Errorf("reload with a fixed secret: %d, want 409", w.Code)
+	}
+	if err := s.ReloadJWTKeys(); err != nil {
+		t.Errorf("SIGHUP reload with a fixed secret: %v", err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-keys")
+	if err := os.WriteFile(path, []byte("# rotated by the batch team\n"+testAPIKeys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t, Config{JWTSecret: testJWTSecret, APIKeysFile: path})
+	logs := captureLogs(t, s, zerolog.InfoLevel)
+	h := s.Handler()
+	body := `{"name":"Ada","email":"ada@example.com"}`
+	token := "Bearer " + hs256(t, map[string]any{"sub": "ops", "exp": time.Now().Add(time.Hour).Unix()})
+
+	if w := serve(h, http.MethodPost, "/api/v1/users", body, apiKeyHeader, "wrong"); w.Code != http.StatusUnauthorized ||
+		!strings.Contains(w.Body.String(), codeInvalidAPIKey.Code) {
+		t.Errorf("unknown key: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users", body); !strings.Contains(w.Body.String(), codeUnauthorized.Code) {
+		t.Errorf("no credential: %d %s", w.Code, w.Body)
+	}
+	// Either credential grants access: a valid token makes up for a stale key.
+	if w := serve(h, http.MethodPost, "/api/v1/users", body, apiKeyHeader, "wrong", "Authorization", token); w.Code != http.StatusCreated {
+		t.Errorf("stale key with a valid token: %d %s", w.Code, w.Body)
+	}
+	logs.Reset()
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1", `{"name":"Ada L"}`, apiKeyHeader, aliceKey); w.Code != http.StatusOK {
+		t.Fatalf("valid key: %d %s", w.Code, w.Body)
+	}
+	entries := accessEntries(t, logs)
+	if len(entries) != 1 || entries[0]["api_key"] != "alice" {
+		t.Errorf("access log %v, want api_key alice", entries)
+	}
+	if strings.Contains(logs.String(), aliceKey) {
+		t.Errorf("the key itself was logged: %s", logs)
+	}
+
+	// A rotation takes effect on ReloadAPIKeys, as on SIGHUP.
+	if err := os.WriteFile(path, []byte(strings.Split(testAPIKeys, ",")[1]), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReloadAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1", `{"name":"Ada"}`, apiKeyHeader, aliceKey); w.Code != http.StatusUnauthorized {
+		t.Errorf("rotated-out key: %d", w.Code)
+	}
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1", `{"name":"Ada"}`, apiKeyHeader, bobKey); w.Code != http.StatusOK {
+		t.Errorf("rotated-in key: %d %s", w.Code, w.Body)
+	}
+	os.WriteFile(path, []byte("bob:short"), 0o600)
+	if err := s.ReloadAPIKeys(); err == nil {
+		t.Error("broken key file accepted")
+	}
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1", `{"name":"Ada"}`, apiKeyHeader, bobKey); w.Code != http.StatusOK {
+		t.Errorf("after a failed reload: %d", w.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// cleanPrefix turns "api/users-service/" or "/api//users-service" into
+// "/api/users-service". The root prefix is the empty string.
+func cleanPrefix(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" || p == "/" {
+		return ""
+	}
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return ""
+	}
+	return p
+}
+
+// link builds an absolute path for p as the client sees it: the prefix a
+// trusted proxy stripped (X-Forwarded-Prefix), then BasePath, then the API
+// version the request came in on, then p.
+func (s *Server) link(r *http.Request, p string) string {
+	prefix := s.cfg.BasePath
+	if s.cfg.TrustForwardedPrefix {
+		if fwd := r.Header.Get("X-Forwarded-Prefix"); fwd != "" && !strings.ContainsAny(fwd, "?#\\") {
+			prefix = cleanPrefix(fwd) + prefix
+		}
+	}
+	return prefix + s.versionPrefix(r) + p
+}
+
+// versionPrefix is the API version prefix of the route r matched, empty
+// for the legacy mount.
+func (s *Server) versionPrefix(r *http.Request) string {
+	route := strings.TrimPrefix(routeOf(r), s.cfg.BasePath)
+	for _, v := range s.apiVersions() {
+		if v.Prefix != "" && strings.HasPrefix(route, v.Prefix+"/") {
+			return v.Prefix
+		}
+	}
+	return ""
+}
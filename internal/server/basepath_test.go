@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCleanPrefix(t *testing.T) {
+	for in, want := range map[string]string{
+		"": "", "/": "", " / ": "", "api/users-service/": "/api/users-service",
+		"/api//users-service": "/api/users-service", "/a/../b": "/b",
+	} {
+		if got := cleanPrefix(in); got != want {
+			t.Errorf("cleanPrefix(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// The same handlers serve at the root, under BASE_PATH and behind a proxy
+// that strips the prefix; every URL they emit must be the one the client
+// has to use, and following it must work.
+func TestURLsUnderPathPrefix(t *testing.T) {
+	const prefix = "/api/users-service"
+	for _, d := range []struct {
+		name string
+		cfg  Config
+		// mount is where requests arrive, header is X-Forwarded-Prefix
+		// and want is the prefix emitted URLs must carry.
+		mount, header, want string
+	}{
+		{name: "root"},
+		{name: "base path", cfg: Config{BasePath: prefix}, mount: prefix, want: prefix},
+		{name: "proxy", cfg: Config{TrustForwardedPrefix: true}, header: prefix, want: prefix},
+		{name: "untrusted proxy", header: prefix},
+	} {
+		t.Run(d.name, func(t *testing.T) {
+			d.cfg.Docs, d.cfg.APIKeys, d.cfg.ShareLinkSecret = true, testAPIKeys, "s3cret"
+			s, _ := newTestServer(t, d.cfg)
+			h := s.Handler()
+			call := func(method, path, body string) *httptest.ResponseRecorder {
+				return serve(h, method, d.mount+path, body, "X-Forwarded-Prefix", d.header, apiKeyHeader, aliceKey)
+			}
+			// follow requests an emitted URL the way the proxy would pass
+			// it on.
+			follow := func(url string) int {
+				if !strings.HasPrefix(url, d.want) {
+					t.Fatalf("%q lacks the prefix %q", url, d.want)
+				}
+				return serve(h, http.MethodGet, d.mount+strings.TrimPrefix(url, d.want), "", apiKeyHeader, aliceKey).Code
+			}
+
+			w := call(http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`)
+			if loc := w.Header().Get("Location"); loc != d.want+"/api/v1/users/1" {
+				t.Errorf("Location = %q", loc)
+			} else if code := follow(loc); code != http.StatusOK {
+				t.Errorf("following Location: %d", code)
+			}
+
+			var link shareLink
+			w = call(http.MethodPost, "/api/v1/admin/users/1/share-links", `{}`)
+			if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil || link.URL == "" {
+				t.Fatalf("share link: %d %s", w.Code, w.Body)
+			}
+			if code := follow(link.URL); code != http.StatusOK {
+				t.Errorf("following share link %q: %d", link.URL, code)
+			}
+
+			var spec struct {
+				Servers []struct{ URL string } `json:"servers"`
+			}
+			if err := json.Unmarshal(call(http.MethodGet, "/api/v1/openapi.json", "").Body.Bytes(), &spec); err != nil {
+				t.Fatal(err)
+			}
+			if len(spec.Servers) != 1 || spec.Servers[0].URL != d.want+"/api/v1" {
+				t.Errorf("OpenAPI servers = %+v", spec.Servers)
+			}
+
+			// html/template escapes the slashes inside the script.
+			specURL := strings.ReplaceAll(d.want+"/api/v1/openapi.json", "/", `\/`)
+			if body := call(http.MethodGet, "/api/v1/docs", "").Body.String(); !strings.Contains(body, `url: "`+specURL+`"`) {
+				t.Errorf("docs page does not point at the spec:\n%s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// maxBatchUsers caps POST /users/batch so one request can't hold a write
+// transaction open for long.
+const maxBatchUsers = 1000
+
+// maxBatchBodyBytes is the body limit of POST /users/batch, enough for
+// maxBatchUsers users with modest metadata.
+const maxBatchBodyBytes = 8 << 20
+
+type batchUser struct {
+	Name     string          `json:"name" binding:"required"`
+	Email    string          `json:"email" binding:"required,email"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// createUsers inserts a JSON array of users in one transaction, in
+// batches sized by memory headroom, and answers their ids in input order. Every element is validated before the
+// database is touched, and an email that is taken (or repeated within
+// the batch) fails the whole batch with the offending index.
+func (s *Server) createUsers(w http.ResponseWriter, r *http.Request) {
+	if s.growth.rejectWrites() {
+		respondError(w, r, codeStorageFull, "user storage limit reached")
+		return
+	}
+
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	var items []batchUser
+	if err := json.Unmarshal(body, &items); err != nil {
+		respondPayloadError(w, r, &items, err)
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatchUsers {
+		respondError(w, r, codeInvalidPayload, "a batch holds 1 to "+strconv.Itoa(maxBatchUsers)+" users")
+		return
+	}
+
+	users := make([]repository.NewUser, len(items))
+	var details []fieldError
+	emails := make(map[string]int, len(items))
+	for i, item := range items {
+		u, errs := validateBatchUser(i, item)
+		details = append(details, errs...)
+		users[i] = u
+		if first, dup := emails[item.Email]; dup && len(errs) == 0 {
+			resp := errorBody(codeEmailInUse, "email appears twice in the batch")
+			resp["index"], resp["first_index"] = i, first
+			writeJSON(w, r, codeEmailInUse.Status, resp)
+			return
+		}
+		emails[item.Email] = i
+	}
+	if len(details) > 0 {
+		resp := errorBody(codeInvalidPayload, "invalid payload")
+		resp["details"] = details
+		writeJSON(w, r, codeInvalidPayload.Status, resp)
+		return
+	}
+
+	ids, err := s.repo.CreateUsers(r.Context(), users, s.batchSize(r, "batch_create", batchMinUsers, len(users)))
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	var batchErr *repository.BatchError
+	if errors.As(err, &batchErr) && errors.Is(err, repository.ErrEmailAlreadyExists) {
+		resp := errorBody(codeEmailInUse, "email already in use")
+		resp["index"] = batchErr.Index
+		writeJSON(w, r, codeEmailInUse.Status, resp)
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int("users", len(users)).Msg("failed to create users")
+		respondError(w, r, codeInternal, "failed to create users")
+		return
+	}
+	s.cache.invalidate()
+
+	writeJSON(w, r, http.StatusCreated, usersCreated{IDs: ids})
+}
+
+// validateBatchUser applies createUser's checks to element i, reporting
+// every failure with a [i]-prefixed field path.
+func validateBatchUser(i int, item batchUser) (repository.NewUser, []fieldError) {
+	prefix := "[" + strconv.Itoa(i) + "]."
+	var details []fieldError
+
+	var invalid validator.ValidationErrors
+	if err := validate.Struct(&item); errors.As(err, &invalid) {
+		for _, fe := range invalid {
+			field := prefix + jsonPath(reflect.TypeOf(item), fe.StructNamespace())
+			details = append(details, fieldError{Field: field, Rule: fe.Tag(), Param: fe.Param(), Message: ruleMessage(field, fe)})
+		}
+		return repository.NewUser{}, details
+	}
+
+	name, err := normalizeName(item.Name)
+	if err != nil {
+		details = append(details, fieldError{Field: prefix + "name", Rule: "name", Message: "invalid name: " + err.Error()})
+	}
+	metadata, err := decodeMetadata(item.Metadata)
+	if err == nil && metadata != nil {
+		err = validateMetadata(metadata)
+	}
+	if err != nil {
+		details = append(details, fieldError{Field: prefix + "metadata", Rule: "metadata", Message: err.Error()})
+	}
+	return repository.NewUser{Name: name, Email: item.Email, Metadata: metadata}, details
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestCreateUsersBatch(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	seedUsers(t, repo, 1)
+
+	w := serve(h, http.MethodPost, "/api/v1/users/batch",
+		`[{"name":"Ada","email":"ada@example.com"},{"name":"Grace","email":"grace@example.com","metadata":{"team":"core"}}]`)
+	var created usersCreated
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if !slices.Equal(created.IDs, []int64{2, 3}) {
+		t.Fatalf("ids %v, want [2 3] in input order", created.IDs)
+	}
+	if u, err := repo.GetUserByID(context.Background(), 3); err != nil || u.Name != "Grace" || u.Metadata["team"] != "core" {
+		t.Errorf("second user stored as %+v, %v", u, err)
+	}
+}
+
+func TestCreateUsersBatchRejects(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	if _, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var tooMany []string
+	for i := range maxBatchUsers + 1 {
+		tooMany = append(tooMany, fmt.Sprintf(`{"name":"U","email":"u%d@example.com"}`, i))
+	}
+	for _, tc := range []struct {
+		name, body string
+		status     int
+		want       []string
+	}{
+		{"empty", `[]`, http.StatusBadRequest, []string{"1 to 1000 users"}},
+		{"over the cap", "[" + strings.Join(tooMany, ",") + "]", http.StatusBadRequest, []string{"1 to 1000 users"}},
+		{"not an array", `{"name":"Bo","email":"bo@example.com"}`, http.StatusBadRequest, []string{codeInvalidPayload.Code}},
+		{"every invalid element reported", `[{"name":"Bo","email":"bo@example.com"},{"name":"Cy","email":"nope"},{"email":"dee@example.com"}]`,
+			http.StatusBadRequest, []string{`"field":"[1].email"`, `"field":"[2].name"`}},
+		{"bad metadata", `[{"name":"Bo","email":"bo@example.com","metadata":[1]}]`, http.StatusBadRequest, []string{`"field":"[0].metadata"`}},
+		{"repeated within the batch", `[{"name":"Bo","email":"bo@example.com"},{"name":"Cy","email":"cy@example.com"},{"name":"Bo2","email":"bo@example.com"}]`,
+			http.StatusConflict, []string{`"index":2`, `"first_index":0`}},
+		{"taken by an existing user", `[{"name":"Bo","email":"bo@example.com"},{"name":"Ada","email":"ada@example.com"}]`,
+			http.StatusConflict, []string{codeEmailInUse.Code, `"index":1`}},
+	} {
+		w := serve(h, http.MethodPost, "/api/v1/users/batch", tc.body)
+		if w.Code != tc.status {
+			t.Errorf("%s: %d %s, want %d", tc.name, w.Code, w.Body, tc.status)
+			continue
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: body lacks %s: %s", tc.name, want, w.Body)
+			}
+		}
+	}
+
+	// Nothing of any rejected batch was inserted.
+	if n, err := repo.CountUsers(context.Background(), repository.UserFilter{}); err != nil || n != 1 {
+		t.Errorf("%d users after rejected batches, %v; want 1", n, err)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// fieldError is one entry of the details array of an invalid_payload
+// response. Field uses the JSON name; Offset is the byte position for
+// malformed JSON.
+type fieldError struct {
+	Field    string `json:"field,omitempty"`
+	Rule     string `json:"rule"`
+	Param    string `json:"param,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Offset   int64  `json:"offset,omitempty"`
+	Message  string `json:"message"`
+}
+
+// bindJSON decodes and validates the body into obj, answering
+// invalid_payload with field-level details when that fails. The body is
+// read first, so a client that aborts mid-upload is told apart from one
+// that sent truncated JSON; see readBody.
+func bindJSON(w http.ResponseWriter, r *http.Request, obj any) bool {
+	body, ok := readBody(w, r)
+	if !ok {
+		return false
+	}
+	err := decodeJSON(body, obj)
+	if err == nil {
+		return true
+	}
+	respondPayloadError(w, r, obj, err)
+	return false
+}
+
+// validate checks the binding struct tags of request payloads.
+var validate = func() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("binding")
+	return v
+}()
+
+// decodeJSON decodes the first JSON value of body into obj and validates
+// it. An empty body is io.EOF.
+func decodeJSON(body []byte, obj any) error {
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(obj); err != nil {
+		return err
+	}
+	return validate.Struct(obj)
+}
+
+func respondPayloadError(w http.ResponseWriter, r *http.Request, obj any, err error) {
+	body := errorBody(codeInvalidPayload, "invalid payload")
+	body["details"] = bindingDetails(obj, err)
+	writeJSON(w, r, codeInvalidPayload.Status, body)
+}
+
+// bindingDetails translates a decodeJSON error for obj.
+func bindingDetails(obj any, err error) []fieldError {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		invalid   validator.ValidationErrors
+	)
+	switch {
+	case errors.Is(err, io.EOF):
+		return []fieldError{{Rule: "required", Message: "request body is empty"}}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return []fieldError{{Rule: "syntax", Message: "request body is truncated JSON"}}
+	case errors.As(err, &syntaxErr):
+		return []fieldError{{Rule: "syntax", Offset: syntaxErr.Offset, Message: syntaxErr.Error()}}
+	case errors.As(err, &typeErr):
+		// encoding/json already reports the JSON path; it is empty when
+		// the body itself has the wrong type.
+		field := indexPath(typeErr.Field)
+		subject := field
+		if subject == "" {
+			subject = "request body"
+		}
+		actual := typeErr.Value
+		if actual == "bool" {
+			actual = "boolean"
+		}
+		return []fieldError{{
+			Field:    field,
+			Rule:     "type",
+			Expected: jsonKind(typeErr.Type),
+			Actual:   actual,
+			Offset:   typeErr.Offset,
+			Message:  subject + " must be a JSON " + jsonKind(typeErr.Type),
+		}}
+	case errors.As(err, &invalid):
+		details := make([]fieldError, 0, len(invalid))
+		for _, fe := range invalid {
+			field := jsonPath(reflect.TypeOf(obj), fe.StructNamespace())
+			details = append(details, fieldError{
+				Field:   field,
+				Rule:    fe.Tag(),
+				Param:   fe.Param(),
+				Message: ruleMessage(field, fe),
+			})
+		}
+		return details
+	}
+	return []fieldError{{Rule: "invalid", Message: err.Error()}}
+}
+
+// jsonPath maps a validator namespace such as "payload.Name" to the JSON
+// field path clients sent ("name").
+func jsonPath(t reflect.Type, namespace string) string {
+	parts := strings.Split(namespace, ".")
+	if len(parts) > 1 {
+		parts = parts[1:] // the root struct's own name
+	}
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map) {
+			t = t.Elem()
+		}
+		name := part
+		if t != nil && t.Kind() == reflect.Struct {
+			if f, ok := t.FieldByName(strings.SplitN(part, "[", 2)[0]); ok {
+				if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag != "" && tag != "-" {
+					name = tag + part[len(f.Name):]
+				}
+				t = f.Type
+			} else {
+				t = nil
+			}
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ".")
+}
+
+// indexPath writes the array indexes of an encoding/json path ("0.name")
+// the way validation details do ("[0].name").
+func indexPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+func ruleMessage(field string, fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return field + " is required"
+	case "email":
+		return field + " must be a valid email address"
+	}
+	if fe.Param() != "" {
+		return field + " must satisfy " + fe.Tag() + "=" + fe.Param()
+	}
+	return field + " must satisfy " + fe.Tag()
+}
+
+// jsonKind names the JSON type that decodes into t.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	}
+	return t.String()
+}
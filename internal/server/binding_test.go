@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// Every malformed payload answers invalid_payload with details naming the
+// JSON field, the violated rule and, for bad JSON, where it went wrong.
+func TestBindingDetails(t *testing.T) {
+	s, repo := newTestServer(t, Config{APIKeys: testAPIKeys})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+
+	tests := []struct {
+		name, method, path, body string
+		details                  string
+	}{
+		{"empty body", http.MethodPost, "/api/v1/users", "",
+			`[{"rule":"required","message":"request body is empty"}]`},
+		{"truncated JSON", http.MethodPost, "/api/v1/users", `{"name":"Ada"`,
+			`[{"rule":"syntax","message":"request body is truncated JSON"}]`},
+		{"syntax error", http.MethodPost, "/api/v1/users", `{"name":"Ada",}`,
+			`[{"rule":"syntax","offset":15,"message":"invalid character '}' looking for beginning of object key string"}]`},
+		{"wrong field type", http.MethodPost, "/api/v1/users", `{"name":42,"email":"ada@example.com"}`,
+			`[{"field":"name","rule":"type","expected":"string","actual":"number","offset":10,"message":"name must be a JSON string"}]`},
+		{"wrong body type", http.MethodPost, "/api/v1/users", `[]`,
+			`[{"rule":"type","expected":"object","actual":"array","offset":1,"message":"request body must be a JSON object"}]`},
+		{"missing and invalid fields", http.MethodPost, "/api/v1/users", `{"email":"nope"}`,
+			`[{"field":"name","rule":"required","message":"name is required"},{"field":"email","rule":"email","message":"email must be a valid email address"}]`},
+		{"both fields missing", http.MethodPut, "/api/v1/users/1", `{}`,
+			`[{"field":"name","rule":"required","message":"name is required"},{"field":"email","rule":"required","message":"email is required"}]`},
+		{"optional field invalid", http.MethodPatch, "/api/v1/users/1", `{"email":"nope"}`,
+			`[{"field":"email","rule":"email","message":"email must be a valid email address"}]`},
+		{"batch element type", http.MethodPost, "/api/v1/users/batch", `[{"name":"Ada"},{"name":true}]`,
+			`[{"field":"[1].name","rule":"type","expected":"string","actual":"boolean","offset":28,"message":"[1].name must be a JSON string"}]`},
+		{"batch element invalid", http.MethodPost, "/api/v1/users/batch", `[{"name":"Ada","email":"ada@example.com"},{"name":"Bo","email":"bad"}]`,
+			`[{"field":"[1].email","rule":"email","message":"[1].email must be a valid email address"}]`},
+		{"admin payload", http.MethodPut, "/api/v1/admin/read-only", `{"enabled":"yes"}`,
+			`[{"field":"enabled","rule":"type","expected":"boolean","actual":"string","offset":16,"message":"enabled must be a JSON boolean"}]`},
+		{"admin payload missing field", http.MethodPut, "/api/v1/admin/read-only", `{}`,
+			`[{"field":"enabled","rule":"required","message":"enabled is required"}]`},
+	}
+	for _, tt := range tests {
+		w := serve(h, tt.method, tt.path, tt.body, apiKeyHeader, aliceKey)
+		var resp struct {
+			Code    string
+			Details json.RawMessage
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v\n%s", tt.name, err, w.Body)
+		}
+		if w.Code != http.StatusBadRequest || resp.Code != codeInvalidPayload.Code || string(resp.Details) != tt.details {
+			t.Errorf("%s: %d %s\ndetails %s\nwant    %s", tt.name, w.Code, resp.Code, resp.Details, tt.details)
+		}
+	}
+}
+
+func TestIndexPath(t *testing.T) {
+	for in, want := range map[string]string{
+		"":               "",
+		"name":           "name",
+		"0.name":         "[0].name",
+		"items.2.labels": "items[2].labels",
+		"0.1":            "[0][1]",
+	} {
+		if got := indexPath(in); got != want {
+			t.Errorf("indexPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
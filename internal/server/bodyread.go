@@ -0,0 +1,62 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// statusClientClosedRequest is nginx's 499: the client went away before
+// its request body arrived. Nobody reads the response; the status exists
+// for the access log and is kept out of the status-coded request metrics
+// so flaky client networks don't trip 4xx alerts.
+const statusClientClosedRequest = 499
+
+// limitBody caps the request body at limit bytes. A declared
+// Content-Length over the limit is refused before anything is read;
+// otherwise http.MaxBytesReader stops the read and readBody answers 413.
+func limitBody(limit int64) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				respondError(w, r, codePayloadTooLarge, "request body exceeds "+strconv.FormatInt(limit, 10)+" bytes")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bodyLimit is rt's body size limit.
+func (s *Server) bodyLimit(rt route) int64 {
+	if rt.MaxBodyBytes > 0 {
+		return rt.MaxBodyBytes
+	}
+	return s.cfg.MaxBodyBytes
+}
+
+// readBody reads the whole request body for every path that decodes one
+// (JSON binding, raw metadata and label documents, the idempotency and
+// journal middleware), so a body that fails to arrive is answered the
+// same way everywhere. It reports false after responding.
+//
+// Only transport failures reach here: a complete body with malformed
+// content is read fine and rejected as 400 by its decoder.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		return body, true
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		respondError(w, r, codePayloadTooLarge, "request body exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+		return nil, false
+	}
+	// Truncated bodies (io.ErrUnexpectedEOF), resets and read deadlines all
+	// mean the client stopped sending; the access log shows the cause.
+	recordError(r, err)
+	w.WriteHeader(statusClientClosedRequest)
+	return nil, false
+}
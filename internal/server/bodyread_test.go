@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// sendTruncated writes a request announcing a longer body than it sends
+// and closes the connection, like a client losing its network mid-upload;
+// reset closes it with an RST instead of a FIN.
+func sendTruncated(t *testing.T, addr, method, path string, reset bool) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial := `{"name":"Ada","em`
+	fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: test\r\nContent-Type: application/json\r\nContent-Length: 200\r\n\r\n%s", method, path, partial)
+	if reset {
+		conn.(*net.TCPConn).SetLinger(0)
+	}
+	conn.Close()
+}
+
+func TestClientAbortDuringBody(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	seedUsers(t, repo, 1)
+	logs := captureLogs(t, s, zerolog.InfoLevel)
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	scrape := func() string { return serve(s.Handler(), http.MethodGet, "/metrics", "").Body.String() }
+	waitFor := func(metric string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(scrape(), metric) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never appeared:\n%s", metric, scrape())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	// JSON binding, a raw label document, and a closed as well as a reset
+	// connection all end up as client aborts.
+	sendTruncated(t, addr, http.MethodPost, "/api/v1/users", false)
+	sendTruncated(t, addr, http.MethodPost, "/api/v1/users", true)
+	sendTruncated(t, addr, http.MethodPut, "/api/v1/users/1/labels", false)
+	waitFor(`http_client_aborts_total{method="POST",route="/api/v1/users"} 2`)
+	waitFor(`http_client_aborts_total{method="PUT",route="/api/v1/users/:id/labels"} 1`)
+
+	// Close waits for the handlers, so every access log line is written.
+	ts.Close()
+
+	m := scrape()
+	if strings.Contains(m, `status="499"`) {
+		t.Errorf("aborts counted with the status-coded requests:\n%s", m)
+	}
+	entries := accessEntries(t, logs)
+	if len(entries) != 3 {
+		t.Fatalf("%d access log entries, want 3:\n%s", len(entries), logs)
+	}
+	for _, e := range entries {
+		if e["status"] != float64(statusClientClosedRequest) || e["level"] != "info" {
+			t.Errorf("abort logged as %v at %v", e["status"], e["level"])
+		}
+	}
+	if n, _ := repo.CountUsers(context.Background(), repository.UserFilter{}); n != 1 {
+		t.Errorf("%d users after aborted creates, want 1", n)
+	}
+}
+
+// A body that arrives whole but is not valid JSON is still the client's
+// mistake, and an oversized one is 413 whether or not it was declared.
+func TestCompleteBadBodies(t *testing.T) {
+	s, _ := newTestServer(t, Config{MaxBodyBytes: 64})
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	post := func(body string, chunked bool) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/users", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if chunked {
+			req.ContentLength = -1
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := post(`{"name":"Ada","em`, false); code != http.StatusBadRequest {
+		t.Errorf("truncated JSON sent in full: %d, want 400", code)
+	}
+	big := `{"name":"` + strings.Repeat("a", 100) + `","email":"ada@example.com"}`
+	if code := post(big, false); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("declared oversized body: %d, want 413", code)
+	}
+	if code := post(big, true); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked oversized body: %d, want 413", code)
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	seedUsers(t, repo, 1)
+	if s.bodyLimit(route{}) != 1<<20 {
+		t.Errorf("default limit %d, want 1 MiB", s.bodyLimit(route{}))
+	}
+
+	// Just over the default, as one JSON document per route.
+	name := strings.Repeat("a", 1<<20)
+	for _, tc := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/v1/users", `{"name":"` + name + `","email":"ada@example.com"}`},
+		{http.MethodPut, "/api/v1/users/1", `{"name":"` + name + `","email":"ada@example.com"}`},
+		{http.MethodPatch, "/api/v1/users/1", `{"name":"` + name + `"}`},
+		{http.MethodPatch, "/api/v1/users/1/metadata", `{"note":"` + name + `"}`},
+		{http.MethodPut, "/api/v1/users/1/labels", `{"team":"` + name + `"}`},
+	} {
+		w := serve(h, tc.method, tc.path, tc.body)
+		var body struct{ Code string }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != http.StatusRequestEntityTooLarge || body.Code != codePayloadTooLarge.Code {
+			t.Errorf("%s %s: %d %s, want 413 %s", tc.method, tc.path, w.Code, body.Code, codePayloadTooLarge.Code)
+		}
+	}
+	if u, _ := repo.GetUserByID(context.Background(), 1); len(u.Name) > 100 {
+		t.Error("an oversized write was applied")
+	}
+
+	// The batch route allows more than the default.
+	var batch []string
+	for i := range 40 {
+		batch = append(batch, fmt.Sprintf(`{"name":"%s","email":"u%d@example.com"}`, strings.Repeat("b", 40_000), i))
+	}
+	w := serve(h, http.MethodPost, "/api/v1/users/batch", "["+strings.Join(batch, ",")+"]")
+	if w.Code == http.StatusRequestEntityTooLarge {
+		t.Errorf("1.6 MB batch rejected under its 8 MiB limit: %s", w.Body)
+	}
+	if s.bodyLimit(route{MaxBodyBytes: maxBatchBodyBytes}) != maxBatchBodyBytes {
+		t.Error("route limit not applied")
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/supervisor"
+)
+
+// Sheddable features, in the order brownout turns them off.
+const (
+	featureMetadataFilter = "metadata_filter"
+	featureSearch         = "search"
+	featureExport         = "export"
+)
+
+var sheddableFeatures = []string{featureMetadataFilter, featureSearch, featureExport}
+
+// brownoutController degrades expensive optional features while the
+// service is saturated instead of letting everything fail. Every interval
+// it samples the pressure gauge; when the average over the window stays
+// at or above high it sheds the next feature, and when it stays at or
+// below low it restores the most recently shed one. A full window must
+// pass between level changes, which together with the gap between high
+// and low keeps it from flapping.
+type brownoutController struct {
+	sample   func() float64
+	log      zerolog.Logger
+	interval time.Duration
+	window   int
+	high     float64
+	low      float64
+
+	mu      sync.Mutex
+	samples []float64
+	level   int
+	since   int // samples taken since the last level change
+}
+
+func newBrownoutController(sample func() float64, logger zerolog.Logger, interval, window time.Duration, high, low float64) *brownoutController {
+	n := int(window / interval)
+	if n < 1 {
+		n = 1
+	}
+	return &brownoutController{
+		sample:   sample,
+		log:      logger,
+		interval: interval,
+		window:   n,
+		high:     high,
+		low:      low,
+	}
+}
+
+func (b *brownoutController) run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.observe(b.sample())
+			supervisor.Beat(ctx)
+		}
+	}
+}
+
+// observe records one pressure sample and adjusts the level.
+func (b *brownoutController) observe(p float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.samples = append(b.samples, p)
+	if len(b.samples) > b.window {
+		b.samples = b.samples[1:]
+	}
+	b.since++
+	if len(b.samples) < b.window || b.since < b.window {
+		return
+	}
+
+	var sum float64
+	for _, s := range b.samples {
+		sum += s
+	}
+	avg := sum / float64(len(b.samples))
+
+	switch {
+	case avg >= b.high && b.level < len(sheddableFeatures):
+		b.level++
+		b.since = 0
+		b.log.Warn().Float64("pressure", avg).Int("level", b.level).Str("shed", sheddableFeatures[b.level-1]).Msg("brownout: shedding feature")
+	case avg <= b.low && b.level > 0:
+		b.level--
+		b.since = 0
+		b.log.Info().Float64("pressure", avg).Int("level", b.level).Str("restored", sheddableFeatures[b.level]).Msg("brownout: restoring feature")
+	}
+}
+
+// disabled reports whether feature is currently shed; always false when
+// brownout is not configured.
+func (b *brownoutController) disabled(feature string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, f := range sheddableFeatures[:b.level] {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter is the earliest a shed feature can come back: the level
+// changes at most once per window of samples.
+func (b *brownoutController) retryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Duration(max(1, b.window-b.since)) * b.interval
+}
+
+// state returns the current level and the shed features.
+func (b *brownoutController) state() (level int, shed []string) {
+	if b == nil {
+		return 0, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.level, append([]string(nil), sheddableFeatures[:b.level]...)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// feed observes n samples of p and returns the level after each.
+func feed(b *brownoutController, p float64, n int) []int {
+	levels := make([]int, n)
+	for i := range levels {
+		b.observe(p)
+		levels[i], _ = b.state()
+	}
+	return levels
+}
+
+// Sustained overload sheds features one window at a time, recovery brings
+// them back in reverse order, and pressure between low and high holds
+// the level.
+func TestBrownoutEscalationAndRecovery(t *testing.T) {
+	b := newBrownoutController(nil, zerolog.Nop(), time.Second, 3*time.Second, 0.8, 0.5)
+
+	if got := feed(b, 0.9, 10); !slices.Equal(got, []int{0, 0, 1, 1, 1, 2, 2, 2, 3, 3}) {
+		t.Fatalf("escalation levels %v", got)
+	}
+	if _, shed := b.state(); !slices.Equal(shed, sheddableFeatures) {
+		t.Errorf("shed %v", shed)
+	}
+	if got := feed(b, 0.7, 6); !slices.Equal(got, []int{3, 3, 3, 3, 3, 3}) {
+		t.Errorf("levels in the hysteresis band %v", got)
+	}
+
+	// The window already holds the band's samples, so the first step
+	// down comes as soon as its average reaches low.
+	if got := feed(b, 0.1, 1); got[0] != 2 || !b.disabled(featureSearch) || b.disabled(featureExport) {
+		t.Fatalf("first recovery step: level %v; the last feature shed must come back first", got)
+	}
+	if got := feed(b, 0.1, 6); !slices.Equal(got, []int{2, 2, 1, 1, 1, 0}) {
+		t.Errorf("recovery levels %v", got)
+	}
+}
+
+// A short spike inside the window does not trip the controller.
+func TestBrownoutIgnoresSpikes(t *testing.T) {
+	b := newBrownoutController(nil, zerolog.Nop(), time.Second, 5*time.Second, 0.8, 0.5)
+	feed(b, 0.2, 5)
+	if got := feed(b, 1, 2); slices.Max(got) != 0 {
+		t.Errorf("levels after a spike %v", got)
+	}
+}
+
+// Only the shed features answer feature_disabled; core CRUD keeps working
+// and the level shows up in metrics and /readyz.
+func TestBrownoutShedsOnlyOptionalFeatures(t *testing.T) {
+	var load atomic.Value
+	load.Store(0.0)
+	s, repo := newTestServer(t, Config{BrownoutHigh: 0.8, BrownoutLow: 0.5, BrownoutWindow: time.Second})
+	seedUsers(t, repo, 1)
+	s.brownout.sample = func() float64 { return load.Load().(float64) }
+	h := s.Handler()
+
+	load.Store(1.0)
+	for range len(sheddableFeatures) * s.brownout.window {
+		s.brownout.observe(s.brownout.sample())
+	}
+
+	for _, target := range []string{"/api/v1/users?metadata.team=core", "/api/v1/users?name=ada", "/api/v1/users?email=example", "/api/v1/users.csv?name=ada", "/api/v1/users.csv"} {
+		w := serve(h, http.MethodGet, target, "")
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), `"code":"feature_disabled"`) {
+			t.Errorf("%s under brownout: %d %s", target, w.Code, w.Body)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s under brownout: no Retry-After", target)
+		}
+	}
+	for _, target := range []string{"/api/v1/users", "/api/v1/users?name=", "/api/v1/users?label=team%3Dcore", "/api/v1/users/1"} {
+		if w := serve(h, http.MethodGet, target, ""); w.Code != http.StatusOK {
+			t.Errorf("%s under brownout: %d", target, w.Code)
+		}
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`); w.Code != http.StatusCreated {
+		t.Errorf("create under brownout: %d", w.Code)
+	}
+
+	if metrics := serve(h, http.MethodGet, "/metrics", "").Body.String(); !strings.Contains(metrics, "brownout_level 3") {
+		t.Error("metrics lack brownout_level 3")
+	}
+	if body := serve(h, http.MethodGet, "/readyz", "").Body.String(); !strings.Contains(body, `"brownout":{"level":3,"shed":["metadata_filter","search","export"]}`) {
+		t.Errorf("readyz: %s", body)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/requestctx"
+)
+
+// responseCache keeps serialized list responses in memory for a short TTL.
+// A nil *responseCache is valid and behaves as a disabled cache, so handlers
+// can call it unconditionally.
+type responseCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int
+	size     int
+	// gen is bumped on every invalidation so a response computed before a
+	// mutation is never stored after it.
+	gen     uint64
+	entries map[string]cacheEntry
+
+	requests *metrics.CounterVec
+}
+
+type cacheEntry struct {
+	body    []byte
+	header  http.Header // response headers a hit must repeat
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration, maxBytes int) *responseCache {
+	return &responseCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+func (c *responseCache) registerMetrics(reg *metrics.Registry) {
+	if c == nil {
+		return
+	}
+	c.requests = reg.Counter("users_list_cache_requests_total", "GET /users lookups in the response cache, by result (hit, miss or bypass).", "result")
+}
+
+// count records the outcome of a lookup.
+func (c *responseCache) count(result string) {
+	if c != nil && c.requests != nil {
+		c.requests.With(result).Inc()
+	}
+}
+
+// cacheKey is the tenant and role of the caller plus the normalized query
+// string, so parameter order doesn't split entries and a response is only
+// ever served to the kind of caller it was built for.
+func cacheKey(r *http.Request) string {
+	ctx := r.Context()
+	tenant, _ := requestctx.Tenant(ctx)
+	query := r.URL.RawQuery
+	if q, err := url.ParseQuery(query); err == nil {
+		query = q.Encode()
+	}
+	return tenant + "\n" + callerRole(r) + "\n" + query
+}
+
+// callerRole is the kind of caller: a token holder, an API-key service or
+// an anonymous client. Redaction rules that differ by role must key the
+// cache on it, which cacheKey does.
+func callerRole(r *http.Request) string {
+	ctx := r.Context()
+	if _, ok := requestctx.Actor(ctx); ok {
+		return "user"
+	}
+	if _, ok := requestctx.Consumer(ctx); ok {
+		return "service"
+	}
+	return "anonymous"
+}
+
+// bypassCache reports whether the client asked for a fresh response.
+func bypassCache(r *http.Request) bool {
+	return strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+}
+
+func (c *responseCache) get(key string) ([]byte, http.Header, bool) {
+	if c == nil {
+		return nil, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, nil, false
+	}
+	if time.Now().After(e.expires) {
+		c.remove(key)
+		return nil, nil, false
+	}
+	return e.body, e.header, true
+}
+
+// generation must be read before computing a response that is later passed to put.
+func (c *responseCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+func (c *responseCache) put(key string, gen uint64, body []byte, header http.Header) {
+	if c == nil || len(body) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		// Data changed while the response was being built.
+		return
+	}
+
+	c.remove(key)
+	c.evict(len(body))
+	c.entries[key] = cacheEntry{body: body, header: header, expires: time.Now().Add(c.ttl)}
+	c.size += len(body)
+}
+
+// invalidate drops every entry; called after any user mutation.
+func (c *responseCache) invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = make(map[string]cacheEntry)
+	c.size = 0
+}
+
+// evict makes room for n more bytes, dropping expired entries first.
+func (c *responseCache) evict(n int) {
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.After(e.expires) {
+			c.remove(key)
+		}
+	}
+	for key := range c.entries {
+		if c.size+n <= c.maxBytes {
+			return
+		}
+		c.remove(key)
+	}
+}
+
+func (c *responseCache) remove(key string) {
+	if e, ok := c.entries[key]; ok {
+		c.size -= len(e.body)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/requestctx"
+)
+
+func TestListCacheServesFreshDataAfterMutation(t *testing.T) {
+	s, _ := newTestServer(t, Config{ListCacheTTL: time.Minute})
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/users", ""); w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first list: X-Cache %q, want MISS", w.Header().Get("X-Cache"))
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users", ""); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("second list: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	if w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`); w.Code != http.StatusCreated {
+		t.Fatalf("create: %d", w.Code)
+	}
+	w := serve(h, http.MethodGet, "/api/v1/users", "")
+	if w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), "ada@example.com") {
+		t.Errorf("list after create: %s %s, want a fresh list with the new user", w.Header().Get("X-Cache"), w.Body)
+	}
+	if w.Header().Get("X-Total-Count") != "1" {
+		t.Errorf("X-Total-Count = %q, want 1", w.Header().Get("X-Total-Count"))
+	}
+
+	if w := serve(h, http.MethodGet, "/api/v1/users", "", "Cache-Control", "no-cache"); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("no-cache: X-Cache %q, want MISS", w.Header().Get("X-Cache"))
+	}
+
+	metrics := serve(h, http.MethodGet, "/metrics", "").Body.String()
+	for _, line := range []string{
+		`users_list_cache_requests_total{result="hit"} 1`,
+		`users_list_cache_requests_total{result="miss"} 2`,
+		`users_list_cache_requests_total{result="bypass"} 1`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+}
+
+// A cached list must not answer a request its parameters should fail.
+func TestListCacheLookupAfterValidation(t *testing.T) {
+	s, _ := newTestServer(t, Config{ListCacheTTL: time.Minute})
+	h := s.Handler()
+
+	for range 2 {
+		if w := serve(h, http.MethodGet, "/api/v1/users?limit=0", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid limit: %d, want 400", w.Code)
+		}
+	}
+
+	// Warm the cache for a metadata filter, then shed the feature.
+	if w := serve(h, http.MethodGet, "/api/v1/users?metadata.team=core", ""); w.Code != http.StatusOK {
+		t.Fatalf("metadata filter: %d", w.Code)
+	}
+	s.brownout = &brownoutController{level: 1}
+	if w := serve(h, http.MethodGet, "/api/v1/users?metadata.team=core", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("cached metadata filter under brownout: %d %s, want 503", w.Code, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestCacheKeyVariesByCaller(t *testing.T) {
+	key := func(ctx context.Context, target string) string {
+		return cacheKey(httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx))
+	}
+	bg := context.Background()
+	anon := key(bg, "/users?b=2&a=1")
+	if anon != key(bg, "/users?a=1&b=2") {
+		t.Errorf("parameter order split the key")
+	}
+	for name, ctx := range map[string]context.Context{
+		"actor":    requestctx.SetActor(bg, "alice"),
+		"consumer": requestctx.SetConsumer(bg, "billing"),
+		"tenant":   requestctx.SetTenant(bg, "acme"),
+	} {
+		if key(ctx, "/users?a=1&b=2") == anon {
+			t.Errorf("%s shares the anonymous key", name)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// remoteIPHeaders carry the client address set by a trusted proxy, in the
+// order they are consulted.
+var remoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+// parseTrustedProxies reads Config.TrustedProxies: CIDRs, or single
+// addresses taken as /32 or /128.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: p}
+			}
+			bits := net.IPv6len * 8
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, net.IPv4len*8
+			}
+			p = ip.String() + "/" + strconv.Itoa(bits)
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// clientIP is the address a request came from. X-Forwarded-For and
+// X-Real-IP are believed only from a trusted proxy, and then walked from
+// the right up to the first address that is not a trusted proxy itself,
+// so a client cannot choose its own address by sending the header.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return ""
+	}
+	remote := net.ParseIP(host)
+	if remote == nil || !s.trustedProxy(remote) {
+		return host
+	}
+	for _, name := range remoteIPHeaders {
+		if ip, ok := s.forwardedFor(r.Header.Get(name)); ok {
+			return ip
+		}
+	}
+	return host
+}
+
+func (s *Server) forwardedFor(header string) (string, bool) {
+	if header == "" {
+		return "", false
+	}
+	items := strings.Split(header, ",")
+	for i := len(items) - 1; i >= 0; i-- {
+		item := strings.TrimSpace(items[i])
+		ip := net.ParseIP(item)
+		if ip == nil {
+			break
+		}
+		if i == 0 || !s.trustedProxy(ip) {
+			return item, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) trustedProxy(ip net.IP) bool {
+	for _, n := range s.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
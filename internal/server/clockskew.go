@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/supervisor"
+)
+
+// clockSkewChecker compares the local clock with the database clock.
+type clockSkewChecker struct {
+	// dbNow returns the database's current time; swapped out in tests.
+	dbNow     func(context.Context) (time.Time, error)
+	log       zerolog.Logger
+	interval  time.Duration
+	threshold time.Duration
+
+	// skew holds the last measurement in nanoseconds (DB minus local).
+	skew atomic.Int64
+}
+
+func newClockSkewChecker(dbNow func(context.Context) (time.Time, error), logger zerolog.Logger, interval, threshold time.Duration) *clockSkewChecker {
+	return &clockSkewChecker{
+		dbNow:     dbNow,
+		log:       logger,
+		interval:  interval,
+		threshold: threshold,
+	}
+}
+
+// run measures immediately and then every interval until ctx is cancelled.
+func (c *clockSkewChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.check(ctx); err != nil && ctx.Err() == nil {
+			c.log.Error().Err(err).Msg("clock skew check failed")
+		}
+		supervisor.Beat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *clockSkewChecker) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	dbTime, err := c.dbNow(ctx)
+	if err != nil {
+		return err
+	}
+	rtt := time.Since(start)
+
+	// Assume the DB read its clock halfway through the round trip.
+	skew := dbTime.Sub(start.Add(rtt / 2))
+	c.skew.Store(int64(skew))
+
+	if c.exceeded() {
+		c.log.Warn().
+			Float64("clock_skew_seconds", skew.Seconds()).
+			Dur("round_trip", rtt).
+			Dur("threshold", c.threshold).
+			Msg("clock skew against database above threshold")
+	}
+	return nil
+}
+
+// seconds returns the last measured skew (DB minus local).
+func (c *clockSkewChecker) seconds() float64 {
+	return time.Duration(c.skew.Load()).Seconds()
+}
+
+// leeway is how much the last measurement widens the JWT leeway: the
+// skew, at most limit, once it is above the threshold, else nothing.
+func (c *clockSkewChecker) leeway(limit time.Duration) time.Duration {
+	if !c.exceeded() {
+		return 0
+	}
+	return min(limit, time.Duration(c.skew.Load()).Abs())
+}
+
+func (c *clockSkewChecker) exceeded() bool {
+	return c != nil && math.Abs(c.seconds()) > c.threshold.Seconds()
+}
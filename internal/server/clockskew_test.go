@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestClockSkewMeasurement(t *testing.T) {
+	offset := 3 * time.Second
+	c := newClockSkewChecker(func(context.Context) (time.Time, error) {
+		return time.Now().Add(offset), nil
+	}, zerolog.Nop(), time.Minute, time.Second)
+
+	if err := c.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.seconds(); got < 2.9 || got > 3.1 {
+		t.Errorf("skew = %.3fs, want about 3s", got)
+	}
+	if !c.exceeded() {
+		t.Error("3s skew not above a 1s threshold")
+	}
+
+	offset = -200 * time.Millisecond
+	if err := c.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.seconds(); got > -0.1 || c.exceeded() {
+		t.Errorf("skew = %.3fs exceeded=%v, want about -0.2s within threshold", got, c.exceeded())
+	}
+}
+
+func TestClockSkewCheckError(t *testing.T) {
+	c := newClockSkewChecker(func(context.Context) (time.Time, error) {
+		return time.Time{}, errors.New("db down")
+	}, zerolog.Nop(), time.Minute, time.Second)
+	if err := c.check(context.Background()); err == nil {
+		t.Error("check hid the database error")
+	}
+	var nilChecker *clockSkewChecker
+	if nilChecker.exceeded() {
+		t.Error("a nil checker reports skew")
+	}
+}
+
+func TestClockSkewLeeway(t *testing.T) {
+	c := newClockSkewChecker(nil, zerolog.Nop(), time.Minute, 5*time.Second)
+	for _, tt := range []struct{ skew, want time.Duration }{
+		{3 * time.Second, 0}, // within the threshold
+		{-40 * time.Second, 40 * time.Second},
+		{10 * time.Minute, 2 * time.Minute}, // capped
+	} {
+		c.skew.Store(int64(tt.skew))
+		if got := c.leeway(2 * time.Minute); got != tt.want {
+			t.Errorf("skew %v: leeway %v, want %v", tt.skew, got, tt.want)
+		}
+	}
+}
+
+// With JWTLeewayFromSkew a token that looks expired on a clock running
+// ahead of the database's is accepted, and only then.
+func TestJWTLeewayFromSkew(t *testing.T) {
+	token := "Bearer " + hs256(t, map[string]any{"sub": "ops", "exp": time.Now().Add(-time.Minute).Unix()})
+	for _, tt := range []struct {
+		name     string
+		fromSkew bool
+		want     int
+	}{
+		{"off", false, http.StatusUnauthorized},
+		{"on", true, http.StatusOK},
+	} {
+		s, _ := newTestServer(t, Config{JWTSecret: testJWTSecret, JWTLeeway: 30 * time.Second,
+			JWTLeewayFromSkew: tt.fromSkew, JWTLeewaySkewCap: 2 * time.Minute, ClockSkewThreshold: 5 * time.Second})
+		s.clock.skew.Store(int64(-90 * time.Second))
+		if w := serve(s.Handler(), http.MethodGet, "/api/v1/admin/auth/keys", "", "Authorization", token); w.Code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestClockSkewGauge(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	s.clock.skew.Store(int64(1500 * time.Millisecond))
+	body := serve(s.Handler(), http.MethodGet, "/metrics", "").Body.String()
+	if !strings.Contains(body, "\nclock_skew_seconds 1.5\n") {
+		t.Errorf("metrics lack clock_skew_seconds 1.5:\n%s", body)
+	}
+}
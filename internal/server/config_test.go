@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/config"
+	"go-k8s-demo/internal/features"
+)
+
+func TestProcessConfig(t *testing.T) {
+	cfg, err := config.Load(func(key string) (string, bool) {
+		v, ok := map[string]string{"PROFILE": "small", "SCALING_CONCURRENCY": "100", "SHARE_LINK_SECRET": "hunter2"}[key]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t, Config{APIKeys: testAPIKeys, Process: cfg.Effective()})
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/config", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", w.Code)
+	}
+	w := serve(h, http.MethodGet, "/api/v1/admin/config", "", apiKeyHeader, aliceKey)
+	var got config.Effective
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %v\n%s", w.Code, err, w.Body)
+	}
+	if got.Profile != config.Small || got.Settings["DB_MAX_CONNS"] != "4" || got.Settings["SCALING_CONCURRENCY"] != "100" {
+		t.Errorf("config %+v", got)
+	}
+	if len(got.Overridden) != 2 || len(got.Warnings) != 1 || !strings.Contains(got.Warnings[0], "SCALING_CONCURRENCY") {
+		t.Errorf("overridden %v, warnings %q", got.Overridden, got.Warnings)
+	}
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("secret served: %s", w.Body)
+	}
+}
+
+func TestFeatureMatrix(t *testing.T) {
+	feats, err := features.Resolve(func(key string) string {
+		return map[string]string{"ENVIRONMENT": "staging", "ENABLE_DEMO_UI": "true"}[key]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t, Config{APIKeys: testAPIKeys, Features: feats})
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/features", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", w.Code)
+	}
+	w := serve(h, http.MethodGet, "/api/v1/admin/features", "", apiKeyHeader, aliceKey)
+	var got features.Matrix
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %v\n%s", w.Code, err, w.Body)
+	}
+	if got.Environment != features.Staging || got.String() != "demo_ui=on docs=on gin_debug=off" || len(got.Overridden) != 1 {
+		t.Errorf("matrix %+v", got)
+	}
+}
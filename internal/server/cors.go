@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for the CORS lists the configuration leaves empty: everything
+// the API accepts and the headers its clients read.
+var (
+	corsDefaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	corsDefaultHeaders = []string{"Authorization", "Content-Type", "If-Match", "Idempotency-Key", "Prefer", apiKeyHeader, requestIDHeader}
+	corsExposedHeaders = []string{"ETag", "Location", "Idempotent-Replayed", "Retry-After", "Deprecation", "Preference-Applied", "X-Total-Count", "X-Result-Truncated", collationHeader,
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", requestIDHeader}
+)
+
+// corsPolicy answers preflights and marks responses for allowed origins.
+// Origins are exact ("https://app.example.com"), "*" for any, or have one
+// wildcard for subdomains ("https://*.example.com", which does not match
+// the bare domain). Disallowed origins get no Access-Control headers, so
+// the browser blocks them; the request itself is served as usual.
+type corsPolicy struct {
+	any         bool
+	exact       map[string]bool
+	patterns    [][2]string // prefix and suffix around the wildcard
+	methods     string
+	headers     string
+	exposed     string
+	maxAge      string
+	credentials bool
+}
+
+func newCORSPolicy(origins, methods, headers []string, maxAge time.Duration, credentials bool) (*corsPolicy, error) {
+	p := &corsPolicy{exact: make(map[string]bool), credentials: credentials}
+	for _, o := range origins {
+		o = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(o)), "/")
+		switch strings.Count(o, "*") {
+		case 0:
+			p.exact[o] = true
+		case 1:
+			if o == "*" {
+				p.any = true
+				continue
+			}
+			prefix, suffix, _ := strings.Cut(o, "*")
+			if !strings.HasSuffix(prefix, "://") || !strings.HasPrefix(suffix, ".") {
+				return nil, errors.New("server: CORS origin " + strconv.Quote(o) + " may only use * for subdomains, as in https://*.example.com")
+			}
+			p.patterns = append(p.patterns, [2]string{prefix, suffix})
+		default:
+			return nil, errors.New("server: CORS origin " + strconv.Quote(o) + " has more than one *")
+		}
+	}
+	if p.any && credentials {
+		// Browsers refuse credentialed responses for *, and reflecting every
+		// origin instead would let any site act with the user's credentials.
+		return nil, errors.New("server: CORS origin * cannot be combined with credentials; list the origins")
+	}
+
+	if len(methods) == 0 {
+		methods = corsDefaultMethods
+	}
+	if len(headers) == 0 {
+		headers = corsDefaultHeaders
+	}
+	p.methods = strings.Join(methods, ", ")
+	p.headers = strings.Join(headers, ", ")
+	p.exposed = strings.Join(corsExposedHeaders, ", ")
+	if maxAge > 0 {
+		p.maxAge = strconv.Itoa(int(maxAge / time.Second))
+	}
+	return p, nil
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	return slices.ContainsFunc(p.patterns, func(pat [2]string) bool {
+		sub, ok := strings.CutPrefix(origin, pat[0])
+		if !ok {
+			return false
+		}
+		sub, ok = strings.CutSuffix(sub, pat[1])
+		return ok && sub != "" && !strings.ContainsAny(sub, "/:@?#")
+	})
+}
+
+// middleware runs before routing, so preflights are answered without
+// reaching any handler or per-route middleware.
+func (p *corsPolicy) middleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !p.any {
+				w.Header().Add("Vary", "Origin")
+			}
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			}
+
+			if p.allowed(origin) {
+				h := w.Header()
+				if p.any {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+				if p.credentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+				if preflight {
+					h.Set("Access-Control-Allow-Methods", p.methods)
+					h.Set("Access-Control-Allow-Headers", p.headers)
+					if p.maxAge != "" {
+						h.Set("Access-Control-Max-Age", p.maxAge)
+					}
+				} else {
+					h.Set("Access-Control-Expose-Headers", p.exposed)
+				}
+			}
+
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCORSExposesCreateAndReplayHeaders(t *testing.T) {
+	s, _ := newTestServer(t, Config{CORSAllowedOrigins: []string{"https://*.example.com"}})
+	w := serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`,
+		"Origin", "https://app.example.com", "Idempotency-Key", "k1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("Access-Control-Allow-Origin = %q", got)
+	}
+	exposed := strings.Split(w.Header().Get("Access-Control-Expose-Headers"), ", ")
+	for _, h := range []string{"Location", "ETag", "Idempotent-Replayed", "X-Total-Count"} {
+		if !slices.Contains(exposed, h) {
+			t.Errorf("%s not exposed: %v", h, exposed)
+		}
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	p, err := newCORSPolicy([]string{"https://app.example.com", "https://*.example.org"}, nil, nil, time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, want := range map[string]bool{
+		"https://app.example.com":       true,
+		"HTTPS://APP.EXAMPLE.COM":       true,
+		"https://a.example.org":         true,
+		"https://example.org":           false,
+		"https://evil.com/.example.org": false,
+		"http://app.example.com":        false,
+	} {
+		if got := p.allowed(origin); got != want {
+			t.Errorf("allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+
+	for _, origins := range [][]string{{"*"}, {"https://*.*.example.com"}, {"https://app*.example.com"}} {
+		if _, err := newCORSPolicy(origins, nil, nil, 0, origins[0] == "*"); err == nil {
+			t.Errorf("%v accepted", origins)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	s, repo := newTestServer(t, Config{
+		CORSAllowedOrigins: []string{"https://app.example.com"}, CORSMaxAge: 10 * time.Minute, CORSAllowCredentials: true,
+		APIKeys: testAPIKeys,
+	})
+	h := s.Handler()
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		return serve(h, http.MethodOptions, "/api/v1/users", "", "Origin", origin,
+			"Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "content-type, x-api-key")
+	}
+
+	// Answered before routing: no credential needed and no handler runs.
+	w := preflight("https://app.example.com")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("preflight: %d %s", w.Code, w.Body)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "600",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if methods := w.Header().Get("Access-Control-Allow-Methods"); !strings.Contains(methods, "POST") || !strings.Contains(methods, "PATCH") {
+		t.Errorf("Access-Control-Allow-Methods = %q", methods)
+	}
+	if headers := w.Header().Get("Access-Control-Allow-Headers"); !strings.Contains(headers, apiKeyHeader) || !strings.Contains(headers, "If-Match") {
+		t.Errorf("Access-Control-Allow-Headers = %q", headers)
+	}
+	if vary := strings.Join(w.Header().Values("Vary"), ", "); !strings.Contains(vary, "Origin") || !strings.Contains(vary, "Access-Control-Request-Method") {
+		t.Errorf("Vary = %q", vary)
+	}
+	if n := countUsers(t, repo); n != 0 {
+		t.Errorf("a preflight created %d users", n)
+	}
+
+	// A denied origin gets the same 204 but nothing that allows it.
+	w = preflight("https://evil.example.net")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("denied preflight: %d", w.Code)
+	}
+	for header := range w.Header() {
+		if strings.HasPrefix(header, "Access-Control-") {
+			t.Errorf("denied preflight got %s: %q", header, w.Header().Get(header))
+		}
+	}
+}
+
+func TestCORSSimpleRequests(t *testing.T) {
+	s, _ := newTestServer(t, Config{CORSAllowedOrigins: []string{"https://app.example.com", "https://*.example.org"}})
+	h := s.Handler()
+
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":  true,
+		"https://beta.example.org": true,
+		"https://example.org":      false,
+		"https://evil.example.net": false,
+	} {
+		w := serve(h, http.MethodGet, "/api/v1/users", "", "Origin", origin)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d, the request is served either way", origin, w.Code)
+		}
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if allowed && got != origin || !allowed && got != "" {
+			t.Errorf("%s: Access-Control-Allow-Origin %q", origin, got)
+		}
+		if allowed == (w.Header().Get("Access-Control-Expose-Headers") == "") {
+			t.Errorf("%s: Access-Control-Expose-Headers %q", origin, w.Header().Get("Access-Control-Expose-Headers"))
+		}
+		if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s: credentials allowed without CORS_ALLOW_CREDENTIALS", origin)
+		}
+		// Caches must key on Origin, allowed or not.
+		if !slices.Contains(w.Header().Values("Vary"), "Origin") {
+			t.Errorf("%s: Vary %q", origin, w.Header().Values("Vary"))
+		}
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users", ""); w.Header().Get("Access-Control-Allow-Origin") != "" || slices.Contains(w.Header().Values("Vary"), "Origin") {
+		t.Errorf("same-origin request got CORS headers: %v", w.Header())
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	s, _ := newTestServer(t, Config{CORSAllowedOrigins: []string{"*"}})
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/users", "", "Origin", "https://anywhere.test")
+	if w.Header().Get("Access-Control-Allow-Origin") != "*" || slices.Contains(w.Header().Values("Vary"), "Origin") {
+		t.Errorf("any origin: ACAO %q, Vary %q", w.Header().Get("Access-Control-Allow-Origin"), w.Header().Values("Vary"))
+	}
+	if _, err := New(Config{CORSAllowedOrigins: []string{"*"}, CORSAllowCredentials: true}); err == nil {
+		t.Error("* with credentials accepted")
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// requiredMiddleware is the protection policy: which per-route middlewares
+// a table entry must end up with. It is written independently of
+// routeMiddleware so a mistake in one is caught by the other.
+func (s *Server) requiredMiddleware(rt route) []string {
+	var want []string
+	if s.limiter != nil && rt.RateLimit != rateExempt {
+		want = append(want, mwRateLimit)
+	}
+	if rt.RateLimit != rateExempt {
+		want = append(want, mwInFlight)
+	}
+	if rt.Deprecated {
+		want = append(want, mwDeprecated)
+	}
+	if rt.Auth || isMutating(rt.Method) {
+		want = append(want, mwAuth)
+	}
+	if isMutating(rt.Method) {
+		want = append(want, mwBodyLimit)
+	}
+	if rt.Timeout > 0 {
+		want = append(want, mwTimeout)
+	}
+	if isMutating(rt.Method) && !rt.AllowInReadOnly {
+		want = append(want, mwReadOnly)
+	}
+	if rt.Method == http.MethodPost && !rt.AllowDuplicates {
+		want = append(want, mwIdempotency)
+	}
+	if s.journal != nil && isMutating(rt.Method) {
+		want = append(want, mwJournal)
+	}
+	return want
+}
+
+// checkRouteCoverage compares what the router actually serves with the route
+// table: every registered route must come from the table, carry the
+// middleware the policy requires for it and be in the OpenAPI document.
+// All violations are reported together, each naming the route and what
+// is wrong with it.
+func (s *Server) checkRouteCoverage() error {
+	table := make(map[string]route)
+	var problems []string
+	for _, rt := range s.routes() {
+		key := routeKey(rt.Method, s.mountPath(rt))
+		if _, dup := table[key]; dup {
+			problems = append(problems, fmt.Sprintf("%s: listed more than once in the route table", key))
+		}
+		table[key] = rt
+		if _, ok := operationDocs[rt.OperationID]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: operation %q is not documented in operationDocs", key, rt.OperationID))
+		}
+	}
+
+	for _, key := range s.router.registered() {
+		rt, ok := table[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: registered outside the route table", key))
+			continue
+		}
+		have := s.mounted[key]
+		for _, name := range s.requiredMiddleware(rt) {
+			if !slices.Contains(have, name) {
+				problems = append(problems, fmt.Sprintf("%s: missing %s middleware", key, name))
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New("server: route coverage check failed:\n  " + strings.Join(problems, "\n  "))
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRouteCoverage(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"defaults":   {},
+		"base path":  {BasePath: "/users-api", ProbesUnderBasePath: true},
+		"management": {ManagementAddr: ":0"},
+		"everything": {RateLimitRPS: 10, RateLimitBurst: 10, JWTSecret: testJWTSecret, APIKeys: testAPIKeys, DemoUI: true,
+			JournalPath: filepath.Join(t.TempDir(), "journal")},
+	} {
+		// New runs the check and fails on any violation.
+		t.Run(name, func(t *testing.T) { newTestServer(t, cfg) })
+		t.Run(name+" on ServeMux", func(t *testing.T) { newTestServer(t, cfg, WithServeMux()) })
+	}
+}
+
+func TestRouteCoverageReportsViolations(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	s.router.handle(http.MethodGet, "/stray", http.NotFoundHandler())
+	create := routeKey(http.MethodPost, "/api/v1/users")
+	s.mounted[create] = slices.DeleteFunc(slices.Clone(s.mounted[create]), func(name string) bool { return name == mwAuth })
+	doc := operationDocs["listUsers"]
+	delete(operationDocs, "listUsers")
+	t.Cleanup(func() { operationDocs["listUsers"] = doc })
+
+	err := s.checkRouteCoverage()
+	if err == nil {
+		t.Fatal("check passed with violations")
+	}
+	for _, want := range []string{
+		"GET /stray: registered outside the route table",
+		"POST /api/v1/users: missing auth middleware",
+		`GET /api/v1/users: operation "listUsers" is not documented`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("report lacks %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// dbReporter is implemented by repositories that can describe the
+// database behind them; the in-memory one cannot.
+type dbReporter interface {
+	Report(ctx context.Context) *repository.DBReport
+}
+
+// dbReport serves GET /admin/db/report, as JSON or with ?format=text as
+// the same table the dbreport subcommand prints.
+func (s *Server) dbReport(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := s.repo.(dbReporter)
+	if !ok {
+		respondError(w, r, codeNotFound, "the configured repository has no database to report on")
+		return
+	}
+
+	rep := reporter.Report(r.Context())
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		writeJSON(w, r, http.StatusOK, rep)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if err := rep.WriteText(w); err != nil {
+			s.reqLog(r).Error().Err(err).Msg("failed to write database report")
+		}
+	default:
+		respondError(w, r, codeInvalidParameter, "format must be json or text")
+	}
+}
+
+// indexAdvisor is implemented by repositories that record which filters
+// and sorts their queries use and can compare them with the indexes.
+type indexAdvisor interface {
+	IndexAdvice(ctx context.Context, minCount int64) (*repository.IndexAdvice, error)
+}
+
+// defaultAdviceMinCount is how often a shape has to run within the window
+// to be reported, unless ?min_count= says otherwise.
+const defaultAdviceMinCount = 10
+
+// indexAdvice serves GET /admin/db/index-advisor: the frequent filter and
+// sort combinations no index supports, with suggested CREATE INDEX
+// statements that are never run.
+func (s *Server) indexAdvice(w http.ResponseWriter, r *http.Request) {
+	advisor, ok := s.repo.(indexAdvisor)
+	if !ok {
+		respondError(w, r, codeNotFound, "the configured repository has no indexes to advise on")
+		return
+	}
+	minCount := int64(defaultAdviceMinCount)
+	if raw := r.URL.Query().Get("min_count"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 1 {
+			respondError(w, r, codeInvalidParameter, "min_count must be a positive integer")
+			return
+		}
+		minCount = n
+	}
+
+	advice, err := advisor.IndexAdvice(r.Context(), minCount)
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to read indexes for the index advisor")
+		respondError(w, r, codeInternal, "internal error")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, advice)
+}
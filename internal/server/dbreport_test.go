@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// reportingRepo answers Report with a fixed report, as a database would.
+type reportingRepo struct {
+	*repository.Memory
+}
+
+func (reportingRepo) Report(context.Context) *repository.DBReport {
+	return &repository.DBReport{
+		ServerVersion: "16.2",
+		Tables:        []repository.TableReport{{Name: "users", LiveRows: 3}},
+		Connections:   map[string]int64{"active": 1},
+		Errors:        map[string]string{"cache_hit": "permission denied"},
+	}
+}
+
+func TestDBReport(t *testing.T) {
+	s, err := New(Config{APIKeys: testAPIKeys}, WithRepository(reportingRepo{repository.NewMemory()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/db/report", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", w.Code)
+	}
+
+	w := serve(h, http.MethodGet, "/api/v1/admin/db/report", "", apiKeyHeader, aliceKey)
+	var rep repository.DBReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("json: %d %v\n%s", w.Code, err, w.Body)
+	}
+	if rep.ServerVersion != "16.2" || len(rep.Tables) != 1 || rep.Errors["cache_hit"] == "" {
+		t.Errorf("json report %+v", rep)
+	}
+
+	w = serve(h, http.MethodGet, "/api/v1/admin/db/report?format=text", "", apiKeyHeader, aliceKey)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") ||
+		!strings.Contains(w.Body.String(), "Postgres 16.2") || !strings.Contains(w.Body.String(), "cache_hit") {
+		t.Errorf("text: %d %s\n%s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+
+	w = serve(h, http.MethodGet, "/api/v1/admin/db/report?format=xml", "", apiKeyHeader, aliceKey)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidParameter.Code) {
+		t.Errorf("unknown format: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestDBReportWithoutDatabase(t *testing.T) {
+	s, _ := newTestServer(t, Config{APIKeys: testAPIKeys})
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/admin/db/report", "", apiKeyHeader, aliceKey)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codeNotFound.Code) {
+		t.Errorf("in-memory repository: %d %s", w.Code, w.Body)
+	}
+}
+
+// advisingRepo answers IndexAdvice with one shape above the threshold.
+type advisingRepo struct {
+	*repository.Memory
+	minCount int64
+}
+
+func (r *advisingRepo) IndexAdvice(_ context.Context, minCount int64) (*repository.IndexAdvice, error) {
+	r.minCount = minCount
+	return &repository.IndexAdvice{MinCount: minCount, Shapes: []repository.ShapeAdvice{{
+		Filters: []string{"name"},
+		Count:   42,
+		Missing: []repository.IndexSuggestion{{For: "name search", Statement: "CREATE INDEX CONCURRENTLY users_name_trgm_idx ON users USING GIN (name gin_trgm_ops)"}},
+	}}}, nil
+}
+
+func TestIndexAdvisor(t *testing.T) {
+	repo := &advisingRepo{Memory: repository.NewMemory()}
+	s, err := New(Config{APIKeys: testAPIKeys}, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/db/index-advisor", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", w.Code)
+	}
+
+	w := serve(h, http.MethodGet, "/api/v1/admin/db/index-advisor", "", apiKeyHeader, aliceKey)
+	var advice repository.IndexAdvice
+	if err := json.Unmarshal(w.Body.Bytes(), &advice); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %v\n%s", w.Code, err, w.Body)
+	}
+	if repo.minCount != defaultAdviceMinCount || len(advice.Shapes) != 1 || advice.Shapes[0].Missing[0].For != "name search" {
+		t.Errorf("min_count %d, advice %+v", repo.minCount, advice)
+	}
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/db/index-advisor?min_count=3", "", apiKeyHeader, aliceKey); w.Code != http.StatusOK || repo.minCount != 3 {
+		t.Errorf("min_count=3: %d, passed %d", w.Code, repo.minCount)
+	}
+	for _, q := range []string{"min_count=0", "min_count=x"} {
+		w := serve(h, http.MethodGet, "/api/v1/admin/db/index-advisor?"+q, "", apiKeyHeader, aliceKey)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidParameter.Code) {
+			t.Errorf("%s: %d %s", q, w.Code, w.Body)
+		}
+	}
+
+	s, _ = newTestServer(t, Config{APIKeys: testAPIKeys})
+	if w := serve(s.Handler(), http.MethodGet, "/api/v1/admin/db/index-advisor", "", apiKeyHeader, aliceKey); w.Code != http.StatusNotFound {
+		t.Errorf("in-memory repository: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-k8s-demo/internal/timing"
+)
+
+// parseRequestTimeout reads a client deadline header. Accepted forms are
+// gRPC timeouts ("500m", "2S": up to 8 digits plus one of H M S m u n),
+// plain seconds ("3", "0.25") and Go durations ("2.5s", "1m30s"). The
+// gRPC form is tried first, so "5m" means 5 milliseconds.
+func parseRequestTimeout(v string) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if d, ok := parseGRPCTimeout(v); ok {
+		return d, true
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), f > 0 && f < 1e6
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return d, d > 0
+	}
+	return 0, false
+}
+
+var grpcTimeoutUnits = map[byte]time.Duration{
+	'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+	'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+}
+
+func parseGRPCTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	unit, ok := grpcTimeoutUnits[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	digits := v[:len(v)-1]
+	if strings.Trim(digits, "0123456789") != "" {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// effectiveTimeout combines the route budget with the client's deadline
+// header, clamped to [min, max]; the smaller one wins.
+func (s *Server) effectiveTimeout(r *http.Request, budget time.Duration) (time.Duration, string) {
+	if s.cfg.DeadlineHeader == "" {
+		return budget, "budget"
+	}
+	raw := r.Header.Get(s.cfg.DeadlineHeader)
+	if raw == "" {
+		return budget, "budget"
+	}
+	d, ok := parseRequestTimeout(raw)
+	if !ok {
+		s.reqLog(r).Debug().Str("header", s.cfg.DeadlineHeader).Str("value", raw).Msg("ignoring malformed deadline header")
+		return budget, "budget"
+	}
+	d = min(max(d, s.cfg.DeadlineMin), s.cfg.DeadlineMax)
+	if d < budget {
+		return d, "client"
+	}
+	return budget, "budget"
+}
+
+// timeoutBudget bounds the request context so repository calls are
+// cancelled once the route's budget, or the caller's shorter deadline,
+// is spent. A handler that has not started its response by then is
+// answered with 504 at the deadline, without waiting for it to return;
+// see timeoutWriter.
+func (s *Server) timeoutBudget(budget time.Duration) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, source := s.effectiveTimeout(r, min(budget, s.cfg.RequestTimeout))
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			rec := timing.FromContext(ctx)
+			rec.Add("deadline", d)
+			rec.Describe("deadline", source)
+			s.reqLog(r).Debug().Str("path", r.URL.Path).Dur("deadline", d).Str("source", source).Msg("request deadline")
+
+			tw := newTimeoutWriter(w)
+			stop := context.AfterFunc(ctx, func() {
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					tw.timeout()
+				}
+			})
+
+			r = r.WithContext(ctx)
+			next.ServeHTTP(tw, r)
+
+			stop()
+			if deadlineExceeded(r) {
+				tw.timeout()
+			}
+			tw.finish()
+			if deadlineExceeded(r) && tw.Status() == codeDeadlineExceeded.Status {
+				s.reqMetrics.timedOut.With(r.Method, routeOf(r)).Inc()
+			}
+		})
+	}
+}
+
+// deadlineExceeded reports whether the request ran out of time.
+func deadlineExceeded(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.DeadlineExceeded)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestParseRequestTimeout(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"500m":  500 * time.Millisecond,
+		"5m":    5 * time.Millisecond, // gRPC minutes are "M"
+		"2S":    2 * time.Second,
+		"1M":    time.Minute,
+		"250u":  250 * time.Microsecond,
+		" 3 ":   3 * time.Second,
+		"0.25":  250 * time.Millisecond,
+		"2.5s":  2500 * time.Millisecond,
+		"1m30s": 90 * time.Second,
+	} {
+		if got, ok := parseRequestTimeout(in); !ok || got != want {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v, want %v", in, got, ok, want)
+		}
+	}
+	for _, in := range []string{"", "soon", "-1", "0", "0m", "-2s", "1e7", "123456789S", "5x", "inf", "NaN"} {
+		if got, ok := parseRequestTimeout(in); ok {
+			t.Errorf("parseRequestTimeout(%q) = %v, want rejected", in, got)
+		}
+	}
+}
+
+// The effective deadline is reported in Server-Timing as
+// deadline;dur=<ms>;desc="client"|"budget".
+func TestClientDeadline(t *testing.T) {
+	s, repo := newTestServer(t, Config{ServerTiming: true, DeadlineHeader: "X-Request-Timeout", DeadlineMin: 50 * time.Millisecond, DeadlineMax: 2 * time.Second})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+
+	for header, want := range map[string]string{
+		"":      `deadline;dur=5000.00;desc="budget"`, // absent: the read budget
+		"200m":  `deadline;dur=200.00;desc="client"`,
+		"1m":    `deadline;dur=50.00;desc="client"`, // raised to DeadlineMin
+		"1.5":   `deadline;dur=1500.00;desc="client"`,
+		"30S":   `deadline;dur=2000.00;desc="client"`, // cut to DeadlineMax
+		"later": `deadline;dur=5000.00;desc="budget"`, // malformed: ignored
+	} {
+		w := serve(h, http.MethodGet, "/api/v1/users/1", "", "X-Request-Timeout", header)
+		if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Server-Timing"), want) {
+			t.Errorf("X-Request-Timeout %q: %d, Server-Timing %q, want %s", header, w.Code, w.Header().Get("Server-Timing"), want)
+		}
+	}
+}
+
+func TestClientDeadlineIgnoredWhenUnconfigured(t *testing.T) {
+	s, repo := newTestServer(t, Config{ServerTiming: true})
+	seedUsers(t, repo, 1)
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/users/1", "", "X-Request-Timeout", "200m")
+	if !strings.Contains(w.Header().Get("Server-Timing"), `desc="budget"`) {
+		t.Errorf("Server-Timing %q", w.Header().Get("Server-Timing"))
+	}
+}
+
+// A caller that gives up after 60ms gets its 504 then, and the
+// repository query is cancelled rather than left running.
+func TestClientDeadlineCancelsQuery(t *testing.T) {
+	s, err := New(Config{DeadlineHeader: "X-Request-Timeout", DeadlineMin: 10 * time.Millisecond}, WithRepository(blockingRepo{repository.NewMemory()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/users/1", "", "X-Request-Timeout", "60m")
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("got %d %s, want 504", w.Code, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("answered after %v, not at the client's deadline", elapsed)
+	}
+}
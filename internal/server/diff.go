@@ -0,0 +1,31 @@
+package server
+
+import (
+	"maps"
+	"reflect"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// fieldDiff is one entry of a structured user diff. The field order is
+// fixed so the output is stable for clients and golden files.
+type fieldDiff struct {
+	Field   string `json:"field"`
+	Old     any    `json:"old"`
+	New     any    `json:"new"`
+	Changed bool   `json:"changed"`
+}
+
+// diffUsers compares every user field except the id.
+func diffUsers(old, new *repository.User) []fieldDiff {
+	return []fieldDiff{
+		diffField("name", old.Name, new.Name),
+		diffField("email", old.Email, new.Email),
+		{Field: "metadata", Old: old.Metadata, New: new.Metadata, Changed: !reflect.DeepEqual(old.Metadata, new.Metadata)},
+		{Field: "labels", Old: old.Labels, New: new.Labels, Changed: !maps.Equal(old.Labels, new.Labels)},
+	}
+}
+
+func diffField[T comparable](field string, old, new T) fieldDiff {
+	return fieldDiff{Field: field, Old: old, New: new, Changed: old != new}
+}
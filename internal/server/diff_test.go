@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got, re-indented, with testdata/name.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, got, "", "  "); err != nil {
+		t.Fatalf("%s: %v\n%s", name, err, got)
+	}
+	buf.WriteByte('\n')
+
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("%s differs from the golden file (run with -update to accept):\n%s", name, buf.Bytes())
+	}
+}
+
+func TestDiffUsers(t *testing.T) {
+	s, repo := newTestServer(t, Config{APIKeys: testAPIKeys})
+	ctx := context.Background()
+	for _, u := range []struct {
+		name, email string
+		metadata    map[string]any
+		labels      map[string]string
+	}{
+		{"Ada Lovelace", "ada@example.com", map[string]any{"plan": "pro", "seats": 3}, map[string]string{"team": "core"}},
+		{"Ada Lovelace", "ada.l@example.com", map[string]any{"plan": "free", "seats": 3}, map[string]string{"team": "core"}},
+	} {
+		created, err := repo.CreateUser(ctx, u.name, u.email, u.metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.ReplaceLabels(ctx, created.ID, 0, u.labels); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+
+	for name, target := range map[string]string{
+		"diff.golden":                 "/api/v1/admin/users/1/diff?against=2",
+		"diff_self.golden":            "/api/v1/admin/users/1/diff?against=1",
+		"diff_missing_against.golden": "/api/v1/admin/users/1/diff?against=3",
+		"diff_missing_id.golden":      "/api/v1/admin/users/3/diff?against=1",
+	} {
+		w := serve(h, http.MethodGet, target, "", apiKeyHeader, aliceKey)
+		golden(t, name, w.Body.Bytes())
+	}
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/users/1/diff?against=3", "", apiKeyHeader, aliceKey); w.Code != http.StatusNotFound {
+		t.Errorf("missing counterpart: %d", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/admin/users/1/diff?version=7", "", apiKeyHeader, aliceKey); w.Code != http.StatusBadRequest {
+		t.Errorf("diff against a version: %d", w.Code)
+	}
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// dryRun reports whether the request asked to preview a write instead of
+// performing it, with ?dry_run=true or Prefer: dry-run (acknowledged with
+// Preference-Applied).
+func dryRun(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	for _, pref := range strings.Split(r.Header.Get("Prefer"), ",") {
+		if name, _, _ := strings.Cut(pref, ";"); strings.EqualFold(strings.TrimSpace(name), "dry-run") {
+			w.Header().Set("Preference-Applied", "dry-run")
+			return true, true
+		}
+	}
+	return boolQuery(w, r, "dry_run")
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A dry run answers the plan and leaves every side-effect channel alone:
+// the user, its labels and views, and the list cache.
+func TestDeleteDryRun(t *testing.T) {
+	s, repo := newTestServer(t, Config{ListCacheTTL: time.Minute})
+	h := s.Handler()
+	ctx := context.Background()
+	seedUsers(t, repo, 1)
+	if _, err := repo.ReplaceLabels(ctx, 1, 0, map[string]string{"team": "core"}); err != nil {
+		t.Fatal(err)
+	}
+	etag := serve(h, http.MethodGet, "/api/v1/users/1", "").Header().Get("ETag")
+	serve(h, http.MethodGet, "/api/v1/users", "")
+	gen := s.cache.generation()
+
+	for _, tc := range []struct {
+		name, target string
+		headers      []string
+		hard         bool
+		removed      string
+	}{
+		{"Prefer header", "/api/v1/users/1", []string{"Prefer", "return=minimal, dry-run"}, false, "{}"},
+		{"query parameter", "/api/v1/users/1?dry_run=true&hard=true", nil, true, `{"user_labels":1,"user_views":0,"users":1}`},
+		{"stale If-Match is still checked", "/api/v1/users/1?dry_run=true", []string{"If-Match", `"99"`}, false, ""},
+	} {
+		w := serve(h, http.MethodDelete, tc.target, "", tc.headers...)
+		if tc.removed == "" {
+			if w.Code != http.StatusPreconditionFailed {
+				t.Errorf("%s: %d %s, want 412", tc.name, w.Code, w.Body)
+			}
+			continue
+		}
+		var got struct {
+			DryRun bool `json:"dry_run"`
+			Plan   struct {
+				User    struct{ ID int64 }
+				Hard    bool
+				Removed json.RawMessage
+			}
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tc.name, w.Code, w.Body)
+		}
+		if !got.DryRun || got.Plan.User.ID != 1 || got.Plan.Hard != tc.hard || string(got.Plan.Removed) != tc.removed {
+			t.Errorf("%s: %s", tc.name, w.Body)
+		}
+		if tc.headers != nil && w.Header().Get("Preference-Applied") != "dry-run" {
+			t.Errorf("%s: Preference-Applied %q", tc.name, w.Header().Get("Preference-Applied"))
+		}
+	}
+
+	if w := serve(h, http.MethodGet, "/api/v1/users/1", ""); w.Code != http.StatusOK || w.Header().Get("ETag") != etag ||
+		!strings.Contains(w.Body.String(), `"team":"core"`) {
+		t.Errorf("user after dry runs: %d %s %s", w.Code, w.Header().Get("ETag"), w.Body)
+	}
+	if s.cache.generation() != gen {
+		t.Error("a dry run invalidated the list cache")
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users", ""); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("list after dry runs: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	if w := serve(h, http.MethodDelete, "/api/v1/users/99?dry_run=true", ""); w.Code != http.StatusNotFound {
+		t.Errorf("dry run for a missing user: %d", w.Code)
+	}
+	if w := serve(h, http.MethodDelete, "/api/v1/users/1?dry_run=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("dry_run=maybe: %d", w.Code)
+	}
+	// The real delete still goes through afterwards.
+	if w := serve(h, http.MethodDelete, "/api/v1/users/1", "", "If-Match", etag); w.Code != http.StatusOK || s.cache.generation() == gen {
+		t.Errorf("DELETE after dry runs: %d %s", w.Code, w.Body)
+	}
+}
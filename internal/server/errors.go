@@ -0,0 +1,133 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// apiError is one entry of the error catalogue. Every error response
+// carries its Code next to the human-readable message:
+//
+//	{"error": "user not found", "code": "user_not_found"}
+//
+// invalid_payload responses add a details array naming each offending
+// field, see fieldError.
+//
+// Codes are only ever created through defineError, so anything a handler
+// can send is listed at GET /errors.
+type apiError struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+	Retryable   bool   `json:"retryable"`
+	Since       string `json:"since"`
+}
+
+// errorCatalogue lists every defined code in definition order.
+var errorCatalogue []*apiError
+
+func defineError(code string, status int, retryable bool, since, description string) *apiError {
+	e := &apiError{Code: code, Status: status, Description: description, Retryable: retryable, Since: since}
+	errorCatalogue = append(errorCatalogue, e)
+	return e
+}
+
+var (
+	codeInvalidID        = defineError("invalid_id", http.StatusBadRequest, false, "1.0", "The user id is not in canonical form: a positive decimal integer without sign, leading zeros or whitespace.")
+	codeInvalidPayload   = defineError("invalid_payload", http.StatusBadRequest, false, "1.0", "The request body is missing, not JSON, or lacks required fields.")
+	codeInvalidName      = defineError("invalid_name", http.StatusBadRequest, false, "1.0", "The name is empty, too long or contains disallowed characters.")
+	codeInvalidMetadata  = defineError("invalid_metadata", http.StatusBadRequest, false, "1.0", "The metadata is not an object or exceeds the size, depth or key limits.")
+	codeInvalidLabels    = defineError("invalid_labels", http.StatusBadRequest, false, "1.0", "A label key or value has invalid syntax, or the user would exceed the label limit.")
+	codeInvalidFilter    = defineError("invalid_filter", http.StatusBadRequest, false, "1.0", "A list filter query parameter is malformed.")
+	codeInvalidParameter = defineError("invalid_parameter", http.StatusBadRequest, false, "1.0", "A query or body parameter has an unsupported value.")
+	codePayloadTooLarge  = defineError("payload_too_large", http.StatusRequestEntityTooLarge, false, "1.0", "The request body is larger than the server accepts.")
+
+	codeResultTooLarge = defineError("result_too_large", http.StatusUnprocessableEntity, false, "1.0", "More rows matched than the server's row cap allows, with strict row limits enabled or a collation the database lacks; narrow the query.")
+
+	codeUnauthorized    = defineError("unauthorized", http.StatusUnauthorized, false, "1.0", "The route requires a bearer token and the request has none, or it is malformed, expired, not yet valid, for another audience or issuer, or its signature does not match.")
+	codeInvalidAPIKey   = defineError("invalid_api_key", http.StatusUnauthorized, false, "1.0", "The X-API-Key header does not match any accepted key.")
+	codeAuthUnavailable = defineError("auth_unavailable", http.StatusServiceUnavailable, true, "1.0", "The keys to verify bearer tokens could not be fetched from the identity provider or read from the keys file.")
+	codeAuthKeysStatic  = defineError("auth_keys_static", http.StatusConflict, false, "1.0", "Bearer tokens are verified with the fixed JWT_SECRET, or not at all; only a keys file or JWKS can be reloaded.")
+
+	codeUserNotFound = defineError("user_not_found", http.StatusNotFound, false, "1.0", "No user exists with the given id.")
+	codeEmailInUse   = defineError("email_in_use", http.StatusConflict, false, "1.0", "Another user already has this email address.")
+	codeNotFound     = defineError("not_found", http.StatusNotFound, false, "1.0", "The requested resource does not exist.")
+
+	codeVersionConflict = defineError("version_conflict", http.StatusPreconditionFailed, false, "1.0", "The user changed since the ETag in If-Match was read; fetch it again and reapply the change.")
+	codeIfMatchRequired = defineError("if_match_required", http.StatusPreconditionRequired, false, "1.0", "Strict concurrency control is enabled and the write has no If-Match header.")
+
+	codeIdempotencyKeyRequired = defineError("idempotency_key_required", http.StatusPreconditionRequired, false, "1.0", "Strict idempotency is enabled and the request has no Idempotency-Key header.")
+	codeIdempotencyKeyReused   = defineError("idempotency_key_reused", http.StatusUnprocessableEntity, false, "1.0", "The Idempotency-Key was already used for a different request.")
+	codeRequestInProgress      = defineError("request_in_progress", http.StatusConflict, true, "1.0", "An identical request is still being processed; retry shortly.")
+
+	codeShareLinksDisabled = defineError("share_links_disabled", http.StatusServiceUnavailable, false, "1.0", "Share links are not configured on this server.")
+	codeShareLinkInvalid   = defineError("share_link_invalid", http.StatusForbidden, false, "1.0", "The share link token is malformed or its signature does not match.")
+	codeShareLinkExpired   = defineError("share_link_expired", http.StatusGone, false, "1.0", "The share link has expired.")
+	codeShareLinkUsed      = defineError("share_link_used", http.StatusGone, false, "1.0", "The one-time share link was already used.")
+
+	codeRateLimited      = defineError("rate_limited", http.StatusTooManyRequests, true, "1.0", "The client sent more requests than its rate limit allows; retry after the Retry-After header's seconds.")
+	codeFeatureDisabled  = defineError("feature_disabled", http.StatusServiceUnavailable, true, "1.0", "An optional feature is temporarily disabled because the service is overloaded; core operations still work.")
+	codeStorageFull      = defineError("storage_limit_reached", http.StatusInsufficientStorage, true, "1.0", "The users table reached its configured hard cap; writes are rejected until space is freed.")
+	codeReadOnly         = defineError("read_only", http.StatusServiceUnavailable, true, "1.0", "The service is in read-only mode (manually or because the database is read-only); reads still work, retry writes later.")
+	codeDeadlineExceeded = defineError("deadline_exceeded", http.StatusGatewayTimeout, true, "1.0", "The request did not finish within its time budget or the deadline the caller sent.")
+	codeInternal         = defineError("internal_error", http.StatusInternalServerError, true, "1.0", "An unexpected server-side failure; details are in the server log.")
+)
+
+// errorBody is the error envelope for e, for handlers that add fields.
+func errorBody(e *apiError, msg string) object {
+	return object{"error": msg, "code": e.Code}
+}
+
+// respondError writes the error envelope with e's status; the handler
+// or middleware returns after it. Internal errors caused by the request running out of
+// time are reported as deadline_exceeded instead.
+func respondError(w http.ResponseWriter, r *http.Request, e *apiError, msg string) {
+	if e == codeInternal && deadlineExceeded(r) {
+		e, msg = codeDeadlineExceeded, "request deadline exceeded"
+	}
+	writeJSON(w, r, e.Status, errorBody(e, msg))
+}
+
+// respondRetry is respondError for the retryable 429 and 503 errors:
+// every producer answers through it, so clients can back off the same way
+// everywhere. wait comes from the state that rejected the request (the
+// bucket's refill, the brownout window, the read-only window) and is
+// sent as Retry-After, in whole seconds rounded up and at least one, and
+// as retry_after_ms in the body.
+func respondRetry(w http.ResponseWriter, r *http.Request, e *apiError, msg string, wait time.Duration) {
+	ms := max(1, (wait+time.Millisecond-1)/time.Millisecond)
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((ms+999)/1000), 10))
+	body := errorBody(e, msg)
+	body["retry_after_ms"] = int64(ms)
+	writeJSON(w, r, e.Status, body)
+}
+
+func (s *Server) listErrors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, object{"errors": errorCatalogue})
+}
+
+var errorsPage = template.Must(template.New("errors").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>API error codes</title>
+<style>body{font-family:system-ui,sans-serif;max-width:60rem;margin:1rem auto}table{border-collapse:collapse;width:100%}th,td{text-align:left;padding:.4rem;border-bottom:1px solid #ddd;vertical-align:top}code{white-space:nowrap}</style>
+</head>
+<body>
+<h1>API error codes</h1>
+<p>Error responses look like <code>{"error": "message", "code": "user_not_found"}</code>. Match on <code>code</code>; the message may change.</p>
+<table>
+<tr><th>Code</th><th>Status</th><th>Retryable</th><th>Since</th><th>Description</th></tr>
+{{range .}}<tr><td><code>{{.Code}}</code></td><td>{{.Status}}</td><td>{{if .Retryable}}yes{{else}}no{{end}}</td><td>{{.Since}}</td><td>{{.Description}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func (s *Server) errorsDoc(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := errorsPage.Execute(w, errorCatalogue); err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to render error catalogue")
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestErrorCatalogue(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, e := range errorCatalogue {
+		if seen[e.Code] {
+			t.Errorf("%s is defined twice", e.Code)
+		}
+		seen[e.Code] = true
+		if e.Status < 400 || e.Status > 599 || e.Description == "" || e.Since == "" {
+			t.Errorf("incomplete entry %+v", e)
+		}
+	}
+}
+
+// Handlers cannot emit a code outside the catalogue: error codes only
+// exist as defineError results, defineError is only called to declare
+// package-level codes, and errorBody is the only place writing a "code"
+// key into a response.
+func TestErrorCodesComeFromCatalogue(t *testing.T) {
+	files, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".go") || strings.HasSuffix(f.Name(), "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, f.Name(), nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch n := n.(type) {
+				case *ast.CallExpr:
+					if id, ok := n.Fun.(*ast.Ident); ok && id.Name == "defineError" {
+						t.Errorf("%s: defineError called inside %s", fset.Position(n.Pos()), fn.Name.Name)
+					}
+				case *ast.CompositeLit:
+					sel, isH := n.Type.(*ast.SelectorExpr)
+					isH = isH && sel.Sel.Name == "H"
+					id, isResp := n.Type.(*ast.Ident)
+					isResp = isResp && id.Name == "errorResponse"
+					if !isH && !isResp || fn.Name.Name == "errorBody" {
+						return true
+					}
+					for _, elt := range n.Elts {
+						if kv, ok := elt.(*ast.KeyValueExpr); ok {
+							lit, isLit := kv.Key.(*ast.BasicLit)
+							field, isField := kv.Key.(*ast.Ident)
+							if isLit && lit.Value == `"code"` || isField && field.Name == "Code" {
+								t.Errorf("%s: %s writes an error code outside errorBody", fset.Position(kv.Pos()), fn.Name.Name)
+							}
+						}
+					}
+				case *ast.IndexExpr:
+					// body["code"] = ...
+					if lit, ok := n.Index.(*ast.BasicLit); ok && lit.Value == `"code"` {
+						t.Errorf("%s: %s writes an error code outside errorBody", fset.Position(n.Pos()), fn.Name.Name)
+					}
+				}
+				return true
+			})
+		}
+	}
+}
+
+func TestErrorCatalogueEndpoints(t *testing.T) {
+	s, _ := newTestServer(t, Config{Docs: true})
+	h := s.Handler()
+
+	var listed struct{ Errors []apiError }
+	if err := json.Unmarshal(serve(h, http.MethodGet, "/api/v1/errors", "").Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Errors) != len(errorCatalogue) {
+		t.Fatalf("GET /errors lists %d codes, the catalogue has %d", len(listed.Errors), len(errorCatalogue))
+	}
+	for i, e := range errorCatalogue {
+		if listed.Errors[i] != *e {
+			t.Errorf("GET /errors entry %d = %+v, want %+v", i, listed.Errors[i], *e)
+		}
+	}
+
+	w := serve(h, http.MethodGet, "/api/v1/docs/errors", "")
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("docs page Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	for _, e := range errorCatalogue {
+		if !strings.Contains(w.Body.String(), "<code>"+e.Code+"</code>") {
+			t.Errorf("docs page lacks %s", e.Code)
+		}
+	}
+}
+
+// Every error the OpenAPI document promises is a catalogue code answered
+// with the status the catalogue gives it.
+func TestOpenAPIErrorsMatchCatalogue(t *testing.T) {
+	byCode := make(map[string]int)
+	for _, e := range errorCatalogue {
+		byCode[e.Code] = e.Status
+	}
+
+	s, _ := newTestServer(t, Config{Docs: true})
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(serve(s.Handler(), http.MethodGet, "/api/v1/openapi.json", "").Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for path, item := range spec.Paths {
+		for method, raw := range item {
+			var op struct {
+				Responses map[string]struct {
+					Content map[string]struct {
+						Schema struct {
+							AllOf []struct {
+								Properties struct {
+									Code struct{ Enum []string }
+								}
+							}
+						}
+					}
+				}
+			}
+			if json.Unmarshal(raw, &op) != nil {
+				continue // "servers" and other non-operation members
+			}
+			for status, resp := range op.Responses {
+				for _, part := range resp.Content["application/json"].Schema.AllOf {
+					for _, code := range part.Properties.Code.Enum {
+						found++
+						if want, ok := byCode[code]; !ok || strconv.Itoa(want) != status {
+							t.Errorf("%s %s documents %s under %s", method, path, code, status)
+						}
+					}
+				}
+			}
+		}
+	}
+	if found == 0 {
+		t.Fatal("no error responses documented")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// Media types GET /users negotiates between.
+const (
+	mimeJSON = "application/json"
+	mimeCSV  = "text/csv"
+)
+
+// csvFlushRows is how many rows are buffered between flushes to the client.
+const csvFlushRows = 100
+
+// exportUsersCSV serves GET /users.csv and GET /users with Accept:
+// text/csv. It takes the list filters and sort of GET /users but not
+// pagination: every matching user is streamed as it is read, one row per
+// user with a column per repository.UserFields entry, in batches sized by
+// memory headroom. Object fields are written as JSON.
+//
+// Once the first row is out the status is committed, so a failure midway
+// can only end the download early; it is logged and the body stops.
+func (s *Server) exportUsersCSV(w http.ResponseWriter, r *http.Request) {
+	r, filter, ok := s.userFilter(w, r)
+	if !ok {
+		return
+	}
+	sort, ok := sortParam(w, r)
+	if !ok {
+		return
+	}
+	if s.brownout.disabled(featureExport) {
+		respondRetry(w, r, codeFeatureDisabled, "CSV export is temporarily disabled under load", s.brownout.retryAfter())
+		return
+	}
+
+	header := make([]string, len(repository.UserFields))
+	for i, f := range repository.UserFields {
+		header[i] = f.JSON
+	}
+
+	// The response starts with the first row, so a failing query still
+	// gets an error response.
+	var cw *csv.Writer
+	begin := func() {
+		w.Header().Set("Content-Type", mimeCSV+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+		if collation := sort.Collation(); collation != "" {
+			w.Header().Set(collationHeader, collation)
+		}
+		w.WriteHeader(http.StatusOK)
+		cw = csv.NewWriter(w)
+		cw.Write(header)
+	}
+	rows := 0
+	err := s.repo.EachUser(r.Context(), filter, sort, s.batchSize(r, "export", exportMinRows, exportMaxRows), func(u repository.User) error {
+		if cw == nil {
+			begin()
+		}
+		record, err := csvRecord(&u)
+		if err != nil {
+			return err
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+		if rows++; rows%csvFlushRows == 0 {
+			cw.Flush()
+			flush(w)
+		}
+		return cw.Error()
+	})
+	if cw == nil && err == nil {
+		begin() // no user matched: the header line alone
+	}
+	if cw == nil && errors.Is(err, repository.ErrCollationUnavailable) {
+		respondError(w, r, codeResultTooLarge, "too many users match to sort by a collation the database lacks; narrow the query")
+		return
+	}
+	if cw == nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to export users")
+		respondError(w, r, codeInternal, "failed to export users")
+		return
+	}
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int("rows", rows).Msg("user export ended early")
+		recordError(r, err)
+	}
+}
+
+// csvRecord renders u in UserFields order. encoding/csv quotes values
+// holding commas, quotes or newlines.
+func csvRecord(u *repository.User) ([]string, error) {
+	record := make([]string, len(repository.UserFields))
+	for i, f := range repository.UserFields {
+		switch v := f.Value(u).(type) {
+		case string:
+			record[i] = v
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			record[i] = v.UTC().Format(time.RFC3339Nano)
+		case *time.Time:
+			if v != nil {
+				record[i] = v.UTC().Format(time.RFC3339Nano)
+			}
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.JSON, err)
+			}
+			record[i] = string(b)
+		}
+	}
+	return record, nil
+}
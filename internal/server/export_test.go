@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// streamingRepo fails EachUser after failAfter rows (never when it is
+// negative) and GetUsers always, so an export that loaded the list
+// instead of streaming it would fail.
+type streamingRepo struct {
+	*repository.Memory
+	failAfter int
+}
+
+func (r streamingRepo) GetUsers(context.Context, repository.UserFilter, repository.Sort, int, int) ([]repository.User, bool, error) {
+	return nil, false, errors.New("the export must not load the list")
+}
+
+func (r streamingRepo) EachUser(ctx context.Context, filter repository.UserFilter, sort repository.Sort, batch repository.BatchSize, fn func(repository.User) error) error {
+	n := 0
+	return r.Memory.EachUser(ctx, filter, sort, batch, func(u repository.User) error {
+		if n == r.failAfter {
+			return errors.New("connection reset")
+		}
+		n++
+		return fn(u)
+	})
+}
+
+func TestExportNegotiation(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	seedUsers(t, repo, 2)
+	h := s.Handler()
+
+	for _, tc := range []struct {
+		target, accept, want string
+	}{
+		{"/api/v1/users", "", "application/json"},
+		{"/api/v1/users", "application/json", "application/json"},
+		{"/api/v1/users", "*/*", "application/json"},
+		{"/api/v1/users", "text/csv", "text/csv"},
+		{"/api/v1/users", "application/json, text/csv", "application/json"},
+		{"/api/v1/users", "text/csv, application/json", "text/csv"},
+		{"/api/v1/users.csv", "", "text/csv"},
+	} {
+		var headers []string
+		if tc.accept != "" {
+			headers = []string{"Accept", tc.accept}
+		}
+		w := serve(h, http.MethodGet, tc.target, "", headers...)
+		if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), tc.want) {
+			t.Errorf("%s Accept %q: %d %s, want %s", tc.target, tc.accept, w.Code, w.Header().Get("Content-Type"), tc.want)
+		}
+		if tc.target == "/api/v1/users" && !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept") {
+			t.Errorf("%s Accept %q: Vary %q lacks Accept", tc.target, tc.accept, w.Header().Values("Vary"))
+		}
+		if isCSV := tc.want == "text/csv"; isCSV != (w.Header().Get("Content-Disposition") == `attachment; filename="users.csv"`) {
+			t.Errorf("%s Accept %q: Content-Disposition %q", tc.target, tc.accept, w.Header().Get("Content-Disposition"))
+		}
+	}
+}
+
+func TestExportStreamsEscapedRows(t *testing.T) {
+	mem := repository.NewMemory()
+	s, err := New(Config{}, WithRepository(streamingRepo{Memory: mem, failAfter: -1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, name := range []string{`Lovelace, Ada`, `Grace "Amazing" Hopper`, "Two\nLines"} {
+		if _, err := mem.CreateUser(ctx, name, strings.Fields(name)[0]+"@example.com", map[string]any{"note": "a,b"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Enough rows for a flush before the end.
+	seedUsers(t, mem, csvFlushRows)
+
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/users.csv", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if !w.Flushed {
+		t.Error("rows were not flushed while streaming")
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) < 4 || rows[0][0] != repository.UserFields[0].JSON {
+		t.Fatalf("%d rows, header %v", len(rows), rows[0])
+	}
+	name := map[string]int{}
+	for i, f := range repository.UserFields {
+		name[f.JSON] = i
+	}
+	for i, want := range []string{`Lovelace, Ada`, `Grace "Amazing" Hopper`, "Two\nLines"} {
+		if got := rows[i+1][name["name"]]; got != want {
+			t.Errorf("row %d name %q, want %q", i+1, got, want)
+		}
+		if got := rows[i+1][name["metadata"]]; got != `{"note":"a,b"}` {
+			t.Errorf("row %d metadata %q", i+1, got)
+		}
+	}
+
+	// Filters apply; no match is the header alone.
+	w = serve(s.Handler(), http.MethodGet, "/api/v1/users.csv?name=nobody", "")
+	if rows, _ := csv.NewReader(w.Body).ReadAll(); w.Code != http.StatusOK || len(rows) != 1 {
+		t.Errorf("no match: %d, %d rows", w.Code, len(rows))
+	}
+	w = serve(s.Handler(), http.MethodGet, "/api/v1/users.csv?name=grace", "")
+	if rows, _ := csv.NewReader(w.Body).ReadAll(); len(rows) != 2 {
+		t.Errorf("name filter: %d rows, want the header and Grace", len(rows))
+	}
+}
+
+// Before the first row a failure is an ordinary error response; after it
+// the download just ends short.
+func TestExportFailure(t *testing.T) {
+	mem := repository.NewMemory()
+	seedUsers(t, mem, 3)
+
+	export := func(failAfter int) *httptest.ResponseRecorder {
+		s, err := New(Config{}, WithRepository(streamingRepo{Memory: mem, failAfter: failAfter}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serve(s.Handler(), http.MethodGet, "/api/v1/users.csv", "")
+	}
+
+	w := export(0)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), codeInternal.Code) || w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("failure before the first row: %d %s", w.Code, w.Body)
+	}
+	w = export(2)
+	rows, _ := csv.NewReader(w.Body).ReadAll()
+	if w.Code != http.StatusOK || len(rows) != 3 {
+		t.Errorf("failure midway: %d with %d rows, want 200 with the header and 2 rows", w.Code, len(rows))
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/supervisor"
+)
+
+// growthMonitor periodically samples the size of every table, exports it
+// as metrics, warns when a table crosses the row or size threshold, and
+// flips the service into write protection once the users table reaches
+// the hard row cap. A nil *growthMonitor never rejects writes.
+type growthMonitor struct {
+	repo     repository.UserRepository
+	log      zerolog.Logger
+	interval time.Duration
+
+	// Zero disables the corresponding threshold.
+	warnRows  int64
+	warnBytes int64
+	capRows   int64
+
+	writeProtected atomic.Bool
+
+	rows  *metrics.GaugeVec
+	bytes *metrics.GaugeVec
+}
+
+func newGrowthMonitor(repo repository.UserRepository, logger zerolog.Logger, interval time.Duration, warnRows, warnBytes, capRows int64) *growthMonitor {
+	return &growthMonitor{
+		repo:      repo,
+		log:       logger,
+		interval:  interval,
+		warnRows:  warnRows,
+		warnBytes: warnBytes,
+		capRows:   capRows,
+	}
+}
+
+func (m *growthMonitor) registerMetrics(reg *metrics.Registry) {
+	if m == nil {
+		return
+	}
+	m.rows = reg.Gauge("table_rows", "Estimated rows per table, from the last growth check.", "table")
+	m.bytes = reg.Gauge("table_size_bytes", "On-disk size per table including indexes, from the last growth check.", "table")
+	reg.GaugeFunc("write_protection", "1 while creates are rejected because the users table hit its hard cap.",
+		func() float64 { return boolGauge(m.rejectWrites()) })
+}
+
+// run checks immediately and then every interval until ctx is cancelled.
+func (m *growthMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.check(ctx); err != nil && ctx.Err() == nil {
+			m.log.Error().Err(err).Msg("table growth check failed")
+		}
+		supervisor.Beat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *growthMonitor) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	stats, err := m.repo.TableStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	var rows int64 // of users, which the cap applies to
+	for _, st := range stats {
+		if m.rows != nil {
+			m.rows.With(st.Table).Set(float64(st.Rows))
+			m.bytes.With(st.Table).Set(float64(st.Bytes))
+		}
+		if m.warnRows > 0 && st.Rows >= m.warnRows {
+			m.log.Warn().Str("table", st.Table).Int64("rows", st.Rows).Int64("threshold", m.warnRows).Msg("table row count above threshold")
+		}
+		if m.warnBytes > 0 && st.Bytes >= m.warnBytes {
+			m.log.Warn().Str("table", st.Table).Int64("bytes", st.Bytes).Int64("threshold", m.warnBytes).Msg("table size above threshold")
+		}
+		if st.Table == "users" {
+			rows = st.Rows
+		}
+	}
+
+	protect := m.capRows > 0 && rows >= m.capRows
+	if m.writeProtected.Swap(protect) != protect {
+		if protect {
+			m.log.Warn().Int64("rows", rows).Int64("cap", m.capRows).Msg("users table hit hard cap, rejecting creates")
+		} else {
+			m.log.Info().Int64("rows", rows).Msg("users table back under hard cap, accepting creates")
+		}
+	}
+
+	return nil
+}
+
+// rejectWrites reports whether creates should currently be refused.
+func (m *growthMonitor) rejectWrites() bool {
+	return m != nil && m.writeProtected.Load()
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestGrowthWriteProtection(t *testing.T) {
+	s, repo := newTestServer(t, Config{TableRowsHardCap: 2})
+	ctx := context.Background()
+	create := func(email string) int {
+		return serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"U","email":"`+email+`"}`).Code
+	}
+
+	if code := create("a@example.com"); code != http.StatusCreated {
+		t.Fatalf("create under the cap: %d", code)
+	}
+	if err := s.growth.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if code := create("b@example.com"); code != http.StatusCreated {
+		t.Fatalf("create reaching the cap: %d", code)
+	}
+
+	// The check that sees the cap reached turns creates away.
+	if err := s.growth.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if code := create("c@example.com"); code != http.StatusInsufficientStorage {
+		t.Errorf("create over the cap: %d, want 507", code)
+	}
+	if w := serve(s.Handler(), http.MethodPost, "/api/v1/users/batch", `[{"name":"U","email":"d@example.com"}]`); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("batch over the cap: %d, want 507", w.Code)
+	}
+	// Reads and other writes are unaffected.
+	if w := serve(s.Handler(), http.MethodPatch, "/api/v1/users/1", `{"name":"V"}`); w.Code != http.StatusOK {
+		t.Errorf("patch under write protection: %d", w.Code)
+	}
+
+	if err := repo.HardDeleteUser(ctx, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.growth.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if code := create("c@example.com"); code != http.StatusCreated {
+		t.Errorf("create after dropping under the cap: %d", code)
+	}
+}
+
+func TestGrowthMetricsCoverEveryTable(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	ctx := context.Background()
+	u, err := repo.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ReplaceLabels(ctx, u.ID, 0, map[string]string{"team": "core", "env": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.IncrementViews(ctx, u.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ConsumeShareLink(ctx, "nonce", u.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.growth.check(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	body := serve(s.Handler(), http.MethodGet, "/metrics", "").Body.String()
+	for _, line := range []string{
+		`table_rows{table="users"} 1`,
+		`table_rows{table="user_labels"} 2`,
+		`table_rows{table="user_views"} 1`,
+		`table_rows{table="share_link_uses"} 1`,
+		`table_size_bytes{table="users"} `,
+		"\nwrite_protection 0\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+}
+
+func TestGrowthWarningThresholds(t *testing.T) {
+	repo := repository.NewMemory()
+	if _, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	// TestMain silences logging globally.
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	defer zerolog.SetGlobalLevel(zerolog.Disabled)
+	var logs bytes.Buffer
+	m := newGrowthMonitor(repo, zerolog.New(&logs), time.Minute, 1, 1<<30, 0)
+	if err := m.check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	out := logs.String()
+	if !strings.Contains(out, `"table":"users"`) || !strings.Contains(out, "table row count above threshold") {
+		t.Errorf("no row warning for users:\n%s", out)
+	}
+	if strings.Contains(out, `"table":"user_views"`) || strings.Contains(out, "table size above threshold") {
+		t.Errorf("warned below a threshold:\n%s", out)
+	}
+	if m.rejectWrites() {
+		t.Error("write protection without a cap")
+	}
+}
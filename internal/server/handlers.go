@@ -0,0 +1,926 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/timing"
+)
+
+// ---------------------------------------------------------
+// HANDLERS
+// ---------------------------------------------------------
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, object{"status": "healthy"})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeJSON(w, r, http.StatusServiceUnavailable, object{"ready": false, "shutting_down": true})
+		return
+	}
+
+	// Simple readiness probe that checks DB connectivity.
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ReadinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := s.repo.Ping(ctx); err != nil {
+		latency := time.Since(start)
+		s.reqLog(r).Warn().Err(err).Str("check", "database").Dur("latency", latency).Msg("readiness probe failed")
+		s.probes.record(probeFailure{
+			Time:      start,
+			Probe:     "readyz",
+			Check:     "database",
+			LatencyMS: float64(latency) / float64(time.Millisecond),
+			Error:     err.Error(),
+		})
+		writeJSON(w, r, http.StatusServiceUnavailable, object{"ready": false})
+		return
+	}
+
+	resp := object{"ready": true}
+	status := http.StatusOK
+	if s.cfg.SchemaVersion > 0 {
+		resp["schema_version"] = s.cfg.SchemaVersion
+	}
+	if stale := s.workers.Stale(); len(stale) > 0 {
+		lag := make(object, len(stale))
+		for _, w := range stale {
+			lag[w.Name] = w.LagSeconds
+			if w.Critical {
+				resp["ready"], status = false, http.StatusServiceUnavailable
+			}
+		}
+		resp["stale_workers"] = lag
+	}
+	if s.clock.exceeded() {
+		resp["clock_skew_seconds"] = s.clock.seconds()
+	}
+	if level, shed := s.brownout.state(); level > 0 {
+		resp["brownout"] = object{"level": level, "shed": shed}
+	}
+	if s.readOnly.active() {
+		resp["read_only"] = s.readOnly.state()
+	}
+	writeJSON(w, r, status, resp)
+}
+
+func (s *Server) probeFailures(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, object{"failures": s.probes.recent()})
+}
+
+func (s *Server) featureMatrix(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, s.cfg.Features)
+}
+
+func (s *Server) processConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, s.cfg.Process)
+}
+
+func (s *Server) workerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, object{"workers": s.workers.Status()})
+}
+
+// maxSearchLength bounds the ?name= and ?email= substring filters.
+const maxSearchLength = 254
+
+// listUsers also serves HEAD /users: net/http discards the body of HEAD
+// responses but still reports the Content-Length the GET would have produced.
+// Accept: text/csv gets the CSV export instead of JSON.
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	if negotiate(r, mimeJSON, mimeCSV) == mimeCSV {
+		s.exportUsersCSV(w, r)
+		return
+	}
+
+	r, filter, ok := s.userFilter(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	pg, err := pageParams(r.URL.Query())
+	if err != nil {
+		respondError(w, r, codeInvalidParameter, err.Error())
+		return
+	}
+	sort, ok := sortParam(w, r)
+	if !ok {
+		return
+	}
+	if sort != nil && pg.cursor {
+		// Cursors encode an id, which only resumes id order; a collation
+		// only comes with a sort, so it never needs to be in one.
+		respondError(w, r, codeInvalidParameter, "sort cannot be combined with after; use limit and offset")
+		return
+	}
+
+	// Looked up only once the request is known to be valid, so a cached
+	// body never answers a request that should fail.
+	key := cacheKey(r)
+	if s.cache != nil {
+		if bypassCache(r) {
+			s.cache.count("bypass")
+		} else {
+			start := time.Now()
+			body, header, ok := s.cache.get(key)
+			timing.Since(ctx, "cache", start)
+			if ok {
+				s.cache.count("hit")
+				timing.FromContext(ctx).Describe("cache", "hit")
+				for name, values := range header {
+					w.Header().Set(name, values[0])
+				}
+				w.Header().Set("X-Cache", "HIT")
+				writeData(w, http.StatusOK, "application/json; charset=utf-8", body)
+				return
+			}
+			s.cache.count("miss")
+			timing.FromContext(ctx).Describe("cache", "miss")
+		}
+	}
+
+	gen := s.cache.generation()
+	var (
+		users     []repository.User
+		truncated bool
+		result    any
+	)
+	if pg.cursor {
+		// One extra row tells whether another page follows.
+		users, err = s.repo.GetUsersAfter(ctx, filter, pg.after, pg.limit+1)
+		var next string
+		if len(users) > pg.limit {
+			users = users[:pg.limit]
+			next = encodeCursor(users[len(users)-1].ID)
+		}
+		if users == nil {
+			users = []repository.User{}
+		}
+		result = userPage{Users: users, NextCursor: next}
+	} else {
+		users, truncated, err = s.repo.GetUsers(ctx, filter, sort, pg.limit, pg.offset)
+		result = users
+	}
+	// Keyset pages skip the count: avoiding a count(*) over the whole
+	// table is what cursors are for.
+	var total int64
+	if err == nil && !pg.cursor {
+		total, err = s.repo.CountUsers(ctx, filter)
+	}
+	if errors.Is(err, repository.ErrCollationUnavailable) {
+		respondError(w, r, codeResultTooLarge, "too many users match to sort by a collation the database lacks; narrow the query")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to get users")
+		respondError(w, r, codeInternal, "failed to fetch users")
+		return
+	}
+	if truncated && s.cfg.StrictRowLimit {
+		respondError(w, r, codeResultTooLarge, "more users match than a single response may return")
+		return
+	}
+
+	start := time.Now()
+	body, err := json.Marshal(result)
+	timing.Since(ctx, "render", start)
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to encode users")
+		respondError(w, r, codeInternal, "failed to fetch users")
+		return
+	}
+	// Kept with the cached body so a HIT repeats them.
+	header := http.Header{}
+	if !pg.cursor {
+		header.Set("X-Total-Count", strconv.FormatInt(total, 10))
+	}
+	if truncated {
+		header.Set("X-Result-Truncated", "true")
+	}
+	if collation := sort.Collation(); collation != "" {
+		header.Set(collationHeader, collation)
+	}
+	for name, values := range header {
+		w.Header().Set(name, values[0])
+	}
+	s.cache.put(key, gen, body, header)
+
+	w.Header().Set("X-Cache", "MISS")
+	writeData(w, http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// userFilter reads the list filters shared by every representation of
+// GET /users, answering 400 when one is malformed. The request it returns
+// carries the caller when the filters take credentials.
+func (s *Server) userFilter(w http.ResponseWriter, r *http.Request) (*http.Request, repository.UserFilter, bool) {
+	mdFilter, err := metadataFilter(r.URL.Query())
+	if err != nil {
+		respondError(w, r, codeInvalidFilter, err.Error())
+		return nil, repository.UserFilter{}, false
+	}
+	if len(mdFilter) > 0 && s.brownout.disabled(featureMetadataFilter) {
+		respondRetry(w, r, codeFeatureDisabled, "metadata filters are temporarily disabled under load", s.brownout.retryAfter())
+		return nil, repository.UserFilter{}, false
+	}
+	labels, err := labelSelectors(r.URL.Query())
+	if err != nil {
+		respondError(w, r, codeInvalidFilter, err.Error())
+		return nil, repository.UserFilter{}, false
+	}
+	// Names are stored in NFC, so the search text must be too for a
+	// decomposed query to find them.
+	name, email := norm.NFC.String(r.URL.Query().Get("name")), r.URL.Query().Get("email")
+	if len(name) > maxSearchLength || len(email) > maxSearchLength {
+		respondError(w, r, codeInvalidFilter, "name and email filters are limited to "+strconv.Itoa(maxSearchLength)+" bytes")
+		return nil, repository.UserFilter{}, false
+	}
+	if (name != "" || email != "") && s.brownout.disabled(featureSearch) {
+		respondRetry(w, r, codeFeatureDisabled, "name and email search is temporarily disabled under load", s.brownout.retryAfter())
+		return nil, repository.UserFilter{}, false
+	}
+	includeDeleted, ok := boolQuery(w, r, "include_deleted")
+	if !ok {
+		return nil, repository.UserFilter{}, false
+	}
+	// Soft-deleted users are for admins: the list itself is open, but
+	// including them takes the credentials of an Auth route.
+	if includeDeleted {
+		if r, ok = s.checkCredentials(w, r); !ok {
+			return nil, repository.UserFilter{}, false
+		}
+	}
+	return r, repository.UserFilter{Metadata: mdFilter, Labels: labels, Name: name, Email: email, IncludeDeleted: includeDeleted}, true
+}
+
+// collationHeader echoes the collation a sorted response was ordered by.
+const collationHeader = "X-Collation"
+
+// sortParam reads ?sort= and ?collation=, shared by every representation
+// of GET /users, answering 400 when either is malformed.
+func sortParam(w http.ResponseWriter, r *http.Request) (repository.Sort, bool) {
+	sort, err := repository.ParseSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		respondError(w, r, codeInvalidParameter, err.Error())
+		return nil, false
+	}
+	if collation := r.URL.Query().Get("collation"); collation != "" {
+		if sort, err = sort.WithCollation(collation); err != nil {
+			respondError(w, r, codeInvalidParameter, err.Error())
+			return nil, false
+		}
+	}
+	return sort, true
+}
+
+// boolQuery reads an optional true/false query parameter, answering
+// invalid_parameter for anything else.
+func boolQuery(w http.ResponseWriter, r *http.Request, key string) (bool, bool) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return false, true
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		respondError(w, r, codeInvalidParameter, key+" must be true or false")
+		return false, false
+	}
+	return b, true
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := s.repo.GetUserByID(r.Context(), id)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to get user")
+		respondError(w, r, codeInternal, "failed to fetch user")
+		return
+	}
+
+	userValidators(w, u)
+	writeJSON(w, r, http.StatusOK, u)
+}
+
+// headUser answers with the headers GET would send: validators and the
+// Content-Length of the same body, which takes the row itself rather than
+// an existence check.
+func (s *Server) headUser(w http.ResponseWriter, r *http.Request) {
+	id, err := parseUserID(r.PathValue("id"), s.cfg.MaxUserID)
+	if errors.Is(err, errImplausibleID) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.repo.GetUserByID(r.Context(), id)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to get user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(u)
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to encode user")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userValidators(w, u)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	if s.growth.rejectWrites() {
+		respondError(w, r, codeStorageFull, "user storage limit reached")
+		return
+	}
+
+	var payload userRequest
+
+	if !bindJSON(w, r, &payload) {
+		return
+	}
+
+	name, err := normalizeName(payload.Name)
+	if err != nil {
+		respondError(w, r, codeInvalidName, "invalid name: "+err.Error())
+		return
+	}
+
+	metadata, err := decodeMetadata(payload.Metadata)
+	if err == nil && metadata != nil {
+		err = validateMetadata(metadata)
+	}
+	if err != nil {
+		respondError(w, r, codeInvalidMetadata, err.Error())
+		return
+	}
+
+	u, err := s.repo.CreateUser(r.Context(), name, payload.Email, metadata)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrEmailAlreadyExists) {
+		respondError(w, r, codeEmailInUse, "email already in use")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to create user")
+		respondError(w, r, codeInternal, "failed to create user")
+		return
+	}
+	s.cache.invalidate()
+
+	w.Header().Set("Location", s.link(r, "/users/"+strconv.FormatInt(u.ID, 10)))
+	userValidators(w, u)
+	writeJSON(w, r, http.StatusCreated, u)
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := s.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var payload userRequest
+
+	if !bindJSON(w, r, &payload) {
+		return
+	}
+
+	name, err := normalizeName(payload.Name)
+	if err != nil {
+		respondError(w, r, codeInvalidName, "invalid name: "+err.Error())
+		return
+	}
+
+	metadata, err := decodeMetadata(payload.Metadata)
+	if err == nil && metadata != nil {
+		err = validateMetadata(metadata)
+	}
+	if err != nil {
+		respondError(w, r, codeInvalidMetadata, err.Error())
+		return
+	}
+
+	u, err := s.repo.UpdateUser(r.Context(), id, version, name, payload.Email, metadata)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrEmailAlreadyExists) {
+		respondError(w, r, codeEmailInUse, "email already in use")
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to update user")
+		respondError(w, r, codeInternal, "failed to update user")
+		return
+	}
+	s.cache.invalidate()
+
+	// "updated" predates the user echo; clients still read it.
+	w.Header().Set("ETag", userETag(u.Version))
+	writeJSON(w, r, http.StatusOK, userUpdated{Updated: true, User: u})
+}
+
+// patchUser changes name and/or email; absent fields are left alone, while
+// present ones are validated like in PUT.
+func (s *Server) patchUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := s.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var payload patchUserRequest
+	if !bindJSON(w, r, &payload) {
+		return
+	}
+	if payload.Name == nil && payload.Email == nil {
+		respondError(w, r, codeInvalidPayload, "body must set name or email")
+		return
+	}
+
+	if payload.Name != nil {
+		name, err := normalizeName(*payload.Name)
+		if err != nil {
+			respondError(w, r, codeInvalidName, "invalid name: "+err.Error())
+			return
+		}
+		payload.Name = &name
+	}
+
+	u, err := s.repo.PatchUser(r.Context(), id, version, payload.Name, payload.Email)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrEmailAlreadyExists) {
+		respondError(w, r, codeEmailInUse, "email already in use")
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to patch user")
+		respondError(w, r, codeInternal, "failed to update user")
+		return
+	}
+	s.cache.invalidate()
+
+	w.Header().Set("ETag", userETag(u.Version))
+	writeJSON(w, r, http.StatusOK, userUpdated{Updated: true, User: u})
+}
+
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := s.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	// Soft delete unless ?hard=true; see restoreUser.
+	hard, ok := boolQuery(w, r, "hard")
+	if !ok {
+		return
+	}
+	dry, ok := dryRun(w, r)
+	if !ok {
+		return
+	}
+	if dry {
+		s.planDeleteUser(w, r, id, version, hard)
+		return
+	}
+	del := s.repo.DeleteUser
+	if hard {
+		del = s.repo.HardDeleteUser
+	}
+	err := del(r.Context(), id, version)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to delete user")
+		respondError(w, r, codeInternal, "failed to delete user")
+		return
+	}
+	s.cache.invalidate()
+
+	writeJSON(w, r, http.StatusOK, userDeleted{Deleted: true})
+}
+
+// planDeleteUser answers a dry-run DELETE with what it would have done.
+// Nothing is written and the list cache is left alone.
+func (s *Server) planDeleteUser(w http.ResponseWriter, r *http.Request, id, version int64, hard bool) {
+	plan, err := s.repo.PlanDeleteUser(r.Context(), id, version, hard)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to plan user deletion")
+		respondError(w, r, codeInternal, "failed to plan deletion")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, deletePlanned{DryRun: true, Plan: plan})
+}
+
+// restoreUser undoes a soft delete; the email was kept reserved, so it
+// cannot conflict.
+func (s *Server) restoreUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := s.repo.RestoreUser(r.Context(), id)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to restore user")
+		respondError(w, r, codeInternal, "failed to restore user")
+		return
+	}
+	s.cache.invalidate()
+
+	w.Header().Set("ETag", userETag(u.Version))
+	writeJSON(w, r, http.StatusOK, u)
+}
+
+func (s *Server) getViews(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	views, err := s.repo.GetViews(r.Context(), id)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to get views")
+		respondError(w, r, codeInternal, "failed to fetch views")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, viewCount{Views: views + s.views.queuedFor(id)})
+}
+
+func (s *Server) addView(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if s.views != nil {
+		// Batched: check existence now so missing users still get a 404,
+		// then let the batcher write the increment later.
+		exists, err := s.repo.UserExists(r.Context(), id)
+		if err != nil {
+			s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to check user existence")
+			respondError(w, r, codeInternal, "failed to record view")
+			return
+		}
+		if !exists {
+			respondError(w, r, codeUserNotFound, "user not found")
+			return
+		}
+
+		s.views.add(id)
+		writeJSON(w, r, http.StatusAccepted, viewQueued{Queued: true})
+		return
+	}
+
+	views, err := s.repo.IncrementViews(r.Context(), id, 1)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to record view")
+		respondError(w, r, codeInternal, "failed to record view")
+		return
+	}
+
+	writeJSON(w, r, http.StatusOK, viewCount{Views: views})
+}
+
+// patchMetadata merges the body into the user's metadata; null values
+// delete keys.
+func (s *Server) patchMetadata(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := s.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	raw, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+
+	patch, err := decodeMetadata(raw)
+	if err == nil && patch == nil {
+		err = metadataErrorf("metadata must be a JSON object")
+	}
+	if err != nil {
+		respondError(w, r, codeInvalidMetadata, err.Error())
+		return
+	}
+
+	set, del := splitMetadataPatch(patch)
+	metadata, err := s.repo.PatchMetadata(r.Context(), id, version, set, del, validateMetadata)
+
+	if s.writeRejected(w, r, err) {
+		return
+	}
+
+	var mdErr *metadataError
+	switch {
+	case errors.As(err, &mdErr):
+		respondError(w, r, codeInvalidMetadata, mdErr.Error())
+		return
+	case errors.Is(err, repository.ErrUserNotFound):
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	case errors.Is(err, repository.ErrVersionConflict):
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	case err != nil:
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to patch metadata")
+		respondError(w, r, codeInternal, "failed to update metadata")
+		return
+	}
+	s.cache.invalidate()
+
+	writeJSON(w, r, http.StatusOK, userMetadata{Metadata: metadata})
+}
+
+// diffUser compares a user with another one (?against=:otherId), e.g. to
+// inspect suspected duplicates.
+func (s *Server) diffUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	if r.URL.Query().Get("version") != "" {
+		respondError(w, r, codeInvalidParameter, "diffing against a version requires audit history, which is not recorded")
+		return
+	}
+
+	otherID, err := parseUserID(r.URL.Query().Get("against"), s.cfg.MaxUserID)
+	if errors.Is(err, errImplausibleID) {
+		body := errorBody(codeUserNotFound, "user not found")
+		body["missing"] = "against"
+		writeJSON(w, r, codeUserNotFound.Status, body)
+		return
+	}
+	if err != nil {
+		respondError(w, r, codeInvalidParameter, "against must be a user id")
+		return
+	}
+
+	ctx := r.Context()
+	sides := []struct {
+		name string
+		id   int64
+		user *repository.User
+	}{{name: "id", id: id}, {name: "against", id: otherID}}
+
+	for i := range sides {
+		u, err := s.repo.GetUserByID(ctx, sides[i].id)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			body := errorBody(codeUserNotFound, "user not found")
+			body["missing"] = sides[i].name
+			writeJSON(w, r, codeUserNotFound.Status, body)
+			return
+		}
+		if err != nil {
+			s.reqLog(r).Error().Err(err).Int64("id", sides[i].id).Msg("failed to get user for diff")
+			respondError(w, r, codeInternal, "failed to fetch user")
+			return
+		}
+		sides[i].user = u
+	}
+
+	writeJSON(w, r, http.StatusOK, userDiff{
+		ID:      id,
+		Against: otherID,
+		Fields:  diffUsers(sides[0].user, sides[1].user),
+	})
+}
+
+func (s *Server) createShareLink(w http.ResponseWriter, r *http.Request) {
+	if s.share == nil {
+		respondError(w, r, codeShareLinksDisabled, "share links are not configured")
+		return
+	}
+
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+
+	var payload shareLinkRequest
+	// An empty body takes every default.
+	body, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	if err := decodeJSON(body, &payload); err != nil && !errors.Is(err, io.EOF) {
+		respondPayloadError(w, r, &payload, err)
+		return
+	}
+
+	var err error
+	ttl := s.cfg.ShareLinkDefaultTTL
+	if payload.ExpiresIn != "" {
+		if ttl, err = time.ParseDuration(payload.ExpiresIn); err != nil || ttl <= 0 || ttl > s.cfg.ShareLinkMaxTTL {
+			respondError(w, r, codeInvalidParameter, "expires_in must be a duration up to "+s.cfg.ShareLinkMaxTTL.String())
+			return
+		}
+	}
+
+	if len(payload.Fields) == 0 {
+		payload.Fields = []string{"name"}
+	}
+	for _, f := range payload.Fields {
+		if !shareableFields[f] {
+			respondError(w, r, codeInvalidParameter, "field "+strconv.Quote(f)+" cannot be shared")
+			return
+		}
+	}
+
+	exists, err := s.repo.UserExists(r.Context(), id)
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to check user existence")
+		respondError(w, r, codeInternal, "failed to create share link")
+		return
+	}
+	if !exists {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	claims := shareClaims{UserID: id, Expires: expires.Unix(), Fields: payload.Fields}
+	if payload.OneTime {
+		if claims.Nonce, err = newShareNonce(); err != nil {
+			s.reqLog(r).Error().Err(err).Msg("failed to generate share link nonce")
+			respondError(w, r, codeInternal, "failed to create share link")
+			return
+		}
+	}
+
+	token, err := s.share.sign(claims)
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to sign share link")
+		respondError(w, r, codeInternal, "failed to create share link")
+		return
+	}
+
+	// Audit trail for handing out access.
+	s.reqLog(r).Info().
+		Int64("id", id).
+		Strs("fields", payload.Fields).
+		Time("expires_at", expires).
+		Bool("one_time", payload.OneTime).
+		Str("client_ip", s.clientIP(r)).
+		Msg("share link created")
+
+	writeJSON(w, r, http.StatusCreated, shareLink{
+		URL:       s.link(r, "/shared/"+token),
+		ExpiresAt: expires,
+		Fields:    payload.Fields,
+		OneTime:   payload.OneTime,
+	})
+}
+
+// sharedUser serves the read-only, redacted view behind a share link.
+func (s *Server) sharedUser(w http.ResponseWriter, r *http.Request) {
+	if s.share == nil {
+		respondError(w, r, codeShareLinksDisabled, "share links are not configured")
+		return
+	}
+
+	claims, err := s.share.verify(r.PathValue("token"), time.Now())
+	switch {
+	case errors.Is(err, errShareExpired):
+		respondError(w, r, codeShareLinkExpired, err.Error())
+		return
+	case err != nil:
+		respondError(w, r, codeShareLinkInvalid, err.Error())
+		return
+	}
+
+	ctx := r.Context()
+	if claims.Nonce != "" {
+		first, err := s.repo.ConsumeShareLink(ctx, claims.Nonce, claims.UserID, time.Unix(claims.Expires, 0))
+		if s.writeRejected(w, r, err) {
+			return
+		}
+		if err != nil {
+			s.reqLog(r).Error().Err(err).Msg("failed to consume share link")
+			respondError(w, r, codeInternal, "failed to load shared user")
+			return
+		}
+		if !first {
+			respondError(w, r, codeShareLinkUsed, "share link was already used")
+			return
+		}
+	}
+
+	u, err := s.repo.GetUserByID(ctx, claims.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	}
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Int64("id", claims.UserID).Msg("failed to get shared user")
+		respondError(w, r, codeInternal, "failed to load shared user")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, redactUser(u, claims.Fields))
+}
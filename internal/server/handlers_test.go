@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestHeadUserMatchesGet(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	u, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", map[string]any{"team": "core"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/users/" + strconv.FormatInt(u.ID, 10)
+
+	get := serve(s.Handler(), http.MethodGet, path, "")
+	head := serve(s.Handler(), http.MethodHead, path, "")
+	if get.Code != http.StatusOK || head.Code != http.StatusOK {
+		t.Fatalf("status: GET %d, HEAD %d", get.Code, head.Code)
+	}
+	if head.Body.Len() != 0 {
+		t.Errorf("HEAD wrote a body of %d bytes", head.Body.Len())
+	}
+	for _, h := range []string{"ETag", "Last-Modified", "Content-Type"} {
+		if g, hd := get.Header().Get(h), head.Header().Get(h); g == "" || g != hd {
+			t.Errorf("%s: GET %q, HEAD %q", h, g, hd)
+		}
+	}
+	if want := strconv.Itoa(get.Body.Len()); head.Header().Get("Content-Length") != want {
+		t.Errorf("HEAD Content-Length = %q, want %s", head.Header().Get("Content-Length"), want)
+	}
+}
+
+func TestHeadUserMissing(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	for path, want := range map[string]int{
+		"/api/v1/users/1":   http.StatusNotFound,
+		"/api/v1/users/abc": http.StatusBadRequest,
+	} {
+		if w := serve(s.Handler(), http.MethodHead, path, ""); w.Code != want {
+			t.Errorf("HEAD %s = %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	if _, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	steps := []struct {
+		body       string
+		status     int
+		code       string
+		name, mail string
+	}{
+		{`{"name":"Ada Lovelace"}`, http.StatusOK, "", "Ada Lovelace", "ada@example.com"},
+		{`{"email":"ada@lovelace.org"}`, http.StatusOK, "", "Ada Lovelace", "ada@lovelace.org"},
+		{`{"name":"Countess","email":"countess@example.com"}`, http.StatusOK, "", "Countess", "countess@example.com"},
+		{`{"name":""}`, http.StatusBadRequest, "invalid_name", "Countess", "countess@example.com"},
+		{`{"email":"not-an-email"}`, http.StatusBadRequest, "", "Countess", "countess@example.com"},
+		{`{}`, http.StatusBadRequest, "invalid_payload", "Countess", "countess@example.com"},
+		{`{"unknown":"x"}`, http.StatusBadRequest, "", "Countess", "countess@example.com"},
+	}
+	for _, st := range steps {
+		w := serve(h, http.MethodPatch, "/api/v1/users/1", st.body)
+		if w.Code != st.status || (st.code != "" && !strings.Contains(w.Body.String(), `"code":"`+st.code+`"`)) {
+			t.Errorf("PATCH %s: %d %s, want %d %s", st.body, w.Code, w.Body, st.status, st.code)
+		}
+		u, err := repo.GetUserByID(context.Background(), 1)
+		if err != nil || u.Name != st.name || u.Email != st.mail {
+			t.Errorf("after PATCH %s: %+v, %v; want %s <%s>", st.body, u, err, st.name, st.mail)
+		}
+	}
+
+	if w := serve(h, http.MethodPatch, "/api/v1/users/2", `{"name":"Nobody"}`); w.Code != http.StatusNotFound {
+		t.Errorf("PATCH missing user: %d", w.Code)
+	}
+}
+
+func TestDuplicateEmail(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	for _, email := range []string{"ada@example.com", "grace@example.com"} {
+		if _, err := repo.CreateUser(context.Background(), "User", email, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`},
+		{http.MethodPut, "/api/v1/users/2", `{"name":"Grace","email":"ada@example.com"}`},
+		{http.MethodPatch, "/api/v1/users/2", `{"email":"ada@example.com"}`},
+	} {
+		w := serve(h, req.method, req.path, req.body)
+		if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"code":"email_in_use"`) {
+			t.Errorf("%s %s: %d %s, want 409 email_in_use", req.method, req.path, w.Code, w.Body)
+		}
+	}
+	if u, _ := repo.GetUserByID(context.Background(), 2); u.Email != "grace@example.com" {
+		t.Errorf("rejected update changed the email to %s", u.Email)
+	}
+
+	// Keeping one's own email is not a collision.
+	if w := serve(h, http.MethodPut, "/api/v1/users/1", `{"name":"Ada","email":"ada@example.com"}`); w.Code != http.StatusOK {
+		t.Errorf("PUT with own email: %d %s", w.Code, w.Body)
+	}
+}
+
+// failingRepo fails every lookup and write of an existing user with err.
+type failingRepo struct {
+	*repository.Memory
+	err error
+}
+
+func (r failingRepo) GetUserByID(context.Context, int64) (*repository.User, error) {
+	return nil, r.err
+}
+
+func (r failingRepo) UpdateUser(context.Context, int64, int64, string, string, map[string]any) (*repository.User, error) {
+	return nil, r.err
+}
+
+func (r failingRepo) PatchUser(context.Context, int64, int64, *string, *string) (*repository.User, error) {
+	return nil, r.err
+}
+
+func (r failingRepo) DeleteUser(context.Context, int64, int64) error {
+	return r.err
+}
+
+func (r failingRepo) GetUsers(context.Context, repository.UserFilter, repository.Sort, int, int) ([]repository.User, bool, error) {
+	if errors.Is(r.err, repository.ErrUserNotFound) {
+		return nil, false, nil
+	}
+	return nil, false, r.err
+}
+
+// Only a missing user is a 404; anything else the repository reports is
+// a 500, so an outage never looks like missing data.
+func TestRepositoryErrorMapping(t *testing.T) {
+	outage := errors.New("connection refused")
+	requests := []struct{ method, path, body string }{
+		{http.MethodGet, "/api/v1/users/1", ""},
+		{http.MethodPut, "/api/v1/users/1", `{"name":"Ada","email":"ada@example.com"}`},
+		{http.MethodPatch, "/api/v1/users/1", `{"name":"Ada"}`},
+		{http.MethodDelete, "/api/v1/users/1", ""},
+	}
+	for _, tt := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{repository.ErrUserNotFound, http.StatusNotFound, codeUserNotFound.Code},
+		{fmt.Errorf("get: %w", repository.ErrUserNotFound), http.StatusNotFound, codeUserNotFound.Code},
+		{outage, http.StatusInternalServerError, codeInternal.Code},
+		{context.DeadlineExceeded, http.StatusInternalServerError, codeInternal.Code},
+	} {
+		s, err := New(Config{}, WithRepository(failingRepo{repository.NewMemory(), tt.err}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := s.Handler()
+		for _, req := range requests {
+			w := serve(h, req.method, req.path, req.body)
+			if w.Code != tt.status || !strings.Contains(w.Body.String(), `"code":"`+tt.code+`"`) {
+				t.Errorf("%s %s with %v: %d %s, want %d %s", req.method, req.path, tt.err, w.Code, w.Body, tt.status, tt.code)
+			}
+			if strings.Contains(w.Body.String(), "connection refused") {
+				t.Errorf("%s %s leaked the repository error: %s", req.method, req.path, w.Body)
+			}
+		}
+	}
+
+	// A list that cannot be read is an outage too, not an empty page.
+	s, err := New(Config{}, WithRepository(failingRepo{repository.NewMemory(), outage}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(s.Handler(), http.MethodGet, "/api/v1/users", ""); w.Code != http.StatusInternalServerError {
+		t.Errorf("GET /users with an outage: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	for _, u := range [][2]string{{"Seán O'Brien", "sean@example.org"}, {"Ada", "ada@example.com"}, {"100% Grace", "grace@example.com"}} {
+		if _, err := repo.CreateUser(context.Background(), u[0], u[1], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+	for query, want := range map[string]string{
+		"name=":                  "Seán O'Brien,Ada,100% Grace",
+		"name=&email=":           "Seán O'Brien,Ada,100% Grace",
+		"name=ADA":               "Ada",
+		"email=.com&name=grace":  "100% Grace",
+		"name=%25":               "100% Grace",
+		"name=%27":               "Seán O'Brien",
+		"name=Sea%CC%81n":        "Seán O'Brien", // decomposed á
+		"name=ada&email=.org":    "",
+		"name=Ada&label=team=ok": "",
+	} {
+		w := serve(h, http.MethodGet, "/api/v1/users?"+query, "")
+		var users []repository.User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body)
+		}
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("%s: %q, want %q", query, got, want)
+		}
+	}
+	long := strings.Repeat("a", maxSearchLength+1)
+	for _, q := range []string{"name=" + long, "email=" + long} {
+		if w := serve(h, http.MethodGet, "/api/v1/users?"+q, ""); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidFilter.Code) {
+			t.Errorf("overlong filter: %d %s", w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// object is a JSON object literal, for responses without a type of their
+// own.
+type object map[string]any
+
+// middleware wraps a handler. Middleware that rejects a request answers
+// it and does not call the next handler.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws, the first one outermost.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// exchange is the state the middleware of one request share: the
+// response as the client sees it, the route template the router matched,
+// the latest request logger and the errors the access log reports. The
+// middleware further out reads what the ones further in set here.
+type exchange struct {
+	w      *responseWriter
+	route  string
+	log    *zerolog.Logger
+	errors []error
+}
+
+type exchangeKey struct{}
+
+// startExchange starts the exchange of every request. It runs outermost,
+// so what it records is what the client received.
+func startExchange(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ex := &exchange{w: &responseWriter{ResponseWriter: w, status: http.StatusOK}}
+		next.ServeHTTP(ex.w, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex)))
+	})
+}
+
+// exchangeOf returns the exchange of r; outside of startExchange, e.g. in
+// unit tests of a single middleware, it is a detached one.
+func exchangeOf(r *http.Request) *exchange {
+	if ex, ok := r.Context().Value(exchangeKey{}).(*exchange); ok {
+		return ex
+	}
+	return &exchange{w: &responseWriter{status: http.StatusOK}}
+}
+
+// routeOf is the route template the request matched, such as
+// "/api/v1/users/:id", or "" when none did.
+func routeOf(r *http.Request) string {
+	return exchangeOf(r).route
+}
+
+// statusOf is the status sent so far, 200 before anything was written.
+func statusOf(r *http.Request) int {
+	return exchangeOf(r).w.status
+}
+
+// recordError keeps err for the access log of the request.
+func recordError(r *http.Request, err error) {
+	ex := exchangeOf(r)
+	ex.errors = append(ex.errors, err)
+}
+
+// errorsText formats the recorded errors one per line.
+func (ex *exchange) errorsText() string {
+	var b strings.Builder
+	for i, err := range ex.errors {
+		fmt.Fprintf(&b, "Error #%02d: %s\n", i+1, err)
+	}
+	return b.String()
+}
+
+// responseWriter records the status and size of the response.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status, w.wroteHeader = code, true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	flush(w.ResponseWriter)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush sends what w buffered, when it can.
+func flush(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).Flush()
+}
+
+// bodyless reports whether a status forbids a response body.
+func bodyless(status int) bool {
+	return status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified
+}
+
+// writeJSON answers v as JSON with status. A value that cannot be
+// encoded is a bug; it is recorded for the access log and answered 500.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		recordError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeData(w, status, "application/json; charset=utf-8", body)
+}
+
+// writeData answers body with status, keeping a Content-Type the handler
+// already set.
+func writeData(w http.ResponseWriter, status int, contentType string, body []byte) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.WriteHeader(status)
+	if !bodyless(status) {
+		w.Write(body)
+	}
+}
+
+// negotiate picks the first offer the Accept header allows, in the
+// header's order and ignoring q-values; without a header it is the first
+// offer, and "" when nothing matches.
+func negotiate(r *http.Request, offers ...string) string {
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return offers[0]
+	}
+	for _, accepted := range strings.Split(header, ",") {
+		accepted, _, _ = strings.Cut(accepted, ";")
+		accepted = strings.TrimSpace(accepted)
+		if accepted == "" {
+			continue
+		}
+		for _, offer := range offers {
+			i := 0
+			for ; i < len(accepted) && i < len(offer); i++ {
+				if accepted[i] == '*' || offer[i] == '*' {
+					return offer
+				}
+				if accepted[i] != offer[i] {
+					break
+				}
+			}
+			if i == len(accepted) {
+				return offer
+			}
+		}
+	}
+	return ""
+}
+
+// recoverPanics answers a handler panic with internal_error, unless the
+// response was already started, and logs it with the stack.
+// http.ErrAbortHandler is net/http's way of aborting a response and is
+// passed on.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			s.reqLog(r).Error().Interface("panic", v).Bytes("stack", debug.Stack()).Msg("handler panicked")
+			if !exchangeOf(r).w.wroteHeader {
+				respondError(w, r, codeInternal, "internal error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/requestctx"
+)
+
+// idempotencyGuard protects non-idempotent routes against duplicate side
+// effects when clients or the ingress retry. Decisions, in order:
+//
+//  1. A request carrying the configured retry indicator header is flagged
+//     in the logs (it is still processed by the rules below).
+//  2. With an Idempotency-Key header, the first request for the key runs;
+//     repeats replay its stored response, waiting if it is still running.
+//     Reusing a key for a different payload is rejected with 422.
+//  3. Without a key, strict mode rejects the request with 428.
+//  4. Otherwise identical requests (same method, path and body) within the
+//     duplicate window share one execution, exactly like rule 2.
+//
+// Keys and fingerprints are scoped to the caller (token subject or API key
+// name), so one caller can never be replayed another's response. An entry
+// stays claimed until its handler returns, even past a 504 from the
+// request deadline, and only server errors the handler itself wrote are
+// forgotten; a retry after a timeout therefore waits for or replays the
+// original instead of running it twice.
+//
+// State is in memory and therefore per replica.
+type idempotencyGuard struct {
+	log         zerolog.Logger
+	keyTTL      time.Duration
+	dupWindow   time.Duration
+	strict      bool
+	retryHeader string
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+	puts    int
+}
+
+type idempotencyEntry struct {
+	fingerprint string
+	expires     time.Time
+	done        chan struct{}
+
+	// Set once done is closed.
+	status int
+	header http.Header
+	body   []byte
+}
+
+// replayedHeaders are the response headers a replay repeats; the rest
+// (request ID, CORS, timing) belong to the request at hand.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+func newIdempotencyGuard(logger zerolog.Logger, keyTTL, dupWindow time.Duration, strict bool, retryHeader string) *idempotencyGuard {
+	return &idempotencyGuard{
+		log:         logger,
+		keyTTL:      keyTTL,
+		dupWindow:   dupWindow,
+		strict:      strict,
+		retryHeader: retryHeader,
+		entries:     make(map[string]*idempotencyEntry),
+	}
+}
+
+func (g *idempotencyGuard) middleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if g.retryHeader != "" && r.Header.Get(g.retryHeader) != "" {
+				g.log.Warn().
+					Str("method", r.Method).
+					Str("path", r.URL.Path).
+					Str("retry", r.Header.Get(g.retryHeader)).
+					Msg("request retried by ingress")
+			}
+
+			body, ok := readBody(w, r)
+			if !ok {
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			who := caller(r)
+			fingerprint := requestFingerprint(who, r.Method, r.URL.Path, body)
+
+			key := r.Header.Get("Idempotency-Key")
+			var storeKey string
+			var ttl time.Duration
+			switch {
+			case key != "":
+				storeKey, ttl = "key:"+strconv.Quote(who)+key, g.keyTTL
+			case g.strict:
+				respondError(w, r, codeIdempotencyKeyRequired, "Idempotency-Key header is required")
+				return
+			case g.dupWindow > 0:
+				storeKey, ttl = "dup:"+fingerprint, g.dupWindow
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			entry, owner := g.claim(storeKey, fingerprint, ttl)
+			if entry.fingerprint != fingerprint {
+				respondError(w, r, codeIdempotencyKeyReused, "Idempotency-Key was already used with a different request")
+				return
+			}
+			if !owner {
+				g.replay(w, r, entry)
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w}
+			completed := false
+			defer func() {
+				// A panicking handler must not leave waiters blocked.
+				if !completed {
+					g.abandon(storeKey, entry)
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+			g.complete(r, storeKey, entry, rec)
+			completed = true
+		})
+	}
+}
+
+// caller identifies who sent the request, for scoping keys.
+func caller(r *http.Request) string {
+	ctx := r.Context()
+	if actor, ok := requestctx.Actor(ctx); ok {
+		return "actor:" + actor
+	}
+	if name, ok := requestctx.Consumer(ctx); ok {
+		return "consumer:" + name
+	}
+	return "anonymous"
+}
+
+// claim returns the live entry for key, creating it when absent. owner is
+// true when the caller created it and must execute the request.
+func (g *idempotencyGuard) claim(key, fingerprint string, ttl time.Duration) (entry *idempotencyEntry, owner bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := g.entries[key]; ok && e.live(now) {
+		return e, false
+	}
+
+	g.puts++
+	if g.puts%256 == 0 {
+		for k, e := range g.entries {
+			if !e.live(now) {
+				delete(g.entries, k)
+			}
+		}
+	}
+
+	e := &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(ttl), done: make(chan struct{})}
+	g.entries[key] = e
+	return e, true
+}
+
+// live reports whether e still answers for its key: until it expires, and
+// for as long as its handler runs.
+func (e *idempotencyEntry) live(now time.Time) bool {
+	select {
+	case <-e.done:
+		return now.Before(e.expires)
+	default:
+		return true
+	}
+}
+
+// complete publishes the response the handler wrote, which is not
+// necessarily the one the client got: a 504 sent at the deadline hides a
+// result that is still worth replaying. Server errors from the handler are
+// not kept, so a later retry gets a fresh attempt; neither is a handler
+// that wrote nothing before its deadline.
+func (g *idempotencyGuard) complete(r *http.Request, key string, e *idempotencyEntry, rec *recordingWriter) {
+	e.status = rec.status
+	if e.status == 0 && rec.buf.Len() == 0 && deadlineExceeded(r) {
+		e.status = codeDeadlineExceeded.Status
+	} else if e.status == 0 {
+		e.status = http.StatusOK
+	}
+	e.header = make(http.Header)
+	for _, h := range replayedHeaders {
+		if v := rec.Header().Get(h); v != "" {
+			e.header.Set(h, v)
+		}
+	}
+	e.body = rec.buf.Bytes()
+
+	if e.status >= http.StatusInternalServerError {
+		g.forget(key, e)
+	}
+	close(e.done)
+}
+
+func (g *idempotencyGuard) abandon(key string, e *idempotencyEntry) {
+	e.status = codeInternal.Status
+	e.header = http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+	e.body, _ = json.Marshal(errorBody(codeInternal, "original request failed"))
+	g.forget(key, e)
+	close(e.done)
+}
+
+func (g *idempotencyGuard) forget(key string, e *idempotencyEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.entries[key] == e {
+		delete(g.entries, key)
+	}
+}
+
+// replay waits for the original execution and repeats its response.
+func (g *idempotencyGuard) replay(w http.ResponseWriter, r *http.Request, e *idempotencyEntry) {
+	select {
+	case <-e.done:
+	case <-r.Context().Done():
+		respondError(w, r, codeRequestInProgress, "original request is still in progress")
+		return
+	}
+
+	for h, v := range e.header {
+		w.Header()[h] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeData(w, e.status, e.header.Get("Content-Type"), e.body)
+}
+
+func requestFingerprint(caller, method, path string, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, strconv.Quote(caller)+" "+method+" "+path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the status and body the handler wrote,
+// whether or not they reached the client.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Flush() {
+	flush(w.ResponseWriter)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// slowRepo finishes CreateUser after delay even when the request has
+// given up, like an insert that commits after the client timed out.
+type slowRepo struct {
+	*repository.Memory
+	delay time.Duration
+}
+
+func (r slowRepo) CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*repository.User, error) {
+	time.Sleep(r.delay)
+	return r.Memory.CreateUser(context.WithoutCancel(ctx), name, email, metadata)
+}
+
+const (
+	aliceKey = "alice-key"
+	bobKey   = "bob-key"
+	// sha256 of aliceKey and bobKey.
+	testAPIKeys = "alice:72ee9d4355ccb9d3a4c9dbf37382e38e75c1b1a225b5bd1f729ee91bbda30c20," +
+		"bob:9b94dc1a51a38769f135edf04033ad7f2f487b6c25929be7a861cfc1ab10cf98"
+)
+
+func countUsers(t *testing.T, repo repository.UserRepository) int64 {
+	t.Helper()
+	n, err := repo.CountUsers(context.Background(), repository.UserFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	body := `{"name":"Ada","email":"ada@example.com"}`
+
+	first := serve(s.Handler(), http.MethodPost, "/api/v1/users", body, "Idempotency-Key", "k1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first: %d %s", first.Code, first.Body)
+	}
+	again := serve(s.Handler(), http.MethodPost, "/api/v1/users", body, "Idempotency-Key", "k1")
+	if again.Code != http.StatusCreated || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay: %d %v", again.Code, again.Header())
+	}
+	for _, h := range []string{"Location", "ETag", "Content-Type"} {
+		if got, want := again.Header().Get(h), first.Header().Get(h); got == "" || got != want {
+			t.Errorf("replayed %s = %q, want %q", h, got, want)
+		}
+	}
+	if again.Body.String() != first.Body.String() {
+		t.Errorf("replayed body %s, want %s", again.Body, first.Body)
+	}
+	if n := countUsers(t, repo); n != 1 {
+		t.Errorf("users = %d, want 1", n)
+	}
+
+	reused := serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Bo","email":"bo@example.com"}`, "Idempotency-Key", "k1")
+	if reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another payload: %d, want 422", reused.Code)
+	}
+}
+
+func TestIdempotencyScopedToCaller(t *testing.T) {
+	s, repo := newTestServer(t, Config{APIKeys: testAPIKeys, DuplicateWindow: time.Minute})
+
+	alice := serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`,
+		"X-API-Key", aliceKey, "Idempotency-Key", "shared")
+	if alice.Code != http.StatusCreated {
+		t.Fatalf("alice: %d %s", alice.Code, alice.Body)
+	}
+
+	// The same key from another caller is a different request.
+	bob := serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Bo","email":"bo@example.com"}`,
+		"X-API-Key", bobKey, "Idempotency-Key", "shared")
+	if bob.Code != http.StatusCreated || bob.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("bob with alice's key: %d replayed=%q", bob.Code, bob.Header().Get("Idempotent-Replayed"))
+	}
+
+	// So is the same body inside the duplicate window: bob runs it and
+	// hits the unique email instead of receiving alice's response.
+	dup := `{"name":"Cy","email":"cy@example.com"}`
+	if w := serve(s.Handler(), http.MethodPost, "/api/v1/users", dup, "X-API-Key", aliceKey); w.Code != http.StatusCreated {
+		t.Fatalf("alice dup: %d", w.Code)
+	}
+	w := serve(s.Handler(), http.MethodPost, "/api/v1/users", dup, "X-API-Key", bobKey)
+	if w.Header().Get("Idempotent-Replayed") != "" || w.Code != http.StatusConflict {
+		t.Errorf("bob's identical body: %d replayed=%q, want 409 without replay", w.Code, w.Header().Get("Idempotent-Replayed"))
+	}
+	if n := countUsers(t, repo); n != 3 {
+		t.Errorf("users = %d, want 3", n)
+	}
+}
+
+func TestIdempotencyConcurrentRetriesCreateOneRow(t *testing.T) {
+	mem := repository.NewMemory()
+	s, err := New(Config{}, WithRepository(slowRepo{mem, 20 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"name":"Ada","email":"ada@example.com"}`
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve(s.Handler(), http.MethodPost, "/api/v1/users", body, "Idempotency-Key", "k").Code
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusCreated {
+			t.Errorf("request %d: %d", i, code)
+		}
+	}
+	if n := countUsers(t, mem); n != 1 {
+		t.Errorf("users = %d, want exactly 1", n)
+	}
+}
+
+// The first attempt times out with 504 while its insert still commits;
+// retries must not insert again, whether they arrive while it runs or
+// after it finished.
+func TestIdempotencyTimeoutThenRetryCreatesOneRow(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     Config
+		headers []string
+	}{
+		{"key", Config{}, []string{"Idempotency-Key", "retry-1"}},
+		{"duplicate window", Config{DuplicateWindow: time.Minute}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mem := repository.NewMemory()
+			cfg := tc.cfg
+			cfg.RequestTimeout = 30 * time.Millisecond
+			cfg.DeadlineMin = time.Millisecond
+			s, err := New(cfg, WithRepository(slowRepo{mem, 120 * time.Millisecond}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := `{"name":"Ada","email":"ada@example.com"}`
+
+			done := make(chan int)
+			go func() {
+				done <- serve(s.Handler(), http.MethodPost, "/api/v1/users", body, tc.headers...).Code
+			}()
+			time.Sleep(50 * time.Millisecond) // the first attempt has had its 504
+
+			// A retry while the original still runs waits for it and
+			// gives up at its own deadline.
+			early := serve(s.Handler(), http.MethodPost, "/api/v1/users", body, tc.headers...)
+			if early.Code == http.StatusCreated && early.Header().Get("Idempotent-Replayed") == "" {
+				t.Errorf("early retry ran the insert again")
+			}
+			if code := <-done; code != http.StatusGatewayTimeout {
+				t.Errorf("first attempt: %d, want 504", code)
+			}
+
+			late := serve(s.Handler(), http.MethodPost, "/api/v1/users", body, tc.headers...)
+			if late.Code != http.StatusCreated || late.Header().Get("Idempotent-Replayed") != "true" {
+				t.Errorf("late retry: %d replayed=%q, want the original 201", late.Code, late.Header().Get("Idempotent-Replayed"))
+			}
+			if late.Header().Get("Location") == "" {
+				t.Errorf("late retry lost Location")
+			}
+			if n := countUsers(t, mem); n != 1 {
+				t.Errorf("users = %d, want exactly 1", n)
+			}
+		})
+	}
+}
+
+func TestStrictIdempotencyRequiresKey(t *testing.T) {
+	s, _ := newTestServer(t, Config{StrictIdempotency: true})
+	w := serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("POST without key: %d, want 428", w.Code)
+	}
+}
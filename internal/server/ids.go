@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errNonCanonicalID = errors.New("user id must be a positive decimal integer without sign, leading zeros or whitespace")
+	// errImplausibleID marks well-formed ids that cannot belong to a row.
+	errImplausibleID = errors.New("user id out of range")
+)
+
+// parseUserID accepts ids in canonical form only, so every user has
+// exactly one URL: "5" is fine; "05", "+5", " 5" and "5.0" are not.
+// Ids above max (beyond what the id column can hold) are reported as
+// errImplausibleID so callers can answer 404 without a query.
+func parseUserID(s string, max int64) (int64, error) {
+	if s == "" || s[0] < '1' || s[0] > '9' {
+		return 0, errNonCanonicalID
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, errNonCanonicalID
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n > max {
+		return 0, errImplausibleID
+	}
+	return n, nil
+}
+
+// userIDParam parses the :id path parameter. When it returns false the
+// 400 or 404 response has already been written.
+func (s *Server) userIDParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := parseUserID(r.PathValue("id"), s.cfg.MaxUserID)
+	switch {
+	case errors.Is(err, errImplausibleID):
+		respondError(w, r, codeUserNotFound, "user not found")
+		return 0, false
+	case err != nil:
+		respondError(w, r, codeInvalidID, "invalid user id: "+err.Error())
+		return 0, false
+	}
+	return id, true
+}
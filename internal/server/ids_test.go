@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestParseUserID(t *testing.T) {
+	const max = 1000
+	tests := []struct {
+		in   string
+		want int64
+		err  error
+	}{
+		{"1", 1, nil},
+		{"5", 5, nil},
+		{"10", 10, nil},
+		{"1000", 1000, nil},
+
+		{"", 0, errNonCanonicalID},
+		{"0", 0, errNonCanonicalID},
+		{"00", 0, errNonCanonicalID},
+		{"05", 0, errNonCanonicalID},
+		{"0005", 0, errNonCanonicalID},
+		{"+5", 0, errNonCanonicalID},
+		{"-5", 0, errNonCanonicalID},
+		{" 5", 0, errNonCanonicalID},
+		{"5 ", 0, errNonCanonicalID},
+		{"5\n", 0, errNonCanonicalID},
+		{"\t5", 0, errNonCanonicalID},
+		{"5.0", 0, errNonCanonicalID},
+		{"5e2", 0, errNonCanonicalID},
+		{"0x5", 0, errNonCanonicalID},
+		{"1_000", 0, errNonCanonicalID},
+		{"abc", 0, errNonCanonicalID},
+		{"٥", 0, errNonCanonicalID}, // Arabic-Indic five
+		{"５", 0, errNonCanonicalID}, // fullwidth five
+
+		{"1001", 0, errImplausibleID},
+		{strconv.FormatInt(math.MaxInt64, 10), 0, errImplausibleID},
+		{"9223372036854775808", 0, errImplausibleID}, // MaxInt64 + 1
+		{"99999999999999999999999999", 0, errImplausibleID},
+	}
+	for _, tt := range tests {
+		got, err := parseUserID(tt.in, max)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("parseUserID(%q) = %d, %v; want %d, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+
+	if got, err := parseUserID(strconv.FormatInt(math.MaxInt64, 10), math.MaxInt64); err != nil || got != math.MaxInt64 {
+		t.Errorf("MaxInt64 with no limit: %d, %v", got, err)
+	}
+}
+
+// lookupRepo counts lookups so tests can tell a 404 that skipped the
+// database from one that asked it.
+type lookupRepo struct {
+	*repository.Memory
+	lookups atomic.Int64
+}
+
+func (r *lookupRepo) GetUserByID(ctx context.Context, id int64) (*repository.User, error) {
+	r.lookups.Add(1)
+	return r.Memory.GetUserByID(ctx, id)
+}
+
+func TestUserIDParam(t *testing.T) {
+	repo := &lookupRepo{Memory: repository.NewMemory()}
+	s, err := New(Config{MaxUserID: 1000}, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/api/v1/users/1" {
+		t.Fatalf("create: %d, Location %q", w.Code, w.Header().Get("Location"))
+	}
+
+	for _, path := range []string{"/api/v1/users/0001", "/api/v1/users/+1", "/api/v1/users/%201", "/api/v1/users/1.0"} {
+		if w := serve(h, http.MethodGet, path, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: %d, want 400", path, w.Code)
+		}
+	}
+	if n := repo.lookups.Load(); n != 0 {
+		t.Errorf("non-canonical ids reached the repository %d times", n)
+	}
+
+	for _, path := range []string{"/api/v1/users/1001", "/api/v1/users/9223372036854775807", "/api/v1/users/99999999999999999999"} {
+		if w := serve(h, http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, w.Code)
+		}
+	}
+	if n := repo.lookups.Load(); n != 0 {
+		t.Errorf("implausible ids reached the repository %d times", n)
+	}
+
+	if w := serve(h, http.MethodGet, "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Errorf("canonical id: %d", w.Code)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users/999", ""); w.Code != http.StatusNotFound || repo.lookups.Load() != 2 {
+		t.Errorf("plausible missing id: %d after %d lookups, want 404 from the repository", w.Code, repo.lookups.Load())
+	}
+}
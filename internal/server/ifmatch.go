@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// userETag is the strong ETag of a user at version.
+func userETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// userValidators sets the ETag and Last-Modified of u, the same on GET
+// and HEAD.
+func userValidators(w http.ResponseWriter, u *repository.User) {
+	w.Header().Set("ETag", userETag(u.Version))
+	w.Header().Set("Last-Modified", u.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// ifMatchVersion reads the If-Match header of a user write as the version
+// it is conditional on; zero means unconditional (no header, or "*"). A
+// weak or foreign tag can never match a user's strong ETag and is answered
+// with 412 right away.
+func (s *Server) ifMatchVersion(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	switch {
+	case h == "" && s.cfg.RequireIfMatch:
+		respondError(w, r, codeIfMatchRequired, "If-Match header is required")
+		return 0, false
+	case h == "" || h == "*":
+		return 0, true
+	case strings.Contains(h, ","):
+		respondError(w, r, codeInvalidParameter, "If-Match must be a single ETag or *")
+		return 0, false
+	}
+	tag, ok := strings.CutPrefix(h, `"`)
+	if ok {
+		tag, ok = strings.CutSuffix(tag, `"`)
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if !ok || err != nil || version <= 0 {
+		respondError(w, r, codeVersionConflict, "If-Match does not match the user's ETag")
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUserIfMatch(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	u, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/users/" + strconv.FormatInt(u.ID, 10)
+
+	w := serve(h, http.MethodGet, path, "")
+	if w.Header().Get("ETag") != `"1"` || w.Header().Get("Last-Modified") == "" {
+		t.Fatalf("GET validators: %v", w.Header())
+	}
+	if head := serve(h, http.MethodHead, path, ""); head.Header().Get("ETag") != `"1"` {
+		t.Errorf("HEAD ETag %q", head.Header().Get("ETag"))
+	}
+
+	for _, tc := range []struct {
+		name, method, body, ifMatch string
+		want                        int
+		etag                        string
+	}{
+		{"PUT current", http.MethodPut, `{"name":"Ada L","email":"ada@example.com"}`, `"1"`, http.StatusOK, `"2"`},
+		{"PUT stale", http.MethodPut, `{"name":"Ada K","email":"ada@example.com"}`, `"1"`, http.StatusPreconditionFailed, `"2"`},
+		{"PATCH current", http.MethodPatch, `{"name":"Ada K"}`, `"2"`, http.StatusOK, `"3"`},
+		{"PATCH weak", http.MethodPatch, `{"name":"Ada"}`, `W/"3"`, http.StatusPreconditionFailed, `"3"`},
+		{"PATCH unquoted", http.MethodPatch, `{"name":"Ada"}`, `3`, http.StatusPreconditionFailed, `"3"`},
+		{"PATCH a list", http.MethodPatch, `{"name":"Ada"}`, `"3", "4"`, http.StatusBadRequest, `"3"`},
+		{"PATCH without If-Match", http.MethodPatch, `{"name":"Ada"}`, "", http.StatusOK, `"4"`},
+		{"PUT any", http.MethodPut, `{"name":"Ada","email":"ada@example.com"}`, "*", http.StatusOK, `"5"`},
+		{"DELETE stale", http.MethodDelete, "", `"4"`, http.StatusPreconditionFailed, `"5"`},
+	} {
+		var headers []string
+		if tc.ifMatch != "" {
+			headers = []string{"If-Match", tc.ifMatch}
+		}
+		w := serve(h, tc.method, path, tc.body, headers...)
+		if w.Code != tc.want {
+			t.Fatalf("%s: %d %s, want %d", tc.name, w.Code, w.Body, tc.want)
+		}
+		if tc.want == http.StatusPreconditionFailed && !strings.Contains(w.Body.String(), codeVersionConflict.Code) {
+			t.Errorf("%s: %s", tc.name, w.Body)
+		}
+		if got := serve(h, http.MethodGet, path, "").Header().Get("ETag"); got != tc.etag {
+			t.Errorf("%s: ETag %s afterwards, want %s", tc.name, got, tc.etag)
+		}
+	}
+
+	if w := serve(h, http.MethodDelete, path, "", "If-Match", `"5"`); w.Code != http.StatusOK {
+		t.Errorf("DELETE current: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPut, path, `{"name":"Ada","email":"ada@example.com"}`, "If-Match", `"6"`); w.Code != http.StatusNotFound {
+		t.Errorf("PUT of a deleted user: %d, want 404", w.Code)
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	s, repo := newTestServer(t, Config{RequireIfMatch: true})
+	h := s.Handler()
+	u, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/users/" + strconv.FormatInt(u.ID, 10)
+	for _, tc := range []struct{ method, body string }{
+		{http.MethodPut, `{"name":"Ada L","email":"ada@example.com"}`},
+		{http.MethodPatch, `{"name":"Ada L"}`},
+		{http.MethodDelete, ""},
+	} {
+		w := serve(h, tc.method, path, tc.body)
+		if w.Code != http.StatusPreconditionRequired || !strings.Contains(w.Body.String(), codeIfMatchRequired.Code) {
+			t.Errorf("%s without If-Match: %d %s, want 428", tc.method, w.Code, w.Body)
+		}
+	}
+	if w := serve(h, http.MethodPatch, path, `{"name":"Ada L"}`, "If-Match", `"1"`); w.Code != http.StatusOK {
+		t.Errorf("PATCH with If-Match: %d %s", w.Code, w.Body)
+	}
+	// Creating a user has nothing to be conditional on.
+	if w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Grace","email":"grace@example.com"}`); w.Code != http.StatusCreated {
+		t.Errorf("POST in strict mode: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestIfMatchOnMetadataAndLabels(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	ctx := context.Background()
+	u, err := repo.CreateUser(ctx, "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ReplaceLabels(ctx, u.ID, 0, map[string]string{"team": "core"}); err != nil {
+		t.Fatal(err)
+	}
+	path := "/api/v1/users/" + strconv.FormatInt(u.ID, 10)
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodPatch, path + "/metadata", `{"tier":"gold"}`},
+		{http.MethodPut, path + "/labels", `{"team":"infra"}`},
+		{http.MethodPatch, path + "/labels", `{"env":"prod"}`},
+		{http.MethodDelete, path + "/labels/team", ""},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			etag := serve(s.Handler(), http.MethodGet, path, "").Header().Get("ETag")
+
+			stale := serve(s.Handler(), tc.method, tc.path, tc.body, "If-Match", `"999"`)
+			if stale.Code != http.StatusPreconditionFailed {
+				t.Fatalf("stale If-Match: %d %s, want 412", stale.Code, stale.Body)
+			}
+			if got := serve(s.Handler(), http.MethodGet, path, "").Header().Get("ETag"); got != etag {
+				t.Fatalf("a rejected write moved the ETag from %s to %s", etag, got)
+			}
+
+			ok := serve(s.Handler(), tc.method, tc.path, tc.body, "If-Match", etag)
+			if ok.Code >= 300 {
+				t.Fatalf("current If-Match: %d %s", ok.Code, ok.Body)
+			}
+			if got := serve(s.Handler(), http.MethodGet, path, "").Header().Get("ETag"); got == etag {
+				t.Errorf("the write left the ETag at %s", etag)
+			}
+
+			// The ETag read before the write no longer matches.
+			if again := serve(s.Handler(), tc.method, tc.path, tc.body, "If-Match", etag); again.Code != http.StatusPreconditionFailed {
+				t.Errorf("replayed If-Match: %d, want 412", again.Code)
+			}
+		})
+	}
+}
+
+func TestRequireIfMatchOnLabels(t *testing.T) {
+	s, repo := newTestServer(t, Config{RequireIfMatch: true})
+	u, err := repo.CreateUser(context.Background(), "Ada", "ada@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := serve(s.Handler(), http.MethodPatch, "/api/v1/users/"+strconv.FormatInt(u.ID, 10)+"/labels", `{"team":"core"}`)
+	if w.Code != http.StatusPreconditionRequired {
+		t.Errorf("labels without If-Match: %d, want 428", w.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"go-k8s-demo/internal/journal"
+	"go-k8s-demo/internal/requestctx"
+)
+
+// isMutating reports whether method changes server state.
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// journalMiddleware brackets the handler with begin/end journal records.
+// It runs last in the route chain so replays and rejections by earlier
+// middleware are not journaled.
+func journalMiddleware(j *journal.Journal, route string) middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, ok := readBody(w, r)
+			if !ok {
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			ctx := r.Context()
+			reqID, ok := requestctx.RequestID(ctx)
+			if !ok {
+				reqID = r.Header.Get("X-Request-ID")
+			}
+			actor, ok := requestctx.Actor(ctx)
+			if !ok {
+				actor, _ = requestctx.Consumer(ctx)
+			}
+
+			id := j.Begin(route, reqID, actor, body)
+			defer func() {
+				// A panic is recorded as a 500 before recoverPanics renders it.
+				status := statusOf(r)
+				if p := recover(); p != nil {
+					j.Complete(id, http.StatusInternalServerError)
+					panic(p)
+				}
+				j.Complete(id, status)
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// logInterrupted reports operations a previous process began but never
+// finished.
+func (s *Server) logInterrupted(entries []journal.Entry) {
+	for _, e := range entries {
+		s.log.Warn().
+			Time("started", e.Time).
+			Str("route", e.Route).
+			Str("request_id", e.RequestID).
+			Str("actor", e.Actor).
+			Str("payload_sha256", e.PayloadHash).
+			Msg("possibly interrupted operation from previous run")
+	}
+}
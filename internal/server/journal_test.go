@@ -0,0 +1,50 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-k8s-demo/internal/journal"
+)
+
+func TestJournaledRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	s, _ := newTestServer(t, Config{JournalPath: path, JournalSync: journal.SyncAlways})
+	h := s.Handler()
+
+	if w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`, "X-Request-ID", "req-1"); w.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users", ""); w.Code != http.StatusOK {
+		t.Fatalf("list: %d", w.Code)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []journal.Entry
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e journal.Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	// Reads are not journaled; the write is bracketed by begin and end.
+	if len(entries) != 2 {
+		t.Fatalf("entries = %+v, want begin and end of the create", entries)
+	}
+	begin, end := entries[0], entries[1]
+	if begin.Op != "begin" || begin.Route != "POST /api/v1/users" || begin.RequestID != "req-1" || begin.PayloadHash == "" {
+		t.Errorf("begin = %+v", begin)
+	}
+	if end.Op != "end" || end.ID != begin.ID || end.Status != http.StatusCreated {
+		t.Errorf("end = %+v", end)
+	}
+}
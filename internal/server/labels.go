@@ -0,0 +1,236 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// Label syntax follows Kubernetes: a key is an optional DNS subdomain
+// prefix and a slash, then a name of up to 63 characters; a value is
+// empty or name-shaped.
+const (
+	maxLabelsPerUser   = 64
+	maxLabelNameLen    = 63
+	maxLabelPrefixLen  = 253
+	maxLabelSelectors  = 10
+	labelSelectorParam = "label"
+)
+
+var (
+	labelName   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelPrefix = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// labelError is a client mistake in a label set; handlers answer it with
+// 400 and its message.
+type labelError struct{ msg string }
+
+func (e *labelError) Error() string { return e.msg }
+
+func labelErrorf(format string, args ...any) error {
+	return &labelError{msg: fmt.Sprintf(format, args...)}
+}
+
+func validateLabelKey(key string) error {
+	name := key
+	if prefix, rest, ok := strings.Cut(key, "/"); ok {
+		if prefix == "" || len(prefix) > maxLabelPrefixLen || !labelPrefix.MatchString(prefix) {
+			return labelErrorf("label key %q: the prefix must be a lowercase DNS subdomain of at most %d characters", key, maxLabelPrefixLen)
+		}
+		name = rest
+	}
+	if len(name) > maxLabelNameLen || !labelName.MatchString(name) {
+		return labelErrorf("label key %q: the name must be 1-%d alphanumerics, '-', '_' or '.', starting and ending alphanumeric", key, maxLabelNameLen)
+	}
+	return nil
+}
+
+func validateLabelValue(key, value string) error {
+	if value != "" && (len(value) > maxLabelNameLen || !labelName.MatchString(value)) {
+		return labelErrorf("label %q: the value must be empty or 1-%d alphanumerics, '-', '_' or '.', starting and ending alphanumeric", key, maxLabelNameLen)
+	}
+	return nil
+}
+
+// validateLabels checks every label and the per-user limit on a complete
+// label set.
+func validateLabels(labels map[string]string) error {
+	if len(labels) > maxLabelsPerUser {
+		return labelErrorf("a user can have at most %d labels", maxLabelsPerUser)
+	}
+	for k, v := range labels {
+		if err := validateLabelKey(k); err != nil {
+			return err
+		}
+		if err := validateLabelValue(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelSelectors parses repeated ?label=key=value list filters, which are
+// ANDed together.
+func labelSelectors(query map[string][]string) (map[string]string, error) {
+	values := query[labelSelectorParam]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) > maxLabelSelectors {
+		return nil, fmt.Errorf("at most %d label selectors are allowed", maxLabelSelectors)
+	}
+	selectors := make(map[string]string, len(values))
+	for _, sel := range values {
+		key, value, ok := strings.Cut(sel, "=")
+		if !ok {
+			return nil, fmt.Errorf("label selector %q must take the form key=value", sel)
+		}
+		if err := validateLabelKey(key); err != nil {
+			return nil, err
+		}
+		if err := validateLabelValue(key, value); err != nil {
+			return nil, err
+		}
+		if prev, dup := selectors[key]; dup && prev != value {
+			return nil, fmt.Errorf("label %q is selected with two different values", key)
+		}
+		selectors[key] = value
+	}
+	return selectors, nil
+}
+
+// decodeLabels parses a body that must be a JSON object of strings, or
+// of strings and nulls when allowNull is set (a PATCH removing labels).
+func decodeLabels(raw []byte, allowNull bool) (set map[string]string, del []string, err error) {
+	var body map[string]*string
+	if err := json.Unmarshal(raw, &body); err != nil || body == nil {
+		return nil, nil, labelErrorf("labels must be a JSON object of string values")
+	}
+	set = make(map[string]string, len(body))
+	for k, v := range body {
+		if v == nil {
+			if !allowNull {
+				return nil, nil, labelErrorf("label %q: null is only allowed in PATCH, to remove the label", k)
+			}
+			del = append(del, k)
+			continue
+		}
+		set[k] = *v
+	}
+	return set, del, nil
+}
+
+// replaceLabels serves PUT /users/:id/labels: the body becomes the
+// user's complete label set.
+func (s *Server) replaceLabels(w http.ResponseWriter, r *http.Request) {
+	s.writeLabels(w, r, false)
+}
+
+// patchLabels serves PATCH /users/:id/labels: labels in the body are set,
+// null values remove them and all others are kept.
+func (s *Server) patchLabels(w http.ResponseWriter, r *http.Request) {
+	s.writeLabels(w, r, true)
+}
+
+func (s *Server) writeLabels(w http.ResponseWriter, r *http.Request, merge bool) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := s.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+
+	raw, ok := readBody(w, r)
+	if !ok {
+		return
+	}
+	set, del, err := decodeLabels(raw, merge)
+	if err == nil && !merge {
+		err = validateLabels(set)
+	}
+	if err != nil {
+		respondError(w, r, codeInvalidLabels, err.Error())
+		return
+	}
+
+	var labels map[string]string
+	if merge {
+		labels, err = s.repo.PatchLabels(r.Context(), id, version, set, del, validateLabels)
+	} else {
+		labels, err = s.repo.ReplaceLabels(r.Context(), id, version, set)
+	}
+
+	if s.writeRejected(w, r, err) {
+		return
+	}
+
+	var lblErr *labelError
+	switch {
+	case errors.As(err, &lblErr):
+		respondError(w, r, codeInvalidLabels, lblErr.Error())
+		return
+	case errors.Is(err, repository.ErrUserNotFound):
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	case errors.Is(err, repository.ErrVersionConflict):
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	case err != nil:
+		s.reqLog(r).Error().Err(err).Int64("id", id).Msg("failed to write labels")
+		respondError(w, r, codeInternal, "failed to update labels")
+		return
+	}
+	s.cache.invalidate()
+
+	writeJSON(w, r, http.StatusOK, userLabels{Labels: labels})
+}
+
+// deleteLabel serves DELETE /users/:id/labels/*key; the wildcard lets
+// prefixed keys such as example.com/team keep their slash.
+func (s *Server) deleteLabel(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.userIDParam(w, r)
+	if !ok {
+		return
+	}
+	version, ok := s.ifMatchVersion(w, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	if err := validateLabelKey(key); err != nil {
+		respondError(w, r, codeInvalidLabels, err.Error())
+		return
+	}
+
+	err := s.repo.DeleteLabel(r.Context(), id, version, key)
+	if s.writeRejected(w, r, err) {
+		return
+	}
+
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		respondError(w, r, codeUserNotFound, "user not found")
+		return
+	case errors.Is(err, repository.ErrLabelNotFound):
+		respondError(w, r, codeNotFound, "the user has no label "+key)
+		return
+	case errors.Is(err, repository.ErrVersionConflict):
+		respondError(w, r, codeVersionConflict, "user was modified since If-Match was read")
+		return
+	case err != nil:
+		s.reqLog(r).Error().Err(err).Int64("id", id).Str("key", key).Msg("failed to delete label")
+		respondError(w, r, codeInternal, "failed to delete label")
+		return
+	}
+	s.cache.invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestLabelSelectors(t *testing.T) {
+	tests := []struct {
+		query string
+		want  map[string]string
+		err   string
+	}{
+		{"", nil, ""},
+		{"label=team=core", map[string]string{"team": "core"}, ""},
+		{"label=team=core&label=env=prod", map[string]string{"team": "core", "env": "prod"}, ""},
+		{"label=example.com/team=core", map[string]string{"example.com/team": "core"}, ""},
+		{"label=team=", map[string]string{"team": ""}, ""},
+		{"label=team=core&label=team=core", map[string]string{"team": "core"}, ""},
+		{"label=team", nil, "must take the form key=value"},
+		{"label==core", nil, "the name must be"},
+		{"label=-team=core", nil, "the name must be"},
+		{"label=Example.com/team=core", nil, "the prefix must be"},
+		{"label=/team=core", nil, "the prefix must be"},
+		{"label=team=co re", nil, "the value must be"},
+		{"label=team=" + strings.Repeat("a", 64), nil, "the value must be"},
+		{"label=team=core&label=team=infra", nil, "two different values"},
+		{strings.Repeat("label=a=b&", maxLabelSelectors) + "label=a=b", nil, "at most 10 label selectors"},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		got, err := labelSelectors(q)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%q: error %v, want one containing %q", tt.query, err, tt.err)
+		case tt.err == "" && err != nil:
+			t.Errorf("%q: %v", tt.query, err)
+		case tt.err == "" && fmt.Sprint(got) != fmt.Sprint(tt.want):
+			t.Errorf("%q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	atLimit := map[string]string{}
+	for i := range maxLabelsPerUser {
+		atLimit[fmt.Sprintf("k%d", i)] = "v"
+	}
+	if err := validateLabels(atLimit); err != nil {
+		t.Errorf("%d labels: %v", maxLabelsPerUser, err)
+	}
+	atLimit["one-more"] = "v"
+	if err := validateLabels(atLimit); err == nil {
+		t.Errorf("%d labels accepted", len(atLimit))
+	}
+	for key, ok := range map[string]bool{
+		"team":                             true,
+		"a.b_c-d":                          true,
+		"example.com/team":                 true,
+		strings.Repeat("a", 63):            true,
+		strings.Repeat("a", 64):            false,
+		"team.":                            false,
+		"a/b/c":                            false,
+		strings.Repeat("a", 254) + "/team": false,
+	} {
+		if err := validateLabelKey(key); (err == nil) != ok {
+			t.Errorf("validateLabelKey(%q) = %v", key, err)
+		}
+	}
+}
+
+func TestLabelEndpoints(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	seedUsers(t, repo, 1)
+	path := "/api/v1/users/1/labels"
+
+	decode := func(body []byte) map[string]string {
+		t.Helper()
+		var r struct{ Labels map[string]string }
+		if err := json.Unmarshal(body, &r); err != nil {
+			t.Fatalf("%v: %s", err, body)
+		}
+		return r.Labels
+	}
+
+	w := serve(h, http.MethodPut, path, `{"team":"core","example.com/env":"prod"}`)
+	if w.Code != http.StatusOK || fmt.Sprint(decode(w.Body.Bytes())) != "map[example.com/env:prod team:core]" {
+		t.Fatalf("PUT: %d %s", w.Code, w.Body)
+	}
+	w = serve(h, http.MethodPatch, path, `{"team":null,"tier":"gold"}`)
+	if w.Code != http.StatusOK || fmt.Sprint(decode(w.Body.Bytes())) != "map[example.com/env:prod tier:gold]" {
+		t.Fatalf("PATCH: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodDelete, path+"/example.com/env", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE a prefixed key: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodDelete, path+"/example.com/env", ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE a missing label: %d", w.Code)
+	}
+	got, _ := repo.GetUserByID(context.Background(), 1)
+	if fmt.Sprint(got.Labels) != "map[tier:gold]" {
+		t.Errorf("stored labels %v", got.Labels)
+	}
+
+	var tooMany []string
+	for i := range maxLabelsPerUser {
+		tooMany = append(tooMany, fmt.Sprintf(`"k%d":"v"`, i))
+	}
+	for _, tc := range []struct{ method, body string }{
+		{http.MethodPut, `{"team":null}`},
+		{http.MethodPut, `["team"]`},
+		{http.MethodPut, `{"-team":"core"}`},
+		{http.MethodPatch, `{"team":"co re"}`},
+		// The limit applies to the merged set.
+		{http.MethodPatch, "{" + strings.Join(tooMany, ",") + "}"},
+	} {
+		w := serve(h, tc.method, path, tc.body)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidLabels.Code) {
+			t.Errorf("%s %.40s: %d %s, want 400 %s", tc.method, tc.body, w.Code, w.Body, codeInvalidLabels.Code)
+		}
+	}
+	if w := serve(h, http.MethodPut, "/api/v1/users/99/labels", `{"team":"core"}`); w.Code != http.StatusNotFound {
+		t.Errorf("labels of a missing user: %d", w.Code)
+	}
+}
+
+func TestListByLabels(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	ctx := context.Background()
+	for i, labels := range []map[string]string{
+		{"team": "core", "env": "prod"},
+		{"team": "core", "env": "staging"},
+		{"team": "infra", "env": "prod"},
+		{"team": "core", "env": "prod", "canary": ""},
+		nil,
+	} {
+		u, err := repo.CreateUser(ctx, fmt.Sprintf("User %d", i), fmt.Sprintf("u%d@example.com", i), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if labels != nil {
+			if _, err := repo.ReplaceLabels(ctx, u.ID, 0, labels); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for query, want := range map[string][]int64{
+		"label=team=core":                              {1, 2, 4},
+		"label=env=prod":                               {1, 3, 4},
+		"label=team=core&label=env=prod":               {1, 4},
+		"label=team=core&label=env=prod&label=canary=": {4},
+		"label=team=ops":                               {},
+		"label=team=infra&label=env=staging":           {},
+		"label=team=core&name=User 1":                  {2},
+	} {
+		w := serve(h, http.MethodGet, "/api/v1/users?"+strings.ReplaceAll(query, " ", "%20"), "")
+		var users []repository.User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body)
+		}
+		var ids []int64
+		for _, u := range users {
+			ids = append(ids, u.ID)
+		}
+		if !slices.Equal(ids, want) {
+			t.Errorf("%s: ids %v, want %v", query, ids, want)
+		}
+	}
+
+	for _, q := range []string{"label=team", "label=team=core&label=team=infra", strings.Repeat("label=a=b&", 11)} {
+		w := serve(h, http.MethodGet, "/api/v1/users?"+q, "")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidFilter.Code) {
+			t.Errorf("%s: %d %s, want 400", q, w.Code, w.Body)
+		}
+	}
+}
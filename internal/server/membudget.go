@@ -0,0 +1,129 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/repository"
+)
+
+// Batch bounds of the bulk operations a memoryBudget sizes.
+const (
+	exportMinRows = 50
+	exportMaxRows = 1000
+	batchMinUsers = 25
+)
+
+// memoryBudget sizes the batches of the CSV export and POST /users/batch
+// by how much of the memory limit is free. Every operation starts at its
+// largest batch; while less than headroom of the limit is free it halves
+// its batches down to the smallest, and once twice that is free again it
+// doubles them back. Without a limit the batches stay at their largest.
+type memoryBudget struct {
+	headroom float64
+	// limit is the memory limit in bytes, 0 when there is none; inUse
+	// is the memory it is measured against.
+	limit func() uint64
+	inUse func() uint64
+
+	size        *metrics.GaugeVec
+	adjustments *metrics.CounterVec
+}
+
+// newMemoryBudget budgets against GOMEMLIMIT; nil when headroom is 0.
+func newMemoryBudget(headroom float64) *memoryBudget {
+	if headroom <= 0 {
+		return nil
+	}
+	return &memoryBudget{headroom: headroom, limit: goMemLimit, inUse: goMemInUse}
+}
+
+func (b *memoryBudget) registerMetrics(reg *metrics.Registry) {
+	if b == nil {
+		return
+	}
+	b.size = reg.Gauge("bulk_batch_size", "Rows per batch the last bulk operation used, by operation (export or batch_create).", "operation")
+	b.adjustments = reg.Counter("bulk_batch_adjustments_total", "Batch size changes for memory headroom, by operation and direction (shrink or grow).", "operation", "direction")
+}
+
+// goMemLimit is the runtime's soft memory limit; it is unlimited unless
+// GOMEMLIMIT or debug.SetMemoryLimit set it.
+func goMemLimit() uint64 {
+	if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+		return uint64(l)
+	}
+	return 0
+}
+
+// goMemInUse is the memory the limit applies to: everything the runtime
+// mapped, less the heap it returned to the OS.
+func goMemInUse() uint64 {
+	samples := []rtmetrics.Sample{{Name: "/memory/classes/total:bytes"}, {Name: "/memory/classes/heap/released:bytes"}}
+	rtmetrics.Read(samples)
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// free is the share of the limit not in use; ok is false without a
+// limit.
+func (b *memoryBudget) free() (share float64, freeBytes uint64, ok bool) {
+	limit := b.limit()
+	if limit == 0 {
+		return 0, 0, false
+	}
+	if used := b.inUse(); used < limit {
+		freeBytes = limit - used
+	}
+	return float64(freeBytes) / float64(limit), freeBytes, true
+}
+
+// batchSizer sizes the batches of one operation.
+type batchSizer struct {
+	budget           *memoryBudget
+	op               string
+	log              *zerolog.Logger
+	minimum, maximum int
+	size             int
+}
+
+// batchSize returns the repository.BatchSize of operation op for the
+// request: between minimum and maximum rows by memory headroom, or nil,
+// all rows at once, without a budget.
+func (s *Server) batchSize(r *http.Request, op string, minimum, maximum int) repository.BatchSize {
+	if s.budget == nil {
+		return nil
+	}
+	z := &batchSizer{budget: s.budget, op: op, log: s.reqLog(r), minimum: minimum, maximum: maximum, size: maximum}
+	return z.next
+}
+
+// next adapts the size to the headroom now and returns it.
+func (z *batchSizer) next() int {
+	b := z.budget
+	share, freeBytes, ok := b.free()
+	switch {
+	case !ok:
+	case share < b.headroom && z.size > z.minimum:
+		z.resize(max(z.minimum, z.size/2), "shrink", share, freeBytes)
+	case share >= 2*b.headroom && z.size < z.maximum:
+		z.resize(min(z.maximum, z.size*2), "grow", share, freeBytes)
+	}
+	if b.size != nil {
+		b.size.With(z.op).Set(float64(z.size))
+	}
+	return z.size
+}
+
+func (z *batchSizer) resize(size int, direction string, share float64, freeBytes uint64) {
+	z.log.Info().Str("operation", z.op).Int("from", z.size).Int("batch_size", size).
+		Float64("free_ratio", share).Uint64("free_bytes", freeBytes).
+		Msg("bulk batch size adjusted for memory headroom")
+	z.size = size
+	if z.budget.adjustments != nil {
+		z.budget.adjustments.With(z.op, direction).Inc()
+	}
+}
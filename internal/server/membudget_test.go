@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestBatchSizerAdapts(t *testing.T) {
+	inUse := uint64(0)
+	b := &memoryBudget{headroom: 0.2, limit: func() uint64 { return 100 << 20 }, inUse: func() uint64 { return inUse }}
+	log := zerolog.Nop()
+	z := &batchSizer{budget: b, op: "export", log: &log, minimum: 50, maximum: 1000, size: 1000}
+
+	var sizes []int
+	for _, used := range []uint64{50, 90, 90, 90, 90, 90, 90, 70, 55, 55, 10, 10} {
+		inUse = used << 20
+		sizes = append(sizes, z.next())
+	}
+	// Shrinks below 20% free, holds between 20% and 40%, grows above.
+	want := []int{1000, 500, 250, 125, 62, 50, 50, 50, 100, 200, 400, 800}
+	if !slices.Equal(sizes, want) {
+		t.Errorf("sizes %v, want %v", sizes, want)
+	}
+
+	// Without a memory limit the batches stay at their largest.
+	b.limit = func() uint64 { return 0 }
+	inUse = 1 << 40
+	z.size = z.maximum
+	if n := z.next(); n != 1000 {
+		t.Errorf("without a limit: %d", n)
+	}
+}
+
+// With an artificially low memory budget the export and the batch create
+// shrink their batches to the least and still finish, and say so in the
+// log and the metrics.
+func TestBulkEndpointsUnderMemoryPressure(t *testing.T) {
+	s, repo := newTestServer(t, Config{MemoryHeadroom: 0.2})
+	s.budget.limit = func() uint64 { return 64 << 20 }
+	s.budget.inUse = func() uint64 { return 62 << 20 }
+	var logs bytes.Buffer
+	s.log = zerolog.New(&logs)
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+	h := s.Handler()
+
+	var body strings.Builder
+	body.WriteString("[")
+	for i := range 120 {
+		if i > 0 {
+			body.WriteString(",")
+		}
+		fmt.Fprintf(&body, `{"name":"Batch %d","email":"batch%d@example.com"}`, i, i)
+	}
+	body.WriteString("]")
+	if w := serve(h, http.MethodPost, "/api/v1/users/batch", body.String(), "Content-Type", "application/json"); w.Code != http.StatusCreated {
+		t.Fatalf("batch create: %d %s", w.Code, w.Body)
+	}
+	seedUsers(t, repo, exportMaxRows)
+
+	w := serve(h, http.MethodGet, "/api/v1/users.csv", "")
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if w.Code != http.StatusOK || err != nil || len(records) != 1+120+exportMaxRows {
+		t.Fatalf("export: %d, %d records, %v", w.Code, len(records), err)
+	}
+
+	out := serve(h, http.MethodGet, "/metrics", "").Body.String()
+	for _, line := range []string{
+		fmt.Sprintf(`bulk_batch_size{operation="export"} %d`, exportMinRows),
+		// 1000 to 50 takes five halvings.
+		`bulk_batch_adjustments_total{direction="shrink",operation="export"} 5`,
+		fmt.Sprintf(`bulk_batch_size{operation="batch_create"} %d`, batchMinUsers),
+		`bulk_batch_adjustments_total{direction="shrink",operation="batch_create"} 3`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+	if !strings.Contains(logs.String(), "bulk batch size adjusted for memory headroom") || !strings.Contains(logs.String(), `"batch_size":50`) {
+		t.Errorf("no adjustment logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Limits for the free-form metadata object attached to users.
+const (
+	maxMetadataDepth  = 5
+	maxMetadataKeys   = 50
+	maxMetadataBytes  = 8 << 10
+	maxMetadataKeyLen = 64
+)
+
+// reservedMetadataKeys would be confused with real user fields.
+var reservedMetadataKeys = map[string]bool{
+	"id":       true,
+	"name":     true,
+	"email":    true,
+	"metadata": true,
+	"labels":   true,
+}
+
+// metadataError is a client mistake in a metadata document; handlers
+// answer it with 400 and its message.
+type metadataError struct{ msg string }
+
+func (e *metadataError) Error() string { return e.msg }
+
+func metadataErrorf(format string, args ...any) error {
+	return &metadataError{msg: fmt.Sprintf(format, args...)}
+}
+
+// decodeMetadata parses a metadata payload that must be a JSON object.
+// Absent or null payloads return nil.
+func decodeMetadata(raw json.RawMessage) (map[string]any, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] != '{' {
+		return nil, metadataErrorf("metadata must be a JSON object")
+	}
+
+	var md map[string]any
+	if err := json.Unmarshal(raw, &md); err != nil {
+		return nil, metadataErrorf("metadata is not valid JSON")
+	}
+	return md, nil
+}
+
+// validateMetadata enforces key names, nesting depth, total key count and
+// serialized size on a complete metadata document.
+func validateMetadata(md map[string]any) error {
+	for k := range md {
+		if k == "" || len(k) > maxMetadataKeyLen {
+			return metadataErrorf("metadata keys must be 1-%d characters", maxMetadataKeyLen)
+		}
+		if reservedMetadataKeys[k] || strings.HasPrefix(k, "_") {
+			return metadataErrorf("metadata key %q is reserved", k)
+		}
+	}
+
+	keys, depth := metadataShape(md, 1)
+	if depth > maxMetadataDepth {
+		return metadataErrorf("metadata nesting exceeds %d levels", maxMetadataDepth)
+	}
+	if keys > maxMetadataKeys {
+		return metadataErrorf("metadata has more than %d keys", maxMetadataKeys)
+	}
+
+	b, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxMetadataBytes {
+		return metadataErrorf("metadata exceeds %d bytes", maxMetadataBytes)
+	}
+	return nil
+}
+
+// metadataShape counts object keys at every level and the maximum depth.
+func metadataShape(v any, level int) (keys, depth int) {
+	depth = level
+	switch v := v.(type) {
+	case map[string]any:
+		for _, child := range v {
+			k, d := metadataShape(child, level+1)
+			keys += k + 1
+			depth = max(depth, d)
+		}
+	case []any:
+		for _, child := range v {
+			k, d := metadataShape(child, level+1)
+			keys += k
+			depth = max(depth, d)
+		}
+	default:
+		// Scalars don't add a level of their own.
+		depth = level - 1
+	}
+	return keys, depth
+}
+
+// metadataFilter extracts ?metadata.<key>=<value> list filters.
+func metadataFilter(query map[string][]string) (map[string]string, error) {
+	var filter map[string]string
+	for param, values := range query {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok {
+			continue
+		}
+		if key == "" || len(values) != 1 {
+			return nil, errors.New("metadata filters take the form metadata.<key>=<value>")
+		}
+		if filter == nil {
+			filter = make(map[string]string)
+		}
+		filter[key] = values[0]
+	}
+	return filter, nil
+}
+
+// splitMetadataPatch separates keys to set from keys to delete (null values).
+func splitMetadataPatch(patch map[string]any) (set map[string]any, del []string) {
+	set = make(map[string]any, len(patch))
+	for k, v := range patch {
+		if v == nil {
+			del = append(del, k)
+			continue
+		}
+		set[k] = v
+	}
+	return set, del
+}
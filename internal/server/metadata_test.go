@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func nested(depth int) map[string]any {
+	md := map[string]any{"leaf": true}
+	for range depth - 1 {
+		md = map[string]any{"next": md}
+	}
+	return md
+}
+
+func TestValidateMetadata(t *testing.T) {
+	many := make(map[string]any)
+	for i := range maxMetadataKeys + 1 {
+		many[strings.Repeat("k", i+1)] = i
+	}
+	for name, tc := range map[string]struct {
+		md   map[string]any
+		want string // empty when valid
+	}{
+		"flat":          {md: map[string]any{"team": "platform", "tags": []any{"a", "b"}}},
+		"deepest":       {md: nested(maxMetadataDepth)},
+		"too deep":      {md: nested(maxMetadataDepth + 1), want: "nesting exceeds"},
+		"too many keys": {md: many, want: "more than 50 keys"},
+		"too big":       {md: map[string]any{"blob": strings.Repeat("x", maxMetadataBytes)}, want: "exceeds 8192 bytes"},
+		"reserved":      {md: map[string]any{"email": "x"}, want: `"email" is reserved`},
+		"underscore":    {md: map[string]any{"_internal": 1}, want: `"_internal" is reserved`},
+		"empty key":     {md: map[string]any{"": 1}, want: "keys must be"},
+	} {
+		err := validateMetadata(tc.md)
+		if (err == nil) != (tc.want == "") || err != nil && !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: %v, want %q", name, err, tc.want)
+		}
+	}
+
+	for _, raw := range []string{`[]`, `"x"`, `1`, `{"a":`} {
+		if _, err := decodeMetadata(json.RawMessage(raw)); err == nil {
+			t.Errorf("decodeMetadata(%s) accepted", raw)
+		}
+	}
+}
+
+func TestMetadataEndpoints(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	h := s.Handler()
+
+	w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com","metadata":{"team":"platform","level":3}}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"metadata":{"level":3,"team":"platform"}`) {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	serve(h, http.MethodPost, "/api/v1/users", `{"name":"Bob","email":"bob@example.com","metadata":{"team":"web"}}`)
+
+	for _, body := range []string{
+		`{"name":"C","email":"c@example.com","metadata":["team"]}`,
+		`{"name":"C","email":"c@example.com","metadata":{"id":1}}`,
+	} {
+		if w := serve(h, http.MethodPost, "/api/v1/users", body); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"code":"invalid_metadata"`) {
+			t.Errorf("create with %s: %d %s", body, w.Code, w.Body)
+		}
+	}
+
+	// Merge: level is deleted, team replaced, region added.
+	w = serve(h, http.MethodPatch, "/api/v1/users/1/metadata", `{"level":null,"team":"core","region":"eu"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"metadata":{"region":"eu","team":"core"}}` {
+		t.Errorf("patch: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1/metadata", `null`); w.Code != http.StatusBadRequest {
+		t.Errorf("null patch: %d", w.Code)
+	}
+	if w := serve(h, http.MethodPatch, "/api/v1/users/1/metadata", `{"blob":"`+strings.Repeat("x", maxMetadataBytes)+`"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("patch past the size limit: %d", w.Code)
+	}
+
+	var users []struct{ Name string }
+	w = serve(h, http.MethodGet, "/api/v1/users?metadata.team=core", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil || len(users) != 1 || users[0].Name != "Ada" {
+		t.Errorf("filter: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users?metadata.=core", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed filter: %d", w.Code)
+	}
+}
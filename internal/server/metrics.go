@@ -0,0 +1,77 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+const metricsPath = "/metrics"
+
+// requestMetrics is the HTTP instrumentation registered on the server's
+// metrics registry.
+type requestMetrics struct {
+	requests *metrics.CounterVec
+	duration *metrics.HistogramVec
+	aborted  *metrics.CounterVec
+	timedOut *metrics.CounterVec
+}
+
+func (s *Server) registerMetrics(reg *metrics.Registry) {
+	s.reqMetrics = &requestMetrics{
+		requests: reg.Counter("http_requests_total", "HTTP requests served, by route template and status.", "method", "route", "status"),
+		duration: reg.Histogram("http_request_duration_seconds", "HTTP request latency, by route template and status.", nil, "method", "route", "status"),
+		aborted:  reg.Counter("http_client_aborts_total", "Requests whose client went away before the body arrived, by route template.", "method", "route"),
+		timedOut: reg.Counter("http_requests_timed_out_total", "Requests answered with 504 because their deadline passed, by route template.", "method", "route"),
+	}
+	reg.GaugeFunc("http_requests_in_flight", "Requests currently being handled, probes excluded.",
+		func() float64 { return float64(s.pressure.inFlight.Load()) })
+	reg.GaugeFunc("read_only_mode", "1 while mutating requests are rejected as read-only.",
+		func() float64 { return boolGauge(s.readOnly.active()) })
+	reg.GaugeFunc("brownout_level", "Number of optional features currently shed under load.",
+		func() float64 { level, _ := s.brownout.state(); return float64(level) })
+	reg.GaugeFunc("background_workers_stale", "Background workers that missed their liveness deadline.",
+		func() float64 { return float64(len(s.workers.Stale())) })
+	reg.GaugeFunc("clock_skew_seconds", "Last measured offset of the database clock from the local one (DB minus local).",
+		s.clock.seconds)
+	s.retain.registerMetrics(reg)
+	s.cache.registerMetrics(reg)
+	s.growth.registerMetrics(reg)
+	s.limiter.registerMetrics(reg)
+	s.budget.registerMetrics(reg)
+}
+
+// middleware records every request under its route template, so ids in
+// paths don't create a series each; unmatched paths share one. Client
+// aborts are counted apart: they say nothing about the service.
+func (m *requestMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		route := routeOf(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		if statusOf(r) == statusClientClosedRequest {
+			m.aborted.With(r.Method, route).Inc()
+			return
+		}
+		status := strconv.Itoa(statusOf(r))
+		m.requests.With(r.Method, route, status).Inc()
+		m.duration.Observe(time.Since(start).Seconds(), r.Method, route, status)
+	})
+}
+
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	s.metrics.Handler().ServeHTTP(w, r)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
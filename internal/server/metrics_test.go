@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// Requests are counted under their route template, and scrapes are kept
+// out of the access log.
+func TestRequestMetrics(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	seedUsers(t, repo, 2)
+	h := s.Handler()
+
+	var logs bytes.Buffer
+	s.log = zerolog.New(&logs)
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+	serve(h, http.MethodGet, "/api/v1/users/1", "")
+	serve(h, http.MethodGet, "/api/v1/users/2", "")
+	serve(h, http.MethodGet, "/api/v1/users/99", "")
+	serve(h, http.MethodGet, "/no/such/path", "")
+	logged := logs.Len()
+	out := serve(h, http.MethodGet, "/metrics", "").Body.String()
+
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/api/v1/users/:id",status="200"} 2`,
+		`http_requests_total{method="GET",route="/api/v1/users/:id",status="404"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/api/v1/users/:id",status="200"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/api/v1/users/:id",status="200",le="+Inf"} 2`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("metrics lack %s", line)
+		}
+	}
+	if strings.Contains(out, "/api/v1/users/1") {
+		t.Error("a concrete id became a label value")
+	}
+
+	if !strings.Contains(logs.String(), `"/api/v1/users/1"`) {
+		t.Errorf("requests were not access-logged: %s", logs.String())
+	}
+	if logs.Len() != logged || strings.Contains(logs.String(), "/metrics") {
+		t.Errorf("the scrape was access-logged: %s", logs.String()[logged:])
+	}
+}
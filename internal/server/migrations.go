@@ -0,0 +1,32 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"go-k8s-demo/internal/migrate"
+)
+
+// migrationReporter is implemented by repositories whose database the
+// embedded migrations apply to; the in-memory one has none.
+type migrationReporter interface {
+	Migrations(ctx context.Context) (*migrate.Status, error)
+}
+
+// migrationStatus serves GET /admin/migrations: the schema version, the
+// embedded migrations the database lacks and, while a replica migrates,
+// which version it is applying and for how long.
+func (s *Server) migrationStatus(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := s.repo.(migrationReporter)
+	if !ok {
+		respondError(w, r, codeNotFound, "the configured repository has no database to migrate")
+		return
+	}
+	st, err := reporter.Migrations(r.Context())
+	if err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to read the migration status")
+		respondError(w, r, codeInternal, "failed to read the migration status")
+		return
+	}
+	writeJSON(w, r, http.StatusOK, st)
+}
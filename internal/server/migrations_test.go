@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/migrate"
+	"go-k8s-demo/internal/repository"
+)
+
+// migratingRepo answers Migrations as a database another replica is
+// migrating would, or fails with err.
+type migratingRepo struct {
+	*repository.Memory
+	err error
+}
+
+func (m migratingRepo) Migrations(context.Context) (*migrate.Status, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &migrate.Status{
+		Version:   9,
+		Latest:    11,
+		Pending:   []migrate.Pending{{Version: 10, Name: "V10__a.sql"}, {Version: 11, Name: "V11__b.sql"}},
+		Migrating: &migrate.Progress{PID: 42, Applying: 10, ElapsedSeconds: 3.5},
+	}, nil
+}
+
+func TestMigrationStatus(t *testing.T) {
+	s, err := New(Config{APIKeys: testAPIKeys}, WithRepository(migratingRepo{Memory: repository.NewMemory()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/migrations", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", w.Code)
+	}
+	w := serve(h, http.MethodGet, "/api/v1/admin/migrations", "", apiKeyHeader, aliceKey)
+	want := `{"version":9,"latest":11,"pending":[{"version":10,"name":"V10__a.sql"},{"version":11,"name":"V11__b.sql"}],"migrating":{"pid":42,"applying":10,"elapsed_seconds":3.5}}`
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != want {
+		t.Errorf("%d %s\nwant %s", w.Code, w.Body, want)
+	}
+
+	failing, err := New(Config{APIKeys: testAPIKeys}, WithRepository(migratingRepo{Memory: repository.NewMemory(), err: errors.New("connection refused")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = serve(failing.Handler(), http.MethodGet, "/api/v1/admin/migrations", "", apiKeyHeader, aliceKey)
+	var resp apiError
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusInternalServerError || resp.Code != codeInternal.Code {
+		t.Errorf("failing database: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestMigrationStatusWithoutDatabase(t *testing.T) {
+	s, _ := newTestServer(t, Config{APIKeys: testAPIKeys})
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/admin/migrations", "", apiKeyHeader, aliceKey)
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), codeNotFound.Code) {
+		t.Errorf("in-memory repository: %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func userFieldNames() []string {
+	names := make([]string, len(repository.UserFields))
+	for i, f := range repository.UserFields {
+		names[i] = f.JSON
+	}
+	return names
+}
+
+// The CSV header, the JSON body and the OpenAPI schema all follow
+// repository.UserFields; none keeps its own list.
+func TestUserArtifactsMatchDescriptor(t *testing.T) {
+	s, repo := newTestServer(t, Config{Docs: true})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+	want := userFieldNames()
+
+	w := serve(h, http.MethodGet, "/api/v1/users.csv", "")
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if w.Code != http.StatusOK || err != nil || len(rows) != 2 {
+		t.Fatalf("export: %d %v\n%s", w.Code, err, w.Body)
+	}
+	if !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("CSV header %v, want %v", rows[0], want)
+	}
+	if len(rows[1]) != len(want) {
+		t.Errorf("CSV row has %d columns, header %d", len(rows[1]), len(want))
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(serve(h, http.MethodGet, "/api/v1/users/1", "").Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range want {
+		if _, ok := body[name]; !ok && name != "deleted_at" { // omitempty
+			t.Errorf("JSON body lacks %s", name)
+		}
+		delete(body, name)
+	}
+	for name := range body {
+		t.Errorf("JSON body has %s, which is not in UserFields", name)
+	}
+
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage
+			}
+		}
+	}
+	if err := json.Unmarshal(serve(h, http.MethodGet, "/api/v1/openapi.json", "").Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	user, ok := spec.Components.Schemas["User"]
+	if !ok {
+		t.Fatal("no User schema")
+	}
+	var props []string
+	for name := range user.Properties {
+		props = append(props, name)
+	}
+	slices.Sort(props)
+	sorted := slices.Sorted(slices.Values(want))
+	if !reflect.DeepEqual(props, sorted) {
+		t.Errorf("User schema properties %v, want %v", props, sorted)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxNameRunes limits names by characters, not bytes, so multi-byte
+// scripts and emoji get the same budget as ASCII.
+const maxNameRunes = 100
+
+var (
+	errNameEmpty   = errors.New("name must not be empty")
+	errNameTooLong = errors.New("name is too long")
+	errNameInvalid = errors.New("name contains invalid characters")
+)
+
+// normalizeName returns name in NFC with surrounding whitespace trimmed,
+// so equal-looking names are stored and compared identically.
+//
+// Letters from every script and emoji are allowed. Control characters,
+// private-use code points, replacement characters (what the JSON decoder
+// turns unpaired surrogates into) and format characters are rejected,
+// except the joiners and tag characters emoji sequences rely on.
+func normalizeName(name string) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", errNameInvalid
+	}
+
+	name = strings.TrimSpace(norm.NFC.String(name))
+	if name == "" {
+		return "", errNameEmpty
+	}
+	if utf8.RuneCountInString(name) > maxNameRunes {
+		return "", errNameTooLong
+	}
+
+	for _, r := range name {
+		if !allowedNameRune(r) {
+			return "", errNameInvalid
+		}
+	}
+
+	return name, nil
+}
+
+func allowedNameRune(r rune) bool {
+	switch {
+	case r == utf8.RuneError:
+		return false
+	case r == '\u200C', r == '\u200D':
+		// Zero-width (non-)joiners: emoji ZWJ sequences and some scripts.
+		return true
+	case r >= 0xE0020 && r <= 0xE007F:
+		// Tag characters used by subdivision flag emoji.
+		return true
+	case r == ' ':
+		return true
+	case unicode.IsControl(r), unicode.IsSpace(r):
+		return false
+	case unicode.In(r, unicode.Cf, unicode.Co, unicode.Cs):
+		return false
+	}
+	return unicode.IsPrint(r)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// trickyNames are stored as given; each is already in NFC.
+var trickyNames = []string{
+	"José Ångström",        // precomposed accents
+	"Nguyễn Thị Minh Khai", // stacked diacritics
+	"עדה לאבלייס",          // right-to-left
+	"محمد بن موسى",         // right-to-left, joining script
+	"👩‍💻 Grace",            // ZWJ sequence
+	"🏴󠁧󠁢󠁳󠁣󠁴󠁿 Mairi",        // tag-character flag
+	"Ana 👍🏽",               // skin tone modifier
+	"नमस्ते दुनिया",        // combining vowel signs
+	"山田 太郎",                // CJK
+	"می‌خواهم",             // ZWNJ
+}
+
+func TestNormalizeName(t *testing.T) {
+	for _, name := range trickyNames {
+		if got, err := normalizeName(name); err != nil || got != name {
+			t.Errorf("normalizeName(%q) = %q, %v", name, got, err)
+		}
+	}
+
+	if got, _ := normalizeName("  Jose\u0301  "); got != "José" {
+		t.Errorf("decomposed name stored as %+q", got)
+	}
+	if _, err := normalizeName(strings.Repeat("😀", maxNameRunes)); err != nil {
+		t.Errorf("%d emoji rejected: %v", maxNameRunes, err)
+	}
+	if _, err := normalizeName(strings.Repeat("é", maxNameRunes+1)); err != errNameTooLong {
+		t.Errorf("%d runes: %v", maxNameRunes+1, err)
+	}
+	for _, bad := range []string{"Ada\x00", "Ada\nLovelace", "evil\u202Egnp", "\uE000", "Ada\uFFFD", "\xff"} {
+		if _, err := normalizeName(bad); err != errNameInvalid {
+			t.Errorf("normalizeName(%+q) = %v, want errNameInvalid", bad, err)
+		}
+	}
+}
+
+// Every tricky name survives create, get, list, CSV export and search
+// byte for byte, and a decomposed query finds the composed name.
+func TestTrickyNamesRoundTrip(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	h := s.Handler()
+
+	ids := make(map[string]int64)
+	for i, name := range trickyNames {
+		body, _ := json.Marshal(map[string]string{"name": norm.NFD.String(name), "email": fmt.Sprintf("tricky%d@example.com", i)})
+		w := serve(h, http.MethodPost, "/api/v1/users", string(body))
+		var u repository.User
+		if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &u) != nil {
+			t.Fatalf("create %q: %d %s", name, w.Code, w.Body)
+		}
+		if u.Name != name {
+			t.Errorf("created %+q, want %+q", u.Name, name)
+		}
+		ids[name] = u.ID
+	}
+
+	for name, id := range ids {
+		var u repository.User
+		w := serve(h, http.MethodGet, fmt.Sprintf("/api/v1/users/%d", id), "")
+		if json.Unmarshal(w.Body.Bytes(), &u) != nil || u.Name != name {
+			t.Errorf("get %d: %s", id, w.Body)
+		}
+	}
+
+	var listed []repository.User
+	if err := json.Unmarshal(serve(h, http.MethodGet, "/api/v1/users?limit=100", "").Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, u := range listed {
+		names = append(names, u.Name)
+	}
+	for _, name := range trickyNames {
+		if !slices.Contains(names, name) {
+			t.Errorf("list lacks %+q", name)
+		}
+	}
+
+	rows, err := csv.NewReader(serve(h, http.MethodGet, "/api/v1/users.csv", "").Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := slices.Index(rows[0], "name")
+	if col < 0 {
+		t.Fatalf("CSV header %q has no name column", rows[0])
+	}
+	var exported []string
+	for _, row := range rows[1:] {
+		exported = append(exported, row[col])
+	}
+	for _, name := range trickyNames {
+		if !slices.Contains(exported, name) {
+			t.Errorf("CSV export lacks %+q", name)
+		}
+	}
+
+	for _, name := range trickyNames {
+		q := url.QueryEscape(norm.NFD.String(name))
+		var found []repository.User
+		if err := json.Unmarshal(serve(h, http.MethodGet, "/api/v1/users?name="+q, "").Body.Bytes(), &found); err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].ID != ids[name] {
+			t.Errorf("search for decomposed %+q found %+v", name, found)
+		}
+	}
+}
@@ -0,0 +1,634 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go-k8s-demo/internal/auth"
+	"go-k8s-demo/internal/config"
+	"go-k8s-demo/internal/features"
+	"go-k8s-demo/internal/migrate"
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/supervisor"
+)
+
+// The OpenAPI document is assembled from three sources: the route table
+// (paths, methods, deprecation and the errors its middleware can send),
+// operationDocs below (what the handler itself reads and answers) and the
+// Go types of request and response bodies. checkRouteCoverage fails
+// startup when a route has no operationDocs entry.
+
+// schema is a literal OpenAPI schema object.
+type schema map[string]any
+
+// oneOf documents a body that is one of several Go types.
+type oneOf []any
+
+// param is a query or header parameter.
+type param struct {
+	Name        string
+	Description string
+	Schema      schema
+	Required    bool
+}
+
+// opDoc documents what the route table cannot say about an operation.
+type opDoc struct {
+	Summary     string
+	Description string
+	Query       []param
+	Headers     []param
+	// Body is a value of the request body type, nil for none.
+	Body any
+	// Status is the success status, 200 when zero.
+	Status int
+	// Response is a value of the success body type, a schema or a oneOf;
+	// nil leaves the body undocumented (HEAD, streamed pages).
+	Response any
+	// Produces lists the success media types, application/json when empty.
+	Produces []string
+	// ResponseHeaders maps success headers to their description.
+	ResponseHeaders map[string]string
+	// Errors lists what the handler answers on its own; errors of the
+	// route's middleware and of the :id parameter are added from the
+	// route table.
+	Errors []*apiError
+}
+
+var (
+	stringSchema  = schema{"type": "string"}
+	integerSchema = schema{"type": "integer", "format": "int64"}
+	booleanSchema = schema{"type": "boolean"}
+)
+
+var (
+	ifMatchHeader = param{Name: "If-Match", Description: "The ETag of the user as last read; the write fails with 412 when it changed since. * or absent skips the check unless strict concurrency control is enabled.", Schema: stringSchema}
+	listFilters   = []param{
+		{Name: "name", Description: "Case-insensitive substring of the name, at most 254 bytes.", Schema: stringSchema},
+		{Name: "email", Description: "Case-insensitive substring of the email, at most 254 bytes.", Schema: stringSchema},
+		{Name: "label", Description: "key=value label selector; repeat to require several.", Schema: schema{"type": "array", "items": stringSchema}},
+		{Name: "include_deleted", Description: "Include soft-deleted users; requires a bearer token or API key.", Schema: booleanSchema},
+		{Name: "sort", Description: `Comma-separated fields, descending with a leading minus, e.g. "name,-id".`, Schema: stringSchema},
+		{Name: "collation", Description: "Compare name and email in sort by this locale's rules; requires sorting by one of them. Echoed in X-Collation.", Schema: schema{"type": "string", "enum": repository.SupportedCollations()}},
+	}
+	metadataFilterNote = "Metadata filters take the form ?metadata.<key>=<value> and match users whose metadata has that top-level value."
+	userIDErrors       = []*apiError{codeInvalidID, codeUserNotFound}
+	authErrors         = []*apiError{codeUnauthorized, codeInvalidAPIKey, codeAuthUnavailable}
+	textHTML           = []string{"text/html; charset=utf-8"}
+)
+
+// userValidatorDocs are the validators GET and HEAD of a user send.
+var userValidatorDocs = map[string]string{
+	"ETag":          "The user's version, for If-Match.",
+	"Last-Modified": "When the user was last updated.",
+}
+
+// operationDocs is keyed by route OperationID.
+var operationDocs = map[string]opDoc{
+	"healthz": {Summary: "Liveness probe", Response: schema{"type": "object", "properties": schema{"status": stringSchema}}},
+	"readyz": {
+		Summary:     "Readiness probe",
+		Description: "503 with ready=false while the database is unreachable, a critical worker is stale or the server is shutting down.",
+		Response: schema{"type": "object", "properties": schema{
+			"ready":              booleanSchema,
+			"shutting_down":      booleanSchema,
+			"schema_version":     integerSchema,
+			"stale_workers":      schema{"type": "object", "additionalProperties": schema{"type": "number"}},
+			"clock_skew_seconds": schema{"type": "number"},
+			"brownout":           schema{"type": "object", "properties": schema{"level": integerSchema, "shed": schema{"type": "array", "items": stringSchema}}},
+			"read_only":          schema{"$ref": "#/components/schemas/ReadOnlyState"},
+		}},
+	},
+	"scalingPressure": {Summary: "Load signal for the autoscaler", Response: pressureReport{}},
+	"metrics":         {Summary: "Prometheus metrics", Response: stringSchema, Produces: []string{"text/plain; version=0.0.4"}},
+
+	"listProbeFailures": {Summary: "Recent failed probes", Response: schema{"type": "object", "properties": schema{"failures": arrayOf[probeFailure]()}}},
+	"listErrorCodes":    {Summary: "The error catalogue", Response: schema{"type": "object", "properties": schema{"errors": arrayOf[apiError]()}}},
+	"listAuthKeys":      {Summary: "Bearer token verification keys", Response: schema{"type": "object", "properties": schema{"keys": arrayOf[auth.KeyInfo]()}}, Errors: []*apiError{codeNotFound}},
+	"reloadAuthKeys":    {Summary: "Reload the bearer token verification keys", Response: schema{"type": "object", "properties": schema{"keys": arrayOf[auth.KeyInfo]()}}, Errors: []*apiError{codeAuthKeysStatic, codeAuthUnavailable}},
+	"listFeatures":      {Summary: "Enabled features", Response: features.Matrix{}},
+	"getConfig":         {Summary: "Resolved process configuration", Response: config.Effective{}},
+	"listWorkers":       {Summary: "Background worker status", Response: schema{"type": "object", "properties": schema{"workers": arrayOf[supervisor.Status]()}}},
+	"getDatabaseReport": {
+		Summary:  "Database health report",
+		Query:    []param{{Name: "format", Schema: schema{"type": "string", "enum": []string{"json", "text"}}}},
+		Response: repository.DBReport{},
+		Produces: []string{"application/json", "text/plain; charset=utf-8"},
+		Errors:   []*apiError{codeInvalidParameter, codeNotFound},
+	},
+	"getIndexAdvice": {
+		Summary:     "Frequent filter and sort combinations without a supporting index",
+		Description: "Counts the shapes users queries took over the last hour and matches them against pg_indexes. The CREATE INDEX statements are suggestions and are never run.",
+		Query:       []param{{Name: "min_count", Description: "Times a shape has to run within the window to be reported; 10 by default.", Schema: schema{"type": "integer", "minimum": 1}}},
+		Response:    repository.IndexAdvice{},
+		Errors:      []*apiError{codeInvalidParameter, codeNotFound},
+	},
+	"getMigrationStatus": {Summary: "Schema version, pending migrations and migration progress", Response: migrate.Status{}, Errors: []*apiError{codeNotFound}},
+	"setReadOnly":        {Summary: "Switch manual read-only mode", Body: readOnlyRequest{}, Response: readOnlyState{}},
+	"diffUser": {
+		Summary:  "Compare two users",
+		Query:    []param{{Name: "against", Description: "Id of the other user.", Schema: integerSchema, Required: true}},
+		Response: userDiff{},
+		Errors:   []*apiError{codeInvalidParameter},
+	},
+	"createShareLink": {
+		Summary:  "Create a signed, read-only share link",
+		Body:     shareLinkRequest{},
+		Status:   http.StatusCreated,
+		Response: shareLink{},
+		Errors:   []*apiError{codeInvalidParameter, codeShareLinksDisabled},
+	},
+	"getSharedUser": {
+		Summary:  "Fields of a user behind a share link",
+		Response: schema{"type": "object", "properties": schema{"id": integerSchema, "name": stringSchema, "email": stringSchema, "metadata": schema{"type": "object"}}},
+		Errors:   []*apiError{codeShareLinksDisabled, codeShareLinkInvalid, codeShareLinkExpired, codeShareLinkUsed, codeUserNotFound},
+	},
+
+	"listUsers": {
+		Summary:     "List users",
+		Description: "Offset pages (limit, offset) answer an array; cursor pages (after) answer an object with next_cursor. Without limit, offset or after every match up to the server's row cap is returned. Accept: text/csv streams the export instead. " + metadataFilterNote,
+		Query: append([]param{
+			{Name: "limit", Description: "Page size, capped at 200; 50 when only offset or after is given.", Schema: schema{"type": "integer", "minimum": 1, "maximum": maxPageSize}},
+			{Name: "offset", Description: "Rows to skip; cannot be combined with after.", Schema: schema{"type": "integer", "minimum": 0}},
+			{Name: "after", Description: "A next_cursor from the previous page; empty for the first page. Cannot be combined with sort.", Schema: stringSchema},
+		}, listFilters...),
+		Response:        oneOf{[]repository.User(nil), userPage{}},
+		Produces:        []string{"application/json", mimeCSV},
+		ResponseHeaders: map[string]string{"X-Total-Count": "Number of users matching the filters; not sent with after.", "X-Result-Truncated": "true when the row cap cut the result short.", collationHeader: "The collation the users were sorted by, when one was given."},
+		Errors:          append([]*apiError{codeInvalidParameter, codeInvalidFilter, codeFeatureDisabled, codeResultTooLarge}, authErrors...),
+	},
+	"headUsers": {
+		Summary:         "Count users",
+		Description:     "GET /users without the body. " + metadataFilterNote,
+		Query:           listFilters,
+		ResponseHeaders: map[string]string{"X-Total-Count": "Number of users matching the filters."},
+		Errors:          append([]*apiError{codeInvalidParameter, codeInvalidFilter, codeFeatureDisabled, codeResultTooLarge}, authErrors...),
+	},
+	"exportUsersCSV": {
+		Summary:     "Export users as CSV",
+		Description: "Takes the filters and sort of GET /users but not pagination. A failure after the first row ends the download early. " + metadataFilterNote,
+		Query:       listFilters,
+		Response:    stringSchema,
+		Produces:    []string{mimeCSV},
+		Errors:      append([]*apiError{codeInvalidParameter, codeInvalidFilter, codeFeatureDisabled}, authErrors...),
+	},
+	"getUser": {Summary: "Get a user", Response: repository.User{}, ResponseHeaders: userValidatorDocs},
+	"headUser": {
+		Summary:         "Check that a user exists",
+		Description:     "Sends the headers of GET /users/{id}, Content-Length included, without the body.",
+		ResponseHeaders: userValidatorDocs,
+	},
+	"createUser": {
+		Summary:  "Create a user",
+		Body:     userRequest{},
+		Status:   http.StatusCreated,
+		Response: repository.User{},
+		Errors:   []*apiError{codeInvalidName, codeInvalidMetadata, codeEmailInUse, codeStorageFull},
+		ResponseHeaders: map[string]string{
+			"Location":      "The new user's URL.",
+			"ETag":          userValidatorDocs["ETag"],
+			"Last-Modified": userValidatorDocs["Last-Modified"],
+		},
+	},
+	"createUsers": {
+		Summary:     "Create users in one transaction",
+		Description: "Up to 1000 users; one invalid or taken email fails the whole batch with its index in details.",
+		Body:        []userRequest(nil),
+		Status:      http.StatusCreated,
+		Response:    usersCreated{},
+		Errors:      []*apiError{codeEmailInUse, codeStorageFull},
+	},
+	"updateUser": {
+		Summary:         "Replace a user",
+		Headers:         []param{ifMatchHeader},
+		Body:            userRequest{},
+		Response:        userUpdated{},
+		ResponseHeaders: map[string]string{"ETag": "The new version."},
+		Errors:          []*apiError{codeInvalidName, codeInvalidMetadata, codeEmailInUse, codeVersionConflict, codeIfMatchRequired, codeInvalidParameter},
+	},
+	"patchUser": {
+		Summary:         "Change a user's name or email",
+		Headers:         []param{ifMatchHeader},
+		Body:            patchUserRequest{},
+		Response:        userUpdated{},
+		ResponseHeaders: map[string]string{"ETag": "The new version."},
+		Errors:          []*apiError{codeInvalidName, codeEmailInUse, codeVersionConflict, codeIfMatchRequired, codeInvalidParameter},
+	},
+	"deleteUser": {
+		Summary:     "Delete a user",
+		Description: "Soft-deletes unless hard=true. A dry run (dry_run=true or Prefer: dry-run) answers the plan and changes nothing.",
+		Query: []param{
+			{Name: "hard", Description: "Remove the user and its rows instead of marking it deleted.", Schema: booleanSchema},
+			{Name: "dry_run", Description: "Preview the delete.", Schema: booleanSchema},
+		},
+		Headers:  []param{ifMatchHeader, {Name: "Prefer", Description: "dry-run previews the delete.", Schema: stringSchema}},
+		Response: oneOf{userDeleted{}, deletePlanned{}},
+		Errors:   []*apiError{codeInvalidParameter, codeVersionConflict, codeIfMatchRequired},
+	},
+	"restoreUser": {Summary: "Undo a soft delete", Response: repository.User{}},
+	"patchUserMetadata": {
+		Summary:  "Merge into a user's metadata",
+		Headers:  []param{ifMatchHeader},
+		Body:     schema{"type": "object", "description": "Keys to set; null deletes a key."},
+		Response: userMetadata{},
+		Errors:   []*apiError{codeInvalidMetadata, codeVersionConflict, codeIfMatchRequired, codeInvalidParameter},
+	},
+	"replaceUserLabels": {
+		Summary:  "Replace a user's labels",
+		Headers:  []param{ifMatchHeader},
+		Body:     map[string]string(nil),
+		Response: userLabels{},
+		Errors:   []*apiError{codeInvalidLabels, codeVersionConflict, codeIfMatchRequired, codeInvalidParameter},
+	},
+	"patchUserLabels": {
+		Summary:  "Set or remove some of a user's labels",
+		Headers:  []param{ifMatchHeader},
+		Body:     schema{"type": "object", "description": "Labels to set; null removes one.", "additionalProperties": schema{"type": "string", "nullable": true}},
+		Response: userLabels{},
+		Errors:   []*apiError{codeInvalidLabels, codeVersionConflict, codeIfMatchRequired, codeInvalidParameter},
+	},
+	"deleteUserLabel": {
+		Summary:  "Remove one label",
+		Headers:  []param{ifMatchHeader},
+		Response: userLabels{},
+		Errors:   []*apiError{codeInvalidLabels, codeNotFound, codeVersionConflict, codeIfMatchRequired, codeInvalidParameter},
+	},
+	"getUserViews": {Summary: "A user's view count", Response: viewCount{}},
+	"addUserView": {
+		Summary:     "Count a view",
+		Description: "With view batching enabled the increment is queued and the answer is 202.",
+		Response:    oneOf{viewCount{}, viewQueued{}},
+	},
+
+	"errorCodesDoc": {Summary: "The error catalogue as a page", Response: stringSchema, Produces: textHTML},
+	"openAPISpec":   {Summary: "This document", Response: schema{"type": "object"}},
+	"swaggerUI":     {Summary: "Interactive API documentation", Response: stringSchema, Produces: textHTML},
+	"demoUI":        {Summary: "Demo UI assets", Response: stringSchema, Produces: []string{"*/*"}, Errors: []*apiError{codeNotFound}},
+}
+
+func arrayOf[T any]() schema {
+	return schema{"type": "array", "items": reflect.TypeFor[T]()}
+}
+
+// openAPISpec serves GET /openapi.json for the API version it is mounted
+// under. Paths are relative to that version's server URL; the probes
+// outside it carry their own.
+func (s *Server) openAPISpec(w http.ResponseWriter, r *http.Request) {
+	version := apiVersion{Routes: s.v1Routes}
+	prefix := s.versionPrefix(r)
+	for _, v := range s.apiVersions() {
+		if v.Prefix == prefix {
+			version = v
+		}
+	}
+	base := strings.TrimSuffix(s.link(r, ""), prefix)
+	root := base
+	if !s.cfg.ProbesUnderBasePath {
+		root = strings.TrimSuffix(base, s.cfg.BasePath)
+	}
+	if root == "" {
+		root = "/"
+	}
+	server := base + prefix
+	if server == "" {
+		server = "/" // the legacy mount at the root
+	}
+
+	b := schemaBuilder{components: map[string]any{}}
+	paths := map[string]schema{}
+	for _, rt := range s.rootRoutes() {
+		if s.onManagement(rt) {
+			continue // not reachable on this listener
+		}
+		item := b.pathItem(paths, rt)
+		item["servers"] = []schema{{"url": root}}
+	}
+	for _, rt := range version.Routes() {
+		rt.Deprecated = rt.Deprecated || version.Deprecated
+		b.pathItem(paths, rt)
+	}
+	b.of(reflect.TypeFor[readOnlyState]())
+
+	writeJSON(w, r, http.StatusOK, object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "Users API",
+			"version":     "1.0",
+			"description": "Every error answers the ErrorResponse schema; match on code, the message may change. GET /errors lists all codes.",
+		},
+		"servers": []object{{"url": server}},
+		"paths":   paths,
+		"components": object{
+			"schemas": b.components,
+			"securitySchemes": object{
+				"bearerAuth": object{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"apiKey":     object{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+			},
+		},
+	})
+}
+
+var openAPIPath = regexp.MustCompile(`[:*]([^/]+)`)
+
+// pathItem adds the operation for rt to paths and returns its path item.
+func (b *schemaBuilder) pathItem(paths map[string]schema, rt route) schema {
+	p := openAPIPath.ReplaceAllString(rt.Path, "{$1}")
+	item, ok := paths[p]
+	if !ok {
+		item = schema{}
+		paths[p] = item
+	}
+	item[strings.ToLower(rt.Method)] = b.operation(rt)
+	return item
+}
+
+func (b *schemaBuilder) operation(rt route) schema {
+	doc := operationDocs[rt.OperationID]
+	op := schema{"operationId": rt.OperationID, "summary": doc.Summary}
+	if doc.Description != "" {
+		op["description"] = doc.Description
+	}
+	if rt.Deprecated {
+		op["deprecated"] = true
+	}
+	if rt.Auth {
+		op["security"] = []schema{{"bearerAuth": []string{}}, {"apiKey": []string{}}}
+	}
+
+	var params []schema
+	for _, name := range openAPIPath.FindAllStringSubmatch(rt.Path, -1) {
+		p := schema{"name": name[1], "in": "path", "required": true, "schema": stringSchema}
+		if name[1] == "id" {
+			p["schema"] = schema{"type": "string", "pattern": "^[1-9][0-9]*$"}
+		}
+		params = append(params, p)
+	}
+	for _, in := range []struct {
+		where  string
+		params []param
+	}{{"query", doc.Query}, {"header", doc.Headers}} {
+		for _, p := range in.params {
+			params = append(params, b.param(in.where, p))
+		}
+	}
+	if rt.Method == http.MethodPost && !rt.AllowDuplicates {
+		params = append(params, schema{"name": "Idempotency-Key", "in": "header", "description": "Replays the first response to retries with the same key.", "schema": stringSchema})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.Body != nil {
+		op["requestBody"] = schema{"required": true, "content": schema{"application/json": schema{"schema": b.value(doc.Body)}}}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := schema{"description": http.StatusText(status)}
+	if doc.Response != nil {
+		produces := doc.Produces
+		if len(produces) == 0 {
+			produces = []string{"application/json"}
+		}
+		content := schema{}
+		// Alternatives to the first media type are text renderings.
+		for i, media := range produces {
+			if i == 0 {
+				content[media] = schema{"schema": b.value(doc.Response)}
+			} else {
+				content[media] = schema{"schema": stringSchema}
+			}
+		}
+		success["content"] = content
+	}
+	headers := schema{}
+	for name, desc := range doc.ResponseHeaders {
+		headers[name] = schema{"description": desc, "schema": stringSchema}
+	}
+	if rt.RateLimit != rateExempt {
+		for name, desc := range rateLimitHeaderDocs {
+			headers[name] = schema{"description": desc, "schema": integerSchema}
+		}
+	}
+	if len(headers) > 0 {
+		success["headers"] = headers
+	}
+	responses := schema{strconv.Itoa(status): success}
+	for status, codes := range routeErrors(rt, doc) {
+		names := make([]string, len(codes))
+		for i, e := range codes {
+			names[i] = e.Code
+		}
+		responses[strconv.Itoa(status)] = schema{
+			"description": strings.Join(names, ", "),
+			"content": schema{"application/json": schema{"schema": schema{"allOf": []schema{
+				b.of(reflect.TypeFor[errorResponse]()),
+				{"properties": schema{"code": schema{"type": "string", "enum": names}}},
+			}}}},
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+func (b *schemaBuilder) param(in string, p param) schema {
+	out := schema{"name": p.Name, "in": in, "schema": p.Schema}
+	if p.Description != "" {
+		out["description"] = p.Description
+	}
+	if p.Required {
+		out["required"] = true
+	}
+	if p.Schema["type"] == "array" {
+		out["explode"] = true
+	}
+	return out
+}
+
+// routeErrors groups every error rt can answer by status: the handler's
+// own from doc and those its middleware and parameters imply.
+func routeErrors(rt route, doc opDoc) map[int][]*apiError {
+	codes := slices.Clone(doc.Errors)
+	if strings.Contains(rt.Path, ":id") {
+		codes = append(codes, userIDErrors...)
+	}
+	if rt.Auth {
+		codes = append(codes, authErrors...)
+	}
+	if doc.Body != nil {
+		codes = append(codes, codeInvalidPayload, codePayloadTooLarge)
+	}
+	if rt.Timeout > 0 {
+		codes = append(codes, codeDeadlineExceeded)
+	}
+	if isMutating(rt.Method) && !rt.AllowInReadOnly {
+		codes = append(codes, codeReadOnly)
+	}
+	if rt.Method == http.MethodPost && !rt.AllowDuplicates {
+		codes = append(codes, codeIdempotencyKeyRequired, codeIdempotencyKeyReused, codeRequestInProgress)
+	}
+	if rt.RateLimit != rateExempt {
+		codes = append(codes, codeRateLimited, codeInternal)
+	}
+
+	byStatus := make(map[int][]*apiError)
+	for _, e := range codes {
+		if !slices.Contains(byStatus[e.Status], e) {
+			byStatus[e.Status] = append(byStatus[e.Status], e)
+		}
+	}
+	for _, list := range byStatus {
+		sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+	}
+	return byStatus
+}
+
+// schemaBuilder turns Go types into schemas, collecting named structs
+// as components.
+type schemaBuilder struct {
+	components map[string]any
+}
+
+var (
+	timeType = reflect.TypeFor[time.Time]()
+	rawType  = reflect.TypeFor[json.RawMessage]()
+)
+
+// value documents v: a literal schema as is, a oneOf as alternatives and
+// anything else by its type.
+func (b *schemaBuilder) value(v any) schema {
+	switch v := v.(type) {
+	case schema:
+		out := schema{}
+		for k, e := range v {
+			out[k] = b.nested(e)
+		}
+		return out
+	case oneOf:
+		alts := make([]schema, len(v))
+		for i, e := range v {
+			alts[i] = b.value(e)
+		}
+		return schema{"oneOf": alts}
+	}
+	return b.of(reflect.TypeOf(v))
+}
+
+// nested resolves Go types embedded in literal schemas, as arrayOf does.
+func (b *schemaBuilder) nested(v any) any {
+	switch v := v.(type) {
+	case reflect.Type:
+		return b.of(v)
+	case schema:
+		return b.value(v)
+	}
+	return v
+}
+
+func (b *schemaBuilder) of(t reflect.Type) schema {
+	switch t {
+	case timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case rawType:
+		return schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.of(t.Elem())
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return schema{"type": "array", "items": b.of(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": b.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := componentName(t)
+		ref := schema{"$ref": "#/components/schemas/" + name}
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = schema{} // breaks cycles
+			b.components[name] = b.object(t)
+		}
+		return ref
+	}
+	return schema{}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) schema {
+	props := schema{}
+	var required []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop := b.of(f.Type)
+		rules := strings.Split(f.Tag.Get("binding"), ",")
+		if slices.Contains(rules, "email") {
+			prop["format"] = "email"
+		}
+		if slices.Contains(rules, "required") {
+			required = append(required, name)
+		}
+		props[name] = prop
+	}
+	out := schema{"type": "object", "properties": props}
+	if len(required) > 0 {
+		out["required"] = required
+	}
+	return out
+}
+
+// componentName is the exported form of t's name, e.g. UserRequest.
+func componentName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+var swaggerPage = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Users API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "{{.}}", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`))
+
+// swaggerUI serves GET /docs. Swagger UI itself is loaded from a CDN by
+// the browser; the server only hands out the page.
+func (s *Server) swaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := swaggerPage.Execute(w, s.link(r, "/openapi.json")); err != nil {
+		s.reqLog(r).Error().Err(err).Msg("failed to render API docs")
+	}
+}
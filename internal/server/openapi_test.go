@@ -0,0 +1,323 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// specValidator checks a decoded document against the structural rules of
+// the OpenAPI 3.0 schema the generator can break: required members, known
+// keywords, parameter placement and resolvable references.
+type specValidator struct {
+	t          *testing.T
+	schemas    map[string]any
+	security   map[string]any
+	operations map[string]string
+}
+
+var (
+	openAPIVersion  = regexp.MustCompile(`^3\.0\.\d+$`)
+	responseStatus  = regexp.MustCompile(`^([1-5]\d\d|[1-5]XX|default)$`)
+	pathTemplate    = regexp.MustCompile(`\{([^/{}]+)\}`)
+	pathItemMembers = []string{"summary", "description", "servers", "parameters", "get", "put", "post", "delete", "options", "head", "patch", "trace"}
+	schemaKeywords  = []string{
+		"$ref", "type", "format", "description", "enum", "pattern", "minimum", "maximum", "minLength", "maxLength",
+		"minItems", "maxItems", "items", "properties", "additionalProperties", "required", "allOf", "oneOf", "anyOf",
+		"nullable", "readOnly", "writeOnly", "example", "default", "deprecated",
+	}
+	schemaTypes = []string{"string", "number", "integer", "boolean", "array", "object"}
+)
+
+func (v *specValidator) errorf(at, format string, args ...any) {
+	v.t.Helper()
+	v.t.Errorf("%s: %s", at, fmt.Sprintf(format, args...))
+}
+
+func (v *specValidator) document(doc map[string]any) {
+	if s, _ := doc["openapi"].(string); !openAPIVersion.MatchString(s) {
+		v.errorf("openapi", "%v is not a 3.0 version", doc["openapi"])
+	}
+	info, _ := doc["info"].(map[string]any)
+	for _, key := range []string{"title", "version"} {
+		if s, _ := info[key].(string); s == "" {
+			v.errorf("info", "%s missing", key)
+		}
+	}
+	v.servers("servers", doc["servers"])
+	components, _ := doc["components"].(map[string]any)
+	v.schemas, _ = components["schemas"].(map[string]any)
+	v.security, _ = components["securitySchemes"].(map[string]any)
+	for name, s := range v.schemas {
+		v.schema("components.schemas."+name, s)
+	}
+
+	paths, ok := doc["paths"].(map[string]any)
+	if !ok || len(paths) == 0 {
+		v.errorf("paths", "missing")
+	}
+	for path, raw := range paths {
+		if !strings.HasPrefix(path, "/") {
+			v.errorf(path, "path does not start with /")
+		}
+		item, _ := raw.(map[string]any)
+		for member, op := range item {
+			switch {
+			case !slices.Contains(pathItemMembers, member):
+				v.errorf(path, "unknown path item member %q", member)
+			case member == "servers":
+				v.servers(path+" servers", op)
+			case member != "summary" && member != "description" && member != "parameters":
+				v.operation(path, member, op)
+			}
+		}
+	}
+}
+
+func (v *specValidator) servers(at string, raw any) {
+	servers, ok := raw.([]any)
+	if !ok || len(servers) == 0 {
+		v.errorf(at, "no servers")
+	}
+	for _, s := range servers {
+		if url, _ := s.(map[string]any)["url"].(string); url == "" {
+			v.errorf(at, "server without url: %v", s)
+		}
+	}
+}
+
+func (v *specValidator) operation(path, method string, raw any) {
+	at := strings.ToUpper(method) + " " + path
+	op, _ := raw.(map[string]any)
+	id, _ := op["operationId"].(string)
+	if prev, dup := v.operations[id]; id == "" || dup {
+		v.errorf(at, "operationId %q missing or also used by %s", id, prev)
+	}
+	v.operations[id] = at
+
+	seen := map[string]bool{}
+	declared := map[string]bool{}
+	params, _ := op["parameters"].([]any)
+	for _, raw := range params {
+		p, _ := raw.(map[string]any)
+		name, _ := p["name"].(string)
+		in, _ := p["in"].(string)
+		if name == "" || !slices.Contains([]string{"query", "header", "path", "cookie"}, in) {
+			v.errorf(at, "parameter %v needs a name and a valid in", p)
+		}
+		if seen[in+" "+name] {
+			v.errorf(at, "%s parameter %s declared twice", in, name)
+		}
+		seen[in+" "+name] = true
+		if in == "path" {
+			declared[name] = true
+			if p["required"] != true {
+				v.errorf(at, "path parameter %s is not required", name)
+			}
+		}
+		v.schema(at+" parameter "+name, p["schema"])
+	}
+	for _, m := range pathTemplate.FindAllStringSubmatch(path, -1) {
+		if !declared[m[1]] {
+			v.errorf(at, "path parameter %s not declared", m[1])
+		}
+		delete(declared, m[1])
+	}
+	for name := range declared {
+		v.errorf(at, "path parameter %s not in the path", name)
+	}
+
+	if body, ok := op["requestBody"]; ok {
+		v.content(at+" requestBody", body.(map[string]any)["content"])
+	}
+	responses, _ := op["responses"].(map[string]any)
+	if len(responses) == 0 {
+		v.errorf(at, "no responses")
+	}
+	for status, raw := range responses {
+		resp, _ := raw.(map[string]any)
+		if !responseStatus.MatchString(status) {
+			v.errorf(at, "response key %q", status)
+		}
+		if d, _ := resp["description"].(string); d == "" {
+			v.errorf(at+" "+status, "response without description")
+		}
+		if content, ok := resp["content"]; ok {
+			v.content(at+" "+status, content)
+		}
+		headers, _ := resp["headers"].(map[string]any)
+		for name, h := range headers {
+			v.schema(at+" "+status+" header "+name, h.(map[string]any)["schema"])
+		}
+	}
+
+	security, _ := op["security"].([]any)
+	for _, req := range security {
+		for name := range req.(map[string]any) {
+			if _, ok := v.security[name]; !ok {
+				v.errorf(at, "unknown security scheme %s", name)
+			}
+		}
+	}
+}
+
+func (v *specValidator) content(at string, raw any) {
+	content, _ := raw.(map[string]any)
+	if len(content) == 0 {
+		v.errorf(at, "no content")
+	}
+	for media, m := range content {
+		if !strings.Contains(media, "/") {
+			v.errorf(at, "media type %q", media)
+		}
+		v.schema(at+" "+media, m.(map[string]any)["schema"])
+	}
+}
+
+func (v *specValidator) schema(at string, raw any) {
+	s, ok := raw.(map[string]any)
+	if !ok {
+		v.errorf(at, "schema is %T, not an object", raw)
+		return
+	}
+	for key := range s {
+		if !slices.Contains(schemaKeywords, key) {
+			v.errorf(at, "unknown schema keyword %q", key)
+		}
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		name, found := strings.CutPrefix(ref, "#/components/schemas/")
+		if _, exists := v.schemas[name]; !found || !exists {
+			v.errorf(at, "unresolved $ref %s", ref)
+		}
+		if len(s) > 1 {
+			v.errorf(at, "$ref with siblings, which OpenAPI 3.0 ignores")
+		}
+		return
+	}
+	if typ, ok := s["type"]; ok && !slices.Contains(schemaTypes, fmt.Sprint(typ)) {
+		v.errorf(at, "type %v", typ)
+	}
+	if s["type"] == "array" {
+		if _, ok := s["items"]; !ok {
+			v.errorf(at, "array without items")
+		}
+	}
+	if items, ok := s["items"]; ok {
+		v.schema(at+"[]", items)
+	}
+	props, _ := s["properties"].(map[string]any)
+	for name, p := range props {
+		v.schema(at+"."+name, p)
+	}
+	if required, ok := s["required"].([]any); ok {
+		for _, name := range required {
+			if _, ok := props[fmt.Sprint(name)]; !ok {
+				v.errorf(at, "required %v is not a property", name)
+			}
+		}
+	}
+	switch ap := s["additionalProperties"].(type) {
+	case nil, bool:
+	default:
+		v.schema(at+"{}", ap)
+	}
+	for _, key := range []string{"allOf", "oneOf", "anyOf"} {
+		if list, ok := s[key]; ok {
+			alts, _ := list.([]any)
+			if len(alts) == 0 {
+				v.errorf(at, "empty %s", key)
+			}
+			for i, alt := range alts {
+				v.schema(fmt.Sprintf("%s %s[%d]", at, key, i), alt)
+			}
+		}
+	}
+	if enum, ok := s["enum"]; ok {
+		if list, _ := enum.([]any); len(list) == 0 {
+			v.errorf(at, "empty enum")
+		}
+	}
+}
+
+// The served documents, with every optional route mounted, are valid
+// OpenAPI 3.0 and cover each route of their version.
+func TestOpenAPIDocumentIsValid(t *testing.T) {
+	s, _ := newTestServer(t, Config{Docs: true, LegacyRoutes: true, APIKeys: testAPIKeys, ShareLinkSecret: "s3cret"})
+	for _, prefix := range []string{"/api/v1", ""} {
+		w := serve(s.Handler(), http.MethodGet, prefix+"/openapi.json", "")
+		var doc map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s/openapi.json: %d %v", prefix, w.Code, err)
+		}
+		v := &specValidator{t: t, operations: map[string]string{}}
+		v.document(doc)
+
+		for _, rt := range append(s.rootRoutes(), s.v1Routes()...) {
+			if _, ok := v.operations[rt.OperationID]; !ok {
+				t.Errorf("%s: %s %s is not documented", prefix, rt.Method, rt.Path)
+			}
+		}
+		if len(v.operations) != len(s.rootRoutes())+len(s.v1Routes()) {
+			t.Errorf("%s: %d operations for %d routes", prefix, len(v.operations), len(s.rootRoutes())+len(s.v1Routes()))
+		}
+	}
+}
+
+func TestOpenAPIListParameters(t *testing.T) {
+	s, _ := newTestServer(t, Config{Docs: true})
+	type operation struct {
+		Parameters []struct{ Name, In string }
+		Responses  map[string]json.RawMessage
+	}
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	if err := json.Unmarshal(serve(s.Handler(), http.MethodGet, "/api/v1/openapi.json", "").Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	var list, put operation
+	if err := json.Unmarshal(spec.Paths["/users"]["get"], &list); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(spec.Paths["/users/{id}"]["put"], &put); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range list.Parameters {
+		names = append(names, p.In+" "+p.Name)
+	}
+	for _, want := range []string{"query limit", "query offset", "query after", "query sort", "query name", "query label", "query include_deleted"} {
+		if !slices.Contains(names, want) {
+			t.Errorf("GET /users lacks %s: %v", want, names)
+		}
+	}
+	for _, status := range []string{"200", "400", "429", "500"} {
+		if _, ok := list.Responses[status]; !ok {
+			t.Errorf("GET /users lacks a %s response", status)
+		}
+	}
+	if _, ok := put.Responses["412"]; !ok {
+		t.Error("PUT /users/{id} lacks the 412 of If-Match")
+	}
+}
+
+func TestDocsFlag(t *testing.T) {
+	off, _ := newTestServer(t, Config{})
+	for _, path := range []string{"/api/v1/openapi.json", "/api/v1/docs"} {
+		if w := serve(off.Handler(), http.MethodGet, path, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s without ENABLE_DOCS: %d, want 404", path, w.Code)
+		}
+	}
+
+	on, _ := newTestServer(t, Config{Docs: true})
+	w := serve(on.Handler(), http.MethodGet, "/api/v1/docs", "")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(w.Body.String(), `url: "\/api\/v1\/openapi.json"`) {
+		t.Errorf("docs page: %d %s\n%s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+)
+
+// Page size bounds for GET /users?limit=&offset= and ?after=.
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// page is how GET /users was asked to paginate. Offset mode is used with
+// limit or offset; cursor mode with after, whose empty value asks for the
+// first page. Without any of them the list is not paginated.
+type page struct {
+	limit  int
+	offset int
+
+	cursor bool
+	after  int64
+}
+
+// pageParams reads the pagination parameters; a limit above maxPageSize
+// is lowered to it.
+func pageParams(query url.Values) (p page, err error) {
+	rawLimit, rawOffset := query.Get("limit"), query.Get("offset")
+	_, p.cursor = query["after"]
+	if rawLimit == "" && rawOffset == "" && !p.cursor {
+		return page{}, nil
+	}
+	if p.cursor && rawOffset != "" {
+		return page{}, errors.New("offset and after cannot be combined; use after for cursor pagination or offset alone")
+	}
+
+	p.limit = defaultPageSize
+	if rawLimit != "" {
+		if p.limit, err = strconv.Atoi(rawLimit); err != nil || p.limit < 1 {
+			return page{}, errors.New("limit must be a positive integer")
+		}
+		p.limit = min(p.limit, maxPageSize)
+	}
+	if rawOffset != "" {
+		if p.offset, err = strconv.Atoi(rawOffset); err != nil || p.offset < 0 {
+			return page{}, errors.New("offset must be a non-negative integer")
+		}
+	}
+	if after := query.Get("after"); after != "" {
+		if p.after, err = decodeCursor(after); err != nil {
+			return page{}, errors.New("after must be a cursor returned as next_cursor")
+		}
+	}
+	return p, nil
+}
+
+// encodeCursor makes the opaque next_cursor for a page ending at id. It is
+// just the id for now; clients must not rely on that.
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err == nil && id < 1 {
+		err = errors.New("cursor id out of range")
+	}
+	return id, err
+}
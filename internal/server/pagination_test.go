@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// countingRepo counts CountUsers calls.
+type countingRepo struct {
+	*repository.Memory
+	counts atomic.Int32
+}
+
+func (r *countingRepo) CountUsers(ctx context.Context, filter repository.UserFilter) (int64, error) {
+	r.counts.Add(1)
+	return r.Memory.CountUsers(ctx, filter)
+}
+
+func seedUsers(t *testing.T, repo repository.UserRepository, n int) {
+	t.Helper()
+	for i := range n {
+		if _, err := repo.CreateUser(context.Background(), fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCursorPaginationWalksEveryUserWithoutCounting(t *testing.T) {
+	repo := &countingRepo{Memory: repository.NewMemory()}
+	seedUsers(t, repo, 7)
+	s, err := New(Config{}, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[int64]bool{}
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 4 {
+			t.Fatal("cursor never ran out")
+		}
+		w := serve(s.Handler(), http.MethodGet, "/api/v1/users?limit=3&after="+url.QueryEscape(cursor), "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("page %d: %d %s", pages, w.Code, w.Body)
+		}
+		if h := w.Header().Get("X-Total-Count"); h != "" {
+			t.Errorf("cursor page sent X-Total-Count %s", h)
+		}
+		var page userPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range page.Users {
+			if seen[u.ID] {
+				t.Errorf("user %d on two pages", u.ID)
+			}
+			seen[u.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 7 {
+		t.Errorf("walked %d users, want 7", len(seen))
+	}
+	if n := repo.counts.Load(); n != 0 {
+		t.Errorf("cursor mode ran CountUsers %d times", n)
+	}
+}
+
+func TestPageParams(t *testing.T) {
+	for query, want := range map[string]page{
+		"":                    {},
+		"name=ada":            {},
+		"offset=10":           {limit: defaultPageSize, offset: 10},
+		"limit=20":            {limit: 20},
+		"limit=200":           {limit: maxPageSize},
+		"limit=5000&offset=3": {limit: maxPageSize, offset: 3},
+		"after=":              {limit: defaultPageSize, cursor: true},
+	} {
+		q, _ := url.ParseQuery(query)
+		if got, err := pageParams(q); err != nil || got != want {
+			t.Errorf("%q = %+v, %v; want %+v", query, got, err, want)
+		}
+	}
+}
+
+func TestOffsetPagination(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	seedUsers(t, repo, 5)
+
+	w := serve(s.Handler(), http.MethodGet, "/api/v1/users?limit=2&offset=4", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	var users []repository.User
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || w.Header().Get("X-Total-Count") != "5" {
+		t.Errorf("got %d users, X-Total-Count %q; want 1 and 5", len(users), w.Header().Get("X-Total-Count"))
+	}
+	w = serve(s.Handler(), http.MethodGet, "/api/v1/users?offset=9", "")
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" || w.Header().Get("X-Total-Count") != "5" {
+		t.Errorf("past the end: %d %s, X-Total-Count %q", w.Code, w.Body, w.Header().Get("X-Total-Count"))
+	}
+
+	for _, q := range []string{"limit=0", "limit=-5", "limit=x", "offset=-1", "offset=x", "after=&offset=1", "after=zzz", "after=&sort=name"} {
+		if w := serve(s.Handler(), http.MethodGet, "/api/v1/users?"+q, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func TestRowCapTruncation(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		repo := repository.NewMemory(repository.WithMaxRows(2))
+		seedUsers(t, repo, 3)
+		s, err := New(Config{StrictRowLimit: strict}, WithRepository(repo))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := serve(s.Handler(), http.MethodGet, "/api/v1/users", "")
+		switch {
+		case strict && w.Code != http.StatusUnprocessableEntity:
+			t.Errorf("strict: %d, want 422", w.Code)
+		case !strict && (w.Code != http.StatusOK || w.Header().Get("X-Result-Truncated") != "true"):
+			t.Errorf("lenient: %d X-Result-Truncated=%q, want 200 and true", w.Code, w.Header().Get("X-Result-Truncated"))
+		}
+		if w := serve(s.Handler(), http.MethodGet, "/api/v1/users?limit=2", ""); w.Code != http.StatusOK || w.Header().Get("X-Result-Truncated") != "" {
+			t.Errorf("a page within the cap: %d X-Result-Truncated=%q", w.Code, w.Header().Get("X-Result-Truncated"))
+		}
+	}
+}
+
+func TestSortCollation(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	for i, name := range []string{"Örjan", "Zoe", "Olof"} {
+		if _, err := repo.CreateUser(context.Background(), name, fmt.Sprintf("u%d@example.com", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+
+	for collation, want := range map[string]string{"de-DE": "Olof,Örjan,Zoe", "sv-SE": "Olof,Zoe,Örjan"} {
+		w := serve(h, http.MethodGet, "/api/v1/users?sort=name&collation="+collation, "")
+		var users []repository.User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		for i, u := range users {
+			if i > 0 {
+				got += ","
+			}
+			got += u.Name
+		}
+		if got != want || w.Header().Get("X-Collation") != collation {
+			t.Errorf("%s: %s with X-Collation %q, want %s", collation, got, w.Header().Get("X-Collation"), want)
+		}
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users.csv?sort=name&collation=sv-SE", ""); w.Code != http.StatusOK || w.Header().Get("X-Collation") != "sv-SE" {
+		t.Errorf("CSV export: %d X-Collation %q", w.Code, w.Header().Get("X-Collation"))
+	}
+
+	w := serve(h, http.MethodGet, "/api/v1/users?sort=name&collation=tlh", "")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "sv-SE") {
+		t.Errorf("unknown collation: %d %s, want 400 listing the supported ones", w.Code, w.Body)
+	}
+	for _, q := range []string{"collation=de-DE", "sort=id&collation=de-DE"} {
+		if w := serve(h, http.MethodGet, "/api/v1/users?"+q, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: %d, want 400", q, w.Code)
+		}
+	}
+}
+
+func TestSortParam(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	for i, name := range []string{"Bo", "Ada", "Cy"} {
+		if _, err := repo.CreateUser(context.Background(), name, fmt.Sprintf("u%d@example.com", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := s.Handler()
+	for query, want := range map[string]string{
+		"sort=name":           "Ada,Bo,Cy",
+		"sort=-name":          "Cy,Bo,Ada",
+		"sort=-id":            "Cy,Ada,Bo",
+		"sort=-name&limit=2":  "Cy,Bo",
+		"sort=email&offset=1": "Ada,Cy",
+	} {
+		w := serve(h, http.MethodGet, "/api/v1/users?"+query, "")
+		var users []repository.User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body)
+		}
+		var names []string
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("%s: %s, want %s", query, got, want)
+		}
+	}
+	for _, q := range []string{"sort=password", "sort=name,name", "sort=name%20DESC"} {
+		w := serve(h, http.MethodGet, "/api/v1/users?"+q, "")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), codeInvalidParameter.Code) {
+			t.Errorf("%s: %d %s, want 400", q, w.Code, w.Body)
+		}
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users?sort=age", ""); !strings.Contains(w.Body.String(), "email, id, name") {
+		t.Errorf("unknown field error does not list the sortable ones: %s", w.Body)
+	}
+}
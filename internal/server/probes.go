@@ -0,0 +1,86 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// probePaths are the endpoints hit by the kubelet every few seconds.
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// probeFailure is one failed probe kept for post-incident review.
+type probeFailure struct {
+	Time      time.Time `json:"time"`
+	Probe     string    `json:"probe"`
+	Check     string    `json:"check"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error"`
+}
+
+// probeLog decides which probe requests reach the access log and remembers
+// the most recent probe failures in a fixed-size ring buffer.
+type probeLog struct {
+	// sampleEvery logs one in N successful probes; 0 suppresses them all.
+	sampleEvery uint64
+	seen        atomic.Uint64
+
+	mu       sync.Mutex
+	failures []probeFailure
+	next     int
+	full     bool
+}
+
+func newProbeLog(sampleEvery uint64, history int) *probeLog {
+	if history < 1 {
+		history = 1
+	}
+	return &probeLog{
+		sampleEvery: sampleEvery,
+		failures:    make([]probeFailure, history),
+	}
+}
+
+// skipAccessLog reports whether the access log leaves out the request of
+// ex. Failed probes are always logged.
+func (p *probeLog) skipAccessLog(ex *exchange) bool {
+	if !probePaths[ex.route] || ex.w.status >= http.StatusBadRequest {
+		return false
+	}
+	if p.sampleEvery == 0 {
+		return true
+	}
+	return p.seen.Add(1)%p.sampleEvery != 0
+}
+
+func (p *probeLog) record(f probeFailure) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures[p.next] = f
+	p.next = (p.next + 1) % len(p.failures)
+	if p.next == 0 {
+		p.full = true
+	}
+}
+
+// recent returns the remembered failures, newest first.
+func (p *probeLog) recent() []probeFailure {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := p.next
+	if p.full {
+		n = len(p.failures)
+	}
+
+	out := make([]probeFailure, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, p.failures[(p.next-i+len(p.failures))%len(p.failures)])
+	}
+	return out
+}
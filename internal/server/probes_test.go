@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// downRepo fails every ping, as a database that went away would.
+type downRepo struct {
+	*repository.Memory
+	err error
+}
+
+func (r downRepo) Ping(context.Context) error {
+	return r.err
+}
+
+func TestProbeLogRing(t *testing.T) {
+	p := newProbeLog(0, 3)
+	if got := p.recent(); len(got) != 0 {
+		t.Fatalf("fresh ring: %v", got)
+	}
+	for i := range 5 {
+		p.record(probeFailure{Error: fmt.Sprint(i)})
+	}
+	var got []string
+	for _, f := range p.recent() {
+		got = append(got, f.Error)
+	}
+	if strings.Join(got, ",") != "4,3,2" {
+		t.Errorf("recent = %v, want the last three, newest first", got)
+	}
+}
+
+// A failed readiness probe is logged at warn with the check, its latency
+// and the error, even with successful probes suppressed, and is kept for
+// /admin/probe-failures.
+func TestProbeFailures(t *testing.T) {
+	s, err := New(Config{APIKeys: testAPIKeys, ProbeFailureHistory: 2},
+		WithRepository(downRepo{repository.NewMemory(), errors.New("connection refused")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	logs := captureLogs(t, s, zerolog.InfoLevel)
+
+	for range 3 {
+		if w := serve(h, http.MethodGet, "/readyz", ""); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("readyz with the database down: %d %s", w.Code, w.Body)
+		}
+	}
+	var warned int
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if e["message"] != "readiness probe failed" {
+			continue
+		}
+		warned++
+		if e["level"] != "warn" || e["check"] != "database" || e["error"] != "connection refused" || e["latency"] == nil {
+			t.Errorf("failure event %v", e)
+		}
+	}
+	if warned != 3 {
+		t.Errorf("%d failure events, want 3:\n%s", warned, logs)
+	}
+	if entries := accessEntries(t, logs); len(entries) != 3 {
+		t.Errorf("%d access log entries for failed probes, want 3", len(entries))
+	}
+
+	if w := serve(h, http.MethodGet, "/api/v1/admin/probe-failures", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a key: %d", w.Code)
+	}
+	w := serve(h, http.MethodGet, "/api/v1/admin/probe-failures", "", apiKeyHeader, aliceKey)
+	var resp struct{ Failures []probeFailure }
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("%d %v\n%s", w.Code, err, w.Body)
+	}
+	if len(resp.Failures) != 2 {
+		t.Fatalf("%d failures kept, want the history of 2", len(resp.Failures))
+	}
+	for _, f := range resp.Failures {
+		if f.Probe != "readyz" || f.Check != "database" || f.Error != "connection refused" || f.Time.IsZero() {
+			t.Errorf("failure %+v", f)
+		}
+	}
+}
+
+// Suppressing probe logs leaves the request metrics counting every probe.
+func TestProbeLogSuppressionKeepsMetrics(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	h := s.Handler()
+	logs := captureLogs(t, s, zerolog.DebugLevel)
+	for range 4 {
+		serve(h, http.MethodGet, "/healthz", "")
+	}
+	if entries := accessEntries(t, logs); len(entries) != 0 {
+		t.Errorf("%d probe entries logged, want none", len(entries))
+	}
+	out := serve(h, http.MethodGet, metricsPath, "").Body.String()
+	if want := `http_requests_total{method="GET",route="/healthz",status="200"} 4`; !strings.Contains(out, want) {
+		t.Errorf("metrics lack %s:\n%s", want, out)
+	}
+}
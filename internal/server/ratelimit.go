@@ -0,0 +1,189 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+// rateLimiter is a token bucket per client IP and rate class: each bucket
+// holds up to burst tokens and refills at rps per second, and a request
+// takes one. Client IPs come from Server.clientIP, which only believes
+// X-Forwarded-For from TrustedProxies.
+//
+// A bucket that has refilled completely behaves exactly like a new one,
+// so full buckets are evicted; memory is bounded by the clients active
+// within one refill period. State is per replica unless a limiterSync
+// shares it through the database.
+type rateLimiter struct {
+	rps   float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	inserts int
+	// taken are the keys taken from since the last snapshot.
+	taken map[string]bool
+
+	throttled *metrics.CounterVec
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rps:     rps,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*tokenBucket),
+		taken:   make(map[string]bool),
+	}
+}
+
+func (l *rateLimiter) registerMetrics(reg *metrics.Registry) {
+	if l == nil {
+		return
+	}
+	l.throttled = reg.Counter("http_requests_throttled_total", "Requests rejected by the per-client rate limiter, by rate class.", "class")
+	reg.GaugeFunc("rate_limit_buckets", "Rate-limit buckets currently held in memory.", func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return float64(len(l.buckets))
+	})
+}
+
+// rateLimitHeaderDocs describes the quota headers in the OpenAPI document.
+var rateLimitHeaderDocs = map[string]string{
+	"X-RateLimit-Limit":     "Requests the client's bucket holds when full; sent when rate limiting is on.",
+	"X-RateLimit-Remaining": "Requests left in the bucket after this one.",
+	"X-RateLimit-Reset":     "Unix time at which the bucket is full again.",
+}
+
+// quota is a bucket's state right after a take, as the X-RateLimit
+// headers report it.
+type quota struct {
+	remaining int       // whole tokens left
+	reset     time.Time // when the bucket is full again
+	// wait is how long until the next token, for a rejected request.
+	wait time.Duration
+}
+
+// take consumes a token from key's bucket, or reports how long until the
+// next one is available. The quota is read under the same lock, so
+// concurrent requests never see more tokens than they can take.
+func (l *rateLimiter) take(key string, now time.Time) (q quota, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.buckets[key]
+	if !found {
+		l.inserts++
+		if l.inserts%256 == 0 {
+			l.evict(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps)
+	b.last = now
+	ok = b.tokens >= 1
+	if ok {
+		b.tokens--
+		l.taken[key] = true
+	} else {
+		q.wait = time.Duration((1 - b.tokens) / l.rps * float64(time.Second))
+	}
+	q.remaining = int(b.tokens)
+	q.reset = l.fullAt(b)
+	return q, ok
+}
+
+// fullAt is when b is full again. It is all there is to a bucket besides
+// the rate and burst, which is why it is what replicas share.
+func (l *rateLimiter) fullAt(b *tokenBucket) time.Time {
+	return b.last.Add(time.Duration((l.burst - b.tokens) / l.rps * float64(time.Second)))
+}
+
+// snapshot returns when each bucket taken from since the last snapshot
+// is full again. Evicted ones are full and left out.
+func (l *rateLimiter) snapshot() map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]time.Time, len(l.taken))
+	for key := range l.taken {
+		if b, ok := l.buckets[key]; ok {
+			out[key] = l.fullAt(b)
+		}
+	}
+	clear(l.taken)
+	return out
+}
+
+// retake marks the buckets of a snapshot that could not be sent as
+// taken from again.
+func (l *rateLimiter) retake(snapshot map[string]time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range snapshot {
+		l.taken[key] = true
+	}
+}
+
+// merge takes in buckets other replicas, or this one before a restart,
+// drained: each bucket ends up as empty as the emptier of the two, so a
+// client never gains tokens from a merge.
+func (l *rateLimiter) merge(shared map[string]time.Time, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, fullAt := range shared {
+		tokens := max(0, l.burst-fullAt.Sub(now).Seconds()*l.rps)
+		if b, ok := l.buckets[key]; ok {
+			if local := min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rps); local <= tokens {
+				continue
+			}
+		} else if tokens >= l.burst {
+			continue
+		}
+		l.buckets[key] = &tokenBucket{tokens: tokens, last: now}
+	}
+}
+
+// evict drops buckets that are full again.
+func (l *rateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rps >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// middleware charges requests against the bucket of their client and
+// class and answers 429 with Retry-After once it is empty. Every response
+// tells the client its quota: X-RateLimit-Limit is the burst,
+// X-RateLimit-Remaining the requests left now and X-RateLimit-Reset the
+// Unix time the bucket is full again.
+func (l *rateLimiter) middleware(class rateClass, clientIP func(*http.Request) string) middleware {
+	limit := strconv.Itoa(int(l.burst))
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q, ok := l.take(string(class)+" "+clientIP(r), time.Now())
+			w.Header().Set("X-RateLimit-Limit", limit)
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(q.remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(q.reset.UnixNano())/1e9)), 10))
+			if !ok {
+				if l.throttled != nil {
+					l.throttled.With(string(class)).Inc()
+				}
+				respondRetry(w, r, codeRateLimited, "too many requests; retry later", q.wait)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestRateLimitQuotaFromFullToEmptyAndBack(t *testing.T) {
+	l := newRateLimiter(2, 3) // a token every 500ms
+	now := time.Unix(1_700_000_000, 0)
+
+	for want := 2; want >= 0; want-- {
+		q, ok := l.take("k", now)
+		if !ok || q.remaining != want {
+			t.Fatalf("take: ok=%v remaining=%d, want %d", ok, q.remaining, want)
+		}
+		// Full again once the tokens taken so far have refilled.
+		if wantReset := now.Add(time.Duration(3-want) * 500 * time.Millisecond); !q.reset.Equal(wantReset) {
+			t.Errorf("remaining %d: reset %v, want %v", want, q.reset, wantReset)
+		}
+	}
+	q, ok := l.take("k", now)
+	if ok || q.remaining != 0 || q.wait != 500*time.Millisecond {
+		t.Fatalf("empty bucket: ok=%v %+v", ok, q)
+	}
+
+	// One token refills after 500ms; the full bucket a second later.
+	now = now.Add(500 * time.Millisecond)
+	if q, ok := l.take("k", now); !ok || q.remaining != 0 {
+		t.Errorf("after one refill: ok=%v %+v", ok, q)
+	}
+	now = now.Add(1500 * time.Millisecond)
+	if q, ok := l.take("k", now); !ok || q.remaining != 2 {
+		t.Errorf("after a full refill: ok=%v %+v", ok, q)
+	}
+}
+
+func TestRateLimitQuotaUnderConcurrency(t *testing.T) {
+	l := newRateLimiter(0.001, 50)
+	now := time.Now()
+	var (
+		mu        sync.Mutex
+		remaining = map[int]int{}
+		granted   int
+		wg        sync.WaitGroup
+	)
+	for range 80 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q, ok := l.take("k", now)
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				granted++
+				remaining[q.remaining]++
+			} else if q.remaining != 0 {
+				t.Errorf("rejected with remaining %d", q.remaining)
+			}
+		}()
+	}
+	wg.Wait()
+	if granted != 50 {
+		t.Errorf("granted %d, want the burst of 50", granted)
+	}
+	// Each granted request saw its own, distinct count.
+	for r := range 50 {
+		if remaining[r] != 1 {
+			t.Errorf("remaining %d reported %d times", r, remaining[r])
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	s, _ := newTestServer(t, Config{RateLimitRPS: 0.01, RateLimitBurst: 2})
+	h := s.Handler()
+	start := time.Now().Unix()
+
+	for i, want := range []string{"1", "0", "0"} {
+		w := serve(h, http.MethodGet, "/api/v1/users", "")
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != want {
+			t.Errorf("request %d: remaining %q, want %s", i, got, want)
+		}
+		if w.Header().Get("X-RateLimit-Limit") != "2" {
+			t.Errorf("request %d: limit %q", i, w.Header().Get("X-RateLimit-Limit"))
+		}
+		reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil || reset <= start {
+			t.Errorf("request %d: reset %q is not a future Unix time", i, w.Header().Get("X-RateLimit-Reset"))
+		}
+		if i == 2 && (w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "") {
+			t.Errorf("over the limit: %d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	// Writes have their own bucket; probes have none.
+	if w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`); w.Header().Get("X-RateLimit-Remaining") != "1" {
+		t.Errorf("write bucket: remaining %q, want 1", w.Header().Get("X-RateLimit-Remaining"))
+	}
+	for _, path := range []string{"/healthz", "/metrics"} {
+		if w := serve(h, http.MethodGet, path, ""); w.Header().Get("X-RateLimit-Limit") != "" {
+			t.Errorf("exempt %s got X-RateLimit headers", path)
+		}
+	}
+}
+
+// Buckets that refilled are dropped on the next sweep; active ones stay.
+func TestRateLimitEviction(t *testing.T) {
+	l := newRateLimiter(1, 2)
+	now := time.Unix(1_700_000_000, 0)
+	l.take("busy", now)
+	l.take("busy", now)
+	for i := range 254 {
+		l.take("idle "+strconv.Itoa(i), now)
+	}
+
+	// Every idle bucket is full a second later; the busy one needs two.
+	now = now.Add(time.Second)
+	l.take("trigger", now) // the 256th insert sweeps
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("a bucket still refilling was evicted")
+	}
+	if len(l.buckets) != 2 {
+		t.Errorf("%d buckets after the sweep, want busy and trigger", len(l.buckets))
+	}
+	// An evicted client starts over with a full bucket.
+	if q, ok := l.take("idle 0", now); !ok || q.remaining != 1 {
+		t.Errorf("evicted client: ok=%v %+v", ok, q)
+	}
+}
+
+func TestRateLimitByClientIP(t *testing.T) {
+	s, _ := newTestServer(t, Config{RateLimitRPS: 0.01, RateLimitBurst: 1, TrustedProxies: []string{"192.0.2.0/24"}})
+	h := s.Handler()
+
+	// httptest requests come from 192.0.2.1, a trusted proxy, so each
+	// forwarded client has its own bucket.
+	for _, client := range []string{"203.0.113.7", "203.0.113.8"} {
+		if w := serve(h, http.MethodGet, "/api/v1/users", "", "X-Forwarded-For", client); w.Code != http.StatusOK {
+			t.Errorf("first request of %s: %d", client, w.Code)
+		}
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users", "", "X-Forwarded-For", "203.0.113.7"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("second request of 203.0.113.7: %d, want 429", w.Code)
+	}
+
+	// From an untrusted peer the header is ignored: every request counts
+	// against the peer itself.
+	untrusted, _ := newTestServer(t, Config{RateLimitRPS: 0.01, RateLimitBurst: 1})
+	serve(untrusted.Handler(), http.MethodGet, "/api/v1/users", "", "X-Forwarded-For", "203.0.113.7")
+	if w := serve(untrusted.Handler(), http.MethodGet, "/api/v1/users", "", "X-Forwarded-For", "203.0.113.8"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For got a fresh bucket: %d", w.Code)
+	}
+}
+
+func TestRateLimitMetrics(t *testing.T) {
+	s, _ := newTestServer(t, Config{RateLimitRPS: 0.01, RateLimitBurst: 1})
+	h := s.Handler()
+	for range 3 {
+		serve(h, http.MethodGet, "/api/v1/users", "")
+	}
+	serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`)
+
+	out := serve(h, http.MethodGet, "/metrics", "").Body.String()
+	for _, want := range []string{`http_requests_throttled_total{class="read"} 2`, "rate_limit_buckets 2"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %s:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `http_requests_throttled_total{class="write"}`) {
+		t.Error("a write within its burst was counted as throttled")
+	}
+}
+
+// A restarted server picks up the buckets its predecessor drained.
+func TestRateLimitSyncSurvivesRestart(t *testing.T) {
+	repo := repository.NewMemory()
+	cfg := Config{RateLimitRPS: 0.01, RateLimitBurst: 2, RateLimitSyncInterval: time.Minute}
+	before, err := New(cfg, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		serve(before.Handler(), http.MethodGet, "/api/v1/users", "")
+	}
+	before.limitSync.sync(context.Background())
+
+	after, err := New(cfg, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after.limitSync.sync(context.Background())
+	if w := serve(after.Handler(), http.MethodGet, "/api/v1/users", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("after the restart: %d, want 429", w.Code)
+	}
+	if w := serve(after.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`); w.Code != http.StatusCreated {
+		t.Errorf("untouched write bucket: %d, want 201", w.Code)
+	}
+}
+
+// Replicas converge on the emptier bucket, and no sync gives tokens back.
+func TestRateLimitSyncConverges(t *testing.T) {
+	repo := repository.NewMemory()
+	a, b := newRateLimiter(0.001, 5), newRateLimiter(0.001, 5)
+	syncA := newLimiterSync(a, repo.SyncRateLimits, zerolog.Nop(), time.Minute)
+	syncB := newLimiterSync(b, repo.SyncRateLimits, zerolog.Nop(), time.Minute)
+	now := time.Now()
+	for range 3 {
+		a.take("k", now)
+	}
+	b.take("k", now)
+	b.take("only b", now)
+
+	ctx := context.Background()
+	syncA.sync(ctx)
+	syncB.sync(ctx)
+	syncA.sync(ctx)
+	for name, l := range map[string]*rateLimiter{"a": a, "b": b} {
+		if q, _ := l.take("k", time.Now()); q.remaining != 1 {
+			t.Errorf("%s: %d left after the sync, want the 2 of the emptier bucket minus this take", name, q.remaining)
+		}
+	}
+	if q, _ := a.take("only b", time.Now()); q.remaining != 3 {
+		t.Errorf("a: %d left of a bucket only b drained, want 3", q.remaining)
+	}
+}
+
+// A failed sync keeps the buckets local and sends them next time.
+func TestRateLimitSyncRetriesAfterFailure(t *testing.T) {
+	l := newRateLimiter(0.001, 5)
+	var sent []map[string]time.Time
+	fail := true
+	store := func(_ context.Context, buckets map[string]time.Time, _ time.Time) (map[string]time.Time, error) {
+		sent = append(sent, buckets)
+		if fail {
+			return nil, errors.New("database down")
+		}
+		return buckets, nil
+	}
+	sync := newLimiterSync(l, store, zerolog.Nop(), time.Minute)
+	l.take("k", time.Now())
+	sync.sync(context.Background())
+	fail = false
+	sync.sync(context.Background())
+	if len(sent) != 2 || len(sent[1]) != 1 {
+		t.Errorf("sent %v, want the bucket again after the failure", sent)
+	}
+	if q, _ := l.take("k", time.Now()); q.remaining != 3 {
+		t.Errorf("%d left, want 3", q.remaining)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/supervisor"
+)
+
+// limiterSync shares the rate limiter's buckets through the database, so
+// a rollout does not hand every client a fresh burst and replicas charge
+// a client alike. Requests only ever touch the local buckets; every
+// interval the ones taken from are sent, merged with the other replicas'
+// by keeping the emptier bucket, and what others drained is taken in.
+// Between syncs a client can get up to one burst per replica.
+type limiterSync struct {
+	limiter  *rateLimiter
+	store    func(ctx context.Context, buckets map[string]time.Time, now time.Time) (map[string]time.Time, error)
+	log      zerolog.Logger
+	interval time.Duration
+}
+
+func newLimiterSync(l *rateLimiter, store func(ctx context.Context, buckets map[string]time.Time, now time.Time) (map[string]time.Time, error), logger zerolog.Logger, interval time.Duration) *limiterSync {
+	return &limiterSync{limiter: l, store: store, log: logger, interval: interval}
+}
+
+// run restores the shared buckets at once, then syncs every interval
+// until ctx is cancelled, and syncs a last time so the next pod starts
+// from what this one charged.
+func (s *limiterSync) run(ctx context.Context) {
+	s.sync(ctx)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			finalCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			s.sync(finalCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+		s.sync(ctx)
+		supervisor.Beat(ctx)
+	}
+}
+
+// sync is one round trip. When it fails the buckets stay local, and the
+// ones taken from are sent again next time.
+func (s *limiterSync) sync(ctx context.Context) {
+	taken := s.limiter.snapshot()
+	now := time.Now()
+	shared, err := s.store(ctx, taken, now)
+	if err != nil {
+		s.log.Warn().Err(err).Int("buckets", len(taken)).Msg("rate limit sync failed")
+		s.limiter.retake(taken)
+		return
+	}
+	s.limiter.merge(shared, now)
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/supervisor"
+)
+
+// readOnlyGuard rejects mutating requests while the service is read-only,
+// e.g. during a DR drill where the database is promoted read-only. The
+// mode is on while either the manual switch (READ_ONLY_MODE or
+// PUT /admin/read-only) or the detected state is set. Detection trips as
+// soon as a write fails with SQLSTATE 25006 and is re-probed every
+// interval, so it clears once the database accepts writes again.
+type readOnlyGuard struct {
+	probe    func(ctx context.Context) (bool, error)
+	log      zerolog.Logger
+	interval time.Duration
+
+	manual   atomic.Bool
+	detected atomic.Bool
+	// until is the UnixNano end of the manual maintenance window, or 0;
+	// checked is the UnixNano of the last probe.
+	until   atomic.Int64
+	checked atomic.Int64
+}
+
+func newReadOnlyGuard(probe func(ctx context.Context) (bool, error), logger zerolog.Logger, interval time.Duration, manual bool) *readOnlyGuard {
+	g := &readOnlyGuard{probe: probe, log: logger, interval: interval}
+	g.manual.Store(manual)
+	return g
+}
+
+// run probes immediately and then every interval until ctx is cancelled.
+func (g *readOnlyGuard) run(ctx context.Context) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		g.check(ctx)
+		supervisor.Beat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (g *readOnlyGuard) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	g.checked.Store(time.Now().UnixNano())
+	ro, err := g.probe(ctx)
+	if err != nil {
+		// Keep the last known state; readyz reports the outage itself.
+		if ctx.Err() == nil {
+			g.log.Warn().Err(err).Msg("read-only probe failed")
+		}
+		return
+	}
+	g.setDetected(ro)
+}
+
+// trip records that the database rejected a write as read-only; the next
+// probe clears it again if that was transient.
+func (g *readOnlyGuard) trip() {
+	g.setDetected(true)
+}
+
+func (g *readOnlyGuard) setDetected(ro bool) {
+	if g.detected.Swap(ro) == ro {
+		return
+	}
+	if ro {
+		g.log.Warn().Msg("database is read-only, rejecting writes")
+	} else {
+		g.log.Info().Msg("database accepts writes again")
+	}
+}
+
+// setManual switches the manual mode; until, if set, is when the
+// maintenance window is expected to end.
+func (g *readOnlyGuard) setManual(on bool, until *time.Time) {
+	if on && until != nil {
+		g.until.Store(until.UnixNano())
+	} else {
+		g.until.Store(0)
+	}
+	if g.manual.Swap(on) != on {
+		g.log.Warn().Bool("enabled", on).Msg("read-only mode switched manually")
+	}
+}
+
+// active reports whether writes are currently rejected.
+func (g *readOnlyGuard) active() bool {
+	return g.manual.Load() || g.detected.Load()
+}
+
+type readOnlyState struct {
+	Manual   bool       `json:"manual"`
+	Detected bool       `json:"detected"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+func (g *readOnlyGuard) state() readOnlyState {
+	st := readOnlyState{Manual: g.manual.Load(), Detected: g.detected.Load()}
+	if until := g.until.Load(); until != 0 {
+		t := time.Unix(0, until).UTC()
+		st.Until = &t
+	}
+	return st
+}
+
+// retryAfter is how long until writes may work again: the rest of the
+// maintenance window when one was given, else until the next probe,
+// which is also when detected mode can clear. A manual mode without a
+// window might end any time, so the probe interval stands in for it.
+func (g *readOnlyGuard) retryAfter(now time.Time) time.Duration {
+	wait := g.interval
+	if checked := g.checked.Load(); checked != 0 && g.detected.Load() {
+		wait = time.Unix(0, checked).Add(g.interval).Sub(now)
+	}
+	if until := g.until.Load(); until != 0 && g.manual.Load() {
+		if w := time.Unix(0, until).Sub(now); w > wait || !g.detected.Load() {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// middleware rejects the request up front while read-only mode is active.
+func (g *readOnlyGuard) middleware() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if g.active() {
+				g.reject(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (g *readOnlyGuard) reject(w http.ResponseWriter, r *http.Request) {
+	respondRetry(w, r, codeReadOnly, "service is in read-only mode", g.retryAfter(time.Now()))
+}
+
+// writeRejected answers the request if err is the database refusing a
+// write because it is read-only, and trips detection so later writes are
+// rejected before reaching it.
+func (s *Server) writeRejected(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, repository.ErrReadOnly) {
+		return false
+	}
+	s.readOnly.trip()
+	s.readOnly.reject(w, r)
+	return true
+}
+
+// setReadOnly toggles the manual read-only switch. Detected read-only
+// mode cannot be cleared here; it ends when the database accepts writes.
+func (s *Server) setReadOnly(w http.ResponseWriter, r *http.Request) {
+	var payload readOnlyRequest
+	if !bindJSON(w, r, &payload) {
+		return
+	}
+
+	s.readOnly.setManual(*payload.Enabled, payload.Until)
+	writeJSON(w, r, http.StatusOK, s.readOnly.state())
+}
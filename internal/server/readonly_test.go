@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// promotedRepo stands in for a database that can be switched read-only
+// underneath the service, as in a DR drill.
+type promotedRepo struct {
+	*repository.Memory
+	ro *atomic.Bool
+}
+
+func (r promotedRepo) ReadOnly(ctx context.Context) (bool, error) { return r.ro.Load(), ctx.Err() }
+
+func (r promotedRepo) CreateUser(ctx context.Context, name, email string, metadata map[string]any) (*repository.User, error) {
+	if r.ro.Load() {
+		return nil, repository.ErrReadOnly
+	}
+	return r.Memory.CreateUser(ctx, name, email, metadata)
+}
+
+const adaJSON = `{"name":"Ada","email":"ada@example.com"}`
+
+func isReadOnly(w *httptest.ResponseRecorder) bool {
+	return w.Code == http.StatusServiceUnavailable && strings.Contains(w.Body.String(), `"code":"read_only"`)
+}
+
+func TestReadOnlyManual(t *testing.T) {
+	s, repo := newTestServer(t, Config{ReadOnlyMode: true, APIKeys: testAPIKeys})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+
+	if w := serve(h, http.MethodPost, "/api/v1/users", adaJSON, apiKeyHeader, aliceKey); !isReadOnly(w) {
+		t.Fatalf("write while read-only: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Errorf("read while read-only: %d", w.Code)
+	}
+
+	// The switch itself stays reachable, or the mode could never end.
+	w := serve(h, http.MethodPut, "/api/v1/admin/read-only", `{"enabled":false}`, apiKeyHeader, aliceKey)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"manual":false`) {
+		t.Fatalf("switch off: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users", adaJSON, apiKeyHeader, aliceKey); w.Code != http.StatusCreated {
+		t.Fatalf("write after switching off: %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(h, http.MethodPut, "/api/v1/admin/read-only", `{"enabled":true}`, apiKeyHeader, aliceKey); w.Code != http.StatusOK {
+		t.Fatalf("switch on: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users", adaJSON, apiKeyHeader, aliceKey); !isReadOnly(w) {
+		t.Errorf("write after switching on: %d %s", w.Code, w.Body)
+	}
+}
+
+// A write the database refuses trips detection, later writes are turned
+// away before reaching it, and the next probe that finds the database
+// writable again clears the mode.
+func TestReadOnlyDetectionAndRecovery(t *testing.T) {
+	var ro atomic.Bool
+	s, err := New(Config{APIKeys: testAPIKeys}, WithRepository(promotedRepo{repository.NewMemory(), &ro}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	ro.Store(true)
+	if w := serve(h, http.MethodPost, "/api/v1/users", adaJSON, apiKeyHeader, aliceKey); !isReadOnly(w) {
+		t.Fatalf("write refused by the database: %d %s", w.Code, w.Body)
+	}
+	if st := s.readOnly.state(); !st.Detected || st.Manual {
+		t.Fatalf("state after the refused write: %+v", st)
+	}
+
+	// Detected mode cannot be switched off by hand.
+	serve(h, http.MethodPut, "/api/v1/admin/read-only", `{"enabled":false}`, apiKeyHeader, aliceKey)
+	ro.Store(false)
+	if w := serve(h, http.MethodPost, "/api/v1/users", adaJSON, apiKeyHeader, aliceKey); !isReadOnly(w) {
+		t.Errorf("write before the next probe: %d %s", w.Code, w.Body)
+	}
+
+	s.readOnly.check(context.Background())
+	if w := serve(h, http.MethodPost, "/api/v1/users", adaJSON, apiKeyHeader, aliceKey); w.Code != http.StatusCreated {
+		t.Errorf("write after recovery: %d %s", w.Code, w.Body)
+	}
+
+	// A probe finding the database read-only trips the mode without any
+	// write having to fail first.
+	ro.Store(true)
+	s.readOnly.check(context.Background())
+	if !s.readOnly.active() {
+		t.Error("probe did not detect the read-only database")
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/requestctx"
+)
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen bounds caller-supplied IDs; longer ones are replaced.
+const maxRequestIDLen = 128
+
+// requestID adopts the caller's X-Request-ID, or generates a UUID, and
+// echoes it back. The request context carries the ID and a logger that
+// adds request_id to every event, for handlers and the repository alike.
+func (s *Server) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		l := s.log.With().Str("request_id", id).Logger()
+		next.ServeHTTP(w, withLogger(r.WithContext(requestctx.SetRequestID(r.Context(), id)), &l))
+	})
+}
+
+// validRequestID accepts printable ASCII without spaces, so an ID cannot
+// forge log lines or response headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+	return string(out[:])
+}
+
+// withLogger makes l the request logger of r, for everything below and,
+// through the exchange, for the access log line written above.
+func withLogger(r *http.Request, l *zerolog.Logger) *http.Request {
+	exchangeOf(r).log = l
+	return r.WithContext(requestctx.SetLogger(r.Context(), l))
+}
+
+// reqLog is the request logger set by requestID and authenticate, or the
+// server's logger outside of them. Middleware running before
+// authenticate still gets the caller once it has been identified.
+func (s *Server) reqLog(r *http.Request) *zerolog.Logger {
+	if l := exchangeOf(r).log; l != nil {
+		return l
+	}
+	if l, ok := requestctx.Logger(r.Context()); ok {
+		return l
+	}
+	return &s.log
+}
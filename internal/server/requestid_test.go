@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/requestctx"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestIDHeader(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	h := s.Handler()
+
+	if got := serve(h, http.MethodGet, "/api/v1/users", "", requestIDHeader, "trace-abc.123").Header().Get(requestIDHeader); got != "trace-abc.123" {
+		t.Errorf("caller's ID came back as %q", got)
+	}
+
+	seen := map[string]bool{}
+	for _, id := range []string{"", "has space", "line\r\nX-Injected: 1", "ünïcode", strings.Repeat("a", maxRequestIDLen+1)} {
+		var headers []string
+		if id != "" {
+			headers = []string{requestIDHeader, id}
+		}
+		got := serve(h, http.MethodGet, "/api/v1/users", "", headers...).Header().Get(requestIDHeader)
+		if !uuidV4.MatchString(got) {
+			t.Errorf("ID %q replaced by %q, want a UUID", id, got)
+		}
+		if seen[got] {
+			t.Errorf("UUID %s generated twice", got)
+		}
+		seen[got] = true
+	}
+	if got := serve(h, http.MethodGet, "/api/v1/users", "", requestIDHeader, strings.Repeat("a", maxRequestIDLen)).Header().Get(requestIDHeader); len(got) != maxRequestIDLen {
+		t.Errorf("an ID of the maximum length was replaced by %q", got)
+	}
+}
+
+// loggingRepo logs from the repository the way the pgx one does: with
+// the logger the request context carries.
+type loggingRepo struct {
+	*repository.Memory
+}
+
+func (r loggingRepo) GetUserByID(ctx context.Context, id int64) (*repository.User, error) {
+	if l, ok := requestctx.Logger(ctx); ok {
+		l.Error().Int64("id", id).Msg("query failed")
+	}
+	return nil, errors.New("connection refused")
+}
+
+// Every line logged while handling a request carries its ID: the
+// repository's, the handler's and the access log's.
+func TestRequestIDInLogs(t *testing.T) {
+	s, err := New(Config{}, WithRepository(loggingRepo{repository.NewMemory()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t, s, zerolog.InfoLevel)
+	serve(s.Handler(), http.MethodGet, "/api/v1/users/1", "", requestIDHeader, "trace-42")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	for _, want := range []string{"query failed", "failed to get user", "request"} {
+		found := false
+		for _, line := range lines {
+			if strings.Contains(line, `"message":"`+want+`"`) {
+				found = true
+				if !strings.Contains(line, `"request_id":"trace-42"`) {
+					t.Errorf("%q logged without the request ID: %s", want, line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("nothing logged %q:\n%s", want, logs)
+		}
+	}
+}
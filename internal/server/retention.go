@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/supervisor"
+)
+
+// minShareLinkRetention is how long a consumed share link is kept past
+// its expiry at the very least, whatever is configured: links are
+// checked for expiry with the replica's clock, so a nonce purged the
+// moment the database thinks it expired could be replayed on a replica
+// whose clock runs behind.
+const minShareLinkRetention = time.Hour
+
+// retentionJob purges rows that are only kept to reject replays until
+// they expire: consumed one-time share links. Other per-request state
+// (idempotency keys, duplicate fingerprints) lives in memory and expires
+// on its own. Every replica runs the job; the batched deletes are
+// idempotent, so they only share the work. A nil *retentionJob does
+// nothing.
+type retentionJob struct {
+	purge     func(ctx context.Context, expiredFor time.Duration, limit int) (int64, error)
+	log       zerolog.Logger
+	interval  time.Duration
+	retention time.Duration
+	batch     int
+
+	purged  *metrics.CounterVec
+	lastRun atomic.Int64 // unix seconds of the last complete run
+}
+
+func newRetentionJob(purge func(ctx context.Context, expiredFor time.Duration, limit int) (int64, error), logger zerolog.Logger, interval, retention time.Duration, batch int) *retentionJob {
+	if retention < minShareLinkRetention {
+		logger.Warn().Dur("configured", retention).Dur("minimum", minShareLinkRetention).
+			Msg("share link retention below minimum, using the minimum")
+		retention = minShareLinkRetention
+	}
+	return &retentionJob{
+		purge:     purge,
+		log:       logger,
+		interval:  interval,
+		retention: retention,
+		batch:     batch,
+	}
+}
+
+func (j *retentionJob) registerMetrics(reg *metrics.Registry) {
+	if j == nil {
+		return
+	}
+	j.purged = reg.Counter("retention_purged_rows_total", "Expired rows deleted by the retention job, by table.", "table")
+	reg.GaugeFunc("retention_last_run_timestamp_seconds", "Unix time the retention job last completed a run.",
+		func() float64 { return float64(j.lastRun.Load()) })
+}
+
+// run purges immediately and then every interval until ctx is cancelled.
+func (j *retentionJob) run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := j.purgeShareLinks(ctx); err != nil && ctx.Err() == nil {
+			j.log.Error().Err(err).Str("table", "share_link_uses").Msg("retention purge failed")
+		} else if err == nil {
+			j.lastRun.Store(time.Now().Unix())
+		}
+		supervisor.Beat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeShareLinks deletes in batches until one comes back short, so a
+// large backlog never turns into one long-running DELETE.
+func (j *retentionJob) purgeShareLinks(ctx context.Context) error {
+	var total int64
+	for {
+		batchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		n, err := j.purge(batchCtx, j.retention, j.batch)
+		cancel()
+		total += n
+		if n > 0 {
+			j.purged.With("share_link_uses").Add(float64(n))
+		}
+		if err != nil {
+			return err
+		}
+		supervisor.Beat(ctx)
+		if n < int64(j.batch) || ctx.Err() != nil {
+			break
+		}
+	}
+	if total > 0 {
+		j.log.Info().Int64("rows", total).Str("table", "share_link_uses").Msg("purged expired rows")
+	}
+	return ctx.Err()
+}
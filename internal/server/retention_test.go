@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+// backlogPurger deletes up to limit of its remaining rows per call.
+type backlogPurger struct {
+	rows       int64
+	calls      []int
+	expiredFor time.Duration
+	err        error
+}
+
+func (p *backlogPurger) purge(_ context.Context, expiredFor time.Duration, limit int) (int64, error) {
+	p.calls = append(p.calls, limit)
+	p.expiredFor = expiredFor
+	if p.err != nil {
+		return 0, p.err
+	}
+	n := min(p.rows, int64(limit))
+	p.rows -= n
+	return n, nil
+}
+
+func TestRetentionJobBatches(t *testing.T) {
+	p := &backlogPurger{rows: 25}
+	reg := metrics.NewRegistry()
+	j := newRetentionJob(p.purge, zerolog.Nop(), time.Hour, 48*time.Hour, 10)
+	j.registerMetrics(reg)
+
+	if err := j.purgeShareLinks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// 10, 10, then a short batch of 5 ends the run.
+	if len(p.calls) != 3 || p.rows != 0 || p.expiredFor != 48*time.Hour {
+		t.Errorf("%d batches, %d rows left, retention %v", len(p.calls), p.rows, p.expiredFor)
+	}
+
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `retention_purged_rows_total{table="share_link_uses"} 25`) {
+		t.Errorf("purged rows not counted:\n%s", w.Body)
+	}
+}
+
+func TestRetentionJobMinimum(t *testing.T) {
+	p := &backlogPurger{}
+	j := newRetentionJob(p.purge, zerolog.Nop(), time.Hour, time.Minute, 10)
+	j.registerMetrics(metrics.NewRegistry())
+	if err := j.purgeShareLinks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p.expiredFor != minShareLinkRetention {
+		t.Errorf("purged rows expired for %v, want at least %v", p.expiredFor, minShareLinkRetention)
+	}
+}
+
+func TestRetentionJobRun(t *testing.T) {
+	p := &backlogPurger{err: errors.New("connection refused")}
+	j := newRetentionJob(p.purge, zerolog.Nop(), time.Millisecond, time.Hour, 10)
+	j.registerMetrics(metrics.NewRegistry())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	j.run(ctx)
+	if len(p.calls) < 2 || j.lastRun.Load() != 0 {
+		t.Errorf("failing purges: %d calls, last run %d; want retries and no completed run", len(p.calls), j.lastRun.Load())
+	}
+
+	p.err = nil
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	j.run(ctx)
+	if j.lastRun.Load() == 0 {
+		t.Error("a successful run is not recorded")
+	}
+}
+
+func TestRetentionDisabled(t *testing.T) {
+	s, _ := newTestServer(t, Config{RetentionInterval: 0})
+	if s.retain != nil {
+		t.Error("retention job built with RETENTION_INTERVAL=0")
+	}
+	if s, _ := newTestServer(t, Config{RetentionInterval: time.Hour}); s.retain == nil || s.retain.batch != 1000 {
+		t.Errorf("retention job %+v, want the default batch size", s.retain)
+	}
+}
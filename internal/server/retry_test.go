@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// retryHint checks that w is a status answer with Retry-After and
+// retry_after_ms agreeing, and returns the milliseconds.
+func retryHint(t *testing.T, w *httptest.ResponseRecorder, status int) time.Duration {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("got %d %s, want %d", w.Code, w.Body, status)
+	}
+	var body struct {
+		RetryAfterMs int64 `json:"retry_after_ms"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	secs, err := strconv.ParseInt(w.Header().Get("Retry-After"), 10, 64)
+	if err != nil || body.RetryAfterMs <= 0 || secs != (body.RetryAfterMs+999)/1000 {
+		t.Fatalf("Retry-After %q and retry_after_ms %d disagree", w.Header().Get("Retry-After"), body.RetryAfterMs)
+	}
+	return time.Duration(body.RetryAfterMs) * time.Millisecond
+}
+
+// within fails unless got is in (want-slack, want].
+func within(t *testing.T, name string, got, want, slack time.Duration) {
+	t.Helper()
+	if got > want || got <= want-slack {
+		t.Errorf("%s: retry after %v, want about %v", name, got, want)
+	}
+}
+
+func TestRetryAfterFromRateLimiter(t *testing.T) {
+	s, _ := newTestServer(t, Config{RateLimitRPS: 0.5, RateLimitBurst: 1})
+	h := s.Handler()
+	serve(h, http.MethodGet, "/api/v1/users", "")
+	// The bucket refills a token every two seconds.
+	within(t, "rate limit", retryHint(t, serve(h, http.MethodGet, "/api/v1/users", ""), http.StatusTooManyRequests), 2*time.Second, 100*time.Millisecond)
+}
+
+func TestRetryAfterFromBrownout(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	b := newBrownoutController(func() float64 { return 1 }, zerolog.Nop(), time.Second, 5*time.Second, 0.8, 0.5)
+	for range 5 {
+		b.observe(1)
+	}
+	s.brownout = b
+	h := s.Handler()
+
+	// Just shed: a full window must pass before the feature can return.
+	w := serve(h, http.MethodGet, "/api/v1/users?metadata.team=a", "")
+	within(t, "after shedding", retryHint(t, w, http.StatusServiceUnavailable), 5*time.Second, time.Millisecond)
+	b.observe(1)
+	b.observe(1)
+	w = serve(h, http.MethodGet, "/api/v1/users?metadata.team=a", "")
+	within(t, "two samples later", retryHint(t, w, http.StatusServiceUnavailable), 3*time.Second, time.Millisecond)
+}
+
+func TestRetryAfterFromReadOnly(t *testing.T) {
+	s, _ := newTestServer(t, Config{ReadOnlyProbeInterval: 10 * time.Second})
+	h := s.Handler()
+	create := func() *httptest.ResponseRecorder {
+		return serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`)
+	}
+
+	s.readOnly.setManual(true, nil)
+	within(t, "manual", retryHint(t, create(), http.StatusServiceUnavailable), 10*time.Second, time.Millisecond)
+
+	until := time.Now().Add(90 * time.Second)
+	s.readOnly.setManual(true, &until)
+	within(t, "maintenance window", retryHint(t, create(), http.StatusServiceUnavailable), 90*time.Second, time.Second)
+	if st := s.readOnly.state(); st.Until == nil || !st.Until.Equal(until.Truncate(0)) {
+		t.Errorf("state until = %v, want %v", st.Until, until)
+	}
+
+	// Detected mode clears at the next probe, three seconds after this one.
+	s.readOnly.setManual(false, nil)
+	s.readOnly.checked.Store(time.Now().Add(-7 * time.Second).UnixNano())
+	s.readOnly.trip()
+	within(t, "detected", retryHint(t, create(), http.StatusServiceUnavailable), 3*time.Second, time.Second)
+}
+
+func TestRetryAfterFromAuth(t *testing.T) {
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer idp.Close()
+	s, _ := newTestServer(t, Config{JWKSURL: idp.URL})
+	token := hs256(t, map[string]any{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()})
+	w := serve(s.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com"}`, "Authorization", "Bearer "+token)
+	within(t, "auth unavailable", retryHint(t, w, http.StatusServiceUnavailable), authRetryAfter, time.Millisecond)
+}
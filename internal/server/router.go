@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// router matches requests against the route table. Paths are written in
+// the table's syntax, ":id" for a segment and "*key" for the rest of the
+// path; handlers read the values with r.PathValue, a catch-all without
+// its leading slash. Only the routers know about either router library:
+// everything else is net/http.
+type router interface {
+	http.Handler
+	handle(method, path string, h http.Handler)
+	// registered lists the route keys handle was called with.
+	registered() []string
+}
+
+// notFound answers requests no route matched.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	respondError(w, r, codeNotFound, "not found")
+}
+
+// ginRouter routes with gin, as the standalone binary always has.
+type ginRouter struct {
+	engine *gin.Engine
+	keys   []string
+}
+
+func newGinRouter() router {
+	e := gin.New()
+	// clientIP resolves addresses; gin trusts no forwarding headers.
+	_ = e.SetTrustedProxies(nil)
+	e.NoRoute(func(c *gin.Context) { notFound(ginWriter{c.Writer}, c.Request) })
+	return &ginRouter{engine: e}
+}
+
+func (g *ginRouter) handle(method, path string, h http.Handler) {
+	g.keys = append(g.keys, routeKey(method, path))
+	g.engine.Handle(method, path, func(c *gin.Context) {
+		for _, p := range c.Params {
+			c.Request.SetPathValue(p.Key, strings.TrimPrefix(p.Value, "/"))
+		}
+		exchangeOf(c.Request).route = path
+		h.ServeHTTP(ginWriter{c.Writer}, c.Request)
+	})
+}
+
+func (g *ginRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.engine.ServeHTTP(w, r)
+}
+
+func (g *ginRouter) registered() []string {
+	return g.keys
+}
+
+// ginWriter sends the status when the handler writes it, as net/http
+// handlers expect, instead of when gin gets to it after the handler.
+type ginWriter struct {
+	gin.ResponseWriter
+}
+
+func (w ginWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// muxRouter routes with net/http's ServeMux, for embedding the API in
+// a host that does not use gin; see WithServeMux. It answers what gin
+// does: a GET route does not serve HEAD, a path served for other methods
+// only is not found rather than 405, and so is a path with "." or ".."
+// segments instead of being redirected to its clean form.
+type muxRouter struct {
+	mux  *http.ServeMux
+	keys []string
+}
+
+func newMuxRouter() router {
+	m := &muxRouter{mux: http.NewServeMux()}
+	m.mux.HandleFunc("/", notFound)
+	return m
+}
+
+func (m *muxRouter) handle(method, path string, h http.Handler) {
+	m.keys = append(m.keys, routeKey(method, path))
+	m.mux.Handle(method+" "+muxPattern(path), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			notFound(w, r) // HEAD through a GET pattern
+			return
+		}
+		exchangeOf(r).route = path
+		h.ServeHTTP(w, r)
+	}))
+}
+
+func (m *muxRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !cleanPath(r.URL.Path) {
+		notFound(w, r)
+		return
+	}
+	m.mux.ServeHTTP(w, r)
+}
+
+// cleanPath reports whether ServeMux would serve p as it is.
+func cleanPath(p string) bool {
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean == p
+}
+
+func (m *muxRouter) registered() []string {
+	return m.keys
+}
+
+// muxPattern writes a route table path as a ServeMux pattern:
+// "/users/:id/labels/*key" is "/users/{id}/labels/{key...}".
+func muxPattern(path string) string {
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			segments[i] = "{" + seg[1:] + "}"
+		case strings.HasPrefix(seg, "*"):
+			segments[i] = "{" + seg[1:] + "...}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
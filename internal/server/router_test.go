@@ -0,0 +1,83 @@
+package server
+
+import (
+	"go/parser"
+	"go/token"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Both routers answer every request alike: the same route, the same path
+// values and the same not-found envelope where nothing matches.
+func TestRoutersAgree(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, object{"route": routeOf(r), "id": r.PathValue("id"), "key": r.PathValue("key")})
+	})
+	routers := map[string]router{"gin": newGinRouter(), "mux": newMuxRouter()}
+	for _, rt := range routers {
+		rt.handle(http.MethodGet, "/users/:id", echo)
+		rt.handle(http.MethodDelete, "/users/:id/labels/*key", echo)
+	}
+
+	for _, tt := range []struct {
+		method, target string
+		status         int
+		body           string
+	}{
+		{http.MethodGet, "/users/7", 200, `{"id":"7","key":"","route":"/users/:id"}`},
+		{http.MethodDelete, "/users/7/labels/example.com/team", 200, `{"id":"7","key":"example.com/team","route":"/users/:id/labels/*key"}`},
+		{http.MethodHead, "/users/7", 404, ``},
+		{http.MethodPost, "/users/7", 404, `{"code":"not_found","error":"not found"}`},
+		{http.MethodGet, "/users", 404, `{"code":"not_found","error":"not found"}`},
+		{http.MethodGet, "/users/7/../8", 404, `{"code":"not_found","error":"not found"}`},
+		{http.MethodGet, "/users/./7", 404, `{"code":"not_found","error":"not found"}`},
+	} {
+		for name, rt := range routers {
+			w := serve(chain(rt, startExchange), tt.method, tt.target, "")
+			if w.Code != tt.status || (tt.method != http.MethodHead && strings.TrimSpace(w.Body.String()) != tt.body) {
+				t.Errorf("%s %s %s: %d %s, want %d %s", name, tt.method, tt.target, w.Code, w.Body, tt.status, tt.body)
+			}
+		}
+	}
+}
+
+func TestMuxPattern(t *testing.T) {
+	for in, want := range map[string]string{
+		"/users":                 "/users",
+		"/users/:id":             "/users/{id}",
+		"/users/:id/labels/*key": "/users/{id}/labels/{key...}",
+		"/ui/*filepath":          "/ui/{filepath...}",
+	} {
+		if got := muxPattern(in); got != want {
+			t.Errorf("muxPattern(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// Handlers and middleware are plain net/http, so the API serves the same
+// behind either router; gin stays an implementation detail of the gin
+// router, and request-scoped values live in requestctx.
+func TestOnlyTheRouterImportsGin(t *testing.T) {
+	files, err := os.ReadDir(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".go") || strings.HasSuffix(f.Name(), "_test.go") || f.Name() == "router.go" {
+			continue
+		}
+		file, err := parser.ParseFile(fset, f.Name(), nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range file.Imports {
+			if path, _ := strconv.Unquote(imp.Path.Value); strings.HasPrefix(path, "github.com/gin-gonic/") {
+				t.Errorf("%s imports %s; only router.go may", f.Name(), path)
+			}
+		}
+	}
+}
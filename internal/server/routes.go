@@ -0,0 +1,259 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// rateClass groups routes that share a rate-limit budget.
+type rateClass string
+
+const (
+	rateExempt rateClass = "exempt"
+	rateRead   rateClass = "read"
+	rateWrite  rateClass = "write"
+)
+
+// Default per-route timeout budgets.
+const (
+	readBudget  = 5 * time.Second
+	writeBudget = 10 * time.Second
+)
+
+// route is one entry of the declarative route table. All per-route
+// middleware decisions are derived from these fields by registerRoutes.
+type route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+
+	// Auth marks routes that require an authenticated caller: every
+	// mutating route and the admin reads.
+	Auth bool
+	// Timeout bounds the request context; zero leaves it unbounded.
+	Timeout time.Duration
+	// RateLimit names the rate-limit class the route is charged against.
+	RateLimit rateClass
+	// OperationID is the stable identifier used in API documentation.
+	OperationID string
+	// Deprecated routes advertise it with a Deprecation response header.
+	Deprecated bool
+	// Unprefixed routes are mounted at the root even when BasePath is set.
+	Unprefixed bool
+	// Management routes move to the management listener, at its root,
+	// when Config.ManagementAddr is set.
+	Management bool
+	// AllowDuplicates exempts a POST route from duplicate suppression,
+	// for endpoints where repeating the same request is the point.
+	AllowDuplicates bool
+	// AllowInReadOnly keeps a mutating route available in read-only mode.
+	AllowInReadOnly bool
+	// MaxBodyBytes overrides Config.MaxBodyBytes for a mutating route,
+	// e.g. for bulk endpoints.
+	MaxBodyBytes int64
+}
+
+// apiVersion is one prefix the versioned API is mounted under, with the
+// route table it serves there.
+type apiVersion struct {
+	Prefix string
+	Routes func() []route
+	// Deprecated marks every route of the version.
+	Deprecated bool
+}
+
+// apiVersions lists the mounted API versions. A new version is one more
+// entry with its own table, typically v1Routes with the changed entries
+// replaced. The unversioned legacy mount goes away with LegacyRoutes.
+func (s *Server) apiVersions() []apiVersion {
+	versions := []apiVersion{{Prefix: "/api/v1", Routes: s.v1Routes}}
+	if s.cfg.LegacyRoutes {
+		versions = append(versions, apiVersion{Prefix: "", Routes: s.v1Routes, Deprecated: true})
+	}
+	return versions
+}
+
+// routes is the single source of truth for everything the router serves:
+// the unversioned probes, then every API version's table with the version
+// prefix applied to its paths.
+func (s *Server) routes() []route {
+	table := s.rootRoutes()
+	for _, v := range s.apiVersions() {
+		for _, rt := range v.Routes() {
+			rt.Path = v.Prefix + rt.Path
+			rt.Deprecated = rt.Deprecated || v.Deprecated
+			table = append(table, rt)
+		}
+	}
+	return table
+}
+
+// rootRoutes are served outside every API version.
+func (s *Server) rootRoutes() []route {
+	return []route{
+		{Method: http.MethodGet, Path: "/healthz", Handler: s.healthz, RateLimit: rateExempt, OperationID: "healthz", Unprefixed: true, Management: true},
+		{Method: http.MethodGet, Path: "/readyz", Handler: s.readyz, RateLimit: rateExempt, OperationID: "readyz", Unprefixed: true, Management: true},
+		{Method: http.MethodGet, Path: "/scaling", Handler: s.scaling, RateLimit: rateExempt, OperationID: "scalingPressure", Unprefixed: true},
+		{Method: http.MethodGet, Path: metricsPath, Handler: s.serveMetrics, RateLimit: rateExempt, OperationID: "metrics", Unprefixed: true, Management: true},
+	}
+}
+
+// v1Routes is the /api/v1 table; paths are relative to the version prefix.
+func (s *Server) v1Routes() []route {
+	table := []route{
+		{Method: http.MethodGet, Path: "/admin/probe-failures", Handler: s.probeFailures, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "listProbeFailures"},
+		{Method: http.MethodGet, Path: "/errors", Handler: s.listErrors, Timeout: readBudget, RateLimit: rateRead, OperationID: "listErrorCodes"},
+
+		{Method: http.MethodGet, Path: "/admin/auth/keys", Handler: s.authKeys, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "listAuthKeys"},
+		{Method: http.MethodPost, Path: "/admin/auth/keys/reload", Handler: s.reloadAuthKeys, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "reloadAuthKeys", AllowInReadOnly: true},
+		{Method: http.MethodGet, Path: "/admin/features", Handler: s.featureMatrix, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "listFeatures"},
+		{Method: http.MethodGet, Path: "/admin/config", Handler: s.processConfig, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "getConfig"},
+		{Method: http.MethodGet, Path: "/admin/workers", Handler: s.workerStatus, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "listWorkers"},
+		{Method: http.MethodGet, Path: "/admin/db/report", Handler: s.dbReport, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "getDatabaseReport"},
+		{Method: http.MethodGet, Path: "/admin/db/index-advisor", Handler: s.indexAdvice, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "getIndexAdvice"},
+		{Method: http.MethodGet, Path: "/admin/migrations", Handler: s.migrationStatus, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "getMigrationStatus"},
+		{Method: http.MethodPut, Path: "/admin/read-only", Handler: s.setReadOnly, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "setReadOnly", AllowInReadOnly: true},
+		{Method: http.MethodGet, Path: "/admin/users/:id/diff", Handler: s.diffUser, Auth: true, Timeout: readBudget, RateLimit: rateRead, OperationID: "diffUser"},
+		{Method: http.MethodPost, Path: "/admin/users/:id/share-links", Handler: s.createShareLink, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "createShareLink"},
+
+		{Method: http.MethodGet, Path: "/shared/:token", Handler: s.sharedUser, Timeout: readBudget, RateLimit: rateRead, OperationID: "getSharedUser"},
+
+		{Method: http.MethodGet, Path: "/users", Handler: s.listUsers, Timeout: readBudget, RateLimit: rateRead, OperationID: "listUsers"},
+		{Method: http.MethodHead, Path: "/users", Handler: s.listUsers, Timeout: readBudget, RateLimit: rateRead, OperationID: "headUsers"},
+		{Method: http.MethodGet, Path: "/users.csv", Handler: s.exportUsersCSV, Timeout: writeBudget, RateLimit: rateRead, OperationID: "exportUsersCSV"},
+		{Method: http.MethodGet, Path: "/users/:id", Handler: s.getUser, Timeout: readBudget, RateLimit: rateRead, OperationID: "getUser"},
+		{Method: http.MethodHead, Path: "/users/:id", Handler: s.headUser, Timeout: readBudget, RateLimit: rateRead, OperationID: "headUser"},
+		{Method: http.MethodPost, Path: "/users", Handler: s.createUser, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "createUser"},
+		{Method: http.MethodPost, Path: "/users/batch", Handler: s.createUsers, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "createUsers", MaxBodyBytes: maxBatchBodyBytes},
+		{Method: http.MethodPut, Path: "/users/:id", Handler: s.updateUser, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "updateUser"},
+		{Method: http.MethodPatch, Path: "/users/:id", Handler: s.patchUser, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "patchUser"},
+		{Method: http.MethodDelete, Path: "/users/:id", Handler: s.deleteUser, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "deleteUser"},
+		{Method: http.MethodPost, Path: "/users/:id/restore", Handler: s.restoreUser, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "restoreUser"},
+		{Method: http.MethodPatch, Path: "/users/:id/metadata", Handler: s.patchMetadata, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "patchUserMetadata"},
+		{Method: http.MethodPut, Path: "/users/:id/labels", Handler: s.replaceLabels, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "replaceUserLabels"},
+		{Method: http.MethodPatch, Path: "/users/:id/labels", Handler: s.patchLabels, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "patchUserLabels"},
+		{Method: http.MethodDelete, Path: "/users/:id/labels/*key", Handler: s.deleteLabel, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "deleteUserLabel"},
+
+		{Method: http.MethodGet, Path: "/users/:id/views", Handler: s.getViews, Timeout: readBudget, RateLimit: rateRead, OperationID: "getUserViews"},
+		{Method: http.MethodPost, Path: "/users/:id/views", Handler: s.addView, Auth: true, Timeout: writeBudget, RateLimit: rateWrite, OperationID: "addUserView", AllowDuplicates: true},
+	}
+
+	if s.cfg.Docs {
+		table = append(table,
+			route{Method: http.MethodGet, Path: "/openapi.json", Handler: s.openAPISpec, Timeout: readBudget, RateLimit: rateRead, OperationID: "openAPISpec"},
+			route{Method: http.MethodGet, Path: "/docs", Handler: s.swaggerUI, Timeout: readBudget, RateLimit: rateRead, OperationID: "swaggerUI"},
+			route{Method: http.MethodGet, Path: "/docs/errors", Handler: s.errorsDoc, Timeout: readBudget, RateLimit: rateRead, OperationID: "errorCodesDoc"},
+		)
+	}
+	if s.cfg.DemoUI {
+		table = append(table,
+			route{Method: http.MethodGet, Path: "/ui/*filepath", Handler: s.serveUI, Timeout: readBudget, RateLimit: rateRead, OperationID: "demoUI"},
+		)
+	}
+	return table
+}
+
+// Names of the per-route middlewares, as recorded in Server.mounted.
+const (
+	mwRateLimit   = "rate-limit"
+	mwInFlight    = "in-flight"
+	mwDeprecated  = "deprecated"
+	mwAuth        = "auth"
+	mwBodyLimit   = "body-limit"
+	mwTimeout     = "timeout"
+	mwIdempotency = "idempotency"
+	mwJournal     = "journal"
+	mwReadOnly    = "read-only"
+)
+
+// namedHandler is a per-route middleware tagged with its name so the
+// coverage check can tell which ones a mounted route actually received.
+type namedHandler struct {
+	name    string
+	handler middleware
+}
+
+// registerRoutes mounts every table entry with the middleware its metadata
+// asks for, under BasePath unless the entry is Unprefixed.
+func (s *Server) registerRoutes() {
+	s.mounted = make(map[string][]string)
+	for _, rt := range s.routes() {
+		r := s.router
+		if s.onManagement(rt) {
+			r = s.mgmtRouter
+		}
+
+		var mws []middleware
+		var names []string
+		for _, mw := range s.routeMiddleware(rt) {
+			mws = append(mws, mw.handler)
+			names = append(names, mw.name)
+		}
+		path := s.mountPath(rt)
+		r.handle(rt.Method, path, chain(rt.Handler, mws...))
+		s.mounted[routeKey(rt.Method, path)] = names
+	}
+}
+
+func (s *Server) routeMiddleware(rt route) []namedHandler {
+	var chain []namedHandler
+	if s.limiter != nil && rt.RateLimit != rateExempt {
+		chain = append(chain, namedHandler{mwRateLimit, s.limiter.middleware(rt.RateLimit, s.clientIP)})
+	}
+	if rt.RateLimit != rateExempt {
+		chain = append(chain, namedHandler{mwInFlight, s.pressure.track()})
+	}
+	if rt.Deprecated {
+		chain = append(chain, namedHandler{mwDeprecated, deprecated()})
+	}
+	if rt.Auth {
+		chain = append(chain, namedHandler{mwAuth, s.authenticate()})
+	}
+	if isMutating(rt.Method) {
+		chain = append(chain, namedHandler{mwBodyLimit, limitBody(s.bodyLimit(rt))})
+	}
+	if rt.Timeout > 0 {
+		chain = append(chain, namedHandler{mwTimeout, s.timeoutBudget(rt.Timeout)})
+	}
+	if isMutating(rt.Method) && !rt.AllowInReadOnly {
+		chain = append(chain, namedHandler{mwReadOnly, s.readOnly.middleware()})
+	}
+	if rt.Method == http.MethodPost && !rt.AllowDuplicates {
+		chain = append(chain, namedHandler{mwIdempotency, s.idem.middleware()})
+	}
+	if s.journal != nil && isMutating(rt.Method) {
+		chain = append(chain, namedHandler{mwJournal, journalMiddleware(s.journal, routeKey(rt.Method, rt.Path))})
+	}
+	return chain
+}
+
+// mountPath is the full router path of rt.
+func (s *Server) mountPath(rt route) string {
+	if s.unprefixed(rt) {
+		return rt.Path
+	}
+	return s.cfg.BasePath + rt.Path
+}
+
+func (s *Server) unprefixed(rt route) bool {
+	return (rt.Unprefixed && !s.cfg.ProbesUnderBasePath) || s.onManagement(rt)
+}
+
+// onManagement reports whether rt is served by the management listener.
+func (s *Server) onManagement(rt route) bool {
+	return rt.Management && s.mgmtRouter != nil
+}
+
+func routeKey(method, path string) string {
+	return method + " " + path
+}
+
+// deprecated marks responses from routes scheduled for removal.
+func deprecated() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
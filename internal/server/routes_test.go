@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Every table entry is registered and nothing else is; the reverse
+// direction is what checkRouteCoverage enforces at startup.
+func TestRouteTableMatchesRouter(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"defaults":   {},
+		"legacy":     {LegacyRoutes: true, Docs: true},
+		"base path":  {BasePath: "/users-api"},
+		"management": {ManagementAddr: ":0"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, _ := newTestServer(t, cfg)
+			registered := make(map[string]bool)
+			for _, key := range s.router.registered() {
+				registered[key] = true
+			}
+			if s.mgmtRouter != nil {
+				for _, key := range s.mgmtRouter.registered() {
+					registered[key] = true
+				}
+			}
+
+			table := s.routes()
+			for _, rt := range table {
+				if key := routeKey(rt.Method, s.mountPath(rt)); !registered[key] {
+					t.Errorf("%s is in the table but not registered", key)
+				}
+			}
+			if len(registered) != len(table) {
+				t.Errorf("%d routes registered, %d in the table", len(registered), len(table))
+			}
+		})
+	}
+}
+
+// The OpenAPI document is generated from the same table.
+func TestOpenAPIFromRouteTable(t *testing.T) {
+	s, _ := newTestServer(t, Config{LegacyRoutes: true, Docs: true})
+	h := s.Handler()
+
+	for prefix, deprecated := range map[string]bool{"/api/v1": false, "": true} {
+		var spec struct {
+			Paths map[string]map[string]json.RawMessage `json:"paths"`
+		}
+		w := serve(h, http.MethodGet, prefix+"/openapi.json", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &spec); err != nil {
+			t.Fatalf("%s/openapi.json: %d %v", prefix, w.Code, err)
+		}
+		for _, rt := range s.v1Routes() {
+			var op struct {
+				OperationID string `json:"operationId"`
+				Deprecated  bool   `json:"deprecated"`
+			}
+			json.Unmarshal(spec.Paths[openAPIPath.ReplaceAllString(rt.Path, "{$1}")][strings.ToLower(rt.Method)], &op)
+			if op.OperationID != rt.OperationID || op.Deprecated != deprecated {
+				t.Errorf("%s %s%s documented as %+v", rt.Method, prefix, rt.Path, op)
+			}
+		}
+	}
+}
+
+func TestDeprecationHeader(t *testing.T) {
+	s, repo := newTestServer(t, Config{LegacyRoutes: true})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+
+	if w := serve(h, http.MethodGet, "/users/1", ""); w.Code != http.StatusOK || w.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy route: %d, Deprecation %q", w.Code, w.Header().Get("Deprecation"))
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users/1", ""); w.Header().Get("Deprecation") != "" {
+		t.Errorf("versioned route marked deprecated")
+	}
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	legacy, _ := newTestServer(t, Config{LegacyRoutes: true})
+	off, _ := newTestServer(t, Config{})
+
+	for _, path := range []string{"/healthz", "/readyz", metricsPath} {
+		for name, s := range map[string]*Server{"legacy": legacy, "off": off} {
+			w := serve(s.Handler(), http.MethodGet, path, "")
+			if w.Code != http.StatusOK || w.Header().Get("Deprecation") != "" {
+				t.Errorf("%s %s: %d, Deprecation %q", name, path, w.Code, w.Header().Get("Deprecation"))
+			}
+			if w := serve(s.Handler(), http.MethodGet, "/api/v1"+path, ""); w.Code != http.StatusNotFound {
+				t.Errorf("%s %s under /api/v1: %d, want 404", name, path, w.Code)
+			}
+		}
+	}
+
+	// Links follow the mount the request came in on.
+	w := serve(legacy.Handler(), http.MethodPost, "/users", `{"name":"Ada","email":"ada@example.com"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/users/1" || w.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy POST: %d, Location %q, Deprecation %q", w.Code, w.Header().Get("Location"), w.Header().Get("Deprecation"))
+	}
+	w = serve(legacy.Handler(), http.MethodPost, "/api/v1/users", `{"name":"Grace","email":"grace@example.com"}`)
+	if w.Header().Get("Location") != "/api/v1/users/2" {
+		t.Errorf("versioned POST: Location %q", w.Header().Get("Location"))
+	}
+	// Errors of a legacy route are deprecated too.
+	if w := serve(legacy.Handler(), http.MethodGet, "/users/99", ""); w.Code != http.StatusNotFound || w.Header().Get("Deprecation") != "true" {
+		t.Errorf("legacy 404: %d, Deprecation %q", w.Code, w.Header().Get("Deprecation"))
+	}
+
+	if w := serve(off.Handler(), http.MethodGet, "/users", ""); w.Code != http.StatusNotFound {
+		t.Errorf("legacy path with ENABLE_LEGACY_ROUTES=false: %d, want 404", w.Code)
+	}
+	if w := serve(off.Handler(), http.MethodGet, "/api/v1/users", ""); w.Code != http.StatusOK {
+		t.Errorf("versioned path: %d", w.Code)
+	}
+}
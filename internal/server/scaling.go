@@ -0,0 +1,95 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sync/atomic"
+)
+
+// pressureGauge derives a single autoscaling signal from request
+// concurrency and database pool saturation. Every component is a ratio
+// where 1 means "at capacity"; pressure is their weighted mean, so a
+// scaler can target e.g. 0.7. Reads are a few atomics and one pool stat,
+// cheap enough to scrape every second.
+type pressureGauge struct {
+	inFlight atomic.Int64
+	limit    int64
+	pool     func() (acquired, max int32)
+
+	weightInFlight float64
+	weightPool     float64
+}
+
+func newPressureGauge(pool func() (acquired, max int32), limit int64, weightInFlight, weightPool float64) *pressureGauge {
+	return &pressureGauge{
+		pool:           pool,
+		limit:          limit,
+		weightInFlight: weightInFlight,
+		weightPool:     weightPool,
+	}
+}
+
+// track counts the request as in flight for its whole duration.
+func (g *pressureGauge) track() middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			g.inFlight.Add(1)
+			defer g.inFlight.Add(-1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type pressureComponent struct {
+	Value  float64 `json:"value"`
+	Limit  float64 `json:"limit"`
+	Ratio  float64 `json:"ratio"`
+	Weight float64 `json:"weight"`
+}
+
+type pressureReport struct {
+	Pressure      float64                      `json:"pressure"`
+	Components    map[string]pressureComponent `json:"components"`
+	BrownoutLevel int                          `json:"brownout_level"`
+}
+
+func (g *pressureGauge) report() pressureReport {
+	acquired, max := g.pool()
+	components := map[string]pressureComponent{
+		"in_flight": component(float64(g.inFlight.Load()), float64(g.limit), g.weightInFlight),
+		"db_pool":   component(float64(acquired), float64(max), g.weightPool),
+	}
+
+	var sum, weights float64
+	for _, c := range components {
+		sum += c.Ratio * c.Weight
+		weights += c.Weight
+	}
+	var pressure float64
+	if weights > 0 {
+		pressure = sum / weights
+	}
+	return pressureReport{Pressure: round3(pressure), Components: components}
+}
+
+func component(value, limit, weight float64) pressureComponent {
+	var ratio float64
+	if limit > 0 {
+		ratio = value / limit
+	}
+	return pressureComponent{Value: value, Limit: limit, Ratio: round3(ratio), Weight: weight}
+}
+
+func round3(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
+
+// scaling serves GET /scaling for KEDA's metrics-api scaler
+// (valueLocation: "pressure").
+func (s *Server) scaling(w http.ResponseWriter, r *http.Request) {
+	report := s.pressure.report()
+	report.BrownoutLevel, _ = s.brownout.state()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, http.StatusOK, report)
+}
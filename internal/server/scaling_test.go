@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestPressureWeights(t *testing.T) {
+	g := newPressureGauge(func() (int32, int32) { return 5, 10 }, 4, 3, 1)
+	g.inFlight.Store(1)
+
+	r := g.report()
+	// (0.25*3 + 0.5*1) / 4
+	if r.Pressure != 0.313 || r.Components["in_flight"].Ratio != 0.25 || r.Components["db_pool"].Ratio != 0.5 {
+		t.Errorf("report = %+v", r)
+	}
+
+	// A zero weight leaves the component out, a zero limit reads as idle.
+	if r := newPressureGauge(func() (int32, int32) { return 9, 10 }, 4, 1, 0).report(); r.Pressure != 0 {
+		t.Errorf("pool-only load with zero pool weight: %+v", r)
+	}
+	if r := newPressureGauge(func() (int32, int32) { return 0, 0 }, 4, 0, 1).report(); r.Pressure != 0 {
+		t.Errorf("empty pool: %+v", r)
+	}
+}
+
+// gatedRepo holds GetUserByID until the gate is closed.
+type gatedRepo struct {
+	*repository.Memory
+	gate chan struct{}
+}
+
+func (r gatedRepo) GetUserByID(ctx context.Context, id int64) (*repository.User, error) {
+	select {
+	case <-r.gate:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return r.Memory.GetUserByID(ctx, id)
+}
+
+func pressure(t *testing.T, h http.Handler) float64 {
+	t.Helper()
+	var r pressureReport
+	if err := json.Unmarshal(serve(h, http.MethodGet, "/scaling", "").Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	return r.Pressure
+}
+
+// The gauge rises while synthetic load is in flight and falls back once
+// it drains.
+func TestPressureUnderLoad(t *testing.T) {
+	repo := gatedRepo{repository.NewMemory(), make(chan struct{})}
+	seedUsers(t, repo, 1)
+	s, err := New(Config{ScalingConcurrency: 10, ScalingWeightInFlight: 1}, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	if p := pressure(t, h); p != 0 {
+		t.Fatalf("idle pressure = %v", p)
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(h, http.MethodGet, "/api/v1/users/1", "")
+		}()
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pressure(t, h) != 0.8 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pressure = %v under 8 of 10 in flight, want 0.8", pressure(t, h))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(repo.gate)
+	wg.Wait()
+	if p := pressure(t, h); p != 0 {
+		t.Errorf("pressure after the load drained = %v", p)
+	}
+}
@@ -0,0 +1,758 @@
+// Package server wires the HTTP API: router, middleware, background
+// workers and the http.Server lifecycle.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"go-k8s-demo/internal/auth"
+	"go-k8s-demo/internal/config"
+	"go-k8s-demo/internal/features"
+	"go-k8s-demo/internal/journal"
+	"go-k8s-demo/internal/metrics"
+	"go-k8s-demo/internal/repository"
+	"go-k8s-demo/internal/supervisor"
+)
+
+// Config holds the tunables for a Server. The zero value is usable: every
+// optional feature is disabled and Addr defaults to ":8080".
+type Config struct {
+	// Addr is the listen address; use ":0" for a random port in tests.
+	Addr string
+	// ManagementAddr serves /healthz, /readyz and /metrics on a listener
+	// of their own, e.g. ":9090", so NetworkPolicies can keep probes and
+	// scrapes off the API port; empty serves them on Addr.
+	ManagementAddr string
+
+	// Timeouts of the underlying http.Server; zero picks 5s, 15s, 30s and
+	// 60s respectively. WriteTimeout must exceed the route budgets.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// ReadinessTimeout bounds the database ping of /readyz; defaults to 1s.
+	ReadinessTimeout time.Duration
+	// SchemaVersion is the database migration version found at startup,
+	// reported by /readyz; zero leaves it out.
+	SchemaVersion int
+
+	// BasePath mounts the API under a prefix such as "/api/users-service".
+	// Health probes stay at the root so kubelet paths don't change.
+	BasePath string
+	// ProbesUnderBasePath mounts the probes under BasePath as well, for
+	// when the API is embedded and the host owns the root.
+	ProbesUnderBasePath bool
+	// LegacyRoutes keeps serving the API at its old unversioned paths
+	// next to /api/v1, with a Deprecation header on every response.
+	LegacyRoutes bool
+	// TrustForwardedPrefix honors X-Forwarded-Prefix when building links;
+	// only enable it behind a proxy that sets (or strips) the header.
+	TrustForwardedPrefix bool
+
+	// MaxBodyBytes limits request bodies of mutating routes; larger ones
+	// get 413. Routes can raise it with route.MaxBodyBytes.
+	MaxBodyBytes int64
+
+	// TrustedProxies lists the proxy addresses or CIDRs whose
+	// X-Forwarded-For is believed when determining the client IP; empty
+	// uses the connection's remote address.
+	TrustedProxies []string
+	// CORSAllowedOrigins enables CORS for these origins: exact, "*" or
+	// with a subdomain wildcard such as "https://*.example.com". Empty
+	// methods and headers allow everything the API uses.
+	CORSAllowedOrigins   []string
+	CORSAllowedMethods   []string
+	CORSAllowedHeaders   []string
+	CORSMaxAge           time.Duration
+	CORSAllowCredentials bool
+	// RateLimitRPS and RateLimitBurst size the token bucket each client IP
+	// gets per rate class; zero RPS disables rate limiting.
+	RateLimitRPS   float64
+	RateLimitBurst int
+	// RateLimitSyncInterval shares the buckets through the database every
+	// interval, so they survive restarts and hold across replicas; zero
+	// keeps them per replica.
+	RateLimitSyncInterval time.Duration
+
+	// RequestTimeout bounds the time budget of every route; a request
+	// still running when it is spent is cancelled and answered with 504.
+	RequestTimeout time.Duration
+
+	// DeadlineHeader names the header carrying how long the caller will
+	// wait; it can shorten a route's budget, clamped to [DeadlineMin,
+	// DeadlineMax]. Empty ignores client deadlines.
+	DeadlineHeader string
+	DeadlineMin    time.Duration
+	DeadlineMax    time.Duration
+
+	// MaxUserID is the largest id a user can have; larger ids get a 404
+	// without touching the database. Defaults to the SERIAL maximum.
+	MaxUserID int64
+
+	// StrictRowLimit answers 422 instead of a truncated list when the
+	// repository's row cap is hit.
+	StrictRowLimit bool
+
+	// ListCacheTTL enables the GET /users response cache when positive.
+	ListCacheTTL      time.Duration
+	ListCacheMaxBytes int
+
+	// Table growth monitoring: sizes are always exported as metrics; the
+	// thresholds (zero disables) add warnings, and TableRowsHardCap
+	// write protection.
+	TableCheckInterval time.Duration
+	TableRowsWarn      int64
+	TableBytesWarn     int64
+	TableRowsHardCap   int64
+
+	// Clock skew against the database is measured at startup and then every
+	// ClockSkewInterval; skew beyond ClockSkewThreshold is logged and
+	// reported by /readyz.
+	ClockSkewInterval  time.Duration
+	ClockSkewThreshold time.Duration
+
+	// ViewFlushInterval enables in-memory batching of view increments,
+	// flushed every interval or every ViewBatchSize increments.
+	ViewFlushInterval time.Duration
+	ViewBatchSize     int
+
+	// ServerTiming emits a Server-Timing header (db, cache, auth, render) on every
+	// response. The pgx pool must use timing.PgxTracer for db entries.
+	ServerTiming bool
+
+	// Duplicate protection for POST routes; see idempotencyGuard.
+	// IdempotencyKeyTTL is how long Idempotency-Key responses are kept,
+	// DuplicateWindow how long identical key-less requests are merged
+	// (zero disables), StrictIdempotency requires the header, and
+	// RetryHeader names an ingress header that marks retried requests.
+	IdempotencyKeyTTL time.Duration
+	DuplicateWindow   time.Duration
+	StrictIdempotency bool
+	RetryHeader       string
+
+	// RequireIfMatch answers PUT, PATCH and DELETE of a user without an
+	// If-Match header with 428 instead of writing unconditionally.
+	RequireIfMatch bool
+
+	// Autoscaling pressure signal (GET /scaling): ScalingConcurrency is the
+	// in-flight request count treated as full load, and the weights set
+	// how much each component contributes.
+	ScalingConcurrency    int64
+	ScalingWeightInFlight float64
+	ScalingWeightPool     float64
+
+	// BrownoutHigh enables load shedding of optional features when the
+	// scaling pressure averages at least this over BrownoutWindow; they
+	// come back once it averages at most BrownoutLow.
+	BrownoutHigh   float64
+	BrownoutLow    float64
+	BrownoutWindow time.Duration
+
+	// MemoryHeadroom enables memory-aware batches in the CSV export and
+	// POST /users/batch: while less than this share of the memory limit
+	// (GOMEMLIMIT) is free they shrink, and they grow back once twice as
+	// much is. Zero, or no memory limit, keeps the batches at their
+	// largest.
+	MemoryHeadroom float64
+
+	// JournalPath enables the crash-forensics journal of mutating requests
+	// (e.g. on an emptyDir). JournalSync is always, interval or never.
+	JournalPath         string
+	JournalMaxBytes     int64
+	JournalSync         journal.SyncPolicy
+	JournalSyncInterval time.Duration
+
+	// Features is the resolved environment feature matrix, served at
+	// /admin/features; the individual switches below are what take effect.
+	Features features.Matrix
+	// Process is the resolved process configuration, served at
+	// /admin/config.
+	Process config.Effective
+	// Docs serves human-readable API documentation under /docs.
+	Docs bool
+	// DemoUI serves the embedded browser UI at /ui/.
+	DemoUI bool
+
+	// JWTSecret (HS256/384/512), JWKSURL (RS* and ES* keys) or
+	// JWTKeysFile (a JWK Set of any of them, re-read by ReloadJWTKeys)
+	// verifies the bearer tokens Auth routes require. With none set and no
+	// API keys those routes are open, which New logs as a warning.
+	JWTSecret   string
+	JWKSURL     string
+	JWKSRefresh time.Duration
+	JWTKeysFile string
+	// JWTKeyGrace is how long a key removed from the JWKS or keys file
+	// still verifies tokens it signed.
+	JWTKeyGrace time.Duration
+	// JWTIssuer and JWTAudience, when set, must match the token's claims.
+	JWTIssuer   string
+	JWTAudience string
+	// JWTLeeway absorbs clock skew when checking exp and nbf.
+	JWTLeeway time.Duration
+	// JWTLeewayFromSkew widens JWTLeeway by the clock skew measured
+	// against the database while it is above ClockSkewThreshold, by at
+	// most JWTLeewaySkewCap, on the assumption that the token issuer
+	// keeps the database's time.
+	JWTLeewayFromSkew bool
+	JWTLeewaySkewCap  time.Duration
+	// APIKeys and APIKeysFile list hashed API keys accepted in X-API-Key
+	// as an alternative to a bearer token, see auth.APIKeys. The file is
+	// re-read by ReloadAPIKeys.
+	APIKeys     string
+	APIKeysFile string
+
+	// ShareLinkSecret signs read-only share links; empty disables them.
+	ShareLinkSecret     string
+	ShareLinkDefaultTTL time.Duration
+	ShareLinkMaxTTL     time.Duration
+
+	// ReadOnlyMode starts with writes rejected; the mode can also be toggled
+	// at runtime and is entered automatically while the database is
+	// read-only, re-checked every ReadOnlyProbeInterval.
+	ReadOnlyMode          bool
+	ReadOnlyProbeInterval time.Duration
+
+	// WorkerStaleFactor is how many of its intervals a background worker
+	// may go without completing a loop before /readyz reports it stale.
+	WorkerStaleFactor float64
+
+	// RetentionInterval enables the job purging consumed share links once
+	// they expired more than ShareLinkRetention ago (at least an hour),
+	// RetentionBatchSize rows per DELETE.
+	RetentionInterval  time.Duration
+	ShareLinkRetention time.Duration
+	RetentionBatchSize int
+
+	// ProbeLogSample logs one in N successful probes; 0 suppresses them.
+	// ProbeLogDebug logs the others at Debug instead of dropping them.
+	ProbeLogSample      uint64
+	ProbeLogDebug       bool
+	ProbeFailureHistory int
+}
+
+// minWorkerStaleAfter keeps fast loops from flapping stale on a busy node.
+const minWorkerStaleAfter = 10 * time.Second
+
+// Option customizes a Server beyond its Config.
+type Option func(*Server)
+
+// WithRepository sets the data access layer. It is required; use
+// repository.NewMemory to run the handlers without a database.
+func WithRepository(repo repository.UserRepository) Option {
+	return func(s *Server) { s.repo = repo }
+}
+
+// WithLogger replaces the global zerolog logger for handlers and workers.
+func WithLogger(l zerolog.Logger) Option {
+	return func(s *Server) { s.log = l }
+}
+
+// WithMetrics registers the server's metrics on reg, for when the caller
+// exports metrics of its own (e.g. the database pool) at /metrics too.
+// Without it the server uses a registry of its own.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(s *Server) { s.metrics = reg }
+}
+
+// WithMiddleware appends middleware after the built-in request ID, logging and
+// recovery.
+func WithMiddleware(m ...func(http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		for _, mw := range m {
+			s.middleware = append(s.middleware, mw)
+		}
+	}
+}
+
+// WithServeMux routes with net/http's ServeMux instead of gin, for
+// embedding the API in a host that does not use gin. Both routers serve
+// the same handlers with the same middleware.
+func WithServeMux() Option {
+	return func(s *Server) { s.newRouter = newMuxRouter }
+}
+
+// Server is the HTTP API with its background workers.
+type Server struct {
+	cfg        Config
+	repo       repository.UserRepository
+	log        zerolog.Logger
+	middleware []middleware
+	newRouter  func() router
+	trusted    []*net.IPNet
+
+	cache     *responseCache
+	growth    *growthMonitor
+	probes    *probeLog
+	clock     *clockSkewChecker
+	views     *viewBatcher
+	idem      *idempotencyGuard
+	share     *shareSigner
+	limiter   *rateLimiter
+	limitSync *limiterSync
+	auth      *auth.Verifier
+	apiKeys   *auth.APIKeys
+
+	pressure *pressureGauge
+	brownout *brownoutController
+	budget   *memoryBudget
+	journal  *journal.Journal
+	readOnly *readOnlyGuard
+	retain   *retentionJob
+	draining atomic.Bool
+
+	metrics    *metrics.Registry
+	reqMetrics *requestMetrics
+
+	router      router
+	handler     http.Handler        // router behind the global middleware
+	mounted     map[string][]string // route key -> per-route middleware names
+	srv         *http.Server
+	listener    net.Listener
+	mgmtRouter  router // nil unless ManagementAddr is set
+	mgmtSrv     *http.Server
+	mgmtLn      net.Listener
+	errc        chan error
+	stopWorkers context.CancelFunc
+	workers     *supervisor.Supervisor
+}
+
+// New builds a fully wired Server. It does not start listening; call Start.
+func New(cfg Config, opts ...Option) (*Server, error) {
+	s := &Server{
+		cfg:       cfg,
+		log:       log.Logger,
+		newRouter: newGinRouter,
+		errc:      make(chan error, 2),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.repo == nil {
+		return nil, errors.New("server: a repository is required")
+	}
+	if s.cfg.Addr == "" {
+		s.cfg.Addr = ":8080"
+	}
+	if s.cfg.ReadHeaderTimeout <= 0 {
+		s.cfg.ReadHeaderTimeout = 5 * time.Second
+	}
+	if s.cfg.ReadTimeout <= 0 {
+		s.cfg.ReadTimeout = 15 * time.Second
+	}
+	if s.cfg.WriteTimeout <= 0 {
+		s.cfg.WriteTimeout = 30 * time.Second
+	}
+	if s.cfg.IdleTimeout <= 0 {
+		s.cfg.IdleTimeout = 60 * time.Second
+	}
+	if s.cfg.ReadinessTimeout <= 0 {
+		s.cfg.ReadinessTimeout = time.Second
+	}
+	s.cfg.BasePath = cleanPrefix(s.cfg.BasePath)
+	if s.cfg.RequestTimeout <= 0 {
+		s.cfg.RequestTimeout = 10 * time.Second
+	}
+	if s.cfg.DeadlineMin <= 0 {
+		s.cfg.DeadlineMin = 100 * time.Millisecond
+	}
+	if s.cfg.DeadlineMax < s.cfg.DeadlineMin {
+		s.cfg.DeadlineMax = time.Minute
+	}
+	if s.cfg.MaxUserID <= 0 {
+		s.cfg.MaxUserID = math.MaxInt32
+	}
+	if s.cfg.MaxBodyBytes <= 0 {
+		s.cfg.MaxBodyBytes = 1 << 20
+	}
+	if s.cfg.ListCacheMaxBytes <= 0 {
+		s.cfg.ListCacheMaxBytes = 8 << 20
+	}
+	if s.cfg.TableCheckInterval <= 0 {
+		s.cfg.TableCheckInterval = time.Minute
+	}
+	if s.cfg.ClockSkewInterval <= 0 {
+		s.cfg.ClockSkewInterval = 5 * time.Minute
+	}
+	if s.cfg.ClockSkewThreshold <= 0 {
+		s.cfg.ClockSkewThreshold = 5 * time.Second
+	}
+	if s.cfg.IdempotencyKeyTTL <= 0 {
+		s.cfg.IdempotencyKeyTTL = 24 * time.Hour
+	}
+	if s.cfg.ShareLinkDefaultTTL <= 0 {
+		s.cfg.ShareLinkDefaultTTL = time.Hour
+	}
+	if s.cfg.ShareLinkMaxTTL <= 0 {
+		s.cfg.ShareLinkMaxTTL = 7 * 24 * time.Hour
+	}
+	if s.cfg.ScalingConcurrency <= 0 {
+		s.cfg.ScalingConcurrency = 100
+	}
+	if s.cfg.ScalingWeightInFlight <= 0 && s.cfg.ScalingWeightPool <= 0 {
+		s.cfg.ScalingWeightInFlight, s.cfg.ScalingWeightPool = 1, 1
+	}
+	if s.cfg.BrownoutLow <= 0 || s.cfg.BrownoutLow >= s.cfg.BrownoutHigh {
+		s.cfg.BrownoutLow = s.cfg.BrownoutHigh * 2 / 3
+	}
+	if s.cfg.BrownoutWindow <= 0 {
+		s.cfg.BrownoutWindow = 30 * time.Second
+	}
+	if s.cfg.JournalMaxBytes <= 0 {
+		s.cfg.JournalMaxBytes = 16 << 20
+	}
+	if s.cfg.JournalSync == "" {
+		s.cfg.JournalSync = journal.SyncInterval
+	}
+	if s.cfg.JournalSyncInterval <= 0 {
+		s.cfg.JournalSyncInterval = time.Second
+	}
+	if s.cfg.ViewBatchSize <= 0 {
+		s.cfg.ViewBatchSize = 100
+	}
+	if s.cfg.ReadOnlyProbeInterval <= 0 {
+		s.cfg.ReadOnlyProbeInterval = 10 * time.Second
+	}
+	if s.cfg.WorkerStaleFactor <= 0 {
+		s.cfg.WorkerStaleFactor = 3
+	}
+	if s.cfg.RetentionBatchSize <= 0 {
+		s.cfg.RetentionBatchSize = 1000
+	}
+	if s.cfg.ProbeFailureHistory <= 0 {
+		s.cfg.ProbeFailureHistory = 50
+	}
+
+	if s.cfg.ListCacheTTL > 0 {
+		s.cache = newResponseCache(s.cfg.ListCacheTTL, s.cfg.ListCacheMaxBytes)
+		s.log.Info().Dur("ttl", s.cfg.ListCacheTTL).Int("max_bytes", s.cfg.ListCacheMaxBytes).Msg("List response cache enabled")
+	}
+	s.growth = newGrowthMonitor(s.repo, s.log, s.cfg.TableCheckInterval,
+		s.cfg.TableRowsWarn, s.cfg.TableBytesWarn, s.cfg.TableRowsHardCap)
+	s.probes = newProbeLog(s.cfg.ProbeLogSample, s.cfg.ProbeFailureHistory)
+	s.clock = newClockSkewChecker(s.repo.Now, s.log, s.cfg.ClockSkewInterval, s.cfg.ClockSkewThreshold)
+	s.idem = newIdempotencyGuard(s.log, s.cfg.IdempotencyKeyTTL, s.cfg.DuplicateWindow, s.cfg.StrictIdempotency, s.cfg.RetryHeader)
+	s.pressure = newPressureGauge(s.repo.PoolStats, s.cfg.ScalingConcurrency, s.cfg.ScalingWeightInFlight, s.cfg.ScalingWeightPool)
+	if s.cfg.BrownoutHigh > 0 {
+		s.brownout = newBrownoutController(func() float64 { return s.pressure.report().Pressure },
+			s.log, time.Second, s.cfg.BrownoutWindow, s.cfg.BrownoutHigh, s.cfg.BrownoutLow)
+	}
+	s.budget = newMemoryBudget(s.cfg.MemoryHeadroom)
+	s.readOnly = newReadOnlyGuard(s.repo.ReadOnly, s.log, s.cfg.ReadOnlyProbeInterval, s.cfg.ReadOnlyMode)
+	if s.cfg.JWTSecret != "" || s.cfg.JWKSURL != "" || s.cfg.JWTKeysFile != "" {
+		var skewLeeway func() time.Duration
+		if s.cfg.JWTLeewayFromSkew {
+			skewLeeway = func() time.Duration { return s.clock.leeway(s.cfg.JWTLeewaySkewCap) }
+		}
+		v, err := auth.NewVerifier(auth.Config{
+			Secret:      s.cfg.JWTSecret,
+			JWKSURL:     s.cfg.JWKSURL,
+			JWKSRefresh: s.cfg.JWKSRefresh,
+			KeysFile:    s.cfg.JWTKeysFile,
+			KeyGrace:    s.cfg.JWTKeyGrace,
+			Issuer:      s.cfg.JWTIssuer,
+			Audience:    s.cfg.JWTAudience,
+			Leeway:      s.cfg.JWTLeeway,
+			SkewLeeway:  skewLeeway,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.auth = v
+	}
+	if s.cfg.APIKeys != "" || s.cfg.APIKeysFile != "" {
+		keys, err := auth.LoadAPIKeys(s.cfg.APIKeys, s.cfg.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		s.apiKeys = keys
+		s.log.Info().Int("keys", keys.Len()).Msg("API key authentication enabled")
+	}
+	if s.auth == nil && s.apiKeys == nil {
+		s.log.Warn().Msg("Neither JWT nor API key authentication is configured; routes that require it are open")
+	}
+	if s.cfg.ShareLinkSecret != "" {
+		s.share = &shareSigner{secret: []byte(s.cfg.ShareLinkSecret)}
+	}
+	var cors *corsPolicy
+	if len(s.cfg.CORSAllowedOrigins) > 0 {
+		headers := s.cfg.CORSAllowedHeaders
+		if len(headers) == 0 && s.cfg.DeadlineHeader != "" {
+			headers = append(slices.Clone(corsDefaultHeaders), s.cfg.DeadlineHeader)
+		}
+		var err error
+		if cors, err = newCORSPolicy(s.cfg.CORSAllowedOrigins, s.cfg.CORSAllowedMethods, headers, s.cfg.CORSMaxAge, s.cfg.CORSAllowCredentials); err != nil {
+			return nil, err
+		}
+	}
+	if s.cfg.RateLimitRPS > 0 {
+		s.limiter = newRateLimiter(s.cfg.RateLimitRPS, s.cfg.RateLimitBurst)
+		if s.cfg.RateLimitSyncInterval > 0 {
+			s.limitSync = newLimiterSync(s.limiter, s.repo.SyncRateLimits, s.log, s.cfg.RateLimitSyncInterval)
+		}
+	}
+	if s.cfg.ViewFlushInterval > 0 {
+		s.views = newViewBatcher(s.repo.IncrementViews, s.log, s.cfg.ViewFlushInterval, s.cfg.ViewBatchSize)
+	}
+
+	if s.cfg.JournalPath != "" {
+		j, interrupted, err := journal.Open(s.cfg.JournalPath, s.cfg.JournalMaxBytes, s.cfg.JournalSync, s.log)
+		if err != nil {
+			return nil, err
+		}
+		s.journal = j
+		s.logInterrupted(interrupted)
+		s.log.Info().Str("path", s.cfg.JournalPath).Str("sync", string(s.cfg.JournalSync)).Msg("Request journal enabled")
+	}
+
+	if s.cfg.RetentionInterval > 0 {
+		s.retain = newRetentionJob(s.repo.PurgeShareLinkUses, s.log, s.cfg.RetentionInterval, s.cfg.ShareLinkRetention, s.cfg.RetentionBatchSize)
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+	s.registerMetrics(s.metrics)
+
+	trusted, err := parseTrustedProxies(s.cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	s.trusted = trusted
+
+	// Request IDs first, so the access log and everything after carry them.
+	global := []middleware{startExchange, s.requestID}
+
+	// Access log; see accessLog for what is skipped.
+	scrapePath := s.mountPath(route{Path: metricsPath, Unprefixed: true})
+	global = append(global, s.accessLog(scrapePath), s.recoverPanics, s.reqMetrics.middleware)
+	if s.cfg.ServerTiming {
+		global = append(global, serverTiming)
+	}
+	if cors != nil {
+		// Before the embedder's middleware, which may reject preflights.
+		global = append(global, cors.middleware())
+	}
+	global = append(global, s.middleware...)
+
+	s.router = s.newRouter()
+	s.handler = chain(s.router, global...)
+	var mgmtHandler http.Handler
+	if s.cfg.ManagementAddr != "" {
+		// Probes and scrapes keep the request ID, access log and metrics,
+		// but neither CORS nor the embedder's middleware.
+		s.mgmtRouter = s.newRouter()
+		mgmtHandler = chain(s.mgmtRouter, startExchange, s.requestID, s.accessLog(metricsPath), s.recoverPanics, s.reqMetrics.middleware)
+	}
+
+	s.registerRoutes()
+	// Release builds check too: a route that lost its protection must not
+	// reach production.
+	if err := s.checkRouteCoverage(); err != nil {
+		return nil, err
+	}
+
+	s.srv = &http.Server{
+		Addr:              s.cfg.Addr,
+		Handler:           s.handler,
+		ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+		ReadTimeout:       s.cfg.ReadTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+	}
+	if s.mgmtRouter != nil {
+		s.mgmtSrv = &http.Server{
+			Addr:              s.cfg.ManagementAddr,
+			Handler:           mgmtHandler,
+			ReadHeaderTimeout: s.cfg.ReadHeaderTimeout,
+			ReadTimeout:       s.cfg.ReadTimeout,
+			WriteTimeout:      s.cfg.WriteTimeout,
+			IdleTimeout:       s.cfg.IdleTimeout,
+		}
+	}
+
+	return s, nil
+}
+
+// ReloadAPIKeys re-reads APIKeysFile, e.g. on SIGHUP. The previous keys
+// stay in effect when the file cannot be read or parsed.
+func (s *Server) ReloadAPIKeys() error {
+	if s.apiKeys == nil {
+		return nil
+	}
+	if err := s.apiKeys.Reload(); err != nil {
+		return err
+	}
+	s.log.Info().Int("keys", s.apiKeys.Len()).Msg("API keys reloaded")
+	return nil
+}
+
+// ReloadJWTKeys re-reads JWTKeysFile or refetches the JWKS, e.g. on
+// SIGHUP after a rotation; with JWTSecret there is nothing to reload. The
+// previous keys stay in effect on error.
+func (s *Server) ReloadJWTKeys() error {
+	if s.auth == nil {
+		return nil
+	}
+	if err := s.auth.Reload(); err != nil {
+		if errors.Is(err, auth.ErrStaticKey) {
+			return nil
+		}
+		return err
+	}
+	s.log.Info().Int("keys", len(s.auth.Keys(time.Now()))).Msg("JWT keys reloaded")
+	return nil
+}
+
+// Handler exposes the router behind the global middleware, e.g. for
+// httptest.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Addr returns the bound address once Start has succeeded.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.cfg.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+// ManagementAddr returns the bound management address once Start has
+// succeeded, or "" when the probes are served on Addr.
+func (s *Server) ManagementAddr() string {
+	if s.mgmtLn == nil {
+		return s.cfg.ManagementAddr
+	}
+	return s.mgmtLn.Addr().String()
+}
+
+// Start binds the listeners, starts background workers and serves in the
+// background. Both listeners are bound before either serves, so a port
+// that is taken fails Start with nothing running; later serve errors
+// arrive on Err.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	if s.mgmtSrv != nil {
+		mln, err := net.Listen("tcp", s.cfg.ManagementAddr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("management listener: %w", err)
+		}
+		s.mgmtLn = mln
+	}
+	s.listener = ln
+	s.StartWorkers()
+
+	go func() {
+		s.log.Info().Str("addr", s.Addr()).Msg("Server starting")
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.errc <- err
+		}
+	}()
+	if s.mgmtSrv != nil {
+		go func() {
+			s.log.Info().Str("addr", s.ManagementAddr()).Msg("Management server starting")
+			if err := s.mgmtSrv.Serve(s.mgmtLn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.errc <- fmt.Errorf("management server: %w", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// StartWorkers starts the background workers without listening, for when
+// Handler is served by someone else's http.Server. Start calls it.
+func (s *Server) StartWorkers() {
+	if s.workers != nil {
+		return
+	}
+	var workerCtx context.Context
+	workerCtx, s.stopWorkers = context.WithCancel(context.Background())
+	s.workers = supervisor.New(workerCtx, s.log)
+	s.workers.RegisterMetrics(s.metrics)
+	s.workers.Go("table-growth", s.growth.run, s.staleAfter(s.cfg.TableCheckInterval))
+	s.workers.Go("clock-skew", s.clock.run, s.staleAfter(s.cfg.ClockSkewInterval))
+	if s.views != nil {
+		// Views queue up in memory while the flusher is stuck.
+		s.workers.Go("view-flush", s.views.run, s.staleAfter(s.cfg.ViewFlushInterval), supervisor.Critical())
+	}
+	if s.limitSync != nil {
+		s.workers.Go("rate-limit-sync", s.limitSync.run, s.staleAfter(s.cfg.RateLimitSyncInterval))
+	}
+	if s.brownout != nil {
+		s.workers.Go("brownout", s.brownout.run, s.staleAfter(time.Second))
+	}
+	s.workers.Go("read-only-probe", s.readOnly.run, s.staleAfter(s.cfg.ReadOnlyProbeInterval))
+	if s.retain != nil {
+		s.workers.Go("retention", s.retain.run, s.staleAfter(s.cfg.RetentionInterval))
+	}
+	if s.journal != nil && s.cfg.JournalSync == journal.SyncInterval {
+		s.workers.Go("journal-sync", func(ctx context.Context) { s.journal.Run(ctx, s.cfg.JournalSyncInterval) },
+			s.staleAfter(s.cfg.JournalSyncInterval))
+	}
+}
+
+// staleAfter is the liveness deadline of a worker looping every interval.
+func (s *Server) staleAfter(interval time.Duration) supervisor.Option {
+	return supervisor.StaleAfter(max(time.Duration(s.cfg.WorkerStaleFactor*float64(interval)), minWorkerStaleAfter))
+}
+
+// Err reports a fatal serve error after Start.
+func (s *Server) Err() <-chan error {
+	return s.errc
+}
+
+// Drain marks the server as going away: /readyz answers 503 from now on
+// so the pod is taken out of its Service endpoints, while requests that
+// still arrive are served normally until Shutdown.
+func (s *Server) Drain() {
+	if !s.draining.Swap(true) {
+		s.log.Info().Msg("Draining: readiness probe now failing")
+	}
+}
+
+// Shutdown stops accepting requests, waits for in-flight ones, then stops
+// background workers and waits for them to finish (e.g. the final flush of
+// batched views), all within ctx. The repository is left open for the
+// caller to close.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	err := s.srv.Shutdown(ctx)
+	// The management listener goes second, so probes keep answering
+	// (readiness failing) while API requests finish.
+	if s.mgmtSrv != nil {
+		if merr := s.mgmtSrv.Shutdown(ctx); merr != nil && err == nil {
+			err = merr
+		}
+	}
+	if s.stopWorkers != nil {
+		s.stopWorkers()
+	}
+	if werr := s.workers.Wait(ctx); werr != nil {
+		s.log.Error().Err(werr).Msg("background workers did not stop in time")
+		if err == nil {
+			err = werr
+		}
+	}
+	if jerr := s.journal.Close(); jerr != nil && err == nil {
+		err = jerr
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// newTestServer builds a Server on an empty in-memory repository.
+func newTestServer(t *testing.T, cfg Config, opts ...Option) (*Server, *repository.Memory) {
+	t.Helper()
+	repo := repository.NewMemory()
+	s, err := New(cfg, append([]Option{WithRepository(repo)}, opts...)...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s, repo
+}
+
+// serve sends one request through h; headers are name, value pairs.
+func serve(h http.Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body == "" {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func get(t *testing.T, url string) int {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// A fully wired server on random ports, as an integration test would
+// build one.
+func TestStartAndShutdown(t *testing.T) {
+	s, repo := newTestServer(t, Config{Addr: "127.0.0.1:0", ManagementAddr: "127.0.0.1:0"})
+	seedUsers(t, repo, 1)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	api, mgmt := "http://"+s.Addr(), "http://"+s.ManagementAddr()
+
+	if code := get(t, api+"/api/v1/users/1"); code != http.StatusOK {
+		t.Errorf("API: %d", code)
+	}
+	if code := get(t, mgmt+"/healthz"); code != http.StatusOK {
+		t.Errorf("management healthz: %d", code)
+	}
+	if code := get(t, api+"/healthz"); code != http.StatusNotFound {
+		t.Errorf("healthz on the API port: %d", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := http.Get(api + "/api/v1/users/1"); err == nil {
+		t.Error("API still serving after Shutdown")
+	}
+	select {
+	case err := <-s.Err():
+		t.Errorf("serve error: %v", err)
+	default:
+	}
+}
+
+// A taken management port fails Start before anything serves.
+func TestStartPortTaken(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	s, _ := newTestServer(t, Config{Addr: "127.0.0.1:0", ManagementAddr: taken.Addr().String()})
+	if err := s.Start(); err == nil || !strings.Contains(err.Error(), "management listener") {
+		t.Fatalf("Start = %v, want the management listener error", err)
+	}
+	if s.workers != nil {
+		t.Error("workers started although Start failed")
+	}
+}
+
+// Probes and /metrics answer on the management listener only, the API on
+// the main one only; without a management address everything shares the
+// main port, as existing deployments expect.
+func TestManagementRouting(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		mgmt string
+	}{{"separate", "127.0.0.1:0"}, {"main port", ""}} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestServer(t, Config{Addr: "127.0.0.1:0", ManagementAddr: tc.mgmt})
+			if err := s.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer s.Shutdown(context.Background())
+			api := "http://" + s.Addr()
+			if (s.ManagementAddr() == "") != (tc.mgmt == "") {
+				t.Fatalf("ManagementAddr = %q", s.ManagementAddr())
+			}
+
+			for _, path := range []string{"/healthz", "/readyz", metricsPath} {
+				want := http.StatusNotFound
+				if tc.mgmt == "" {
+					want = http.StatusOK
+				}
+				if code := get(t, api+path); code != want {
+					t.Errorf("%s on the API port: %d, want %d", path, code, want)
+				}
+				if tc.mgmt != "" {
+					if code := get(t, "http://"+s.ManagementAddr()+path); code != http.StatusOK {
+						t.Errorf("%s on the management port: %d", path, code)
+					}
+				}
+			}
+			for _, path := range []string{"/api/v1/users", "/scaling"} {
+				if code := get(t, api+path); code != http.StatusOK {
+					t.Errorf("%s on the API port: %d", path, code)
+				}
+				if tc.mgmt != "" {
+					if code := get(t, "http://"+s.ManagementAddr()+path); code != http.StatusNotFound {
+						t.Errorf("%s on the management port: %d, want 404", path, code)
+					}
+				}
+			}
+		})
+	}
+}
+
+// Start binds both listeners or neither: a failed management bind
+// releases the API port.
+func TestStartReleasesPortsOnFailure(t *testing.T) {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := free.Addr().String()
+	free.Close()
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	s, _ := newTestServer(t, Config{Addr: addr, ManagementAddr: taken.Addr().String()})
+	if err := s.Start(); err == nil {
+		t.Fatal("Start succeeded with the management port taken")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("API port still bound after the failed Start: %v", err)
+	}
+	ln.Close()
+
+	// A taken API port fails before the management port is tried.
+	s, _ = newTestServer(t, Config{Addr: taken.Addr().String(), ManagementAddr: "127.0.0.1:0"})
+	if err := s.Start(); err == nil || strings.Contains(err.Error(), "management") {
+		t.Errorf("Start = %v, want the API listener error", err)
+	}
+}
+
+// Drain fails readiness while requests are still served; Shutdown then
+// waits for the in-flight ones, with the probes answering until the API
+// is done.
+func TestDrainAndShutdown(t *testing.T) {
+	mem := repository.NewMemory()
+	gate, entered := make(chan struct{}), make(chan struct{}, 1)
+	repo := enteredRepo{gatedRepo{mem, gate}, entered}
+	s, err := New(Config{Addr: "127.0.0.1:0", ManagementAddr: "127.0.0.1:0"}, WithRepository(repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedUsers(t, mem, 1)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	api, mgmt := "http://"+s.Addr(), "http://"+s.ManagementAddr()
+	// One connection per request, so Shutdown has no keep-alive to wait
+	// out and only the gated request holds it up.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(url string) int {
+		t.Helper()
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := get(mgmt + "/readyz"); code != http.StatusOK {
+		t.Fatalf("readyz before drain: %d", code)
+	}
+
+	s.Drain()
+	resp, err := client.Get(mgmt + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), `"shutting_down":true`) {
+		t.Errorf("readyz while draining: %d %s", resp.StatusCode, body)
+	}
+	if code := get(mgmt + "/healthz"); code != http.StatusOK {
+		t.Errorf("healthz while draining: %d", code)
+	}
+	if code := get(api + "/api/v1/users"); code != http.StatusOK {
+		t.Errorf("API while draining: %d", code)
+	}
+
+	inFlight := make(chan int, 1)
+	go func() {
+		res, err := client.Get(api + "/api/v1/users/1")
+		if err != nil {
+			inFlight <- 0
+			return
+		}
+		res.Body.Close()
+		inFlight <- res.StatusCode
+	}()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned with a request in flight: %v", err)
+	default:
+	}
+	if code := get(mgmt + "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readyz during shutdown: %d", code)
+	}
+
+	close(gate)
+	if code := <-inFlight; code != http.StatusOK {
+		t.Errorf("in-flight request: %d", code)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if _, err := client.Get(mgmt + "/healthz"); err == nil {
+		t.Error("management port still serving after Shutdown")
+	}
+}
+
+// enteredRepo signals each GetUserByID before handing it to the gate.
+type enteredRepo struct {
+	gatedRepo
+	entered chan struct{}
+}
+
+func (r enteredRepo) GetUserByID(ctx context.Context, id int64) (*repository.User, error) {
+	r.entered <- struct{}{}
+	return r.gatedRepo.GetUserByID(ctx, id)
+}
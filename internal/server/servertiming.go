@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"go-k8s-demo/internal/timing"
+)
+
+// serverTiming attaches a timing.Recorder to the request context and emits
+// what was recorded as a Server-Timing header right before the response
+// headers are sent.
+func serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, rec := timing.NewContext(r.Context())
+		tw := &timingWriter{ResponseWriter: w, rec: rec}
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		// Body-less responses are sent by net/http after the chain returns.
+		tw.writeTiming()
+	})
+}
+
+// timingWriter sets the Server-Timing header when the status is written,
+// the last moment headers can still change.
+type timingWriter struct {
+	http.ResponseWriter
+	rec  *timing.Recorder
+	done bool
+}
+
+func (w *timingWriter) writeTiming() {
+	if w.done {
+		return
+	}
+	w.done = true
+	if h := w.rec.Header(); h != "" {
+		w.Header().Set("Server-Timing", h)
+	}
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.writeTiming()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.writeTiming()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	w.writeTiming()
+	flush(w.ResponseWriter)
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
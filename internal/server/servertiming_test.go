@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var timingEntry = regexp.MustCompile(`^([a-z]+);dur=\d+\.\d{2}(?:;desc="([^"]*)")?$`)
+
+// serverTimings parses a Server-Timing header into name -> desc.
+func serverTimings(t *testing.T, h string) map[string]string {
+	t.Helper()
+	got := make(map[string]string)
+	for _, part := range strings.Split(h, ", ") {
+		m := timingEntry.FindStringSubmatch(part)
+		if m == nil {
+			t.Fatalf("malformed Server-Timing entry %q in %q", part, h)
+		}
+		got[m[1]] = m[2]
+	}
+	return got
+}
+
+func TestServerTiming(t *testing.T) {
+	s, repo := newTestServer(t, Config{ServerTiming: true, ListCacheTTL: time.Minute, APIKeys: testAPIKeys})
+	seedUsers(t, repo, 2)
+	h := s.Handler()
+
+	first := serverTimings(t, serve(h, http.MethodGet, "/api/v1/users", "").Header().Get("Server-Timing"))
+	if first["cache"] != "miss" {
+		t.Errorf("first list: %v", first)
+	}
+	if _, ok := first["render"]; !ok {
+		t.Errorf("first list has no render entry: %v", first)
+	}
+	if second := serverTimings(t, serve(h, http.MethodGet, "/api/v1/users", "").Header().Get("Server-Timing")); second["cache"] != "hit" {
+		t.Errorf("second list: %v", second)
+	}
+
+	w := serve(h, http.MethodDelete, "/api/v1/users/2", "", apiKeyHeader, aliceKey)
+	if _, ok := serverTimings(t, w.Header().Get("Server-Timing"))["auth"]; !ok || w.Code != http.StatusOK {
+		t.Errorf("delete: %d, Server-Timing %q", w.Code, w.Header().Get("Server-Timing"))
+	}
+}
+
+func TestServerTimingOff(t *testing.T) {
+	s, _ := newTestServer(t, Config{ListCacheTTL: time.Minute})
+	if h := serve(s.Handler(), http.MethodGet, "/api/v1/users", "").Header().Get("Server-Timing"); h != "" {
+		t.Errorf("Server-Timing = %q with the feature off", h)
+	}
+}
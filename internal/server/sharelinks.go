@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// shareableFields can be exposed through a share link; id is always included.
+var shareableFields = map[string]bool{
+	"name":     true,
+	"email":    true,
+	"metadata": true,
+}
+
+var (
+	errShareInvalid = errors.New("share link is invalid")
+	errShareExpired = errors.New("share link has expired")
+)
+
+// shareClaims is the signed content of a share link token.
+type shareClaims struct {
+	UserID  int64    `json:"u"`
+	Expires int64    `json:"e"`
+	Fields  []string `json:"f"`
+	// Nonce is set for one-time links and recorded when consumed.
+	Nonce string `json:"n,omitempty"`
+}
+
+// shareSigner issues and verifies tokens of the form
+// base64url(claims) "." base64url(HMAC-SHA256(claims)).
+type shareSigner struct {
+	secret []byte
+}
+
+func (s *shareSigner) sign(c shareClaims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(s.mac(payload)), nil
+}
+
+// verify checks the signature before anything else, so a tampered token
+// is reported as invalid even if it also claims to be expired.
+func (s *shareSigner) verify(token string, now time.Time) (shareClaims, error) {
+	var c shareClaims
+	enc := base64.RawURLEncoding
+
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return c, errShareInvalid
+	}
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return c, errShareInvalid
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, s.mac(payload)) {
+		return c, errShareInvalid
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, errShareInvalid
+	}
+	if now.Unix() >= c.Expires {
+		return c, errShareExpired
+	}
+	return c, nil
+}
+
+func (s *shareSigner) mac(payload []byte) []byte {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+func newShareNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// redactUser keeps only the fields a share link allows.
+func redactUser(u *repository.User, fields []string) map[string]any {
+	out := map[string]any{"id": u.ID}
+	for _, f := range fields {
+		switch f {
+		case "name":
+			out["name"] = u.Name
+		case "email":
+			out["email"] = u.Email
+		case "metadata":
+			out["metadata"] = u.Metadata
+		}
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestShareSigner(t *testing.T) {
+	signer := &shareSigner{secret: []byte("s3cret")}
+	now := time.Now()
+	claims := shareClaims{UserID: 7, Expires: now.Add(time.Hour).Unix(), Fields: []string{"name"}}
+	token, err := signer.sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := signer.verify(token, now); err != nil || got.UserID != 7 || got.Fields[0] != "name" {
+		t.Errorf("verify = %+v, %v", got, err)
+	}
+	if _, err := signer.verify(token, now.Add(time.Hour)); err != errShareExpired {
+		t.Errorf("at expiry: %v", err)
+	}
+	if _, err := (&shareSigner{secret: []byte("other")}).verify(token, now); err != errShareInvalid {
+		t.Errorf("other secret: %v", err)
+	}
+
+	// Widening the fields breaks the signature, and a tampered token is
+	// invalid even once it would also have expired.
+	forged, _ := (&shareSigner{secret: []byte("guess")}).sign(shareClaims{UserID: 7, Expires: claims.Expires, Fields: []string{"name", "email"}})
+	payload, _, _ := strings.Cut(forged, ".")
+	_, sig, _ := strings.Cut(token, ".")
+	for _, bad := range []string{payload + "." + sig, "", "no-dot", token + "x", "!!." + sig} {
+		if _, err := signer.verify(bad, now.Add(2*time.Hour)); err != errShareInvalid {
+			t.Errorf("verify(%q) = %v, want errShareInvalid", bad, err)
+		}
+	}
+}
+
+func TestShareLinkLifecycle(t *testing.T) {
+	s, repo := newTestServer(t, Config{APIKeys: testAPIKeys, ShareLinkSecret: "s3cret", ShareLinkMaxTTL: time.Hour})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+	create := func(body string) (shareLink, *httptest.ResponseRecorder) {
+		t.Helper()
+		var link shareLink
+		w := serve(h, http.MethodPost, "/api/v1/admin/users/1/share-links", body, apiKeyHeader, aliceKey)
+		json.Unmarshal(w.Body.Bytes(), &link)
+		return link, w
+	}
+	code := func(w *httptest.ResponseRecorder) string {
+		var body struct{ Code string }
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return body.Code
+	}
+
+	// Creation is audited.
+	var audit bytes.Buffer
+	s.log = zerolog.New(&audit)
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	link, w := create(`{"fields":["email"],"expires_in":"10m"}`)
+	zerolog.SetGlobalLevel(level)
+	if w.Code != http.StatusCreated || !link.ExpiresAt.After(time.Now()) {
+		t.Fatalf("create: %d %s", w.Code, w.Body)
+	}
+	if !strings.Contains(audit.String(), `"message":"share link created"`) || !strings.Contains(audit.String(), `"fields":["email"]`) {
+		t.Errorf("no audit record: %s", audit.String())
+	}
+
+	// Only the granted fields are served, any number of times.
+	for range 2 {
+		w = serve(h, http.MethodGet, link.URL, "")
+		if w.Code != http.StatusOK || w.Body.String() != `{"email":"user0@example.com","id":1}` || w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("shared view: %d %s", w.Code, w.Body)
+		}
+	}
+
+	oneTime, _ := create(`{"one_time":true}`)
+	if w := serve(h, http.MethodGet, oneTime.URL, ""); w.Code != http.StatusOK {
+		t.Fatalf("one-time link, first use: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, oneTime.URL, ""); w.Code != http.StatusGone || code(w) != "share_link_used" {
+		t.Errorf("replayed one-time link: %d %s", w.Code, w.Body)
+	}
+
+	expired, _ := s.share.sign(shareClaims{UserID: 1, Expires: time.Now().Add(-time.Second).Unix(), Fields: []string{"name"}})
+	if w := serve(h, http.MethodGet, "/api/v1/shared/"+expired, ""); w.Code != http.StatusGone || code(w) != "share_link_expired" {
+		t.Errorf("expired link: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, link.URL[:len(link.URL)-2], ""); w.Code != http.StatusForbidden || code(w) != "share_link_invalid" {
+		t.Errorf("tampered link: %d %s", w.Code, w.Body)
+	}
+
+	for _, body := range []string{`{"fields":["labels"]}`, `{"expires_in":"2h"}`, `{"expires_in":"-1m"}`} {
+		if _, w := create(body); w.Code != http.StatusBadRequest {
+			t.Errorf("create with %s: %d", body, w.Code)
+		}
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/admin/users/2/share-links", "", apiKeyHeader, aliceKey); w.Code != http.StatusNotFound {
+		t.Errorf("link to a missing user: %d", w.Code)
+	}
+}
+
+func TestShareLinksDisabled(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	if w := serve(s.Handler(), http.MethodGet, "/api/v1/shared/anything", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("shared view without a secret: %d", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-k8s-demo/internal/repository"
+)
+
+func TestSoftDelete(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	h := s.Handler()
+	seedUsers(t, repo, 2)
+
+	list := func(query string) []repository.User {
+		t.Helper()
+		w := serve(h, http.MethodGet, "/api/v1/users"+query, "")
+		var users []repository.User
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("%s: %d %s", query, w.Code, w.Body)
+		}
+		return users
+	}
+
+	if w := serve(h, http.MethodDelete, "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users/1", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET a soft-deleted user: %d", w.Code)
+	}
+	if users := list(""); len(users) != 1 || users[0].ID != 2 {
+		t.Errorf("list: %+v", users)
+	}
+	all := list("?include_deleted=true")
+	if len(all) != 2 || all[0].DeletedAt == nil || all[1].DeletedAt != nil {
+		t.Fatalf("list including deleted: %+v", all)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users?include_deleted=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("include_deleted=maybe: %d", w.Code)
+	}
+
+	// The email stays reserved, so a restore can never collide.
+	body := `{"name":"Again","email":"` + all[0].Email + `"}`
+	if w := serve(h, http.MethodPost, "/api/v1/users", body); w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), codeEmailInUse.Code) {
+		t.Errorf("email of a soft-deleted user: %d %s, want 409", w.Code, w.Body)
+	}
+
+	w := serve(h, http.MethodPost, "/api/v1/users/1/restore", "")
+	var restored repository.User
+	if err := json.Unmarshal(w.Body.Bytes(), &restored); err != nil || w.Code != http.StatusOK || restored.DeletedAt != nil || w.Header().Get("ETag") == "" {
+		t.Fatalf("restore: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/api/v1/users/1", ""); w.Code != http.StatusOK {
+		t.Errorf("GET after restore: %d", w.Code)
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users/99/restore", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore a missing user: %d", w.Code)
+	}
+
+	if w := serve(h, http.MethodDelete, "/api/v1/users/1?hard=true", ""); w.Code != http.StatusOK {
+		t.Fatalf("hard DELETE: %d %s", w.Code, w.Body)
+	}
+	if users := list("?include_deleted=true"); len(users) != 1 {
+		t.Errorf("hard-deleted user still listed: %+v", users)
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users/1/restore", ""); w.Code != http.StatusNotFound {
+		t.Errorf("restore after a hard delete: %d", w.Code)
+	}
+	if w := serve(h, http.MethodPost, "/api/v1/users", body); w.Code != http.StatusCreated {
+		t.Errorf("email of a hard-deleted user: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodDelete, "/api/v1/users/2?hard=maybe", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("hard=maybe: %d", w.Code)
+	}
+}
+
+// The list is open, but soft-deleted users are only for authenticated
+// callers, in every representation of it.
+func TestIncludeDeletedRequiresCredentials(t *testing.T) {
+	s, repo := newTestServer(t, Config{APIKeys: testAPIKeys})
+	h := s.Handler()
+	seedUsers(t, repo, 2)
+	if w := serve(h, http.MethodDelete, "/api/v1/users/1", "", apiKeyHeader, aliceKey); w.Code != http.StatusOK {
+		t.Fatalf("DELETE: %d %s", w.Code, w.Body)
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		headers        []string
+	}{
+		{http.MethodGet, "/api/v1/users?include_deleted=true", nil},
+		{http.MethodHead, "/api/v1/users?include_deleted=true", nil},
+		{http.MethodGet, "/api/v1/users.csv?include_deleted=true", nil},
+		{http.MethodGet, "/api/v1/users?include_deleted=true", []string{"Accept", mimeCSV}},
+	} {
+		w := serve(h, tc.method, tc.target, "", tc.headers...)
+		if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "user0@") {
+			t.Errorf("%s %s %v without a key: %d %s", tc.method, tc.target, tc.headers, w.Code, w.Body)
+		}
+		w = serve(h, tc.method, tc.target, "", append([]string{apiKeyHeader, "not-a-key"}, tc.headers...)...)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s %v with a wrong key: %d", tc.method, tc.target, tc.headers, w.Code)
+		}
+		w = serve(h, tc.method, tc.target, "", append([]string{apiKeyHeader, aliceKey}, tc.headers...)...)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s %s %v with a key: %d %s", tc.method, tc.target, tc.headers, w.Code, w.Body)
+		}
+	}
+
+	w := serve(h, http.MethodGet, "/api/v1/users.csv?include_deleted=true", "", apiKeyHeader, aliceKey)
+	if rows := strings.Count(strings.TrimSpace(w.Body.String()), "\n"); rows != 2 {
+		t.Errorf("export with a key has %d rows, want both users:\n%s", rows, w.Body)
+	}
+	// Without include_deleted nothing changes for anonymous callers.
+	if w := serve(h, http.MethodGet, "/api/v1/users.csv?include_deleted=false", ""); w.Code != http.StatusOK {
+		t.Errorf("include_deleted=false without a key: %d", w.Code)
+	}
+}
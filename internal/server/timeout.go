@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// timeoutWriter lets the deadline answer 504 while the handler is still
+// running. The handler runs on the request goroutine as usual; only the
+// timeout response comes from another one, so everything the two share
+// goes through mu:
+//
+//   - Until the response is committed (status, first body write or
+//     flush), the handler's headers live in a map of their own and are
+//     copied over on commit, so the timeout never races with header
+//     writes.
+//   - Once timedOut is set, handler writes are dropped with
+//     http.ErrHandlerTimeout; once committed, the timeout does nothing.
+//
+// The handler keeps running until it notices the cancelled context and
+// returns; the middleware then waits for mu, so whatever runs after it
+// sees the final status.
+type timeoutWriter struct {
+	http.ResponseWriter
+
+	mu        sync.Mutex
+	header    http.Header
+	status    int
+	committed bool
+	timedOut  bool
+}
+
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{ResponseWriter: w, header: w.Header().Clone(), status: http.StatusOK}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.committed {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+// commit publishes the handler's headers; the caller holds mu.
+func (w *timeoutWriter) commit() {
+	if w.committed {
+		return
+	}
+	w.committed = true
+	h := w.ResponseWriter.Header()
+	clear(h)
+	maps.Copy(h, w.header)
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut || w.committed {
+		return
+	}
+	w.commit()
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.commit()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.timedOut {
+		w.commit()
+		flush(w.ResponseWriter)
+	}
+}
+
+// Status is the status the client gets: the handler's, or 504 once the
+// deadline answered.
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// timeout writes the 504 unless the handler already committed a
+// response, and reports whether it did.
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.committed || w.timedOut {
+		return false
+	}
+	w.timedOut = true
+	w.status = codeDeadlineExceeded.Status
+	body, _ := json.Marshal(errorBody(codeDeadlineExceeded, "request deadline exceeded"))
+	h := w.ResponseWriter.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(codeDeadlineExceeded.Status)
+	w.ResponseWriter.Write(body)
+	flush(w.ResponseWriter)
+	return true
+}
+
+// finish publishes the headers of a response the handler wrote nothing
+// of, which net/http sends after the chain returns, and stops further
+// timeouts.
+func (w *timeoutWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.timedOut {
+		w.commit()
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/repository"
+)
+
+// blockingRepo holds GetUserByID until the request gives up, like a query
+// stuck on a lock.
+type blockingRepo struct {
+	*repository.Memory
+}
+
+func (r blockingRepo) GetUserByID(ctx context.Context, id int64) (*repository.User, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// The handler and the deadline race for the response; run with -race.
+// Whichever wins, the client gets exactly its response, never a mix.
+func TestTimeoutWriterRace(t *testing.T) {
+	for range 500 {
+		rec := httptest.NewRecorder()
+		tw := newTimeoutWriter(rec)
+
+		var wg sync.WaitGroup
+		var writeErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			tw.Header().Set("Content-Type", "text/plain")
+			tw.Header().Set("X-Handler", "yes")
+			tw.WriteHeader(http.StatusOK)
+			_, writeErr = tw.Write([]byte("ok"))
+		}()
+		go func() {
+			defer wg.Done()
+			tw.timeout()
+		}()
+		wg.Wait()
+		tw.finish()
+
+		switch rec.Code {
+		case http.StatusOK:
+			if writeErr != nil || rec.Body.String() != "ok" || rec.Header().Get("X-Handler") != "yes" {
+				t.Fatalf("handler won but sent %q, headers %v, err %v", rec.Body, rec.Header(), writeErr)
+			}
+		case http.StatusGatewayTimeout:
+			var body struct{ Code string }
+			if !errors.Is(writeErr, http.ErrHandlerTimeout) || json.Unmarshal(rec.Body.Bytes(), &body) != nil ||
+				body.Code != codeDeadlineExceeded.Code || rec.Header().Get("X-Handler") != "" {
+				t.Fatalf("deadline won but sent %q, headers %v, handler err %v", rec.Body, rec.Header(), writeErr)
+			}
+		default:
+			t.Fatalf("status %d", rec.Code)
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	mem := repository.NewMemory()
+	s, err := New(Config{RequestTimeout: 20 * time.Millisecond}, WithRepository(blockingRepo{mem}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+
+	start := time.Now()
+	w := serve(h, http.MethodGet, "/api/v1/users/1", "")
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), `"code":"deadline_exceeded"`) {
+		t.Fatalf("got %d %s, want 504 deadline_exceeded", w.Code, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("answered after %v; the repository call was not cancelled", elapsed)
+	}
+	// Routes whose handler finishes in time are unaffected.
+	if w := serve(h, http.MethodGet, "/api/v1/users", ""); w.Code != http.StatusOK {
+		t.Errorf("fast route: %d", w.Code)
+	}
+
+	metrics := serve(h, http.MethodGet, "/metrics", "").Body.String()
+	if line := `http_requests_timed_out_total{method="GET",route="/api/v1/users/:id"} 1`; !strings.Contains(metrics, line) {
+		t.Errorf("metrics lack %s", line)
+	}
+}
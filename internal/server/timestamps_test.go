@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Timestamps are server-managed: set on create, bumped by every write,
+// echoed as RFC 3339 and never taken from the client.
+func TestUserTimestamps(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	h := s.Handler()
+
+	type stamped struct {
+		CreatedAt string `json:"created_at"`
+		UpdatedAt string `json:"updated_at"`
+	}
+	parse := func(what string, body []byte, wrapped bool) (created, updated time.Time) {
+		t.Helper()
+		var u stamped
+		if wrapped {
+			var r struct{ User stamped }
+			if err := json.Unmarshal(body, &r); err != nil {
+				t.Fatalf("%s: %v", what, err)
+			}
+			u = r.User
+		} else if err := json.Unmarshal(body, &u); err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		created, err := time.Parse(time.RFC3339Nano, u.CreatedAt)
+		if err != nil {
+			t.Fatalf("%s: created_at %q: %v", what, u.CreatedAt, err)
+		}
+		updated, err = time.Parse(time.RFC3339Nano, u.UpdatedAt)
+		if err != nil {
+			t.Fatalf("%s: updated_at %q: %v", what, u.UpdatedAt, err)
+		}
+		return created, updated
+	}
+
+	before := time.Now().Add(-time.Second)
+	w := serve(h, http.MethodPost, "/api/v1/users", `{"name":"Ada","email":"ada@example.com","created_at":"2000-01-01T00:00:00Z"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST: %d %s", w.Code, w.Body)
+	}
+	created, updated := parse("POST", w.Body.Bytes(), false)
+	if created.Before(before) || !updated.Equal(created) {
+		t.Fatalf("POST: created_at %v, updated_at %v; want now, and equal", created, updated)
+	}
+	if c, _ := parse("GET", serve(h, http.MethodGet, "/api/v1/users/1", "").Body.Bytes(), false); !c.Equal(created) {
+		t.Errorf("GET created_at %v, want %v", c, created)
+	}
+
+	last := updated
+	for _, tc := range []struct{ method, body string }{
+		{http.MethodPut, `{"name":"Ada L","email":"ada@example.com","updated_at":"2000-01-01T00:00:00Z"}`},
+		{http.MethodPatch, `{"name":"Ada K"}`},
+	} {
+		time.Sleep(time.Millisecond)
+		w := serve(h, tc.method, "/api/v1/users/1", tc.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tc.method, w.Code, w.Body)
+		}
+		c, u := parse(tc.method, w.Body.Bytes(), true)
+		if !c.Equal(created) || !u.After(last) {
+			t.Errorf("%s: created_at %v (was %v), updated_at %v (was %v)", tc.method, c, created, u, last)
+		}
+		last = u
+	}
+}
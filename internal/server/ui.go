@@ -0,0 +1,43 @@
+package server
+
+import (
+	"embed"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// uiAssets is the demo UI: plain HTML/JS/CSS, no build step and no
+// external assets.
+//
+//go:embed ui
+var uiAssets embed.FS
+
+// serveUI serves GET /ui/*filepath. The page derives the API prefix from
+// its own URL, so it works under BasePath and behind a stripping proxy.
+func (s *Server) serveUI(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("filepath")
+	if name == "" {
+		name = "index.html"
+	}
+
+	data, err := uiAssets.ReadFile("ui/" + name)
+	if err != nil {
+		respondError(w, r, codeNotFound, "not found")
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// Asset names are not content-hashed, so always revalidate the page
+	// and only let scripts and styles be reused briefly.
+	if name == "index.html" {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeData(w, http.StatusOK, contentType, data)
+}
@@ -0,0 +1,130 @@
+(function () {
+  "use strict";
+
+  // The UI lives at <prefix>/ui/; the API is mounted at <prefix>.
+  var base = location.pathname.replace(/\/ui(\/.*)?$/, "");
+  var pageSize = 10;
+  var state = { users: [], page: 0 };
+
+  function $(id) { return document.getElementById(id); }
+
+  function showError(msg) {
+    $("error").textContent = msg;
+    $("error").hidden = !msg;
+  }
+
+  function api(method, path, body) {
+    var headers = { "Accept": "application/json" };
+    var token = localStorage.getItem("token");
+    if (token) headers["Authorization"] = "Bearer " + token;
+    if (body !== undefined) headers["Content-Type"] = "application/json";
+
+    return fetch(base + path, {
+      method: method,
+      headers: headers,
+      body: body === undefined ? undefined : JSON.stringify(body)
+    }).then(function (res) {
+      return res.text().then(function (text) {
+        var data = null;
+        try { data = text ? JSON.parse(text) : null; } catch (e) { /* not JSON */ }
+        if (!res.ok) {
+          var msg = (data && data.error) || res.statusText;
+          if (data && data.code) msg += " (" + data.code + ")";
+          throw new Error(res.status + ": " + msg);
+        }
+        return data;
+      });
+    });
+  }
+
+  function filtered() {
+    var q = $("search").value.trim().toLowerCase();
+    if (!q) return state.users;
+    return state.users.filter(function (u) {
+      return u.name.toLowerCase().indexOf(q) >= 0 || u.email.toLowerCase().indexOf(q) >= 0;
+    });
+  }
+
+  function render() {
+    var users = filtered();
+    var pages = Math.max(1, Math.ceil(users.length / pageSize));
+    state.page = Math.min(state.page, pages - 1);
+
+    var rows = $("rows");
+    rows.textContent = "";
+    users.slice(state.page * pageSize, (state.page + 1) * pageSize).forEach(function (u) {
+      var tr = document.createElement("tr");
+      [u.id, u.name, u.email].forEach(function (v) {
+        var td = document.createElement("td");
+        td.textContent = v;
+        tr.appendChild(td);
+      });
+      tr.addEventListener("click", function () { open(u.id); });
+      rows.appendChild(tr);
+    });
+
+    $("page").textContent = "Page " + (state.page + 1) + " of " + pages;
+    $("prev").disabled = state.page === 0;
+    $("next").disabled = state.page >= pages - 1;
+  }
+
+  function load() {
+    return api("GET", "/users").then(function (users) {
+      state.users = users || [];
+      showError("");
+      render();
+    }).catch(function (err) { showError(err.message); });
+  }
+
+  function open(id) {
+    var form = $("form");
+    var fill = function (u) {
+      form.id.value = u ? u.id : "";
+      form.name.value = u ? u.name : "";
+      form.email.value = u ? u.email : "";
+      form.metadata.value = JSON.stringify((u && u.metadata) || {}, null, 2);
+      $("delete").hidden = !u;
+      $("detail").hidden = false;
+    };
+    if (id === undefined) { fill(null); return; }
+    api("GET", "/users/" + id).then(fill).catch(function (err) { showError(err.message); });
+  }
+
+  function save(ev) {
+    ev.preventDefault();
+    var form = $("form");
+    var metadata;
+    try { metadata = JSON.parse(form.metadata.value || "{}"); } catch (e) {
+      showError("Metadata must be valid JSON");
+      return;
+    }
+    var body = { name: form.name.value, email: form.email.value, metadata: metadata };
+    var req = form.id.value ? api("PUT", "/users/" + form.id.value, body) : api("POST", "/users", body);
+    req.then(function () { $("detail").hidden = true; return load(); })
+      .catch(function (err) { showError(err.message); });
+  }
+
+  function remove() {
+    var id = $("form").id.value;
+    if (!id || !confirm("Delete user " + id + "?")) return;
+    api("DELETE", "/users/" + id)
+      .then(function () { $("detail").hidden = true; return load(); })
+      .catch(function (err) { showError(err.message); });
+  }
+
+  $("token").value = localStorage.getItem("token") || "";
+  $("save-token").addEventListener("click", function () {
+    localStorage.setItem("token", $("token").value.trim());
+    load();
+  });
+  $("search").addEventListener("input", function () { state.page = 0; render(); });
+  $("refresh").addEventListener("click", load);
+  $("new").addEventListener("click", function () { open(); });
+  $("prev").addEventListener("click", function () { state.page--; render(); });
+  $("next").addEventListener("click", function () { state.page++; render(); });
+  $("form").addEventListener("submit", save);
+  $("delete").addEventListener("click", remove);
+  $("close").addEventListener("click", function () { $("detail").hidden = true; });
+
+  load();
+})();
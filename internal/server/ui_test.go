@@ -0,0 +1,56 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDemoUIAssets(t *testing.T) {
+	s, _ := newTestServer(t, Config{DemoUI: true})
+	h := s.Handler()
+
+	// Media types come from the mime table, which the OS may extend.
+	for target, want := range map[string]struct{ contentType, cache string }{
+		"/api/v1/ui/":           {"text/html", "no-cache"},
+		"/api/v1/ui/index.html": {"text/html", "no-cache"},
+		"/api/v1/ui/app.js":     {"javascript", "public, max-age=300"},
+		"/api/v1/ui/style.css":  {"text/css", "public, max-age=300"},
+	} {
+		w := serve(h, http.MethodGet, target, "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Header().Get("Content-Type"), want.contentType) ||
+			w.Header().Get("Cache-Control") != want.cache || w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: %d, headers %v", target, w.Code, w.Header())
+		}
+	}
+	for _, target := range []string{"/api/v1/ui/missing.js", "/api/v1/ui/../ui.go", "/api/v1/ui/%2e%2e/ui.go"} {
+		if w := serve(h, http.MethodGet, target, ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d", target, w.Code)
+		}
+	}
+}
+
+func TestDemoUIOff(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	if w := serve(s.Handler(), http.MethodGet, "/api/v1/ui/", ""); w.Code != http.StatusNotFound {
+		t.Errorf("UI served while disabled: %d", w.Code)
+	}
+}
+
+// Demos run offline: nothing is fetched from a CDN, and API calls use
+// paths relative to the page so BASE_PATH keeps working.
+func TestDemoUIIsSelfContained(t *testing.T) {
+	fs.WalkDir(uiAssets, "ui", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, _ := uiAssets.ReadFile(name)
+		for _, ref := range []string{"http://", "https://", `src="/`, `href="/`} {
+			if strings.Contains(string(data), ref) {
+				t.Errorf("%s references %q", name, ref)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/supervisor"
+)
+
+// viewBatcher buffers view increments in memory and writes them to the
+// database every flushEvery increments or interval, whichever comes first.
+type viewBatcher struct {
+	increment func(ctx context.Context, id, n int64) (int64, error)
+	log       zerolog.Logger
+	interval  time.Duration
+	flushAt   int
+
+	mu      sync.Mutex
+	pending map[int64]int64
+	total   int
+	kick    chan struct{}
+}
+
+func newViewBatcher(increment func(ctx context.Context, id, n int64) (int64, error), logger zerolog.Logger, interval time.Duration, flushAt int) *viewBatcher {
+	return &viewBatcher{
+		increment: increment,
+		log:       logger,
+		interval:  interval,
+		flushAt:   flushAt,
+		pending:   make(map[int64]int64),
+		kick:      make(chan struct{}, 1),
+	}
+}
+
+// add queues one view for id.
+func (b *viewBatcher) add(id int64) {
+	b.mu.Lock()
+	b.pending[id]++
+	b.total++
+	full := b.total >= b.flushAt
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// queuedFor returns the not yet flushed views for id; zero when batching is off.
+func (b *viewBatcher) queuedFor(id int64) int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.pending[id]
+}
+
+// run flushes periodically until ctx is cancelled, then flushes whatever is
+// left so no counted view is lost on shutdown.
+func (b *viewBatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			finalCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			b.flush(finalCtx)
+			cancel()
+			return
+		case <-ticker.C:
+		case <-b.kick:
+		}
+		b.flush(ctx)
+		supervisor.Beat(ctx)
+	}
+}
+
+func (b *viewBatcher) flush(ctx context.Context) {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = make(map[int64]int64)
+	b.total = 0
+	b.mu.Unlock()
+
+	for id, n := range batch {
+		if _, err := b.increment(ctx, id, n); err != nil {
+			// Most likely the user was deleted after the view was queued.
+			b.log.Warn().Err(err).Int64("id", id).Int64("views", n).Msg("dropping batched views")
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+const parallelViews = 300
+
+func addViews(t *testing.T, h http.Handler, want int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for range parallelViews {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := serve(h, http.MethodPost, "/api/v1/users/1/views", ""); w.Code != want {
+				t.Errorf("add view: %d %s", w.Code, w.Body)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func views(t *testing.T, h http.Handler) int64 {
+	t.Helper()
+	var v viewCount
+	w := serve(h, http.MethodGet, "/api/v1/users/1/views", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("get views: %d %s", w.Code, w.Body)
+	}
+	return v.Views
+}
+
+// Parallel increments are never lost to a read-modify-write race.
+func TestConcurrentViews(t *testing.T) {
+	s, repo := newTestServer(t, Config{})
+	seedUsers(t, repo, 1)
+	h := s.Handler()
+
+	addViews(t, h, http.StatusOK)
+	if got := views(t, h); got != parallelViews {
+		t.Errorf("views = %d, want %d", got, parallelViews)
+	}
+}
+
+// Batched views count while still queued and all reach the repository by
+// the end of Shutdown.
+func TestBatchedViews(t *testing.T) {
+	s, repo := newTestServer(t, Config{ViewFlushInterval: time.Hour, ViewBatchSize: 1000})
+	seedUsers(t, repo, 1)
+	s.StartWorkers()
+	h := s.Handler()
+
+	addViews(t, h, http.StatusAccepted)
+	if got := views(t, h); got != parallelViews {
+		t.Errorf("views while queued = %d, want %d", got, parallelViews)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.GetViews(ctx, 1); err != nil || got != parallelViews {
+		t.Errorf("flushed views = %d, %v, want %d", got, err, parallelViews)
+	}
+}
+
+func TestViewsOfMissingUser(t *testing.T) {
+	for name, cfg := range map[string]Config{"direct": {}, "batched": {ViewFlushInterval: time.Hour}} {
+		t.Run(name, func(t *testing.T) {
+			s, _ := newTestServer(t, cfg)
+			h := s.Handler()
+			for _, method := range []string{http.MethodPost, http.MethodGet} {
+				if w := serve(h, method, "/api/v1/users/1/views", ""); w.Code != http.StatusNotFound {
+					t.Errorf("%s: %d %s", method, w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
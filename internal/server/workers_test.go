@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go-k8s-demo/internal/supervisor"
+)
+
+// A frozen worker degrades /readyz within its threshold; a critical one
+// fails it. Both show in the metrics and in /admin/workers.
+func TestStaleWorkerInReadiness(t *testing.T) {
+	s, _ := newTestServer(t, Config{APIKeys: testAPIKeys})
+	s.StartWorkers()
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	h := s.Handler()
+
+	type readiness struct {
+		Ready        bool               `json:"ready"`
+		StaleWorkers map[string]float64 `json:"stale_workers"`
+	}
+	ready := func() (int, readiness) {
+		w := serve(h, http.MethodGet, "/readyz", "")
+		var r readiness
+		if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+			t.Fatalf("readyz: %v\n%s", err, w.Body)
+		}
+		return w.Code, r
+	}
+	// waitStale polls until name is reported stale, failing well before
+	// a worker that is not stuck could look stuck.
+	waitStale := func(name string, threshold time.Duration) (int, readiness) {
+		t.Helper()
+		deadline := time.Now().Add(threshold + time.Second)
+		for {
+			code, r := ready()
+			if _, ok := r.StaleWorkers[name]; ok {
+				return code, r
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never reported stale: %d %+v", name, code, r)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	if code, r := ready(); code != http.StatusOK || !r.Ready || len(r.StaleWorkers) != 0 {
+		t.Fatalf("before: %d %+v", code, r)
+	}
+
+	frozen := func(ctx context.Context) { <-ctx.Done() }
+	start := time.Now()
+	s.workers.Go("frozen", frozen, supervisor.StaleAfter(30*time.Millisecond))
+	code, r := waitStale("frozen", 30*time.Millisecond)
+	if code != http.StatusOK || !r.Ready {
+		t.Errorf("non-critical stale worker: %d %+v, want ready but degraded", code, r)
+	}
+	if lag := r.StaleWorkers["frozen"]; lag < 0.03 || lag > time.Since(start).Seconds() {
+		t.Errorf("lag %v outside [0.03, %v]", lag, time.Since(start).Seconds())
+	}
+
+	s.workers.Go("frozen-critical", frozen, supervisor.StaleAfter(30*time.Millisecond), supervisor.Critical())
+	if code, r := waitStale("frozen-critical", 30*time.Millisecond); code != http.StatusServiceUnavailable || r.Ready {
+		t.Errorf("critical stale worker: %d %+v, want 503", code, r)
+	}
+
+	if m := serve(h, http.MethodGet, "/metrics", "").Body.String(); !strings.Contains(m, "background_workers_stale 2") {
+		t.Errorf("metrics lack background_workers_stale 2")
+	}
+
+	var body struct{ Workers []supervisor.Status }
+	w := serve(h, http.MethodGet, "/api/v1/admin/workers", "", apiKeyHeader, aliceKey)
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("admin/workers: %d %v", w.Code, err)
+	}
+	seen := 0
+	for _, st := range body.Workers {
+		if strings.HasPrefix(st.Name, "frozen") {
+			seen++
+			if !st.Stale || st.LagSeconds < st.StaleAfter || st.Critical != (st.Name == "frozen-critical") {
+				t.Errorf("admin/workers %+v", st)
+			}
+		} else if st.Stale {
+			t.Errorf("built-in worker %s reported stale", st.Name)
+		}
+	}
+	if seen != 2 {
+		t.Errorf("admin/workers lists %d frozen workers, want 2", seen)
+	}
+}
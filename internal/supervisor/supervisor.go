@@ -0,0 +1,295 @@
+// Package supervisor runs long-lived background workers. A worker that
+// panics is logged with its stack and restarted with exponential backoff;
+// one that keeps panicking is eventually given up on and reported dead.
+//
+// Workers started with StaleAfter report liveness by calling Beat once per
+// loop; one that has not beaten for longer than that is reported stale, so
+// a loop stuck on a hung query is noticed even though it never panics.
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+// State is the lifecycle state of a worker.
+type State string
+
+const (
+	StateRunning    State = "running"
+	StateRestarting State = "restarting"
+	StateStopped    State = "stopped" // returned on its own or on shutdown
+	StateDead       State = "dead"    // gave up after too many panics
+)
+
+// Defaults for Supervisor tunables.
+const (
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = time.Minute
+	DefaultMaxRestarts = 10
+)
+
+// Status is a snapshot of one worker, as served by GET /admin/workers.
+type Status struct {
+	Name        string     `json:"name"`
+	State       State      `json:"state"`
+	Started     time.Time  `json:"started"`
+	Panics      int        `json:"panics"`
+	LastPanic   string     `json:"last_panic,omitempty"`
+	LastPanicAt *time.Time `json:"last_panic_at,omitempty"`
+
+	// Liveness, for workers started with StaleAfter. LagSeconds is the
+	// time since the last Beat (or the start, before the first one).
+	LastBeat   *time.Time `json:"last_beat,omitempty"`
+	StaleAfter float64    `json:"stale_after_seconds,omitempty"`
+	LagSeconds float64    `json:"lag_seconds,omitempty"`
+	Stale      bool       `json:"stale,omitempty"`
+	Critical   bool       `json:"critical,omitempty"`
+}
+
+// Option configures one worker.
+type Option func(*worker)
+
+// StaleAfter enables liveness tracking: the worker is stale once it has
+// not called Beat for d.
+func StaleAfter(d time.Duration) Option {
+	return func(w *worker) { w.staleAfter = d }
+}
+
+// Critical marks a worker whose staleness should fail readiness rather
+// than only degrade it.
+func Critical() Option {
+	return func(w *worker) { w.critical = true }
+}
+
+type worker struct {
+	Status
+	staleAfter time.Duration
+	critical   bool
+	lastBeat   time.Time
+}
+
+type beatKey struct{}
+
+// Beat records that the worker owning ctx completed a loop iteration. It
+// is a no-op outside a supervised worker.
+func Beat(ctx context.Context) {
+	if beat, ok := ctx.Value(beatKey{}).(func()); ok {
+		beat()
+	}
+}
+
+// Supervisor owns a set of named workers sharing one context.
+type Supervisor struct {
+	ctx context.Context
+	log zerolog.Logger
+
+	// MinBackoff and MaxBackoff bound the delay before a restart; it
+	// doubles after each consecutive panic. A worker that panics
+	// MaxRestarts times in a row is marked dead. Set before the first Go.
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	MaxRestarts int
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	workers map[string]*worker
+
+	panics   *metrics.CounterVec
+	restarts *metrics.CounterVec
+}
+
+// New returns a Supervisor whose workers run until ctx is cancelled.
+func New(ctx context.Context, logger zerolog.Logger) *Supervisor {
+	return &Supervisor{
+		ctx:         ctx,
+		log:         logger,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		MaxRestarts: DefaultMaxRestarts,
+		workers:     make(map[string]*worker),
+	}
+}
+
+// RegisterMetrics counts panics and restarts per worker on reg. Call it
+// before the first Go.
+func (s *Supervisor) RegisterMetrics(reg *metrics.Registry) {
+	s.panics = reg.Counter("background_worker_panics_total", "Panics recovered from background workers, by worker.", "worker")
+	s.restarts = reg.Counter("background_worker_restarts_total", "Restarts of background workers after a panic, by worker.", "worker")
+}
+
+// Go starts fn under supervision. fn must return when its context is
+// cancelled; returning earlier without panicking ends the worker.
+func (s *Supervisor) Go(name string, fn func(ctx context.Context), opts ...Option) {
+	w := &worker{Status: Status{Name: name, State: StateRunning, Started: time.Now()}}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	s.mu.Lock()
+	if _, dup := s.workers[name]; dup {
+		s.mu.Unlock()
+		panic("supervisor: duplicate worker name " + name)
+	}
+	s.workers[name] = w
+	s.mu.Unlock()
+
+	ctx := context.WithValue(s.ctx, beatKey{}, func() {
+		s.mu.Lock()
+		w.lastBeat = time.Now()
+		s.mu.Unlock()
+	})
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.supervise(ctx, &w.Status, fn)
+	}()
+}
+
+func (s *Supervisor) supervise(ctx context.Context, st *Status, fn func(ctx context.Context)) {
+	backoff := s.MinBackoff
+	consecutive := 0
+	for {
+		started := time.Now()
+		panicked := s.runOnce(ctx, st, fn)
+		if !panicked || s.ctx.Err() != nil {
+			s.setState(st, StateStopped)
+			return
+		}
+
+		// A worker that ran well past the longest backoff is considered
+		// healthy again, so an occasional panic never kills it.
+		if time.Since(started) > s.MaxBackoff {
+			consecutive, backoff = 0, s.MinBackoff
+		}
+		consecutive++
+		if s.MaxRestarts > 0 && consecutive >= s.MaxRestarts {
+			s.log.Error().Str("worker", st.Name).Int("panics", consecutive).Msg("worker keeps panicking; giving up")
+			s.setState(st, StateDead)
+			return
+		}
+
+		s.setState(st, StateRestarting)
+		s.log.Warn().Str("worker", st.Name).Dur("backoff", backoff).Msg("restarting worker")
+		select {
+		case <-time.After(backoff):
+		case <-s.ctx.Done():
+			s.setState(st, StateStopped)
+			return
+		}
+		backoff = min(backoff*2, s.MaxBackoff)
+
+		s.mu.Lock()
+		st.State, st.Started = StateRunning, time.Now()
+		s.mu.Unlock()
+		if s.restarts != nil {
+			s.restarts.With(st.Name).Inc()
+		}
+	}
+}
+
+// runOnce calls fn and reports whether it panicked.
+func (s *Supervisor) runOnce(ctx context.Context, st *Status, fn func(ctx context.Context)) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+			stack := debug.Stack()
+			s.mu.Lock()
+			st.Panics++
+			st.LastPanic = fmt.Sprint(r)
+			now := time.Now()
+			st.LastPanicAt = &now
+			s.mu.Unlock()
+			if s.panics != nil {
+				s.panics.With(st.Name).Inc()
+			}
+			s.log.Error().Str("worker", st.Name).Interface("panic", r).Bytes("stack", stack).Msg("worker panicked")
+		}
+	}()
+	fn(ctx)
+	return false
+}
+
+func (s *Supervisor) setState(st *Status, state State) {
+	s.mu.Lock()
+	st.State = state
+	s.mu.Unlock()
+}
+
+// Status returns a snapshot of every worker, sorted by name.
+func (s *Supervisor) Status() []Status {
+	if s == nil {
+		return []Status{}
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.workers))
+	for _, w := range s.workers {
+		out = append(out, w.snapshot(now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Stale returns the running workers that missed their liveness deadline.
+func (s *Supervisor) Stale() []Status {
+	var stale []Status
+	for _, st := range s.Status() {
+		if st.Stale {
+			stale = append(stale, st)
+		}
+	}
+	return stale
+}
+
+// snapshot copies the status and fills in liveness; callers hold s.mu.
+func (w *worker) snapshot(now time.Time) Status {
+	st := w.Status
+	if w.staleAfter <= 0 {
+		return st
+	}
+	since := w.Started
+	if !w.lastBeat.IsZero() {
+		beat := w.lastBeat
+		st.LastBeat = &beat
+		// A restart resets the clock, the new run has not beaten yet.
+		if beat.After(since) {
+			since = beat
+		}
+	}
+	lag := now.Sub(since)
+	st.StaleAfter = w.staleAfter.Seconds()
+	st.LagSeconds = lag.Seconds()
+	st.Stale = st.State == StateRunning && lag > w.staleAfter
+	st.Critical = w.critical
+	return st
+}
+
+// Wait blocks until every worker has returned or ctx expires, in which
+// case it returns ctx's error. Cancel the Supervisor's context first.
+func (s *Supervisor) Wait(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,156 @@
+package supervisor
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"go-k8s-demo/internal/metrics"
+)
+
+func newTestSupervisor(t *testing.T) (*Supervisor, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(ctx, zerolog.Nop())
+	s.MinBackoff, s.MaxBackoff = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() {
+		cancel()
+		s.Wait(context.Background())
+	})
+	return s, cancel
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func status(s *Supervisor, name string) Status {
+	for _, st := range s.Status() {
+		if st.Name == name {
+			return st
+		}
+	}
+	return Status{}
+}
+
+func TestWorkerRestartsAfterPanic(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	reg := metrics.NewRegistry()
+	s.RegisterMetrics(reg)
+
+	var runs atomic.Int32
+	s.Go("flaky", func(ctx context.Context) {
+		if runs.Add(1) <= 2 {
+			panic("boom")
+		}
+		<-ctx.Done()
+	})
+
+	waitFor(t, "the third run", func() bool { return runs.Load() == 3 })
+	waitFor(t, "running again", func() bool { return status(s, "flaky").State == StateRunning })
+	st := status(s, "flaky")
+	if st.Panics != 2 || st.LastPanic != "boom" || st.LastPanicAt == nil {
+		t.Errorf("status = %+v, want 2 panics with the last recorded", st)
+	}
+
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{
+		`background_worker_panics_total{worker="flaky"} 2`,
+		`background_worker_restarts_total{worker="flaky"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("metrics lack %s:\n%s", line, w.Body)
+		}
+	}
+}
+
+func TestWorkerGivenUpAfterMaxRestarts(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.MaxRestarts = 3
+
+	var runs atomic.Int32
+	s.Go("broken", func(ctx context.Context) {
+		runs.Add(1)
+		panic("always")
+	})
+
+	waitFor(t, "the worker to die", func() bool { return status(s, "broken").State == StateDead })
+	if n := runs.Load(); n != 3 {
+		t.Errorf("ran %d times, want 3", n)
+	}
+}
+
+func TestWorkerReturningStops(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.Go("once", func(ctx context.Context) {})
+	waitFor(t, "the worker to stop", func() bool { return status(s, "once").State == StateStopped })
+	if st := status(s, "once"); st.Panics != 0 {
+		t.Errorf("panics = %d", st.Panics)
+	}
+}
+
+func TestShutdownStopsWorkers(t *testing.T) {
+	s, cancel := newTestSupervisor(t)
+	s.Go("loop", func(ctx context.Context) { <-ctx.Done() })
+	cancel()
+	ctx, done := context.WithTimeout(context.Background(), time.Second)
+	defer done()
+	if err := s.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if st := status(s, "loop"); st.State != StateStopped {
+		t.Errorf("state = %s, want stopped", st.State)
+	}
+}
+
+func TestStaleWorker(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	release := make(chan struct{})
+	defer close(release)
+
+	s.Go("beating", func(ctx context.Context) {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Millisecond):
+				Beat(ctx)
+			}
+		}
+	}, StaleAfter(50*time.Millisecond))
+	s.Go("hung", func(ctx context.Context) { <-release }, StaleAfter(10*time.Millisecond), Critical())
+
+	waitFor(t, "the hung worker to go stale", func() bool { return len(s.Stale()) > 0 })
+	stale := s.Stale()
+	if len(stale) != 1 || stale[0].Name != "hung" || !stale[0].Critical {
+		t.Errorf("stale = %+v, want only the critical hung worker", stale)
+	}
+	if st := status(s, "beating"); st.LastBeat == nil || st.Stale {
+		t.Errorf("beating worker: %+v", st)
+	}
+}
+
+func TestDuplicateWorkerNamePanics(t *testing.T) {
+	s, _ := newTestSupervisor(t)
+	s.Go("dup", func(ctx context.Context) { <-ctx.Done() })
+	defer func() {
+		if recover() == nil {
+			t.Error("second Go with the same name did not panic")
+		}
+	}()
+	s.Go("dup", func(ctx context.Context) { <-ctx.Done() })
+}
@@ -0,0 +1,128 @@
+// Package timing collects per-request durations for the Server-Timing
+// response header.
+//
+// A Recorder travels on the request context, so anything holding the
+// context (handlers, the pgx tracer) can add to it. When no Recorder is
+// attached every call is a cheap no-op.
+package timing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type ctxKey struct{}
+
+// Recorder accumulates named metrics; safe for concurrent use since a
+// request may run several queries in parallel.
+type Recorder struct {
+	mu      sync.Mutex
+	order   []string
+	metrics map[string]*metric
+}
+
+type metric struct {
+	dur   time.Duration
+	count int
+	desc  string
+}
+
+// NewContext returns a copy of ctx carrying a fresh Recorder.
+func NewContext(ctx context.Context) (context.Context, *Recorder) {
+	r := &Recorder{metrics: make(map[string]*metric)}
+	return context.WithValue(ctx, ctxKey{}, r), r
+}
+
+// FromContext returns the Recorder attached to ctx, or nil.
+func FromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(ctxKey{}).(*Recorder)
+	return r
+}
+
+// Add records d under name; repeated names are summed.
+func (r *Recorder) Add(name string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.get(name).add(d)
+}
+
+// Describe attaches a short description (e.g. "hit") to name.
+func (r *Recorder) Describe(name, desc string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.get(name).desc = desc
+}
+
+// Since records the time elapsed since start under name.
+func Since(ctx context.Context, name string, start time.Time) {
+	FromContext(ctx).Add(name, time.Since(start))
+}
+
+func (r *Recorder) get(name string) *metric {
+	m, ok := r.metrics[name]
+	if !ok {
+		m = &metric{}
+		r.metrics[name] = m
+		r.order = append(r.order, name)
+	}
+	return m
+}
+
+func (m *metric) add(d time.Duration) {
+	m.dur += d
+	m.count++
+}
+
+// Header renders the metrics in Server-Timing syntax, e.g.
+// `db;dur=1.52;desc="2 queries", render;dur=0.08`.
+func (r *Recorder) Header() string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	parts := make([]string, 0, len(r.order))
+	for _, name := range r.order {
+		m := r.metrics[name]
+		part := fmt.Sprintf("%s;dur=%.2f", name, float64(m.dur)/float64(time.Millisecond))
+		switch {
+		case m.desc != "":
+			part += fmt.Sprintf(";desc=%q", m.desc)
+		case name == "db":
+			part += fmt.Sprintf(";desc=\"%d queries\"", m.count)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PgxTracer adds the duration of every query to the "db" metric of the
+// Recorder on the query's context.
+type PgxTracer struct{}
+
+type queryStartKey struct{}
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	if FromContext(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, queryStartKey{}, time.Now())
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	if start, ok := ctx.Value(queryStartKey{}).(time.Time); ok {
+		Since(ctx, "db", start)
+	}
+}
@@ -0,0 +1,80 @@
+package timing
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// entry is one Server-Timing metric: name, dur and an optional desc.
+var entry = regexp.MustCompile(`^([a-z]+);dur=(\d+\.\d{2})(?:;desc="([^"]*)")?$`)
+
+// Parallel queries of one request all land in the same sum.
+func TestConcurrentAdd(t *testing.T) {
+	ctx, rec := NewContext(context.Background())
+	var wg sync.WaitGroup
+	for range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			FromContext(ctx).Add("db", time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	if got, want := rec.Header(), `db;dur=100.00;desc="100 queries"`; got != want {
+		t.Errorf("Header() = %s, want %s", got, want)
+	}
+}
+
+func TestHeaderFormat(t *testing.T) {
+	_, rec := NewContext(context.Background())
+	rec.Add("cache", 250*time.Microsecond)
+	rec.Describe("cache", "miss")
+	rec.Add("db", 1520*time.Microsecond)
+	rec.Add("render", 80*time.Microsecond)
+
+	want := []struct{ name, dur, desc string }{{"cache", "0.25", "miss"}, {"db", "1.52", "1 queries"}, {"render", "0.08", ""}}
+	parts := strings.Split(rec.Header(), ", ")
+	if len(parts) != len(want) {
+		t.Fatalf("Header() = %s", rec.Header())
+	}
+	for i, p := range parts {
+		m := entry.FindStringSubmatch(p)
+		if m == nil || m[1] != want[i].name || m[2] != want[i].dur || m[3] != want[i].desc {
+			t.Errorf("entry %d = %q, want %+v", i, p, want[i])
+		}
+	}
+}
+
+// Without a Recorder everything is a no-op, including the tracer.
+func TestDisabled(t *testing.T) {
+	ctx := context.Background()
+	Since(ctx, "db", time.Now())
+	FromContext(ctx).Describe("cache", "hit")
+	if h := FromContext(ctx).Header(); h != "" {
+		t.Errorf("Header() = %q", h)
+	}
+
+	var tracer PgxTracer
+	if got := tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{}); got != ctx {
+		t.Error("tracer wrapped a context without a Recorder")
+	}
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+}
+
+func TestPgxTracer(t *testing.T) {
+	ctx, rec := NewContext(context.Background())
+	var tracer PgxTracer
+	for range 2 {
+		tracer.TraceQueryEnd(tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{}), nil, pgx.TraceQueryEndData{})
+	}
+	if m := entry.FindStringSubmatch(rec.Header()); m == nil || m[1] != "db" || m[3] != "2 queries" {
+		t.Errorf("Header() = %s", rec.Header())
+	}
+}
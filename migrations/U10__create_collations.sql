@@ -0,0 +1,5 @@
+-- Sorting with ?collation= falls back to ordering in Go afterwards.
+DROP COLLATION IF EXISTS "de-DE";
+DROP COLLATION IF EXISTS "en-US";
+DROP COLLATION IF EXISTS "fr-FR";
+DROP COLLATION IF EXISTS "sv-SE";
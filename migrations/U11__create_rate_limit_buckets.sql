@@ -0,0 +1,2 @@
+-- Rate limiting state goes back to being per replica.
+DROP TABLE rate_limit_buckets;
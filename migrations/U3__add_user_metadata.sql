@@ -0,0 +1,2 @@
+-- Dropping the column drops users_metadata_idx with it.
+ALTER TABLE users DROP COLUMN metadata;
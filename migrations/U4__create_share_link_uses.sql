@@ -0,0 +1 @@
+DROP TABLE share_link_uses;
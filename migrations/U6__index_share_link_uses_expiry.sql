@@ -0,0 +1 @@
+DROP INDEX share_link_uses_expires_at_idx;
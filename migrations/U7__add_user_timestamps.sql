@@ -0,0 +1,8 @@
+-- created_at returns to V1's shape; the values it gained are kept.
+DROP TRIGGER users_updated_at ON users;
+DROP FUNCTION users_set_updated_at();
+ALTER TABLE users
+  DROP COLUMN updated_at,
+  ALTER COLUMN created_at DROP NOT NULL,
+  ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP,
+  ALTER COLUMN created_at TYPE TIMESTAMP;
@@ -0,0 +1,3 @@
+-- Soft-deleted users become live rows again; purge them first if they
+-- must stay gone.
+ALTER TABLE users DROP COLUMN deleted_at;
@@ -0,0 +1,14 @@
+-- ?collation= sorts names by one of these locales (repository
+-- SupportedCollations). They need a server built with ICU; without it the
+-- collations are skipped and the API orders small results in Go instead.
+DO $$
+DECLARE
+    locale text;
+BEGIN
+    IF EXISTS (SELECT 1 FROM pg_collation WHERE collprovider = 'i') THEN
+        FOREACH locale IN ARRAY ARRAY['de-DE', 'en-US', 'fr-FR', 'sv-SE'] LOOP
+            EXECUTE format('CREATE COLLATION IF NOT EXISTS %I (provider = icu, locale = %L)', locale, locale);
+        END LOOP;
+    END IF;
+END
+$$;
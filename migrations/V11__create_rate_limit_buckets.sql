@@ -0,0 +1,8 @@
+-- Per-client rate-limit buckets the replicas share (RATE_LIMIT_SYNC_INTERVAL).
+-- full_at is when the bucket is full again, which is all of its state;
+-- replicas merge by keeping the later one, and rows in the past are full
+-- buckets that can be purged.
+CREATE TABLE rate_limit_buckets (
+  key TEXT PRIMARY KEY,
+  full_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+CREATE TABLE user_views (
+  user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  count BIGINT NOT NULL DEFAULT 0
+);
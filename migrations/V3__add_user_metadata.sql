@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN metadata JSONB NOT NULL DEFAULT '{}';
+
+-- jsonb_path_ops supports the @> containment filters used by GET /users.
+CREATE INDEX users_metadata_idx ON users USING GIN (metadata jsonb_path_ops);
@@ -0,0 +1,7 @@
+-- One row per consumed one-time share link; rows past expires_at can be purged.
+CREATE TABLE share_link_uses (
+  nonce TEXT PRIMARY KEY,
+  user_id INT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  consumed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
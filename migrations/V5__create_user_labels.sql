@@ -0,0 +1,10 @@
+-- Kubernetes-style labels (team=payments); one value per key and user.
+CREATE TABLE user_labels (
+  user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+
+-- Serves ?label=key=value selectors on GET /users.
+CREATE INDEX user_labels_key_value_idx ON user_labels (key, value, user_id);
@@ -0,0 +1,2 @@
+-- Lets the retention job find expired share link uses without a scan.
+CREATE INDEX share_link_uses_expires_at_idx ON share_link_uses (expires_at);
@@ -0,0 +1,21 @@
+-- V1 already has a nullable created_at TIMESTAMP; it becomes TIMESTAMPTZ
+-- (read as the server's time zone) and rows without one get the migration
+-- time, as do all existing rows for updated_at.
+UPDATE users SET created_at = now() WHERE created_at IS NULL;
+ALTER TABLE users
+  ALTER COLUMN created_at TYPE TIMESTAMPTZ,
+  ALTER COLUMN created_at SET DEFAULT now(),
+  ALTER COLUMN created_at SET NOT NULL,
+  ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Every UPDATE bumps updated_at, whichever code path issues it.
+CREATE FUNCTION users_set_updated_at() RETURNS trigger AS $$
+BEGIN
+  NEW.updated_at := now();
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_updated_at
+  BEFORE UPDATE ON users
+  FOR EACH ROW EXECUTE FUNCTION users_set_updated_at();
@@ -0,0 +1,3 @@
+-- Optimistic concurrency: every write to a users row increments version,
+-- which the API serves as the ETag and compares against If-Match.
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
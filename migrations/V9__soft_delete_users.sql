@@ -0,0 +1,4 @@
+-- DELETE /users/:id sets deleted_at; reads skip such rows unless asked.
+-- The email stays reserved: users_email_key still covers deleted rows, so
+-- a restore can never collide with a newer account.
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
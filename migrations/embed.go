@@ -0,0 +1,12 @@
+// Package migrations embeds the Flyway-style SQL migrations of this
+// directory (V<version>__<description>.sql), and their undo scripts
+// (U<version>__<description>.sql), so the server binary can apply and
+// roll them back itself; see internal/migrate.
+package migrations
+
+import "embed"
+
+// FS holds every migration file.
+//
+//go:embed *.sql
+var FS embed.FS